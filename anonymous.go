@@ -0,0 +1,133 @@
+package sasl
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// ANONYMOUS_MAX_TRACE_LENGTH is the maximum length in characters of
+	// the trace information of an ANONYMOUS message.
+	ANONYMOUS_MAX_TRACE_LENGTH = 255
+)
+
+// AnonymousClient implements the ANONYMOUS SASL client mechanism
+// https://tools.ietf.org/html/rfc4505
+//
+// The initial response carries the optional trace information, such as
+// an email address, which the server may log.
+type AnonymousClient struct {
+	completed bool
+	cbh       CallbackHandler
+	trace     string
+}
+
+// NewAnonymousClient creates a new AnonymousClient instance sending trace,
+// which may be empty.
+func NewAnonymousClient(trace string) (*AnonymousClient, error) {
+	if err := checkAnonymousTrace(trace); err != nil {
+		return nil, err
+	}
+	return &AnonymousClient{trace: trace}, nil
+}
+
+// NewAnonymousClientWithHandler creates an AnonymousClient which retrieves
+// the trace information through cbh with a NameCallback when the initial
+// response is evaluated. No trace is sent if cbh is nil or does not
+// support the NameCallback.
+func NewAnonymousClientWithHandler(cbh CallbackHandler) (*AnonymousClient, error) {
+	return &AnonymousClient{cbh: cbh}, nil
+}
+
+// GetMechanismName returns the mechanism name "ANONYMOUS".
+func (c *AnonymousClient) GetMechanismName() string {
+	return "ANONYMOUS"
+}
+
+// HasInitialResponse returns true: the trace information is sent as
+// initial response.
+func (c *AnonymousClient) HasInitialResponse() bool {
+	return true
+}
+
+// EvaluateChallenge returns the trace information.
+func (c *AnonymousClient) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("ANONYMOUS authentication already completed")
+	}
+	if c.cbh != nil {
+		ncb := NewNameCallback("ANONYMOUS trace: ", c.trace)
+		err := c.cbh.Handle([]Callback{ncb})
+		if _, ok := err.(*UnsupportedCallbackError); !ok && err != nil {
+			return nil, err
+		} else if err == nil && len(ncb.GetName()) > 0 {
+			c.trace = ncb.GetName()
+		}
+		if err := checkAnonymousTrace(c.trace); err != nil {
+			return nil, err
+		}
+	}
+	c.completed = true
+	return []byte(c.trace), nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+// ANONYMOUS completes after returning one response.
+func (c *AnonymousClient) IsComplete() bool {
+	return c.completed
+}
+
+// Unwrap the incoming buffer.
+func (c *AnonymousClient) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("ANONYMOUS supports neither integrity nor privacy")
+	}
+	return nil, errors.New("ANONYMOUS authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (c *AnonymousClient) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("ANONYMOUS supports neither integrity nor privacy")
+	}
+	return nil, errors.New("ANONYMOUS authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (c *AnonymousClient) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !c.completed {
+		return nil, errors.New("ANONYMOUS authentication not completed")
+	}
+
+	if propName == SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (c *AnonymousClient) Dispose() error {
+	c.cbh = nil
+	return nil
+}
+
+// checkAnonymousTrace verifies that trace is valid UTF-8 of at most
+// ANONYMOUS_MAX_TRACE_LENGTH characters, none of them control characters.
+func checkAnonymousTrace(trace string) error {
+	if !utf8.ValidString(trace) {
+		return errors.New("ANONYMOUS: trace information is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(trace) > ANONYMOUS_MAX_TRACE_LENGTH {
+		return errors.New("ANONYMOUS: trace information exceeds 255 characters")
+	}
+	for _, r := range trace {
+		if unicode.IsControl(r) {
+			return errors.New("ANONYMOUS: trace information contains control characters")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package sasl
+
+import "errors"
+
+const (
+	// ANONYMOUS_AUTHORIZATION_ID_PROPERTY is a property that specifies the
+	// authorization ID an AnonymousServer reports for its clients. If
+	// this property is absent, DEFAULT_ANONYMOUS_AUTHORIZATION_ID is used.
+	ANONYMOUS_AUTHORIZATION_ID_PROPERTY = "golang.security.sasl.anonymous.authzid"
+
+	// ANONYMOUS_TRACE_PROPERTY is the negotiated property holding the
+	// trace information sent by the client of an AnonymousServer.
+	ANONYMOUS_TRACE_PROPERTY = "golang.security.sasl.anonymous.trace"
+
+	DEFAULT_ANONYMOUS_AUTHORIZATION_ID = "anonymous"
+)
+
+// AnonymousServer implements the ANONYMOUS SASL server mechanism
+// https://tools.ietf.org/html/rfc4505
+//
+// Any client is accepted as long as its trace information is valid UTF-8
+// of at most ANONYMOUS_MAX_TRACE_LENGTH characters. The trace is
+// available as negotiated property ANONYMOUS_TRACE_PROPERTY.
+// ANONYMOUS cannot be used if a policy property other than
+// SaslPropertyPolicyNoPlainText, notably SaslPropertyPolicyNoAnonymous,
+// is set to "true".
+type AnonymousServer struct {
+	completed       bool
+	authorizationID string
+	trace           string
+}
+
+// NewAnonymousServer creates a new AnonymousServer instance.
+func NewAnonymousServer(props map[string]interface{}) (*AnonymousServer, error) {
+	if err := CheckMechanismPolicy("ANONYMOUS", props); err != nil {
+		return nil, err
+	}
+	authorizationID := PropertyValue(props, ANONYMOUS_AUTHORIZATION_ID_PROPERTY)
+	if len(authorizationID) <= 0 {
+		authorizationID = DEFAULT_ANONYMOUS_AUTHORIZATION_ID
+	}
+	return &AnonymousServer{authorizationID: authorizationID}, nil
+}
+
+// GetMechanismName returns the mechanism name "ANONYMOUS".
+func (s *AnonymousServer) GetMechanismName() string {
+	return "ANONYMOUS"
+}
+
+// EvaluateResponse processes the trace information sent by the client. An
+// empty response carries no trace, as sent by clients without trace
+// information in their initial response; the exchange completes with the
+// first response.
+func (s *AnonymousServer) EvaluateResponse(response []byte) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("ANONYMOUS authentication already completed")
+	}
+
+	if len(response) > 4*ANONYMOUS_MAX_TRACE_LENGTH {
+		return nil, errors.New("ANONYMOUS: message too long")
+	}
+	if err := checkAnonymousTrace(string(response)); err != nil {
+		return nil, err
+	}
+	s.trace = string(response)
+	s.completed = true
+	return nil, nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+func (s *AnonymousServer) IsComplete() bool {
+	return s.completed
+}
+
+// GetAuthorizationID returns the configured anonymous authorization ID.
+func (s *AnonymousServer) GetAuthorizationID() (string, error) {
+	if !s.completed {
+		return "", errors.New("ANONYMOUS authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// GetTrace returns the trace information sent by the client.
+func (s *AnonymousServer) GetTrace() (string, error) {
+	if !s.completed {
+		return "", errors.New("ANONYMOUS authentication not completed")
+	}
+	return s.trace, nil
+}
+
+// Unwrap the incoming buffer.
+func (s *AnonymousServer) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("ANONYMOUS supports neither integrity nor privacy")
+	}
+	return nil, errors.New("ANONYMOUS authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (s *AnonymousServer) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("ANONYMOUS supports neither integrity nor privacy")
+	}
+	return nil, errors.New("ANONYMOUS authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property. The trace
+// information is available as ANONYMOUS_TRACE_PROPERTY.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (s *AnonymousServer) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.completed {
+		return nil, errors.New("ANONYMOUS authentication not completed")
+	}
+
+	switch propName {
+	case SaslPropertyQop:
+		return "auth", nil
+	case ANONYMOUS_TRACE_PROPERTY:
+		return s.trace, nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (s *AnonymousServer) Dispose() error {
+	return nil
+}
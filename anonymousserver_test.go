@@ -0,0 +1,85 @@
+package sasl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnonymousExchange(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace string
+	}{
+		{"trace", "sirhc@example.com"},
+		{"empty trace", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewAnonymousClient(test.trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewAnonymousServer(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := client.EvaluateChallenge(nil)
+			if err != nil {
+				t.Fatal(err)
+			} else if !client.IsComplete() {
+				t.Fatal("client not complete after its initial response")
+			}
+			if challenge, err := server.EvaluateResponse(response); err != nil {
+				t.Fatal(err)
+			} else if challenge != nil || !server.IsComplete() {
+				t.Fatalf("expected completion, got challenge %q", challenge)
+			}
+			if trace, err := server.GetTrace(); err != nil {
+				t.Fatal(err)
+			} else if trace != test.trace {
+				t.Errorf("trace = %q, want %q", trace, test.trace)
+			}
+		})
+	}
+}
+
+// TestAnonymousServerEmptyResponse checks that an empty initial response
+// completes the exchange without trace, as sent by Cyrus SASL and Java
+// clients.
+func TestAnonymousServerEmptyResponse(t *testing.T) {
+	for _, response := range [][]byte{nil, {}} {
+		server, err := NewAnonymousServer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge, err := server.EvaluateResponse(response); err != nil {
+			t.Fatal(err)
+		} else if challenge != nil || !server.IsComplete() {
+			t.Fatalf("expected completion, got challenge %q", challenge)
+		}
+		if trace, err := server.GetTrace(); err != nil || trace != "" {
+			t.Errorf("trace = %q, %v", trace, err)
+		}
+		if authorizationID, err := server.GetAuthorizationID(); err != nil {
+			t.Fatal(err)
+		} else if authorizationID != DEFAULT_ANONYMOUS_AUTHORIZATION_ID {
+			t.Errorf("authorization ID = %q", authorizationID)
+		}
+		if _, err := server.EvaluateResponse([]byte("trace")); err == nil {
+			t.Error("evaluated a second response")
+		}
+	}
+}
+
+func TestAnonymousServerRejects(t *testing.T) {
+	for _, trace := range []string{"\xff", "line\nbreak", strings.Repeat("x", ANONYMOUS_MAX_TRACE_LENGTH+1)} {
+		server, err := NewAnonymousServer(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.EvaluateResponse([]byte(trace)); err == nil || server.IsComplete() {
+			t.Errorf("accepted trace %q", trace)
+		}
+	}
+}
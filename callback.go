@@ -0,0 +1,308 @@
+package sasl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Callback is a request for information made by a mechanism to the
+// application through a CallbackHandler. The handler fills in the
+// callbacks it recognizes.
+type Callback interface{}
+
+// CallbackHandler retrieves the information requested by mechanisms, such
+// as user names and passwords, on behalf of the application.
+type CallbackHandler interface {
+	// Handle processes the callbacks in order. An
+	// *UnsupportedCallbackError is returned for any callback the handler
+	// does not recognize.
+	Handle(callbacks []Callback) error
+}
+
+// CallbackHandlerFunc adapts a function to the CallbackHandler interface.
+type CallbackHandlerFunc func(callbacks []Callback) error
+
+// Handle calls f(callbacks).
+func (f CallbackHandlerFunc) Handle(callbacks []Callback) error {
+	return f(callbacks)
+}
+
+// UnsupportedCallbackError is returned by a CallbackHandler which does not
+// recognize a callback.
+type UnsupportedCallbackError struct {
+	Callback Callback
+}
+
+func (e *UnsupportedCallbackError) Error() string {
+	return fmt.Sprintf("unsupported callback %T", e.Callback)
+}
+
+// NameCallback retrieves name information.
+type NameCallback struct {
+	prompt      string
+	defaultName string
+	name        string
+}
+
+// NewNameCallback creates a NameCallback with a prompt and a default name.
+func NewNameCallback(prompt, defaultName string) *NameCallback {
+	return &NameCallback{prompt: prompt, defaultName: defaultName}
+}
+
+// GetPrompt returns the prompt.
+func (c *NameCallback) GetPrompt() string {
+	return c.prompt
+}
+
+// GetDefaultName returns the default name, which may be empty.
+func (c *NameCallback) GetDefaultName() string {
+	return c.defaultName
+}
+
+// SetName sets the retrieved name.
+func (c *NameCallback) SetName(name string) {
+	c.name = name
+}
+
+// GetName returns the retrieved name, which is empty if no name was set.
+func (c *NameCallback) GetName() string {
+	return c.name
+}
+
+// PasswordCallback retrieves password information.
+type PasswordCallback struct {
+	prompt   string
+	echoOn   bool
+	password []byte
+}
+
+// NewPasswordCallback creates a PasswordCallback with a prompt and a flag
+// telling whether the password should be displayed as it is being typed.
+func NewPasswordCallback(prompt string, echoOn bool) *PasswordCallback {
+	return &PasswordCallback{prompt: prompt, echoOn: echoOn}
+}
+
+// GetPrompt returns the prompt.
+func (c *PasswordCallback) GetPrompt() string {
+	return c.prompt
+}
+
+// IsEchoOn returns whether the password should be displayed as it is
+// being typed.
+func (c *PasswordCallback) IsEchoOn() bool {
+	return c.echoOn
+}
+
+// SetPassword sets the retrieved password. The callback keeps a copy of
+// password, which is cleared by ClearPassword.
+func (c *PasswordCallback) SetPassword(password []byte) {
+	if password == nil {
+		c.password = nil
+		return
+	}
+	c.password = append([]byte{}, password...)
+}
+
+// GetPassword returns the retrieved password, or nil if no password was
+// set.
+func (c *PasswordCallback) GetPassword() []byte {
+	return c.password
+}
+
+// ClearPassword clears the retrieved password.
+func (c *PasswordCallback) ClearPassword() {
+	ClearBytes(c.password)
+	c.password = nil
+}
+
+// RealmCallback retrieves the realm information of a mechanism. The
+// default text is the realm offered by the server, if any.
+type RealmCallback struct {
+	prompt      string
+	defaultText string
+	text        string
+	textSet     bool
+}
+
+// NewRealmCallback creates a RealmCallback with a prompt and a default
+// realm, which may be empty.
+func NewRealmCallback(prompt, defaultRealm string) *RealmCallback {
+	return &RealmCallback{prompt: prompt, defaultText: defaultRealm}
+}
+
+// GetPrompt returns the prompt.
+func (c *RealmCallback) GetPrompt() string {
+	return c.prompt
+}
+
+// GetDefaultText returns the default realm.
+func (c *RealmCallback) GetDefaultText() string {
+	return c.defaultText
+}
+
+// SetText sets the retrieved realm.
+func (c *RealmCallback) SetText(text string) {
+	c.text = text
+	c.textSet = true
+}
+
+// GetText returns the retrieved realm, or the default realm if no realm
+// was set.
+func (c *RealmCallback) GetText() string {
+	if !c.textSet {
+		return c.defaultText
+	}
+	return c.text
+}
+
+// RealmChoiceCallback lets the application choose one of the realms
+// offered by the server.
+type RealmChoiceCallback struct {
+	prompt          string
+	choices         []string
+	defaultChoice   int
+	multipleAllowed bool
+	selections      []int
+}
+
+// NewRealmChoiceCallback creates a RealmChoiceCallback with a prompt, the
+// realms to choose from, the index of the default choice and whether more
+// than one realm may be selected.
+func NewRealmChoiceCallback(prompt string, choices []string, defaultChoice int, multipleAllowed bool) *RealmChoiceCallback {
+	return &RealmChoiceCallback{
+		prompt:          prompt,
+		choices:         choices,
+		defaultChoice:   defaultChoice,
+		multipleAllowed: multipleAllowed,
+	}
+}
+
+// GetPrompt returns the prompt.
+func (c *RealmChoiceCallback) GetPrompt() string {
+	return c.prompt
+}
+
+// GetChoices returns the realms to choose from.
+func (c *RealmChoiceCallback) GetChoices() []string {
+	return c.choices
+}
+
+// GetDefaultChoice returns the index of the default realm.
+func (c *RealmChoiceCallback) GetDefaultChoice() int {
+	return c.defaultChoice
+}
+
+// AllowMultipleSelections returns whether more than one realm may be
+// selected.
+func (c *RealmChoiceCallback) AllowMultipleSelections() bool {
+	return c.multipleAllowed
+}
+
+// SetSelectedIndex selects the realm at index.
+func (c *RealmChoiceCallback) SetSelectedIndex(index int) {
+	c.selections = []int{index}
+}
+
+// SetSelectedIndexes selects several realms, if multiple selections are
+// allowed.
+func (c *RealmChoiceCallback) SetSelectedIndexes(indexes []int) error {
+	if !c.multipleAllowed && len(indexes) > 1 {
+		return errors.New("multiple selections not allowed")
+	}
+	c.selections = append([]int{}, indexes...)
+	return nil
+}
+
+// GetSelectedIndexes returns the selected indexes, or nil if no realm was
+// selected.
+func (c *RealmChoiceCallback) GetSelectedIndexes() []int {
+	return c.selections
+}
+
+// AuthorizeCallback is used by server mechanisms to decide whether the
+// authenticated user may act on behalf of the requested authorization
+// ID. The handler calls SetAuthorized(true) to grant it, and may replace
+// the authorized ID with a canonical form through SetAuthorizedID.
+type AuthorizeCallback struct {
+	authenticationID string
+	authorizationID  string
+	authorizedID     string
+	authorized       bool
+}
+
+// NewAuthorizeCallback creates an AuthorizeCallback for the authenticated
+// user authenticationID requesting to act as authorizationID.
+func NewAuthorizeCallback(authenticationID, authorizationID string) *AuthorizeCallback {
+	return &AuthorizeCallback{
+		authenticationID: authenticationID,
+		authorizationID:  authorizationID,
+	}
+}
+
+// GetAuthenticationID returns the authenticated user.
+func (c *AuthorizeCallback) GetAuthenticationID() string {
+	return c.authenticationID
+}
+
+// GetAuthorizationID returns the requested authorization ID.
+func (c *AuthorizeCallback) GetAuthorizationID() string {
+	return c.authorizationID
+}
+
+// IsAuthorized returns whether the authorization has been granted.
+func (c *AuthorizeCallback) IsAuthorized() bool {
+	return c.authorized
+}
+
+// SetAuthorized grants or denies the authorization.
+func (c *AuthorizeCallback) SetAuthorized(authorized bool) {
+	c.authorized = authorized
+}
+
+// GetAuthorizedID returns the ID in effect when the authorization has
+// been granted, which is the requested authorization ID unless the
+// handler replaced it. An empty string is returned if the authorization
+// has not been granted.
+func (c *AuthorizeCallback) GetAuthorizedID() string {
+	if !c.authorized {
+		return ""
+	}
+	if len(c.authorizedID) > 0 {
+		return c.authorizedID
+	}
+	return c.authorizationID
+}
+
+// SetAuthorizedID sets the ID in effect once the authorization has been
+// granted.
+func (c *AuthorizeCallback) SetAuthorizedID(authorizedID string) {
+	c.authorizedID = authorizedID
+}
+
+// Authorize asks cbh whether authenticationID may act as authorizationID
+// and returns the authorized ID. An empty authorizationID requests the
+// authenticated identity itself. If cbh is nil or does not support
+// AuthorizeCallback, users may only act as themselves.
+func Authorize(cbh CallbackHandler, authenticationID, authorizationID string) (string, error) {
+	if len(authorizationID) <= 0 {
+		authorizationID = authenticationID
+	}
+	if cbh == nil {
+		if authorizationID != authenticationID {
+			return "", fmt.Errorf("%s is not authorized to act as %s", authenticationID, authorizationID)
+		}
+		return authorizationID, nil
+	}
+
+	acb := NewAuthorizeCallback(authenticationID, authorizationID)
+	if err := cbh.Handle([]Callback{acb}); err != nil {
+		if _, ok := err.(*UnsupportedCallbackError); ok && authorizationID == authenticationID {
+			return authorizationID, nil
+		}
+		return "", err
+	}
+	if !acb.IsAuthorized() {
+		return "", fmt.Errorf("%s is not authorized to act as %s", authenticationID, authorizationID)
+	}
+	return acb.GetAuthorizedID(), nil
+}
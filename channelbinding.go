@@ -0,0 +1,183 @@
+package sasl
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Channel binding types (RFC 5929, RFC 9266).
+const (
+	CHANNEL_BINDING_TLS_UNIQUE           = "tls-unique"
+	CHANNEL_BINDING_TLS_SERVER_END_POINT = "tls-server-end-point"
+	CHANNEL_BINDING_TLS_EXPORTER         = "tls-exporter"
+
+	TLS_EXPORTER_LABEL  = "EXPORTER-Channel-Binding"
+	TLS_EXPORTER_LENGTH = 32
+)
+
+const (
+	// SaslPropertyChannelBinding is a property that specifies the channel
+	// bindings of the connection the exchange is carried over. The
+	// property contains ChannelBindings, a single *ChannelBinding or a
+	// []*ChannelBinding. Mechanisms supporting channel binding bind the
+	// exchange to one of them; the others ignore the property.
+	// Once the exchange has completed, the *ChannelBinding the peer was
+	// verified against is available as a negotiated property, or nil if
+	// the exchange was not bound to the channel.
+	// The value of this constant is
+	// "golang.security.sasl.channel.binding".
+	SaslPropertyChannelBinding = "golang.security.sasl.channel.binding"
+
+	// SaslPropertyChannelBindingType is a property that specifies the
+	// channel binding type a client binds the exchange with, such as
+	// "tls-exporter". If this property is absent, the first of the
+	// ChannelBindings is used.
+	// Once the exchange has completed, the type of the verified binding is
+	// available as a negotiated property.
+	// The value of this constant is
+	// "golang.security.sasl.channel.binding.type".
+	SaslPropertyChannelBindingType = "golang.security.sasl.channel.binding.type"
+)
+
+// ChannelBinding is the channel binding data of one type, which
+// identifies the secure channel an exchange is carried over.
+type ChannelBinding struct {
+	Type string
+	Data []byte
+}
+
+// String returns the channel binding type.
+func (cb *ChannelBinding) String() string {
+	return cb.Type
+}
+
+// ChannelBindings are the channel bindings available on a connection, in
+// order of preference.
+type ChannelBindings []*ChannelBinding
+
+// GetTypes returns the channel binding types, in order of preference.
+func (b ChannelBindings) GetTypes() []string {
+	types := make([]string, 0, len(b))
+	for _, cb := range b {
+		types = append(types, cb.Type)
+	}
+	return types
+}
+
+// Get returns the channel binding of cbType, or nil if it is not
+// available.
+func (b ChannelBindings) Get(cbType string) *ChannelBinding {
+	for _, cb := range b {
+		if cb.Type == cbType {
+			return cb
+		}
+	}
+	return nil
+}
+
+// Select returns the channel binding of cbType, or the preferred one if
+// cbType is empty.
+func (b ChannelBindings) Select(cbType string) (*ChannelBinding, error) {
+	if len(b) <= 0 {
+		return nil, errors.New("no channel binding available")
+	}
+	if len(cbType) <= 0 {
+		return b[0], nil
+	}
+	if cb := b.Get(cbType); cb != nil {
+		return cb, nil
+	}
+	return nil, fmt.Errorf("channel binding type %s not available", cbType)
+}
+
+// NewTLSClientChannelBindings returns the channel bindings of the client
+// side of a TLS connection. The 'tls-server-end-point' binding is
+// derived from the certificate presented by the server.
+//
+// Over TLS 1.3, 'tls-exporter' is preferred and 'tls-unique' is not
+// available. Over earlier versions, 'tls-unique' is preferred, and
+// 'tls-exporter' is only available if the extended master secret was
+// negotiated (RFC 9266 section 3).
+func NewTLSClientChannelBindings(state *tls.ConnectionState) (ChannelBindings, error) {
+	var certificate *x509.Certificate
+	if state != nil && len(state.PeerCertificates) > 0 {
+		certificate = state.PeerCertificates[0]
+	}
+	return newTLSChannelBindings(state, certificate)
+}
+
+// NewTLSServerChannelBindings returns the channel bindings of the server
+// side of a TLS connection. The 'tls-server-end-point' binding is
+// derived from certificate, the certificate the server presented; it is
+// omitted if certificate is nil.
+func NewTLSServerChannelBindings(state *tls.ConnectionState, certificate *x509.Certificate) (ChannelBindings, error) {
+	return newTLSChannelBindings(state, certificate)
+}
+
+func newTLSChannelBindings(state *tls.ConnectionState, certificate *x509.Certificate) (ChannelBindings, error) {
+	if state == nil || !state.HandshakeComplete {
+		return nil, errors.New("TLS handshake not completed")
+	}
+	bindings := ChannelBindings{}
+	if state.Version < tls.VersionTLS13 && len(state.TLSUnique) > 0 {
+		bindings = append(bindings, &ChannelBinding{
+			Type: CHANNEL_BINDING_TLS_UNIQUE,
+			Data: append([]byte{}, state.TLSUnique...),
+		})
+	}
+	if data, err := state.ExportKeyingMaterial(TLS_EXPORTER_LABEL, nil, TLS_EXPORTER_LENGTH); err == nil {
+		exporter := &ChannelBinding{Type: CHANNEL_BINDING_TLS_EXPORTER, Data: data}
+		if state.Version >= tls.VersionTLS13 {
+			bindings = append(ChannelBindings{exporter}, bindings...)
+		} else {
+			bindings = append(bindings, exporter)
+		}
+	}
+	if certificate != nil {
+		bindings = append(bindings, NewTLSServerEndPointChannelBinding(certificate))
+	}
+	return bindings, nil
+}
+
+// NewTLSServerEndPointChannelBinding returns the 'tls-server-end-point'
+// binding of certificate: its hash with the hash function of its
+// signature algorithm, SHA-256 being used in place of MD5 and SHA-1
+// (RFC 5929 section 4.1).
+func NewTLSServerEndPointChannelBinding(certificate *x509.Certificate) *ChannelBinding {
+	h := crypto.SHA256
+	switch certificate.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = crypto.SHA512
+	}
+	hash := h.New()
+	hash.Write(certificate.Raw)
+	return &ChannelBinding{Type: CHANNEL_BINDING_TLS_SERVER_END_POINT, Data: hash.Sum(nil)}
+}
+
+// GetChannelBindings returns the channel bindings held by the
+// SaslPropertyChannelBinding property, or nil if there are none.
+func GetChannelBindings(props map[string]interface{}) ChannelBindings {
+	if props == nil {
+		return nil
+	}
+	var bindings ChannelBindings
+	switch value := props[SaslPropertyChannelBinding].(type) {
+	case ChannelBindings:
+		bindings = value
+	case []*ChannelBinding:
+		bindings = value
+	case *ChannelBinding:
+		if value != nil {
+			bindings = ChannelBindings{value}
+		}
+	}
+	if len(bindings) <= 0 {
+		return nil
+	}
+	return bindings
+}
@@ -0,0 +1,145 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestChannelBindingsSelect(t *testing.T) {
+	bindings := ChannelBindings{
+		{Type: CHANNEL_BINDING_TLS_EXPORTER, Data: []byte("ekm")},
+		{Type: CHANNEL_BINDING_TLS_SERVER_END_POINT, Data: []byte("hash")},
+	}
+	tests := []struct {
+		cbType string
+		want   string
+	}{
+		{"", CHANNEL_BINDING_TLS_EXPORTER},
+		{CHANNEL_BINDING_TLS_SERVER_END_POINT, CHANNEL_BINDING_TLS_SERVER_END_POINT},
+		{CHANNEL_BINDING_TLS_UNIQUE, ""},
+	}
+	for _, test := range tests {
+		cb, err := bindings.Select(test.cbType)
+		if len(test.want) <= 0 {
+			if err == nil {
+				t.Errorf("Select(%q) = %s, want error", test.cbType, cb)
+			}
+		} else if err != nil {
+			t.Errorf("Select(%q): %s", test.cbType, err)
+		} else if cb.Type != test.want {
+			t.Errorf("Select(%q) = %s, want %s", test.cbType, cb, test.want)
+		}
+	}
+	if _, err := (ChannelBindings{}).Select(""); err == nil {
+		t.Error("Select on no channel bindings succeeded")
+	}
+}
+
+func TestGetChannelBindings(t *testing.T) {
+	cb := &ChannelBinding{Type: CHANNEL_BINDING_TLS_EXPORTER, Data: []byte("ekm")}
+	tests := []struct {
+		value interface{}
+		want  int
+	}{
+		{nil, 0},
+		{cb, 1},
+		{(*ChannelBinding)(nil), 0},
+		{[]*ChannelBinding{cb, cb}, 2},
+		{ChannelBindings{}, 0},
+		{"tls-exporter", 0},
+	}
+	for _, test := range tests {
+		props := map[string]interface{}{SaslPropertyChannelBinding: test.value}
+		if bindings := GetChannelBindings(props); len(bindings) != test.want {
+			t.Errorf("GetChannelBindings(%#v) = %v, want %d bindings", test.value, bindings, test.want)
+		} else if test.want == 0 && bindings != nil {
+			t.Errorf("GetChannelBindings(%#v) = %#v, want nil", test.value, bindings)
+		}
+	}
+}
+
+// TestTLSChannelBindings checks that both ends of a TLS connection derive
+// the same channel bindings, in the order of preference of the version.
+func TestTLSChannelBindings(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "localhost"},
+		NotBefore:          time.Now().Add(-time.Hour),
+		NotAfter:           time.Now().Add(time.Hour),
+		SignatureAlgorithm: x509.ECDSAWithSHA384,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version uint16
+		types   []string
+	}{
+		{tls.VersionTLS12, []string{CHANNEL_BINDING_TLS_UNIQUE, CHANNEL_BINDING_TLS_EXPORTER, CHANNEL_BINDING_TLS_SERVER_END_POINT}},
+		{tls.VersionTLS13, []string{CHANNEL_BINDING_TLS_EXPORTER, CHANNEL_BINDING_TLS_SERVER_END_POINT}},
+	}
+	for _, test := range tests {
+		t.Run(tls.VersionName(test.version), func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			server := tls.Server(serverConn, &tls.Config{
+				Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+				MinVersion:   test.version,
+				MaxVersion:   test.version,
+			})
+			client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, MinVersion: test.version, MaxVersion: test.version})
+			done := make(chan error, 1)
+			go func() {
+				done <- server.Handshake()
+			}()
+			if err := client.Handshake(); err != nil {
+				t.Fatal(err)
+			} else if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			clientState, serverState := client.ConnectionState(), server.ConnectionState()
+			clientBindings, err := NewTLSClientChannelBindings(&clientState)
+			if err != nil {
+				t.Fatal(err)
+			}
+			serverBindings, err := NewTLSServerChannelBindings(&serverState, certificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if types := clientBindings.GetTypes(); len(types) != len(test.types) {
+				t.Fatalf("client channel bindings %v, want %v", types, test.types)
+			}
+			for i, cbType := range test.types {
+				clientCB, serverCB := clientBindings[i], serverBindings.Get(cbType)
+				if clientCB.Type != cbType || serverCB == nil {
+					t.Fatalf("client channel bindings %v, server %v, want %v",
+						clientBindings.GetTypes(), serverBindings.GetTypes(), test.types)
+				} else if len(clientCB.Data) <= 0 || !bytes.Equal(clientCB.Data, serverCB.Data) {
+					t.Errorf("%s differs: client %x, server %x", cbType, clientCB.Data, serverCB.Data)
+				}
+			}
+			if endPoint := serverBindings.Get(CHANNEL_BINDING_TLS_SERVER_END_POINT); len(endPoint.Data) != 48 {
+				t.Errorf("tls-server-end-point of a SHA-384 certificate has %d octets", len(endPoint.Data))
+			}
+		})
+	}
+}
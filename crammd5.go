@@ -0,0 +1,172 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+)
+
+const (
+	// CRAM_MD5_CONTEXT_PREFIX is the scheme prefix of CRAM-MD5 contexts in
+	// Dovecot password databases.
+	CRAM_MD5_CONTEXT_PREFIX = "{CRAM-MD5}"
+	// CRAM_MD5_CONTEXT_LENGTH is the length in octets of an encoded
+	// CramMD5Context.
+	CRAM_MD5_CONTEXT_LENGTH = 32
+
+	md5StateMagic = "md5\x01"
+)
+
+// CramMD5Context holds the precomputed HMAC-MD5 contexts of a password:
+// the MD5 chaining values after hashing the inner and the outer padded
+// key. A server keeping the context in place of the password can verify
+// CRAM-MD5 responses, although the context is as sensitive as the
+// password for CRAM-MD5 itself.
+//
+// The encoded form is the one of Dovecot's CRAM-MD5 password scheme: the
+// outer, then the inner chaining values, each as four little-endian
+// 32-bit words, in hexadecimal.
+type CramMD5Context struct {
+	Inner [4]uint32
+	Outer [4]uint32
+}
+
+// NewCramMD5Context computes the HMAC-MD5 contexts of password.
+//
+// The contexts are read from, and later restored into, the marshaled
+// state of crypto/md5, whose layout is not part of its API. The HMAC of
+// the new context is therefore checked against crypto/hmac, so that a
+// change of the layout fails instead of producing wrong contexts.
+func NewCramMD5Context(password []byte) (*CramMD5Context, error) {
+	key := password
+	if len(key) > md5.BlockSize {
+		sum := md5.Sum(key)
+		key = sum[:]
+	}
+	ipad := make([]byte, md5.BlockSize)
+	opad := make([]byte, md5.BlockSize)
+	copy(ipad, key)
+	copy(opad, key)
+	for i := range ipad {
+		ipad[i] ^= 0x36
+		opad[i] ^= 0x5c
+	}
+	defer ClearBytes(ipad)
+	defer ClearBytes(opad)
+
+	ctx := &CramMD5Context{}
+	var err error
+	if ctx.Inner, err = md5ChainingValue(ipad); err != nil {
+		return nil, err
+	}
+	if ctx.Outer, err = md5ChainingValue(opad); err != nil {
+		return nil, err
+	}
+
+	sum, err := ctx.HMAC([]byte(md5StateMagic))
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(md5.New, password)
+	mac.Write([]byte(md5StateMagic))
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, errors.New("CRAM-MD5: unsupported crypto/md5 state format")
+	}
+	return ctx, nil
+}
+
+// ParseCramMD5Context decodes a context in the Dovecot format, with or
+// without the CRAM_MD5_CONTEXT_PREFIX scheme prefix.
+func ParseCramMD5Context(encoded string) (*CramMD5Context, error) {
+	if len(encoded) >= len(CRAM_MD5_CONTEXT_PREFIX) &&
+		strings.EqualFold(encoded[:len(CRAM_MD5_CONTEXT_PREFIX)], CRAM_MD5_CONTEXT_PREFIX) {
+		encoded = encoded[len(CRAM_MD5_CONTEXT_PREFIX):]
+	}
+	raw, err := hex.DecodeString(encoded)
+	if err != nil || len(raw) != CRAM_MD5_CONTEXT_LENGTH {
+		return nil, errors.New("CRAM-MD5: invalid context")
+	}
+	ctx := &CramMD5Context{}
+	for i := 0; i < 4; i++ {
+		ctx.Outer[i] = binary.LittleEndian.Uint32(raw[4*i:])
+		ctx.Inner[i] = binary.LittleEndian.Uint32(raw[16+4*i:])
+	}
+	return ctx, nil
+}
+
+// String encodes the context in the Dovecot format, without the scheme
+// prefix.
+func (c *CramMD5Context) String() string {
+	raw := make([]byte, CRAM_MD5_CONTEXT_LENGTH)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(raw[4*i:], c.Outer[i])
+		binary.LittleEndian.PutUint32(raw[16+4*i:], c.Inner[i])
+	}
+	return hex.EncodeToString(raw)
+}
+
+// HMAC computes the HMAC-MD5 of data keyed with the password of the
+// context.
+func (c *CramMD5Context) HMAC(data []byte) ([]byte, error) {
+	inner, err := resumeMD5(c.Inner)
+	if err != nil {
+		return nil, err
+	}
+	inner.Write(data)
+	outer, err := resumeMD5(c.Outer)
+	if err != nil {
+		return nil, err
+	}
+	outer.Write(inner.Sum(nil))
+	return outer.Sum(nil), nil
+}
+
+// md5ChainingValue returns the MD5 chaining value after hashing block.
+// The value is read from the state marshaled by crypto/md5:
+//
+//	magic "md5\x01" || s[0..3] big-endian || buffered block || length
+func md5ChainingValue(block []byte) ([4]uint32, error) {
+	var words [4]uint32
+	h := md5.New()
+	h.Write(block)
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return words, err
+	}
+	defer ClearBytes(state)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint32(state[len(md5StateMagic)+4*i:])
+	}
+	return words, nil
+}
+
+// resumeMD5 returns an MD5 hash which has already hashed one block,
+// leaving it with chaining value words.
+func resumeMD5(words [4]uint32) (hash.Hash, error) {
+	state := make([]byte, 0, len(md5StateMagic)+4*4+md5.BlockSize+8)
+	state = append(state, md5StateMagic...)
+	for _, word := range words {
+		state = binary.BigEndian.AppendUint32(state, word)
+	}
+	state = append(state, make([]byte, md5.BlockSize)...)
+	state = binary.BigEndian.AppendUint64(state, md5.BlockSize)
+
+	h := md5.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// hmacMD5 computes the hex-encoded HMAC-MD5 of data keyed with password,
+// as sent in a CRAM-MD5 response.
+func hmacMD5(password, data []byte) string {
+	mac := hmac.New(md5.New, password)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
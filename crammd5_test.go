@@ -0,0 +1,146 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"testing"
+)
+
+// RFC 2195 section 2 example.
+const (
+	cramMD5Challenge = "<1896.697170952@postoffice.reston.mci.net>"
+	cramMD5Response  = "tim b913a602c7eda7a495b4e6e7334d3890"
+)
+
+// cramMD5Handler answers the callbacks of CRAM-MD5 clients and servers
+// for user "tim", with the HMAC-MD5 contexts of the password if context
+// is not nil.
+func cramMD5Handler(context *CramMD5Context) CallbackHandler {
+	return CallbackHandlerFunc(func(callbacks []Callback) error {
+		for _, callback := range callbacks {
+			switch cb := callback.(type) {
+			case *NameCallback:
+				cb.SetName("tim")
+			case *PasswordCallback:
+				cb.SetPassword([]byte("tanstaaftanstaaf"))
+			case *CramMD5ContextCallback:
+				if context == nil {
+					return &UnsupportedCallbackError{Callback: callback}
+				}
+				cb.SetContext(context)
+			default:
+				return &UnsupportedCallbackError{Callback: callback}
+			}
+		}
+		return nil
+	})
+}
+
+func TestCramMD5ClientRFCVector(t *testing.T) {
+	client, err := NewCramMD5Client("tim", []byte("tanstaaftanstaaf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.EvaluateChallenge([]byte(cramMD5Challenge))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(response) != cramMD5Response {
+		t.Errorf("response = %q, want %q", response, cramMD5Response)
+	}
+}
+
+func TestCramMD5ServerRFCVector(t *testing.T) {
+	context, err := NewCramMD5Context([]byte("tanstaaftanstaaf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		context  *CramMD5Context
+		response string
+		valid    bool
+	}{
+		{"password", nil, cramMD5Response, true},
+		{"context", context, cramMD5Response, true},
+		{"wrong digest", context, "tim b913a602c7eda7a495b4e6e7334d3891", false},
+		{"missing user", context, "b913a602c7eda7a495b4e6e7334d3890", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := NewCramMD5Server("imap", "postoffice.reston.mci.net", nil, cramMD5Handler(test.context))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.EvaluateResponse(nil); err != nil {
+				t.Fatal(err)
+			}
+			server.challengeData = []byte(cramMD5Challenge)
+
+			_, err = server.EvaluateResponse([]byte(test.response))
+			if !test.valid {
+				if err == nil || server.IsComplete() {
+					t.Fatal("invalid response accepted")
+				}
+				// The exchange ends with the first failure.
+				if _, err := server.EvaluateResponse([]byte(cramMD5Response)); err == nil || server.IsComplete() {
+					t.Error("accepted a response after a failure")
+				} else if server.challengeData != nil {
+					t.Error("challenge kept after a failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "tim" {
+				t.Errorf("authorization ID = %q, %v", authorizationID, err)
+			}
+		})
+	}
+}
+
+// TestCramMD5ContextFormat checks the encoding of contexts against the
+// example of the Dovecot password scheme documentation, for password
+// "test".
+func TestCramMD5ContextFormat(t *testing.T) {
+	const encoded = "e02d374fde0dc75a17a557039a3a5338c7743304777dccd376f332bee68d2cf6"
+
+	context, err := NewCramMD5Context([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	} else if context.String() != encoded {
+		t.Errorf("context = %s, want %s", context, encoded)
+	}
+
+	for _, input := range []string{encoded, CRAM_MD5_CONTEXT_PREFIX + encoded, "{cram-md5}" + encoded} {
+		parsed, err := ParseCramMD5Context(input)
+		if err != nil {
+			t.Fatalf("ParseCramMD5Context(%q): %s", input, err)
+		} else if *parsed != *context {
+			t.Errorf("ParseCramMD5Context(%q) = %s", input, parsed)
+		}
+	}
+	for _, input := range []string{"", encoded[2:], "{PLAIN}" + encoded, encoded[:62] + "zz"} {
+		if _, err := ParseCramMD5Context(input); err == nil {
+			t.Errorf("ParseCramMD5Context(%q) succeeded", input)
+		}
+	}
+}
+
+func TestCramMD5ContextHMAC(t *testing.T) {
+	for _, password := range []string{"", "test", "tanstaaftanstaaf", string(make([]byte, 100))} {
+		context, err := NewCramMD5Context([]byte(password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := context.HMAC([]byte(cramMD5Challenge))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac := hmac.New(md5.New, []byte(password))
+		mac.Write([]byte(cramMD5Challenge))
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			t.Errorf("HMAC of a %d octet password differs", len(password))
+		}
+	}
+}
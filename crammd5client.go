@@ -0,0 +1,128 @@
+package sasl
+
+import "errors"
+
+// CramMD5Client implements the CRAM-MD5 SASL client mechanism
+// https://tools.ietf.org/html/rfc2195
+//
+// The client has no initial response. It answers the challenge of the
+// server with the user name and the HMAC-MD5 of the challenge keyed with
+// the password, and completes once it has sent that response.
+type CramMD5Client struct {
+	completed        bool
+	cbh              CallbackHandler
+	pw               []byte
+	authorizationID  string
+	authenticationID string
+}
+
+// NewCramMD5Client creates a new CramMD5Client instance.
+func NewCramMD5Client(authenticationID string, pw []byte) (*CramMD5Client, error) {
+	if len(authenticationID) <= 0 || pw == nil {
+		return nil, errors.New("CRAM-MD5: authentication ID and password must be specified")
+	}
+	client := &CramMD5Client{
+		authenticationID: authenticationID,
+		pw:               pw,
+	}
+	return client, nil
+}
+
+// NewCramMD5ClientWithHandler creates a CramMD5Client which retrieves the
+// authentication ID and password through cbh when the challenge is
+// evaluated. The authorization ID is offered as default name; CRAM-MD5
+// cannot carry an authorization ID on its own.
+func NewCramMD5ClientWithHandler(authorizationID string, cbh CallbackHandler) (*CramMD5Client, error) {
+	if cbh == nil {
+		return nil, errors.New("CRAM-MD5: callback handler to get username/password required")
+	}
+	client := &CramMD5Client{
+		authorizationID: authorizationID,
+		cbh:             cbh,
+	}
+	return client, nil
+}
+
+// GetMechanismName returns the mechanism name "CRAM-MD5".
+func (c *CramMD5Client) GetMechanismName() string {
+	return "CRAM-MD5"
+}
+
+// HasInitialResponse returns false: the server speaks first.
+func (c *CramMD5Client) HasInitialResponse() bool {
+	return false
+}
+
+// EvaluateChallenge processes the challenge of the server, returning the
+// user name, a space, and the HMAC-MD5 of the challenge in lower-case
+// hexadecimal.
+func (c *CramMD5Client) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("CRAM-MD5 authentication already completed")
+	}
+	if len(challengeData) <= 0 {
+		return nil, errors.New("CRAM-MD5: challenge expected")
+	}
+	if c.pw == nil {
+		authenticationID, pw, err := getUserInfo("CRAM-MD5", c.authorizationID, c.cbh)
+		if err != nil {
+			return nil, err
+		}
+		c.authenticationID, c.pw = authenticationID, pw
+	}
+	digest := hmacMD5(c.pw, challengeData)
+	c.clearPassword()
+	c.completed = true
+	return []byte(c.authenticationID + " " + digest), nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+// CRAM-MD5 completes after returning one response.
+func (c *CramMD5Client) IsComplete() bool {
+	return c.completed
+}
+
+// Unwrap the incoming buffer.
+func (c *CramMD5Client) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("CRAM-MD5 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("CRAM-MD5 authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (c *CramMD5Client) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("CRAM-MD5 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("CRAM-MD5 authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (c *CramMD5Client) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !c.completed {
+		return nil, errors.New("CRAM-MD5 authentication not completed")
+	}
+
+	if propName == SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (c *CramMD5Client) Dispose() error {
+	c.clearPassword()
+	return nil
+}
+
+func (c *CramMD5Client) clearPassword() {
+	if c.pw == nil {
+		return
+	}
+	ClearBytes(c.pw)
+	c.pw = nil
+}
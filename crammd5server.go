@@ -0,0 +1,251 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CramMD5ContextCallback retrieves the precomputed HMAC-MD5 contexts of
+// the password of a user, for servers which do not store the plaintext
+// password. It is passed to the callback handler along with a
+// NameCallback holding the user name.
+type CramMD5ContextCallback struct {
+	prompt  string
+	context *CramMD5Context
+}
+
+// NewCramMD5ContextCallback creates a CramMD5ContextCallback with a
+// prompt.
+func NewCramMD5ContextCallback(prompt string) *CramMD5ContextCallback {
+	return &CramMD5ContextCallback{prompt: prompt}
+}
+
+// GetPrompt returns the prompt.
+func (c *CramMD5ContextCallback) GetPrompt() string {
+	return c.prompt
+}
+
+// SetContext sets the retrieved context, or nil if the user is unknown.
+func (c *CramMD5ContextCallback) SetContext(context *CramMD5Context) {
+	c.context = context
+}
+
+// GetContext returns the retrieved context, or nil if none was set.
+func (c *CramMD5ContextCallback) GetContext() *CramMD5Context {
+	return c.context
+}
+
+// CramMD5Server implements the CRAM-MD5 SASL server mechanism
+// https://tools.ietf.org/html/rfc2195
+//
+// The server sends a challenge of the form
+// <random.timestamp@hostname>, where the host name is the server name
+// the server was created with, or, for an unbound server, the value of
+// SaslPropertyBoundServerName, falling back to the name of the local
+// host. The host name in use is reported by the negotiated property
+// SaslPropertyBoundServerName.
+//
+// The response is verified with the HMAC-MD5 contexts retrieved through
+// the callback handler with a NameCallback and a CramMD5ContextCallback.
+// If the handler does not support CramMD5ContextCallback, the password is
+// retrieved with a PasswordCallback instead. The user name is then checked
+// with an AuthorizeCallback.
+type CramMD5Server struct {
+	completed       bool
+	failed          bool
+	cbh             CallbackHandler
+	fqdn            string
+	challengeData   []byte
+	authorizationID string
+}
+
+// NewCramMD5Server creates a new CramMD5Server instance.
+func NewCramMD5Server(protocol, serverName string, props map[string]interface{}, cbh CallbackHandler) (*CramMD5Server, error) {
+	if cbh == nil {
+		return nil, errors.New("CRAM-MD5: callback handler to get password required")
+	}
+	if err := CheckMechanismPolicy("CRAM-MD5", props); err != nil {
+		return nil, err
+	}
+	fqdn := serverName
+	if len(fqdn) <= 0 {
+		fqdn = PropertyValue(props, SaslPropertyBoundServerName)
+	}
+	if len(fqdn) <= 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("CRAM-MD5: cannot determine server name: %s", err)
+		}
+		fqdn = hostname
+	}
+	server := &CramMD5Server{
+		cbh:  cbh,
+		fqdn: fqdn,
+	}
+	return server, nil
+}
+
+// GetMechanismName returns the mechanism name "CRAM-MD5".
+func (s *CramMD5Server) GetMechanismName() string {
+	return "CRAM-MD5"
+}
+
+// EvaluateResponse generates the challenge on the first call, and
+// verifies the response of the client on the second one. CRAM-MD5
+// does not accept an initial response. A response which is malformed or
+// fails verification ends the exchange.
+func (s *CramMD5Server) EvaluateResponse(response []byte) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("CRAM-MD5 authentication already completed")
+	} else if s.failed {
+		return nil, errors.New("CRAM-MD5 authentication already failed")
+	}
+	if s.challengeData == nil {
+		if len(response) > 0 {
+			return nil, s.fail(errors.New("CRAM-MD5 does not expect any initial response"))
+		}
+		challenge, err := s.generateChallenge()
+		if err != nil {
+			return nil, err
+		}
+		s.challengeData = challenge
+		return challenge, nil
+	}
+
+	index := bytes.LastIndexByte(response, ' ')
+	if index <= 0 {
+		return nil, s.fail(errors.New("CRAM-MD5: invalid response format: missing user name"))
+	}
+	username, digest := string(response[:index]), string(response[index+1:])
+	if len(digest) != 2*16 {
+		return nil, s.fail(errors.New("CRAM-MD5: invalid response format: invalid digest"))
+	}
+
+	context, err := s.getContext(username)
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	expected, err := context.HMAC(s.challengeData)
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(expected)), []byte(digest)) != 1 {
+		return nil, s.fail(errors.New("CRAM-MD5: authentication failed"))
+	}
+
+	authorizationID, err := Authorize(s.cbh, username, "")
+	if err != nil {
+		return nil, s.fail(errors.New("CRAM-MD5: " + err.Error()))
+	}
+	s.authorizationID = authorizationID
+	s.challengeData = nil
+	s.completed = true
+	return nil, nil
+}
+
+// fail aborts the exchange, so that the server cannot be used any further.
+func (s *CramMD5Server) fail(err error) error {
+	s.challengeData = nil
+	s.failed = true
+	return err
+}
+
+// generateChallenge returns a challenge of the form
+// <random.timestamp@hostname>.
+func (s *CramMD5Server) generateChallenge() ([]byte, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	challenge := fmt.Sprintf("<%d.%d@%s>", binary.BigEndian.Uint64(random), time.Now().UnixMilli(), s.fqdn)
+	return []byte(challenge), nil
+}
+
+// getContext retrieves the HMAC-MD5 contexts of the password of
+// username through the callback handler.
+func (s *CramMD5Server) getContext(username string) (*CramMD5Context, error) {
+	ncb := NewNameCallback("CRAM-MD5 authentication ID: ", username)
+	ccb := NewCramMD5ContextCallback("CRAM-MD5 context: ")
+	err := s.cbh.Handle([]Callback{ncb, ccb})
+	if unsupported, ok := err.(*UnsupportedCallbackError); ok && unsupported.Callback == ccb {
+		pcb := NewPasswordCallback("CRAM-MD5 password: ", false)
+		defer pcb.ClearPassword()
+		if err := s.cbh.Handle([]Callback{ncb, pcb}); err != nil {
+			return nil, err
+		}
+		if pcb.GetPassword() == nil {
+			return nil, errors.New("CRAM-MD5: authentication failed")
+		}
+		return NewCramMD5Context(pcb.GetPassword())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ccb.GetContext() == nil {
+		return nil, errors.New("CRAM-MD5: authentication failed")
+	}
+	return ccb.GetContext(), nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+func (s *CramMD5Server) IsComplete() bool {
+	return s.completed
+}
+
+// GetAuthorizationID returns the authorization ID of the client, which is
+// its user name.
+func (s *CramMD5Server) GetAuthorizationID() (string, error) {
+	if !s.completed {
+		return "", errors.New("CRAM-MD5 authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// Unwrap the incoming buffer.
+func (s *CramMD5Server) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("CRAM-MD5 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("CRAM-MD5 authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (s *CramMD5Server) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("CRAM-MD5 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("CRAM-MD5 authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property. The host name
+// used in the challenge is available as SaslPropertyBoundServerName.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (s *CramMD5Server) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.completed {
+		return nil, errors.New("CRAM-MD5 authentication not completed")
+	}
+
+	switch propName {
+	case SaslPropertyQop:
+		return "auth", nil
+	case SaslPropertyBoundServerName:
+		return s.fqdn, nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (s *CramMD5Server) Dispose() error {
+	s.challengeData = nil
+	s.cbh = nil
+	return nil
+}
@@ -0,0 +1,54 @@
+package digest
+
+import (
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+const (
+	MECHANISM_NAME = "DIGEST-MD5"
+)
+
+func init() {
+	sasl.RegisterMechanismPolicy(MECHANISM_NAME, sasl.POLICY_NOANONYMOUS|sasl.POLICY_NOPLAINTEXT)
+	sasl.RegisterClientFactory(&clientFactory{})
+	sasl.RegisterServerFactory(&serverFactory{})
+}
+
+// clientFactory creates DIGEST-MD5 clients.
+type clientFactory struct{}
+
+// GetMechanismNames returns the DIGEST-MD5 mechanism if props allow it.
+func (f *clientFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms([]string{MECHANISM_NAME}, props)
+}
+
+// CreateClient creates a DIGEST-MD5 client if it is requested. The
+// username, password and realm are retrieved through cbh.
+func (f *clientFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Client, error) {
+	for _, mechanism := range mechanisms {
+		if mechanism != MECHANISM_NAME {
+			continue
+		}
+		return NewMD5Client(authorizationID, protocol, serverName, props, cbh)
+	}
+	return nil, nil
+}
+
+// serverFactory creates DIGEST-MD5 servers.
+type serverFactory struct{}
+
+// GetMechanismNames returns the DIGEST-MD5 mechanism if props allow it.
+func (f *serverFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms([]string{MECHANISM_NAME}, props)
+}
+
+// CreateServer creates a DIGEST-MD5 server whose passwords and
+// authorization decisions are retrieved through cbh.
+func (f *serverFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Server, error) {
+	if mechanism != MECHANISM_NAME {
+		return nil, nil
+	}
+	return NewMD5Server(protocol, serverName, props, cbh)
+}
@@ -1,11 +1,36 @@
 package digest
 
-import sasl "github.com/jellybean4/go-sasl"
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
 
 const (
 	MAX_CHALLENGE_LENGTH = 2048
 	MAX_RESPONSE_LENGTH  = 4096
 	DEFAULT_MAXBUF       = 65536
+	RAW_NONCE_SIZE       = 30
+)
+
+const (
+	// CIPHER_PROPERTY names the cipher to use for 'auth-conf'. If it is
+	// set, the mechanism only negotiates this cipher; it is also available
+	// as a negotiated property after the exchange has completed.
+	CIPHER_PROPERTY = "golang.security.sasl.digest.cipher"
+
+	// UTF8_PROPERTY specifies whether the server advertises charset=utf-8.
+	// The property contains "false" to use ISO 8859-1 only; the default
+	// is "true".
+	UTF8_PROPERTY = "golang.security.sasl.digest.utf8"
 )
 
 // Supported ciphers for 'auth-conf'
@@ -42,7 +67,7 @@ const (
 )
 
 var (
-	CIPHER_MASKS    = []byte{DES_3_STRENGTH, RC4_STRENGTH, DES_3_STRENGTH, RC4_56_STRENGTH, RC4_40_STRENGTH}
+	CIPHER_MASKS    = []byte{DES_3_STRENGTH, RC4_STRENGTH, DES_STRENGTH, RC4_56_STRENGTH, RC4_40_STRENGTH}
 	CIPHER_TOKENS   = []string{"3des", "rc4", "des", "rc4-56", "rc4-40"}
 	JCE_CIPHER_NAME = []string{"DESede/CBC/NoPadding", "RC4", "DES/CBC/NoPadding"}
 )
@@ -55,6 +80,354 @@ var (
 // privacy.
 type MD5Base struct {
 	*sasl.Sasl
-	hA1              []byte
-	negotiatedCipher string
+	hA1                []byte
+	negotiatedCipher   string
+	negotiatedQop      string
+	negotiatedRealm    string
+	negotiatedStrength string
+	nonce              []byte
+	cnonce             []byte
+	digestURI          string
+	authorizationID    string
+	useUTF8            bool
+	step               int
+	secCtx             SecurityCtx
+}
+
+// newMD5Base creates the state shared by DIGEST-MD5 clients and servers.
+// The quality-of-protection, strength and receive buffer preferences are
+// taken from props, whose policy properties must allow DIGEST-MD5.
+func newMD5Base(props map[string]interface{}, firstStep int, digestURI string) (*MD5Base, error) {
+	if err := sasl.CheckMechanismPolicy(MECHANISM_NAME, props); err != nil {
+		return nil, err
+	}
+	b := &MD5Base{
+		Sasl:      &sasl.Sasl{},
+		step:      firstStep,
+		digestURI: digestURI,
+	}
+
+	var err error
+	if b.Qop, err = b.ParseQop(sasl.PropertyValue(props, sasl.SaslPropertyQop)); err != nil {
+		return nil, err
+	} else if b.Strength, err = b.ParseStrength(sasl.PropertyValue(props, sasl.SaslPropertyStrength)); err != nil {
+		return nil, err
+	}
+	b.AllQop = b.CombineMasks(b.Qop)
+
+	b.RecvMaxBufSize = DEFAULT_MAXBUF
+	b.SendMaxBufSize = DEFAULT_MAXBUF
+	if maxBuf := sasl.PropertyValue(props, sasl.SaslPropertyMaxBuffer); len(maxBuf) > 0 {
+		size := 0
+		if _, err := fmt.Sscanf(maxBuf, "%d", &size); err != nil || size <= 0 {
+			return nil, fmt.Errorf("Property must be string representation of positive integer: %s", sasl.SaslPropertyMaxBuffer)
+		}
+		b.RecvMaxBufSize = size
+	}
+	return b, nil
+}
+
+// Unwrap the incoming buffer with the negotiated security layer.
+func (b *MD5Base) Unwrap(incoming []byte, start, len int) ([]byte, error) {
+	if !b.Completed {
+		return nil, errors.New("DIGEST-MD5 authentication not completed")
+	} else if b.secCtx == nil {
+		return nil, errors.New("Neither integrity nor privacy was negotiated")
+	}
+	return b.secCtx.Unwrap(incoming, start, len)
+}
+
+// Wrap the outgoing buffer with the negotiated security layer.
+func (b *MD5Base) Wrap(outgoing []byte, start, len int) ([]byte, error) {
+	if !b.Completed {
+		return nil, errors.New("DIGEST-MD5 authentication not completed")
+	} else if b.secCtx == nil {
+		return nil, errors.New("Neither integrity nor privacy was negotiated")
+	}
+	return b.secCtx.Wrap(outgoing, start, len)
+}
+
+// GetNegotiatedProperty retrieves the negotiated property. Besides the
+// properties known to sasl.Sasl, the negotiated strength and the
+// negotiated cipher (CIPHER_PROPERTY) are available when privacy is in use.
+func (b *MD5Base) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !b.Completed {
+		return nil, errors.New("DIGEST-MD5 authentication not completed")
+	}
+	switch propName {
+	case sasl.SaslPropertyStrength:
+		if len(b.negotiatedStrength) <= 0 {
+			return nil, nil
+		}
+		return b.negotiatedStrength, nil
+	case CIPHER_PROPERTY:
+		if len(b.negotiatedCipher) <= 0 {
+			return nil, nil
+		}
+		return b.negotiatedCipher, nil
+	default:
+		return b.Sasl.GetNegotiatedProperty(propName)
+	}
+}
+
+// GetMechanismName returns the mechanism name "DIGEST-MD5".
+func (b *MD5Base) GetMechanismName() string {
+	return "DIGEST-MD5"
+}
+
+// Dispose the sasl
+func (b *MD5Base) Dispose() error {
+	sasl.ClearBytes(b.hA1)
+	b.hA1 = nil
+	b.secCtx = nil
+	return nil
+}
+
+// installSecurityLayer creates the security context for the negotiated
+// quality-of-protection once the authentication has completed.
+func (b *MD5Base) installSecurityLayer(clientMode bool) error {
+	if b.Privacy {
+		ctx, err := NewPrivacy(b, clientMode)
+		if err != nil {
+			return err
+		}
+		b.secCtx = ctx
+	} else if b.Integrity {
+		ctx, err := NewIntegrity(b, clientMode)
+		if err != nil {
+			return err
+		}
+		b.secCtx = ctx
+	}
+	return nil
+}
+
+// generateResponseValue computes the response-value of a digest-response,
+// or the response-auth of a server response when authMethod is empty,
+// and keeps H(A1) for deriving the security layer keys.
+//
+//	A1 = { H( { username-value, ":", realm-value, ":", passwd } ),
+//	       ":", nonce-value, ":", cnonce-value [ ":", authzid-value ] }
+//	A2 = { authMethod, ":", digest-uri-value } [ SECURITY_LAYER_MARKER ]
+//	response-value = HEX( KD ( HEX(H(A1)),
+//	       { nonce-value, ":" nc-value, ":", cnonce-value, ":",
+//	         qop-value, ":", HEX(H(A2)) }))
+func (b *MD5Base) generateResponseValue(authMethod, qop, username, realm string, passwd []byte, nonceCount int) (string, error) {
+	user, err := b.encode(username)
+	if err != nil {
+		return "", err
+	}
+	rlm, err := b.encode(realm)
+	if err != nil {
+		return "", err
+	}
+	pw, err := b.encode(string(passwd))
+	if err != nil {
+		return "", err
+	}
+	defer sasl.ClearBytes(pw)
+
+	secret := &bytes.Buffer{}
+	secret.Write(user)
+	secret.WriteByte(':')
+	secret.Write(rlm)
+	secret.WriteByte(':')
+	secret.Write(pw)
+	hSecret := md5.Sum(secret.Bytes())
+	sasl.ClearBytes(secret.Bytes())
+
+	a1 := &bytes.Buffer{}
+	a1.Write(hSecret[:])
+	a1.WriteByte(':')
+	a1.Write(b.nonce)
+	a1.WriteByte(':')
+	a1.Write(b.cnonce)
+	if len(b.authorizationID) > 0 {
+		a1.WriteByte(':')
+		a1.WriteString(b.authorizationID)
+	}
+	hA1 := md5.Sum(a1.Bytes())
+	b.hA1 = hA1[:]
+
+	a2 := authMethod + ":" + b.digestURI
+	if qop == "auth-int" || qop == "auth-conf" {
+		a2 += SECURITY_LAYER_MARKER
+	}
+	hA2 := md5.Sum([]byte(a2))
+
+	kd := &bytes.Buffer{}
+	kd.WriteString(hex.EncodeToString(b.hA1))
+	kd.WriteByte(':')
+	kd.Write(b.nonce)
+	kd.WriteByte(':')
+	kd.WriteString(nonceCountToHex(nonceCount))
+	kd.WriteByte(':')
+	kd.Write(b.cnonce)
+	kd.WriteByte(':')
+	kd.WriteString(qop)
+	kd.WriteByte(':')
+	kd.WriteString(hex.EncodeToString(hA2[:]))
+	response := md5.Sum(kd.Bytes())
+	return hex.EncodeToString(response[:]), nil
+}
+
+// encode converts a value to the bytes used for computing H(A1).
+// Values are encoded in ISO 8859-1 whenever every character can be
+// represented in it; otherwise UTF-8 is used, provided that the
+// charset=utf-8 directive is in effect.
+func (b *MD5Base) encode(value string) ([]byte, error) {
+	if !utf8.ValidString(value) {
+		return []byte(value), nil
+	}
+	answer := make([]byte, 0, len(value))
+	for _, r := range value {
+		if r > 0xFF {
+			if !b.useUTF8 {
+				return nil, errors.New("DIGEST-MD5: value cannot be encoded in ISO 8859-1")
+			}
+			return []byte(value), nil
+		}
+		answer = append(answer, byte(r))
+	}
+	return answer, nil
+}
+
+// selectQop records the negotiated quality-of-protection given as a mask.
+func (b *MD5Base) selectQop(mask byte) error {
+	switch mask {
+	case sasl.NO_PROTECTION:
+		b.negotiatedQop = "auth"
+	case sasl.INTEGRITY_ONLY_PROTECTION:
+		b.negotiatedQop = "auth-int"
+		b.Integrity = true
+		b.RawSendSize = b.SendMaxBufSize - 16
+	case sasl.PRIVACY_PROTECTION:
+		b.negotiatedQop = "auth-conf"
+		b.Privacy = true
+		b.Integrity = true
+		b.RawSendSize = b.SendMaxBufSize - 26
+	default:
+		return errors.New("DIGEST-MD5: No common protection layer between client and server")
+	}
+	return nil
+}
+
+// newNonce creates the nonce of servers and the cnonce of clients. Tests
+// replace it to reproduce recorded exchanges.
+var newNonce = generateNonce
+
+// generateNonce creates a random nonce for use as nonce or cnonce value.
+func generateNonce() ([]byte, error) {
+	randomData := make([]byte, RAW_NONCE_SIZE)
+	if _, err := rand.Read(randomData); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, base64.StdEncoding.EncodedLen(len(randomData)))
+	base64.StdEncoding.Encode(nonce, randomData)
+	return nonce, nil
+}
+
+// nonceCountToHex converts the nonce count into the 8 hex digits nc-value.
+func nonceCountToHex(count int) string {
+	return fmt.Sprintf("%08x", count)
+}
+
+// quotedStringValue escapes the characters that cannot appear unescaped
+// inside a quoted-string: '"' and '\'.
+func quotedStringValue(value string) string {
+	if !strings.ContainsAny(value, "\"\\") {
+		return value
+	}
+	answer := &bytes.Buffer{}
+	for i := 0; i < len(value); i++ {
+		if value[i] == '"' || value[i] == '\\' {
+			answer.WriteByte('\\')
+		}
+		answer.WriteByte(value[i])
+	}
+	return answer.String()
+}
+
+// parseDirectives parses a digest-challenge or digest-response into its
+// directives. Keys are lowercased; only the realm directive may appear
+// more than once, every other repeated directive is an error.
+func parseDirectives(buf []byte) (map[string][]string, error) {
+	directives := make(map[string][]string)
+	i := 0
+	for i < len(buf) {
+		for i < len(buf) && isLWS(buf[i]) || i < len(buf) && buf[i] == ',' {
+			i++
+		}
+		if i >= len(buf) {
+			break
+		}
+
+		start := i
+		for i < len(buf) && buf[i] != '=' && buf[i] != ',' {
+			i++
+		}
+		if i >= len(buf) || buf[i] != '=' {
+			return nil, fmt.Errorf("DIGEST-MD5: Directive key contains a ',': %s", buf[start:i])
+		}
+		key := strings.ToLower(strings.TrimSpace(string(buf[start:i])))
+		if len(key) <= 0 {
+			return nil, errors.New("DIGEST-MD5: Empty directive key")
+		}
+		i++
+
+		for i < len(buf) && isLWS(buf[i]) {
+			i++
+		}
+		value := &bytes.Buffer{}
+		if i < len(buf) && buf[i] == '"' {
+			i++
+			closed := false
+			for ; i < len(buf); i++ {
+				if buf[i] == '\\' && i+1 < len(buf) {
+					i++
+					value.WriteByte(buf[i])
+				} else if buf[i] == '"' {
+					closed = true
+					i++
+					break
+				} else {
+					value.WriteByte(buf[i])
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("DIGEST-MD5: Unmatched quote found for directive: %s", key)
+			}
+			for i < len(buf) && isLWS(buf[i]) {
+				i++
+			}
+			if i < len(buf) && buf[i] != ',' {
+				return nil, fmt.Errorf("DIGEST-MD5: Expecting comma or linear white space after quoted string: %s", key)
+			}
+		} else {
+			start = i
+			for i < len(buf) && buf[i] != ',' {
+				i++
+			}
+			value.WriteString(strings.TrimSpace(string(buf[start:i])))
+		}
+
+		if _, ok := directives[key]; ok && key != "realm" {
+			return nil, fmt.Errorf("DIGEST-MD5: Peer sent more than one %s directive", key)
+		}
+		directives[key] = append(directives[key], value.String())
+	}
+	return directives, nil
+}
+
+// directiveValue returns the single value of a directive.
+func directiveValue(directives map[string][]string, key string) (string, bool) {
+	vals, ok := directives[key]
+	if !ok || len(vals) <= 0 {
+		return "", false
+	}
+	return vals[0], true
+}
+
+func isLWS(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
 }
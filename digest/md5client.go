@@ -1 +1,403 @@
 package digest
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// MD5Client is an implementation of the DIGEST-MD5 SASL client-side
+// mechanism (RFC 2831).
+//
+// The client does not send an initial response; it answers the server's
+// digest-challenge with a digest-response, then checks the response-auth
+// returned by the server before the exchange is complete. If 'auth-int'
+// or 'auth-conf' has been negotiated, Wrap() and Unwrap() go through the
+// Integrity or Privacy security layer respectively.
+//
+// The username, password and realm are requested from the callback
+// handler when the digest-challenge is processed, with a NameCallback, a
+// PasswordCallback and either a RealmCallback or, if the server offered
+// several realms, a RealmChoiceCallback.
+//
+// The following properties are used:
+//
+//	SaslPropertyQop       - quality-of-protection preferences
+//	SaslPropertyStrength  - cipher strength preferences for 'auth-conf'
+//	SaslPropertyMaxBuffer - maximum size of the receive buffer
+//	CIPHER_PROPERTY       - the only cipher to accept for 'auth-conf'
+type MD5Client struct {
+	*MD5Base
+	cbh             sasl.CallbackHandler
+	username        string
+	passwd          []byte
+	specifiedCipher string
+	nonceCount      int
+}
+
+// NewMD5Client creates a DIGEST-MD5 client for the service
+// protocol/serverName. The credentials are retrieved through cbh.
+func NewMD5Client(authorizationID, protocol, serverName string, props map[string]interface{}, cbh sasl.CallbackHandler) (*MD5Client, error) {
+	if len(protocol) <= 0 || len(serverName) <= 0 {
+		return nil, errors.New("DIGEST-MD5: protocol and server name must be specified")
+	} else if cbh == nil {
+		return nil, errors.New("DIGEST-MD5: callback handler to get username/password required")
+	}
+
+	base, err := newMD5Base(props, 2, protocol+"/"+serverName)
+	if err != nil {
+		return nil, err
+	}
+	base.authorizationID = authorizationID
+
+	client := &MD5Client{
+		MD5Base:         base,
+		cbh:             cbh,
+		specifiedCipher: sasl.PropertyValue(props, CIPHER_PROPERTY),
+	}
+	return client, nil
+}
+
+// HasInitialResponse returns false because DIGEST-MD5 has no initial
+// response.
+func (c *MD5Client) HasInitialResponse() bool {
+	return false
+}
+
+// EvaluateChallenge processes the challenges sent by the server.
+//
+// Step 2 answers the digest-challenge with a digest-response. Step 3
+// verifies the response-auth sent by the server, or answers a new
+// digest-challenge if the server reported the nonce as stale.
+func (c *MD5Client) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	if len(challengeData) > MAX_CHALLENGE_LENGTH {
+		return nil, c.fail(fmt.Errorf("DIGEST-MD5: Invalid digest-challenge length. Got: %d Expected < %d",
+			len(challengeData), MAX_CHALLENGE_LENGTH))
+	}
+
+	switch c.step {
+	case 2:
+		response, err := c.answerChallenge(challengeData)
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		c.step = 3
+		return response, nil
+	case 3:
+		directives, err := parseDirectives(challengeData)
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		rspAuth, ok := directiveValue(directives, "rspauth")
+		if !ok {
+			if stale, _ := directiveValue(directives, "stale"); strings.EqualFold(stale, "true") {
+				response, err := c.answerChallenge(challengeData)
+				if err != nil {
+					return nil, c.fail(err)
+				}
+				return response, nil
+			}
+			return nil, c.fail(errors.New("DIGEST-MD5: Authentication failed. Expecting 'rspauth' authentication success message"))
+		}
+		if err := c.validateResponseValue(rspAuth); err != nil {
+			return nil, c.fail(err)
+		}
+		if err := c.installSecurityLayer(true); err != nil {
+			return nil, c.fail(err)
+		}
+		c.Completed = true
+		c.step = 0
+		c.clearPassword()
+		return nil, nil
+	default:
+		return nil, errors.New("DIGEST-MD5: Client at illegal state")
+	}
+}
+
+// Dispose the sasl
+func (c *MD5Client) Dispose() error {
+	c.clearPassword()
+	return c.MD5Base.Dispose()
+}
+
+// answerChallenge processes a digest-challenge and generates the
+// corresponding digest-response.
+func (c *MD5Client) answerChallenge(challengeData []byte) ([]byte, error) {
+	directives, err := parseDirectives(challengeData)
+	if err != nil {
+		return nil, err
+	} else if err := c.processChallenge(directives); err != nil {
+		return nil, err
+	}
+	return c.generateClientResponse()
+}
+
+// processChallenge records the directives of a digest-challenge:
+//
+//	digest-challenge =
+//	    1#( realm | nonce | qop-options | stale | maxbuf | charset
+//	          algorithm | cipher-opts | auth-param )
+func (c *MD5Client) processChallenge(directives map[string][]string) error {
+	// The credentials are kept when answering a stale challenge.
+	if c.passwd == nil {
+		if err := c.getUserInfo(directives["realm"]); err != nil {
+			return err
+		}
+	}
+
+	if nonce, ok := directiveValue(directives, "nonce"); !ok || len(nonce) <= 0 {
+		return errors.New("DIGEST-MD5: Digest-challenge format violation. Mandatory nonce directive missing")
+	} else {
+		c.nonce = []byte(nonce)
+	}
+
+	if algorithm, ok := directiveValue(directives, "algorithm"); !ok {
+		return errors.New("DIGEST-MD5: Digest-challenge format violation: algorithm directive missing")
+	} else if !strings.EqualFold(algorithm, "md5-sess") {
+		return fmt.Errorf("DIGEST-MD5: Invalid value for 'algorithm' directive: %s", algorithm)
+	}
+
+	c.useUTF8 = false
+	if charset, ok := directiveValue(directives, "charset"); ok {
+		if !strings.EqualFold(charset, "utf-8") {
+			return fmt.Errorf("DIGEST-MD5: digest-challenge format violation. Unrecognised charset value: %s", charset)
+		}
+		c.useUTF8 = true
+	}
+
+	c.SendMaxBufSize = DEFAULT_MAXBUF
+	if maxBuf, ok := directiveValue(directives, "maxbuf"); ok {
+		size, err := strconv.Atoi(maxBuf)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("DIGEST-MD5: 'maxbuf' must be greater than zero: %s", maxBuf)
+		}
+		c.SendMaxBufSize = size
+	}
+
+	qopOptions, ok := directiveValue(directives, "qop")
+	if !ok {
+		qopOptions = "auth"
+	}
+	cipherOpts, _ := directiveValue(directives, "cipher")
+	if err := c.checkQopSupport(qopOptions, cipherOpts); err != nil {
+		return err
+	}
+
+	cnonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	c.cnonce = cnonce
+	c.nonceCount = 1
+	return nil
+}
+
+// getUserInfo retrieves the username, password and realm through the
+// callback handler. realms are the realms offered by the server.
+func (c *MD5Client) getUserInfo(realms []string) error {
+	ncb := sasl.NewNameCallback("DIGEST-MD5 authentication ID: ", c.authorizationID)
+	pcb := sasl.NewPasswordCallback("DIGEST-MD5 password: ", false)
+	defer pcb.ClearPassword()
+
+	if len(realms) > 1 {
+		ccb := sasl.NewRealmChoiceCallback("DIGEST-MD5 realm: ", realms, 0, false)
+		if err := c.handleCallbacks(ccb, ncb, pcb); err != nil {
+			return err
+		}
+		selected := ccb.GetSelectedIndexes()
+		if len(selected) <= 0 {
+			selected = []int{ccb.GetDefaultChoice()}
+		}
+		if selected[0] < 0 || selected[0] >= len(realms) {
+			return errors.New("DIGEST-MD5: Invalid realm chosen")
+		}
+		c.negotiatedRealm = realms[selected[0]]
+	} else {
+		defaultRealm := ""
+		if len(realms) == 1 {
+			defaultRealm = realms[0]
+		}
+		rcb := sasl.NewRealmCallback("DIGEST-MD5 realm: ", defaultRealm)
+		if err := c.handleCallbacks(rcb, ncb, pcb); err != nil {
+			return err
+		}
+		c.negotiatedRealm = rcb.GetText()
+	}
+
+	c.username = ncb.GetName()
+	if len(c.username) <= 0 {
+		c.username = ncb.GetDefaultName()
+	}
+	if len(c.username) <= 0 {
+		return errors.New("DIGEST-MD5: authentication ID not supplied")
+	} else if pcb.GetPassword() == nil {
+		return errors.New("DIGEST-MD5: password not supplied")
+	}
+	c.passwd = append([]byte{}, pcb.GetPassword()...)
+	return nil
+}
+
+// handleCallbacks passes the realm, name and password callbacks to the
+// callback handler. A handler which does not support the realm callback
+// is asked again for the name and password only, leaving the default
+// realm in place.
+func (c *MD5Client) handleCallbacks(realmCallback, ncb, pcb sasl.Callback) error {
+	err := c.cbh.Handle([]sasl.Callback{realmCallback, ncb, pcb})
+	if unsupported, ok := err.(*sasl.UnsupportedCallbackError); ok && unsupported.Callback == realmCallback {
+		err = c.cbh.Handle([]sasl.Callback{ncb, pcb})
+	}
+	return err
+}
+
+// checkQopSupport selects the most preferred quality-of-protection which is
+// offered by the server.
+func (c *MD5Client) checkQopSupport(qopOptions, cipherOpts string) error {
+	c.Privacy = false
+	c.Integrity = false
+	c.negotiatedCipher = ""
+	c.negotiatedStrength = ""
+
+	serverQop, err := c.ParseQop2(qopOptions, make([]string, len(sasl.QOP_TOKENS)), true)
+	if err != nil {
+		return err
+	}
+	serverAllQop := c.CombineMasks(serverQop)
+	if err := c.selectQop(c.FindPreferredMask(serverAllQop, c.Qop)); err != nil {
+		return err
+	}
+	if c.Privacy {
+		return c.checkStrengthSupport(cipherOpts)
+	}
+	return nil
+}
+
+// checkStrengthSupport selects the cipher for 'auth-conf' among those
+// offered by the server, following the strength preferences of the client.
+func (c *MD5Client) checkStrengthSupport(cipherOpts string) error {
+	if len(cipherOpts) <= 0 {
+		return errors.New("DIGEST-MD5: server did not specify cipher to use for 'auth-conf'")
+	}
+
+	serverCiphers := make([]byte, len(CIPHER_TOKENS))
+	supportedCiphers := make([]string, len(CIPHER_TOKENS))
+	cipherMask := byte(0)
+	for _, token := range strings.FieldsFunc(cipherOpts, func(r rune) bool {
+		return strings.ContainsRune(", \t\n", r)
+	}) {
+		for j := range CIPHER_TOKENS {
+			if strings.EqualFold(token, CIPHER_TOKENS[j]) {
+				serverCiphers[j] |= CIPHER_MASKS[j]
+				supportedCiphers[j] = CIPHER_TOKENS[j]
+				cipherMask |= CIPHER_MASKS[j]
+			}
+		}
+	}
+	if cipherMask == UNSET {
+		return fmt.Errorf("DIGEST-MD5: Client supports none of these cipher suites: %s", cipherOpts)
+	}
+
+	for _, s := range c.Strength {
+		if s == UNSET {
+			continue
+		}
+		for j := range serverCiphers {
+			if s != serverCiphers[j] {
+				continue
+			}
+			if len(c.specifiedCipher) > 0 && c.specifiedCipher != supportedCiphers[j] {
+				continue
+			}
+			c.negotiatedCipher = supportedCiphers[j]
+			c.negotiatedStrength = strengthName(s)
+			return nil
+		}
+	}
+	return errors.New("DIGEST-MD5: Unable to negotiate a strength level for 'auth-conf'")
+}
+
+// generateClientResponse builds the digest-response:
+//
+//	digest-response  = 1#( username | realm | nonce | cnonce |
+//	                       nonce-count | qop | digest-uri | response |
+//	                       maxbuf | charset | cipher | authzid |
+//	                       auth-param )
+func (c *MD5Client) generateClientResponse() ([]byte, error) {
+	responseValue, err := c.generateResponseValue("AUTHENTICATE", c.negotiatedQop,
+		c.username, c.negotiatedRealm, c.passwd, c.nonceCount)
+	if err != nil {
+		return nil, err
+	}
+
+	digestResp := &bytes.Buffer{}
+	if c.useUTF8 {
+		digestResp.WriteString("charset=utf-8,")
+	}
+	fmt.Fprintf(digestResp, "username=\"%s\",", quotedStringValue(c.username))
+	if len(c.negotiatedRealm) > 0 {
+		fmt.Fprintf(digestResp, "realm=\"%s\",", quotedStringValue(c.negotiatedRealm))
+	}
+	fmt.Fprintf(digestResp, "nonce=\"%s\",", quotedStringValue(string(c.nonce)))
+	fmt.Fprintf(digestResp, "cnonce=\"%s\",", quotedStringValue(string(c.cnonce)))
+	fmt.Fprintf(digestResp, "nc=%s,", nonceCountToHex(c.nonceCount))
+	fmt.Fprintf(digestResp, "qop=%s,", c.negotiatedQop)
+	if len(c.negotiatedCipher) > 0 {
+		fmt.Fprintf(digestResp, "cipher=%s,", c.negotiatedCipher)
+	}
+	if c.Integrity && c.RecvMaxBufSize != DEFAULT_MAXBUF {
+		fmt.Fprintf(digestResp, "maxbuf=%d,", c.RecvMaxBufSize)
+	}
+	fmt.Fprintf(digestResp, "digest-uri=\"%s\",", quotedStringValue(c.digestURI))
+	fmt.Fprintf(digestResp, "response=%s", responseValue)
+	if len(c.authorizationID) > 0 {
+		fmt.Fprintf(digestResp, ",authzid=\"%s\"", quotedStringValue(c.authorizationID))
+	}
+
+	if digestResp.Len() > MAX_RESPONSE_LENGTH {
+		return nil, fmt.Errorf("DIGEST-MD5: digest-response size too large. Length: %d", digestResp.Len())
+	}
+	return digestResp.Bytes(), nil
+}
+
+// validateResponseValue checks the response-auth sent by the server, which
+// proves that the server knows the password as well.
+func (c *MD5Client) validateResponseValue(fromServer string) error {
+	expected, err := c.generateResponseValue("", c.negotiatedQop,
+		c.username, c.negotiatedRealm, c.passwd, c.nonceCount)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(fromServer)) != 1 {
+		return errors.New("DIGEST-MD5: Mismatched response-auth value in server response")
+	}
+	return nil
+}
+
+// fail aborts the exchange, so that the client cannot be used any further.
+func (c *MD5Client) fail(err error) error {
+	c.step = 0
+	c.clearPassword()
+	return err
+}
+
+func (c *MD5Client) clearPassword() {
+	if c.passwd == nil {
+		return
+	}
+	sasl.ClearBytes(c.passwd)
+	c.passwd = nil
+}
+
+// strengthName returns the name of a strength mask.
+func strengthName(strength byte) string {
+	for i := range sasl.STRENGTH_MASKS {
+		if sasl.STRENGTH_MASKS[i] == strength {
+			return sasl.STRENGTH_TOKENS[i]
+		}
+	}
+	return ""
+}
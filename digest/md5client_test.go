@@ -0,0 +1,97 @@
+package digest
+
+import (
+	"strings"
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// RFC 2831 section 4 example.
+const (
+	rfcChallenge = `realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",qop="auth",algorithm=md5-sess,charset=utf-8`
+	rfcCnonce    = "OA6MHXh6VqTrRk"
+	rfcResponse  = `charset=utf-8,username="chris",realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",` +
+		`cnonce="OA6MHXh6VqTrRk",nc=00000001,qop=auth,digest-uri="imap/elwood.innosoft.com",` +
+		`response=d388dad90d4bbd760a152321f2143af7`
+	rfcRspAuth = "rspauth=ea40f60335c427b5527b84dbabcdfffd"
+)
+
+// useNonce makes the mechanisms created by the test use nonce as their
+// nonce or cnonce.
+func useNonce(t *testing.T, nonce string) {
+	t.Cleanup(func() { newNonce = generateNonce })
+	newNonce = func() ([]byte, error) {
+		return []byte(nonce), nil
+	}
+}
+
+// userHandler answers the callbacks of DIGEST-MD5 clients and servers:
+// the user has password, the client picks realm if the server offers a
+// choice, and the user may act as any of authorized besides itself.
+func userHandler(username, password, realm string, authorized ...string) sasl.CallbackHandler {
+	return sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		for _, callback := range callbacks {
+			switch cb := callback.(type) {
+			case *sasl.NameCallback:
+				cb.SetName(username)
+			case *sasl.PasswordCallback:
+				cb.SetPassword([]byte(password))
+			case *sasl.RealmCallback:
+				cb.SetText(cb.GetDefaultText())
+			case *sasl.RealmChoiceCallback:
+				for i, choice := range cb.GetChoices() {
+					if choice == realm {
+						cb.SetSelectedIndex(i)
+					}
+				}
+			case *sasl.AuthorizeCallback:
+				allowed := cb.GetAuthenticationID() == cb.GetAuthorizationID()
+				for _, id := range authorized {
+					allowed = allowed || cb.GetAuthorizationID() == id
+				}
+				cb.SetAuthorized(allowed)
+			default:
+				return &sasl.UnsupportedCallbackError{Callback: callback}
+			}
+		}
+		return nil
+	})
+}
+
+func TestClientRFCExample(t *testing.T) {
+	useNonce(t, rfcCnonce)
+	client, err := NewMD5Client("", "imap", "elwood.innosoft.com", nil, userHandler("chris", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.EvaluateChallenge([]byte(rfcChallenge))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(response) != rfcResponse {
+		t.Fatalf("digest-response\n%s\nwant\n%s", response, rfcResponse)
+	}
+
+	if _, err := client.EvaluateChallenge([]byte(strings.Replace(rfcRspAuth, "ea40", "ea41", 1))); err == nil {
+		t.Fatal("accepted a wrong response-auth")
+	}
+}
+
+func TestClientRFCExampleRspAuth(t *testing.T) {
+	useNonce(t, rfcCnonce)
+	client, err := NewMD5Client("", "imap", "elwood.innosoft.com", nil, userHandler("chris", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.EvaluateChallenge([]byte(rfcChallenge)); err != nil {
+		t.Fatal(err)
+	}
+	if challenge, err := client.EvaluateChallenge([]byte(rfcRspAuth)); err != nil {
+		t.Fatal(err)
+	} else if challenge != nil || !client.IsComplete() {
+		t.Fatalf("client not complete, response %q", challenge)
+	}
+	if qop, err := client.GetNegotiatedProperty(sasl.SaslPropertyQop); err != nil || qop != "auth" {
+		t.Errorf("negotiated qop = %v, %v", qop, err)
+	}
+}
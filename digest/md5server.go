@@ -1 +1,437 @@
 package digest
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+const (
+	// REALM_PROPERTY specifies the realms offered by the server as a
+	// space-separated list. If it is absent, the server name is offered.
+	REALM_PROPERTY = "golang.security.sasl.digest.realm"
+
+	// NONCE_LIFETIME_PROPERTY specifies for how many seconds a nonce stays
+	// valid. A digest-response computed over an older nonce is answered
+	// with a new digest-challenge carrying stale=true.
+	NONCE_LIFETIME_PROPERTY = "golang.security.sasl.digest.nonce.lifetime"
+
+	DEFAULT_NONCE_LIFETIME = 5 * time.Minute
+
+	// MAX_STALE_CHALLENGES is the number of times a server answers an
+	// expired nonce with a new digest-challenge before failing the
+	// authentication.
+	MAX_STALE_CHALLENGES = 1
+)
+
+// MD5Server is an implementation of the DIGEST-MD5 SASL server-side
+// mechanism (RFC 2831).
+//
+// The server sends a digest-challenge, verifies the digest-response
+// and completes by sending the response-auth. The password of the client
+// is requested from the callback handler with a RealmCallback and a
+// NameCallback, carrying the realm and username sent by the client as
+// defaults, and a PasswordCallback. The authorization ID is then checked
+// with an AuthorizeCallback. A nonce is good for a single response: a
+// response carrying another nonce or a nonce-count other than 1 is
+// rejected as a replay, while a correct response over an expired nonce
+// is answered with a fresh challenge marked stale=true.
+//
+// The following properties are used:
+//
+//	SaslPropertyQop         - quality-of-protection offered
+//	SaslPropertyStrength    - cipher strengths offered for 'auth-conf'
+//	SaslPropertyMaxBuffer   - maximum size of the receive buffer
+//	REALM_PROPERTY          - realms offered
+//	UTF8_PROPERTY           - whether charset=utf-8 is offered
+//	NONCE_LIFETIME_PROPERTY - lifetime of a nonce in seconds
+type MD5Server struct {
+	*MD5Base
+	cbh             sasl.CallbackHandler
+	protocol        string
+	serverName      string
+	serverRealms    []string
+	offeredQop      []string
+	offeredCiphers  []string
+	nonceLifetime   time.Duration
+	nonceIssued     time.Time
+	staleChallenges int
+}
+
+// NewMD5Server creates a DIGEST-MD5 server for the service
+// protocol/serverName. If serverName is empty, the server is unbound:
+// it accepts any host name in the digest-uri, which is then available as
+// the SaslPropertyBoundServerName negotiated property.
+func NewMD5Server(protocol, serverName string, props map[string]interface{}, cbh sasl.CallbackHandler) (*MD5Server, error) {
+	if len(protocol) <= 0 {
+		return nil, errors.New("DIGEST-MD5: protocol must be specified")
+	} else if cbh == nil {
+		return nil, errors.New("DIGEST-MD5: callback handler to get password required")
+	}
+
+	digestHost := serverName
+	if len(digestHost) <= 0 {
+		digestHost = "*"
+	}
+	base, err := newMD5Base(props, 1, protocol+"/"+digestHost)
+	if err != nil {
+		return nil, err
+	}
+	base.useUTF8 = !strings.EqualFold(sasl.PropertyValue(props, UTF8_PROPERTY), "false")
+
+	server := &MD5Server{
+		MD5Base:       base,
+		cbh:           cbh,
+		protocol:      protocol,
+		serverName:    serverName,
+		nonceLifetime: DEFAULT_NONCE_LIFETIME,
+	}
+
+	if realms := sasl.PropertyValue(props, REALM_PROPERTY); len(realms) > 0 {
+		server.serverRealms = strings.Fields(realms)
+	} else if len(serverName) > 0 {
+		server.serverRealms = []string{serverName}
+	}
+
+	if lifetime := sasl.PropertyValue(props, NONCE_LIFETIME_PROPERTY); len(lifetime) > 0 {
+		seconds, err := strconv.Atoi(lifetime)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("Property must be string representation of positive integer: %s", NONCE_LIFETIME_PROPERTY)
+		}
+		server.nonceLifetime = time.Duration(seconds) * time.Second
+	}
+
+	for i := range sasl.QOP_TOKENS {
+		for _, qop := range base.Qop {
+			if qop == sasl.QOP_MASKS[i] {
+				server.offeredQop = append(server.offeredQop, sasl.QOP_TOKENS[i])
+			}
+		}
+	}
+	if base.AllQop&sasl.PRIVACY_PROTECTION != 0 {
+		allStrength := base.CombineMasks(base.Strength)
+		for j := range CIPHER_TOKENS {
+			if CIPHER_MASKS[j]&allStrength != 0 {
+				server.offeredCiphers = append(server.offeredCiphers, CIPHER_TOKENS[j])
+			}
+		}
+		if len(server.offeredCiphers) <= 0 {
+			return nil, errors.New("DIGEST-MD5: no cipher matches the requested strength for 'auth-conf'")
+		}
+	}
+	return server, nil
+}
+
+// EvaluateResponse processes the responses sent by the client.
+//
+// Step 1 generates the digest-challenge; the client must not send an
+// initial response. Step 3 verifies the digest-response and returns the
+// response-auth.
+func (s *MD5Server) EvaluateResponse(response []byte) ([]byte, error) {
+	if len(response) > MAX_RESPONSE_LENGTH {
+		return nil, s.fail(fmt.Errorf("DIGEST-MD5: Invalid digest response length. Got: %d Expected < %d",
+			len(response), MAX_RESPONSE_LENGTH))
+	}
+
+	switch s.step {
+	case 1:
+		if len(response) != 0 {
+			return nil, s.fail(errors.New("DIGEST-MD5 must not have an initial response"))
+		}
+		challenge, err := s.generateChallenge(false)
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		s.step = 3
+		return challenge, nil
+	case 3:
+		challenge, err := s.validateClientResponse(response)
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		return challenge, nil
+	default:
+		return nil, errors.New("DIGEST-MD5: Server at illegal state")
+	}
+}
+
+// GetAuthorizationID reports the authorization ID of the client, which is
+// the username when the client did not supply an authzid.
+func (s *MD5Server) GetAuthorizationID() (string, error) {
+	if !s.Completed {
+		return "", errors.New("DIGEST-MD5 server negotiation not complete")
+	}
+	return s.authorizationID, nil
+}
+
+// GetNegotiatedProperty retrieves the negotiated property. For an unbound
+// server, SaslPropertyBoundServerName is the host name the client used.
+func (s *MD5Server) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.Completed {
+		return nil, errors.New("DIGEST-MD5 server negotiation not complete")
+	}
+	if propName == sasl.SaslPropertyBoundServerName {
+		return s.serverName, nil
+	}
+	return s.MD5Base.GetNegotiatedProperty(propName)
+}
+
+// generateChallenge builds the digest-challenge with a fresh nonce:
+//
+//	digest-challenge =
+//	    1#( realm | nonce | qop-options | stale | maxbuf | charset
+//	          algorithm | cipher-opts | auth-param )
+func (s *MD5Server) generateChallenge(stale bool) ([]byte, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	s.nonce = nonce
+	s.nonceIssued = time.Now()
+
+	challenge := &bytes.Buffer{}
+	for _, realm := range s.serverRealms {
+		fmt.Fprintf(challenge, "realm=\"%s\",", quotedStringValue(realm))
+	}
+	fmt.Fprintf(challenge, "nonce=\"%s\",", s.nonce)
+	fmt.Fprintf(challenge, "qop=\"%s\",", strings.Join(s.offeredQop, ","))
+	if stale {
+		challenge.WriteString("stale=true,")
+	}
+	if s.RecvMaxBufSize != DEFAULT_MAXBUF {
+		fmt.Fprintf(challenge, "maxbuf=%d,", s.RecvMaxBufSize)
+	}
+	if s.useUTF8 {
+		challenge.WriteString("charset=utf-8,")
+	}
+	if len(s.offeredCiphers) > 0 {
+		fmt.Fprintf(challenge, "cipher=\"%s\",", strings.Join(s.offeredCiphers, ","))
+	}
+	challenge.WriteString("algorithm=md5-sess")
+
+	if challenge.Len() > MAX_CHALLENGE_LENGTH {
+		return nil, fmt.Errorf("DIGEST-MD5: digest-challenge size too large. Length: %d", challenge.Len())
+	}
+	return challenge.Bytes(), nil
+}
+
+// validateClientResponse verifies the digest-response and generates the
+// response-auth, or a new digest-challenge if the nonce has gone stale.
+func (s *MD5Server) validateClientResponse(response []byte) ([]byte, error) {
+	directives, err := parseDirectives(response)
+	if err != nil {
+		return nil, err
+	}
+
+	if charset, ok := directiveValue(directives, "charset"); ok {
+		if !s.useUTF8 || !strings.EqualFold(charset, "utf-8") {
+			return nil, fmt.Errorf("DIGEST-MD5: digest response format violation. Incompatible charset value: %s", charset)
+		}
+	}
+
+	username, ok := directiveValue(directives, "username")
+	if !ok || len(username) <= 0 {
+		return nil, errors.New("DIGEST-MD5: digest response format violation. Missing username.")
+	}
+
+	realm, ok := directiveValue(directives, "realm")
+	if ok && len(s.serverRealms) > 0 {
+		found := false
+		for _, serverRealm := range s.serverRealms {
+			found = found || serverRealm == realm
+		}
+		if !found {
+			return nil, fmt.Errorf("DIGEST-MD5: digest response format violation. Nonexistent realm: %s", realm)
+		}
+	}
+
+	// A response over any other nonce, or a nonce-count other than 1,
+	// is a replay of an earlier authentication.
+	if nonce, _ := directiveValue(directives, "nonce"); subtle.ConstantTimeCompare([]byte(nonce), s.nonce) != 1 {
+		return nil, errors.New("DIGEST-MD5: digest response format violation. Mismatched nonce.")
+	}
+	if nc, _ := directiveValue(directives, "nc"); nc != nonceCountToHex(1) {
+		return nil, fmt.Errorf("DIGEST-MD5: digest response format violation. Nonce count does not match: %s", nc)
+	}
+
+	cnonce, ok := directiveValue(directives, "cnonce")
+	if !ok || len(cnonce) <= 0 {
+		return nil, errors.New("DIGEST-MD5: digest response format violation. Missing cnonce.")
+	}
+	s.cnonce = []byte(cnonce)
+
+	if err := s.checkQop(directives); err != nil {
+		return nil, err
+	}
+
+	if maxBuf, ok := directiveValue(directives, "maxbuf"); ok {
+		size, err := strconv.Atoi(maxBuf)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("DIGEST-MD5: digest response format violation. 'maxbuf' must be greater than zero: %s", maxBuf)
+		}
+		s.SendMaxBufSize = size
+		if s.Privacy {
+			s.RawSendSize = size - 26
+		} else if s.Integrity {
+			s.RawSendSize = size - 16
+		}
+	}
+
+	if err := s.checkDigestURI(directives); err != nil {
+		return nil, err
+	}
+
+	responseValue, ok := directiveValue(directives, "response")
+	if !ok {
+		return nil, errors.New("DIGEST-MD5: digest response format violation. Missing response.")
+	}
+
+	// A1 covers the authzid sent by the client, which is replaced by the
+	// authorized ID once the response has been verified.
+	s.authorizationID, _ = directiveValue(directives, "authzid")
+	passwd, err := s.getPassword(username, realm)
+	if err != nil {
+		return nil, err
+	}
+	defer sasl.ClearBytes(passwd)
+
+	s.negotiatedRealm = realm
+	expected, err := s.generateResponseValue("AUTHENTICATE", s.negotiatedQop, username, realm, passwd, 1)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(responseValue)) != 1 {
+		return nil, errors.New("DIGEST-MD5: digest response format violation. Mismatched response.")
+	}
+
+	if time.Since(s.nonceIssued) > s.nonceLifetime {
+		if s.staleChallenges >= MAX_STALE_CHALLENGES {
+			return nil, errors.New("DIGEST-MD5: nonce expired")
+		}
+		s.staleChallenges++
+		s.Privacy = false
+		s.Integrity = false
+		return s.generateChallenge(true)
+	}
+
+	authorizationID, err := sasl.Authorize(s.cbh, username, s.authorizationID)
+	if err != nil {
+		return nil, fmt.Errorf("DIGEST-MD5: %s", err)
+	}
+
+	rspAuth, err := s.generateResponseValue("", s.negotiatedQop, username, realm, passwd, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.installSecurityLayer(false); err != nil {
+		return nil, err
+	}
+	s.authorizationID = authorizationID
+	s.nonce = nil
+	s.Completed = true
+	s.step = 0
+	return []byte("rspauth=" + rspAuth), nil
+}
+
+// getPassword retrieves the password of username in realm through the
+// callback handler.
+func (s *MD5Server) getPassword(username, realm string) ([]byte, error) {
+	rcb := sasl.NewRealmCallback("DIGEST-MD5 realm: ", realm)
+	ncb := sasl.NewNameCallback("DIGEST-MD5 authentication ID: ", username)
+	pcb := sasl.NewPasswordCallback("DIGEST-MD5 password: ", false)
+	defer pcb.ClearPassword()
+
+	err := s.cbh.Handle([]sasl.Callback{rcb, ncb, pcb})
+	if unsupported, ok := err.(*sasl.UnsupportedCallbackError); ok && unsupported.Callback == rcb {
+		err = s.cbh.Handle([]sasl.Callback{ncb, pcb})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pcb.GetPassword() == nil {
+		return nil, errors.New("DIGEST-MD5: cannot perform callbacks to acquire password")
+	}
+	return append([]byte{}, pcb.GetPassword()...), nil
+}
+
+// checkQop verifies that the qop and cipher chosen by the client were
+// offered in the digest-challenge.
+func (s *MD5Server) checkQop(directives map[string][]string) error {
+	qop, ok := directiveValue(directives, "qop")
+	if !ok {
+		qop = "auth"
+	}
+
+	clientQop, err := s.ParseQop2(qop, nil, false)
+	if err != nil {
+		return err
+	}
+	if err := s.selectQop(clientQop[0] & s.AllQop); err != nil {
+		return fmt.Errorf("DIGEST-MD5: digest response format violation. Invalid QOP: %s", qop)
+	}
+	s.RawSendSize = 0
+	if s.Privacy {
+		s.RawSendSize = s.SendMaxBufSize - 26
+	} else if s.Integrity {
+		s.RawSendSize = s.SendMaxBufSize - 16
+	}
+
+	if !s.Privacy {
+		return nil
+	}
+	cipher, ok := directiveValue(directives, "cipher")
+	if !ok {
+		return errors.New("DIGEST-MD5: digest response format violation. No cipher specified.")
+	}
+	for j := range s.offeredCiphers {
+		if strings.EqualFold(cipher, s.offeredCiphers[j]) {
+			s.negotiatedCipher = s.offeredCiphers[j]
+			for k := range CIPHER_TOKENS {
+				if CIPHER_TOKENS[k] == s.negotiatedCipher {
+					s.negotiatedStrength = strengthName(CIPHER_MASKS[k])
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("DIGEST-MD5: server does not support cipher: %s", cipher)
+}
+
+// checkDigestURI verifies that the digest-uri names this service. An
+// unbound server binds to the host name found in the digest-uri.
+func (s *MD5Server) checkDigestURI(directives map[string][]string) error {
+	digestURI, ok := directiveValue(directives, "digest-uri")
+	if !ok {
+		return errors.New("DIGEST-MD5: digest response format violation. Missing digest-uri.")
+	}
+	if len(s.serverName) > 0 {
+		if !strings.EqualFold(digestURI, s.digestURI) {
+			return fmt.Errorf("DIGEST-MD5: digest response format violation. Mismatched URI: %s; expecting: %s",
+				digestURI, s.digestURI)
+		}
+		s.digestURI = digestURI
+		return nil
+	}
+
+	parts := strings.Split(digestURI, "/")
+	if len(parts) < 2 || len(parts) > 3 || !strings.EqualFold(parts[0], s.protocol) || len(parts[1]) <= 0 {
+		return fmt.Errorf("DIGEST-MD5: digest response format violation. Mismatched URI: %s; expecting: %s",
+			digestURI, s.digestURI)
+	}
+	s.serverName = parts[1]
+	s.digestURI = digestURI
+	return nil
+}
+
+// fail aborts the exchange, so that the server cannot be used any further.
+func (s *MD5Server) fail(err error) error {
+	s.step = 0
+	s.nonce = nil
+	return err
+}
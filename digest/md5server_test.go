@@ -0,0 +1,101 @@
+package digest
+
+import (
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+func TestServerRFCExample(t *testing.T) {
+	useNonce(t, "OA6MG9tEQGm2hh")
+	server, err := NewMD5Server("imap", "elwood.innosoft.com", nil, userHandler("chris", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.EvaluateResponse(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rspAuth, err := server.EvaluateResponse([]byte(rfcResponse))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(rspAuth) != rfcRspAuth {
+		t.Errorf("response-auth = %q, want %q", rspAuth, rfcRspAuth)
+	}
+	if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "chris" {
+		t.Errorf("authorization ID = %q, %v", authorizationID, err)
+	}
+}
+
+// exchange runs the exchange between client and server.
+func exchange(client *MD5Client, server *MD5Server) error {
+	challenge, err := server.EvaluateResponse(nil)
+	for err == nil && !client.IsComplete() {
+		var response []byte
+		if response, err = client.EvaluateChallenge(challenge); err == nil && !server.IsComplete() {
+			challenge, err = server.EvaluateResponse(response)
+		}
+	}
+	return err
+}
+
+func TestExchange(t *testing.T) {
+	realms := map[string]interface{}{REALM_PROPERTY: "example.com example.org"}
+	tests := []struct {
+		name       string
+		authzid    string
+		props      map[string]interface{}
+		realm      string
+		password   string
+		authorized string
+	}{
+		{"server name as realm", "", nil, "localhost", "secret", "chris"},
+		{"first realm", "", realms, "example.com", "secret", "chris"},
+		{"second realm", "", realms, "example.org", "secret", "chris"},
+		{"same authzid", "chris", realms, "example.org", "secret", "chris"},
+		{"approved authzid", "admin", realms, "example.org", "secret", "admin"},
+		{"denied authzid", "root", realms, "example.org", "secret", ""},
+		{"wrong password", "", realms, "example.org", "public", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The password of chris depends on the realm.
+			serverHandler := sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+				password := "other"
+				for _, callback := range callbacks {
+					if rcb, ok := callback.(*sasl.RealmCallback); ok && rcb.GetDefaultText() == test.realm {
+						password = "secret"
+					}
+				}
+				return userHandler("chris", password, "", "admin").Handle(callbacks)
+			})
+			client, err := NewMD5Client(test.authzid, "imap", "localhost", nil, userHandler("chris", test.password, test.realm))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewMD5Server("imap", "localhost", test.props, serverHandler)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = exchange(client, server)
+			if len(test.authorized) <= 0 {
+				if err == nil || server.IsComplete() {
+					t.Fatal("expected failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			} else if !client.IsComplete() || !server.IsComplete() {
+				t.Fatal("exchange not complete")
+			}
+			if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != test.authorized {
+				t.Errorf("authorization ID = %q, %v, want %q", authorizationID, err, test.authorized)
+			}
+			if server.negotiatedRealm != test.realm {
+				t.Errorf("realm = %q, want %q", server.negotiatedRealm, test.realm)
+			}
+		})
+	}
+}
@@ -7,12 +7,14 @@ import (
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rc4"
+	"errors"
 	"fmt"
+	"math/bits"
 )
 
 const (
-	CLIENT_INT_MAGIC  = "golang sasl integrity client-to-server magic key"
-	SVR_INT_MAGIC     = "golang sasl integrity server-to-client magic key"
+	CLIENT_INT_MAGIC  = "Digest session key to client-to-server signing key magic constant"
+	SVR_INT_MAGIC     = "Digest session key to server-to-client signing key magic constant"
 	CLIENT_CONF_MAGIC = "Digest H(A1) to client-to-server sealing key magic constant"
 	SVR_CONF_MAGIC    = "Digest H(A1) to server-to-client sealing key magic constant"
 )
@@ -45,25 +47,13 @@ type Integrity struct {
 	md5Base     *MD5Base
 }
 
-type rc4Block struct {
-	rc4Cipher *rc4.Cipher
-}
-
-func (r *rc4Block) BlockSize() int {
-	return 1
-}
-
-func (r *rc4Block) Encrypt(dst, src []byte) {
-	r.rc4Cipher.XORKeyStream(dst, src)
-}
-
-func (r *rc4Block) Decrypt(dst, src []byte) {
-	r.rc4Cipher.XORKeyStream(src, dst)
-}
-
 // NewIntegrity create a new instance of Integrity
-func NewIntegrity(clientMode bool) (*Integrity, error) {
-	i := &Integrity{}
+func NewIntegrity(md5Base *MD5Base, clientMode bool) (*Integrity, error) {
+	i := &Integrity{
+		md5Base:     md5Base,
+		messageType: make([]byte, 2),
+		sequenceNum: make([]byte, 4),
+	}
 	if err := i.generateIntegrityKeyPair(clientMode); err != nil {
 		return nil, err
 	} else if err := i.md5Base.IntToNetworkByteOrder(1, i.messageType, 0, 2); err != nil {
@@ -115,7 +105,7 @@ func (i *Integrity) Wrap(outgoing []byte, start, msgLen int) ([]byte, error) {
 		return nil, err
 	} else if _, err := wrapped.Write(i.messageType[:2]); err != nil {
 		return nil, err
-	} else if wrapped.Write(i.sequenceNum[:4]); err != nil {
+	} else if _, err := wrapped.Write(i.sequenceNum[:4]); err != nil {
 		return nil, err
 	}
 	return wrapped.Bytes(), nil
@@ -126,21 +116,28 @@ func (i *Integrity) Wrap(outgoing []byte, start, msgLen int) ([]byte, error) {
 func (i *Integrity) Unwrap(incoming []byte, start, msgLen int) ([]byte, error) {
 	if msgLen == 0 {
 		return EMPTY_BYTE_SLICE, nil
+	} else if msgLen < 16 {
+		return nil, errors.New("DIGEST-MD5: Wrapped message too short")
 	}
 	mac := make([]byte, 10, 10)
 	msg := make([]byte, msgLen-16, msgLen-16)
 	msgType := make([]byte, 2, 2)
 	seqNum := make([]byte, 4, 4)
 
-	copy(msg, incoming[start:])
+	copy(msg, incoming[start:start+msgLen])
 	copy(mac, incoming[start+len(msg):])
 	copy(msgType, incoming[start+len(msg)+10:])
 	copy(seqNum, incoming[start+len(msg)+12:])
 
 	if expectedMac, err := i.GetHMac(i.peerKi, seqNum, msg, 0, len(msg)); err != nil {
 		return nil, err
-	} else if bytes.Compare(expectedMac, mac) != 0 {
+	} else if !hmac.Equal(expectedMac, mac) {
+		// Discard the message and do not increment the sequence number
 		return EMPTY_BYTE_SLICE, nil
+	} else if parsedMsgType, err := i.md5Base.NetworkByteOrderToInt(msgType, 0, 2); err != nil {
+		return nil, err
+	} else if parsedMsgType != 1 {
+		return nil, fmt.Errorf("DIGEST-MD5: Invalid message type: %d", parsedMsgType)
 	} else if parsedSeqNum, err := i.md5Base.NetworkByteOrderToInt(seqNum, 0, 4); err != nil {
 		return nil, err
 	} else if parsedSeqNum != i.peerSeqNum {
@@ -175,40 +172,69 @@ func (i *Integrity) IncrementSeqNum() {
 // between the client and server to be integrity checked and encrypted.
 // After a successful DIGEST-MD5 authentication, privacy is invoked if the
 // SASL QOP (quality-of-protection) is set to 'auth-conf'.
+//
+// Each message is protected as
+//
+//	CIPHER(Kc, {msg, pad, HMAC(Ki, {SeqNum, msg})[0..9]}), {0x00, 0x01}, SeqNum
+//
+// where pad is only used by the DES and Triple DES ciphers, whose CBC
+// state carries over from one message to the next, just like the RC4
+// key stream does.
 type Privacy struct {
 	*Integrity
-	encCipher cipher.Block
-	decCipher cipher.Block
+	encCipher cipher.BlockMode
+	decCipher cipher.BlockMode
+}
+
+// rc4Mode adapts the RC4 key stream to cipher.BlockMode, with a block size
+// of 1 so that no padding is applied.
+type rc4Mode struct {
+	*rc4.Cipher
+}
+
+func (r *rc4Mode) BlockSize() int {
+	return 1
+}
+
+func (r *rc4Mode) CryptBlocks(dst, src []byte) {
+	r.XORKeyStream(dst, src)
 }
 
 // NewPrivacy create a new Privacy instance for privacy check
-func NewPrivacy(clientMode bool) (*Privacy, error) {
+func NewPrivacy(md5Base *MD5Base, clientMode bool) (*Privacy, error) {
 	p := &Privacy{}
-	if intergity, err := NewIntegrity(clientMode); err != nil {
+	if intergity, err := NewIntegrity(md5Base, clientMode); err != nil {
 		return nil, err
 	} else {
 		p.Integrity = intergity
 	}
+	if err := p.generatePrivacyKeyPair(clientMode); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
+// generatePrivacyKeyPair generates client-server and server-client keys
+// for DIGEST-MD5 privacy and initializes the ciphers with them.
 func (p *Privacy) generatePrivacyKeyPair(clientMode bool) error {
 	ccmagic := []byte(CLIENT_CONF_MAGIC)
 	scmagic := []byte(SVR_CONF_MAGIC)
 	n := 0
 	if p.md5Base.negotiatedCipher == CIPHER_TOKENS[RC4_40] {
-		n = 5
+		n = 5 // H(A1)[0..4]
 	} else if p.md5Base.negotiatedCipher == CIPHER_TOKENS[RC4_56] {
-		n = 7
+		n = 7 // H(A1)[0..6]
 	} else {
-		n = 16
+		n = 16 // H(A1)
 	}
 
+	// kcc: key for confidentiality of msgs from client to server
 	keyBuffer := make([]byte, n+len(ccmagic))
 	copy(keyBuffer, p.md5Base.hA1[:n])
 	copy(keyBuffer[n:], ccmagic)
 	kcc := md5.Sum(keyBuffer)
 
+	// kcs: key for confidentiality of msgs from server to client
 	copy(keyBuffer[n:], scmagic)
 	kcs := md5.Sum(keyBuffer)
 
@@ -221,10 +247,10 @@ func (p *Privacy) generatePrivacyKeyPair(clientMode bool) error {
 		peerKc = kcc[:]
 	}
 
-	if encoder, err := buildCipher(p.md5Base.negotiatedCipher, myKc); err != nil {
-		return nil, err
-	} else if decoder, err := buildCipher(p.md5Base.negotiatedCipher, peerKc); err != nil {
-		return nil, err
+	if encoder, err := buildCipher(p.md5Base.negotiatedCipher, myKc, true); err != nil {
+		return err
+	} else if decoder, err := buildCipher(p.md5Base.negotiatedCipher, peerKc, false); err != nil {
+		return err
 	} else {
 		p.encCipher = encoder
 		p.decCipher = decoder
@@ -232,31 +258,160 @@ func (p *Privacy) generatePrivacyKeyPair(clientMode bool) error {
 	return nil
 }
 
-func (p *Privacy) buildCipher(name string, key []byte) (cipher.Block, error) {
+// buildCipher initializes the cipher for the given Kc. RC4 is keyed with
+// all of Kc. DES is keyed with Kc[0..6] and Triple DES with the two keys
+// Kc[0..6] and Kc[7..13] as {K1, K2, K1}, each expanded with parity bits;
+// both use Kc[8..15] as CBC initialization vector.
+func buildCipher(name string, kc []byte, encrypt bool) (cipher.BlockMode, error) {
+	var block cipher.Block
+	var err error
 	switch name {
-	case CIPHER_TOKENS[DES3]:
-		return des.NewTripleDESCipher(key)
-	case CIPHER_TOKENS[DES]:
-		return des.NewCipher(key)
 	case CIPHER_TOKENS[RC4], CIPHER_TOKENS[RC4_56], CIPHER_TOKENS[RC4_40]:
-		if stream, err := rc4.NewCipher(key); err != nil {
+		stream, err := rc4.NewCipher(kc)
+		if err != nil {
 			return nil, err
-		} else {
-			return &rc4Block{stream}, nil
 		}
+		return &rc4Mode{stream}, nil
+	case CIPHER_TOKENS[DES]:
+		block, err = des.NewCipher(addDesParity(kc[0:7]))
+	case CIPHER_TOKENS[DES3]:
+		subkey1 := addDesParity(kc[0:7])
+		subkey2 := addDesParity(kc[7:14])
+		ede := make([]byte, 0, 24)
+		ede = append(ede, subkey1...)
+		ede = append(ede, subkey2...)
+		ede = append(ede, subkey1...)
+		block, err = des.NewTripleDESCipher(ede)
 	default:
-		return nil, fmt.Errorf("cipher %s not support", name)
+		return nil, fmt.Errorf("DIGEST-MD5: Unsupported cipher %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	iv := kc[8:16]
+	if encrypt {
+		return cipher.NewCBCEncrypter(block, iv), nil
+	}
+	return cipher.NewCBCDecrypter(block, iv), nil
+}
+
+// addDesParity expands a 7-byte DES key value into an 8-byte DES key: each
+// byte of the key takes the next 7 bits of the value, followed by an odd
+// parity bit.
+func addDesParity(raw []byte) []byte {
+	in := uint64(0)
+	for i := 0; i < 7; i++ {
+		in = in<<8 | uint64(raw[i])
+	}
+
+	key := make([]byte, 8)
+	for i := len(key) - 1; i >= 0; i-- {
+		b := byte(in&0x7F) << 1
+		if bits.OnesCount8(b)%2 == 0 {
+			b |= 1
+		}
+		key[i] = b
+		in >>= 7
 	}
+	return key
 }
 
-// func (p *Privacy) Wrap(outgoing []byte, start, msgLen int) ([]byte, error) {
-// 	if msgLen == 0 {
-// 		return EMPTY_BYTE_SLICE, nil
-// 	}
-
-// 	p.IncrementSeqNum()
-// 	mac, err := p.GetHMac(p.myKi, p.sequenceNum, outgoing, start, msgLen)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// }
+// Wrap encrypts the outgoing message together with its MAC, then appends
+// the message type and sequence number.
+func (p *Privacy) Wrap(outgoing []byte, start, msgLen int) ([]byte, error) {
+	if msgLen == 0 {
+		return EMPTY_BYTE_SLICE, nil
+	}
+
+	// HMAC(Ki, {SeqNum, msg})[0..9]
+	p.IncrementSeqNum()
+	mac, err := p.GetHMac(p.myKi, p.sequenceNum, outgoing, start, msgLen)
+	if err != nil {
+		return nil, err
+	}
+
+	// {msg, pad, HMAC(Ki, {SeqNum, msg})[0..9]}
+	toBeEncrypted := &bytes.Buffer{}
+	toBeEncrypted.Write(outgoing[start : start+msgLen])
+	if bs := p.encCipher.BlockSize(); bs > 1 {
+		pad := bs - ((msgLen + 10) % bs)
+		toBeEncrypted.Write(bytes.Repeat([]byte{byte(pad)}, pad))
+	}
+	toBeEncrypted.Write(mac[:10])
+
+	// CIPHER(Kc, {msg, pad, HMAC(Ki, {SeqNum, msg})[0..9]}), type, SeqNum
+	wrapped := make([]byte, toBeEncrypted.Len()+6)
+	p.encCipher.CryptBlocks(wrapped, toBeEncrypted.Bytes())
+	copy(wrapped[toBeEncrypted.Len():], p.messageType[:2])
+	copy(wrapped[toBeEncrypted.Len()+2:], p.sequenceNum[:4])
+	return wrapped, nil
+}
+
+// Unwrap decrypts the incoming message and returns it without padding and
+// MAC - only if the received MAC and re-generated MAC are the same.
+func (p *Privacy) Unwrap(incoming []byte, start, msgLen int) ([]byte, error) {
+	if msgLen == 0 {
+		return EMPTY_BYTE_SLICE, nil
+	}
+	bs := p.decCipher.BlockSize()
+	if msgLen < 16 || (msgLen-6)%bs != 0 {
+		return nil, fmt.Errorf("DIGEST-MD5: Invalid length of wrapped message: %d", msgLen)
+	}
+
+	encryptedMsg := incoming[start : start+msgLen-6]
+	msgType := incoming[start+msgLen-6 : start+msgLen-4]
+	seqNum := incoming[start+msgLen-4 : start+msgLen]
+
+	// Decrypt message - CIPHER(Kc, {msg, pad, HMAC(Ki, {SeqNum, msg})[0..9]})
+	decryptedMsg := make([]byte, len(encryptedMsg))
+	p.decCipher.CryptBlocks(decryptedMsg, encryptedMsg)
+
+	msg := decryptedMsg[:len(decryptedMsg)-10]
+	mac := decryptedMsg[len(decryptedMsg)-10:]
+	if bs > 1 {
+		unpadded, err := unpad(msg, bs)
+		if err != nil {
+			return nil, err
+		}
+		msg = unpadded
+	}
+
+	if expectedMac, err := p.GetHMac(p.peerKi, seqNum, msg, 0, len(msg)); err != nil {
+		return nil, err
+	} else if !hmac.Equal(expectedMac, mac) {
+		// Discard the message and do not increment the sequence number
+		return EMPTY_BYTE_SLICE, nil
+	}
+
+	if parsedMsgType, err := p.md5Base.NetworkByteOrderToInt(msgType, 0, 2); err != nil {
+		return nil, err
+	} else if parsedMsgType != 1 {
+		return nil, fmt.Errorf("DIGEST-MD5: Invalid message type: %d", parsedMsgType)
+	} else if parsedSeqNum, err := p.md5Base.NetworkByteOrderToInt(seqNum, 0, 4); err != nil {
+		return nil, err
+	} else if parsedSeqNum != p.peerSeqNum {
+		return nil, fmt.Errorf("DIGEST-MD5: Out of order sequencing of messages from peer. Got: %d, Expected: %d",
+			parsedSeqNum, p.peerSeqNum)
+	}
+	p.peerSeqNum++
+	return msg, nil
+}
+
+// unpad removes the padding of a message encrypted by a block cipher:
+// 1 to blockSize octets, each holding the number of padding octets.
+func unpad(msg []byte, blockSize int) ([]byte, error) {
+	if len(msg) <= 0 {
+		return nil, errors.New("DIGEST-MD5: Invalid padding")
+	}
+	pad := int(msg[len(msg)-1])
+	if pad < 1 || pad > blockSize || pad > len(msg) {
+		return nil, fmt.Errorf("DIGEST-MD5: Invalid padding length: %d", pad)
+	}
+	for _, b := range msg[len(msg)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("DIGEST-MD5: Invalid padding")
+		}
+	}
+	return msg[:len(msg)-pad], nil
+}
@@ -0,0 +1,336 @@
+package digest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// negotiate runs an exchange for user "chris" with the quality-of-protection
+// qop, and the cipher forced by the client for 'auth-conf'.
+func negotiate(t *testing.T, qop, cipher string) (*MD5Client, *MD5Server) {
+	props := map[string]interface{}{
+		sasl.SaslPropertyQop:      qop,
+		sasl.SaslPropertyStrength: "high,medium,low",
+	}
+	server, err := NewMD5Server("imap", "localhost", props, userHandler("chris", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	props[CIPHER_PROPERTY] = cipher
+	client, err := NewMD5Client("", "imap", "localhost", props, userHandler("chris", "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange(client, server); err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+// wrapper is the side of a negotiated exchange wrapping messages.
+type wrapper interface {
+	Wrap(outgoing []byte, start, len int) ([]byte, error)
+	Unwrap(incoming []byte, start, len int) ([]byte, error)
+}
+
+// roundTrip wraps messages on one side and unwraps them on the other.
+func roundTrip(t *testing.T, from, to wrapper, messages ...string) {
+	for _, message := range messages {
+		// Wrap a message in the middle of a larger buffer.
+		buf := []byte("[" + message + "]")
+		token, err := from.Wrap(buf, 1, len(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := to.Unwrap(append([]byte("xx"), token...), 2, len(token))
+		if err != nil {
+			t.Fatal(err)
+		} else if string(got) != message {
+			t.Fatalf("unwrapped %q, want %q", got, message)
+		}
+	}
+}
+
+var testMessages = []string{"a", "hello", "exactly 8", "a message longer than one block of the cipher", ""}
+
+func TestPrivacyRoundTrip(t *testing.T) {
+	for _, cipher := range CIPHER_TOKENS {
+		t.Run(cipher, func(t *testing.T) {
+			client, server := negotiate(t, "auth-conf", cipher)
+			if negotiated, _ := server.GetNegotiatedProperty(CIPHER_PROPERTY); negotiated != cipher {
+				t.Fatalf("negotiated cipher %v, want %s", negotiated, cipher)
+			}
+			roundTrip(t, client, server, testMessages...)
+			roundTrip(t, server, client, testMessages...)
+			roundTrip(t, client, server, testMessages...)
+
+			message := testMessages[3]
+			if token, err := client.Wrap([]byte(message), 0, len(message)); err != nil {
+				t.Fatal(err)
+			} else if bytes.Contains(token, []byte("message")) {
+				t.Errorf("token %x holds the message in clear", token)
+			}
+		})
+	}
+}
+
+func TestIntegrityRoundTrip(t *testing.T) {
+	client, server := negotiate(t, "auth-int", "")
+	if qop, _ := server.GetNegotiatedProperty(sasl.SaslPropertyQop); qop != "auth-int" {
+		t.Fatalf("negotiated qop %v", qop)
+	}
+	roundTrip(t, client, server, testMessages...)
+	roundTrip(t, server, client, testMessages...)
+
+	token, err := client.Wrap([]byte("hello"), 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// msg, HMAC(Ki, {SeqNum, msg})[0..9], {0x00, 0x01}, SeqNum
+	if len(token) != 5+16 || string(token[:5]) != "hello" {
+		t.Fatalf("token %x", token)
+	} else if !bytes.Equal(token[15:], []byte{0, 1, 0, 0, 0, 4}) {
+		t.Errorf("message type and sequence number %x, want 0001 00000004", token[15:])
+	}
+}
+
+// TestIntegrityRejects checks that altered tokens are discarded without
+// consuming a sequence number, and that tokens out of sequence fail.
+func TestIntegrityRejects(t *testing.T) {
+	client, server := negotiate(t, "auth-int", "")
+	var tokens [][]byte
+	for _, message := range []string{"first", "second"} {
+		token, err := client.Wrap([]byte(message), 0, len(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	alter := func(token []byte, i int) []byte {
+		altered := append([]byte{}, token...)
+		altered[i] ^= 0x01
+		return altered
+	}
+	first := tokens[0]
+
+	tests := []struct {
+		name  string
+		token []byte
+		want  string
+		fails bool
+	}{
+		{"altered message", alter(first, 0), "", false},
+		{"altered MAC", alter(first, 5), "", false},
+		{"altered sequence number", alter(first, len(first)-1), "", false},
+		{"altered message type", alter(first, len(first)-5), "", true},
+		{"too short", first[:15], "", true},
+		{"out of sequence", tokens[1], "", true},
+		{"first", first, "first", false},
+		{"replayed", first, "", true},
+		{"second", tokens[1], "second", false},
+	}
+	for _, test := range tests {
+		got, err := server.Unwrap(test.token, 0, len(test.token))
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: unwrapped %q, want error", test.name, got)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if string(got) != test.want {
+			t.Errorf("%s: unwrapped %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// The keys of each direction differ.
+	back, err := server.Wrap([]byte("reply"), 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := server.Unwrap(back, 0, len(back)); err != nil || len(got) != 0 {
+		t.Errorf("unwrapped own token: %q, %v", got, err)
+	}
+}
+
+// TestPrivacyRejects checks that altered, reordered and malformed tokens
+// are never delivered.
+func TestPrivacyRejects(t *testing.T) {
+	for _, cipher := range []string{"rc4", "3des"} {
+		t.Run(cipher, func(t *testing.T) {
+			for _, reorder := range []bool{false, true} {
+				client, server := negotiate(t, "auth-conf", cipher)
+				first, err := client.Wrap([]byte("first message"), 0, 13)
+				if err != nil {
+					t.Fatal(err)
+				}
+				second, err := client.Wrap([]byte("second message"), 0, 14)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := server.Unwrap(first, 0, 15); err == nil {
+					t.Error("accepted a truncated token")
+				}
+
+				token := second
+				if !reorder {
+					token = append([]byte{}, first...)
+					token[3] ^= 0x01
+				}
+				// Chained block cipher state makes a reordered 3des token fail
+				// to decrypt rather than fail the sequence check.
+				if got, err := server.Unwrap(token, 0, len(token)); err == nil && len(got) != 0 {
+					t.Errorf("reordered %v: unwrapped %q", reorder, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAddDesParity(t *testing.T) {
+	raw := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd}
+	key := addDesParity(raw)
+	if hex.EncodeToString(key) != "0191d0ad794cae9b" {
+		t.Errorf("key %x", key)
+	}
+	for i, b := range key {
+		if bits.OnesCount8(b)%2 != 1 {
+			t.Errorf("byte %d of %x has even parity", i, key)
+		}
+	}
+}
+
+// cyrusVectors come from sessions between the Cyrus SASL DIGEST-MD5 client
+// plugin (2.1.28) and MD5Server, for user "chris" with password "secret".
+// The client tokens were wrapped by Cyrus SASL. The server tokens were
+// wrapped by MD5Server and unwrapped by Cyrus SASL, so they only guard
+// against regressions of MD5Server.
+var cyrusVectors = []struct {
+	cipher       string
+	nonce        string
+	cnonce       string
+	response     string
+	rspAuth      string
+	clientTokens []string
+	serverTokens []string
+}{
+	{
+		cipher:   "rc4",
+		nonce:    "Js1a6EXJTX6pC7EHcvhgNHntXlMF0k7SHEtVGhde",
+		cnonce:   "UxcdlX9nPXyfGqpD8kzpPZkMeefPkfnqmeXVhtpvZzo=",
+		response: "fd61d79d78e6ace0148e6211b2be9753",
+		rspAuth:  "fae48a71ce6c091cbbec2581e1fb2a94",
+		clientTokens: []string{
+			"d9f9519a9ce48b8d72bee9468c33d8000100000000",
+			"9fa61eab3ee1d7559e130681f168b9a9f452ddd9789f98377a80c1286eb4d81cd4976a373bf3fc63591ede9137cdb7702e000100000001",
+		},
+		serverTokens: []string{
+			"3859c001bd47f4e7562411c40de99d000100000000",
+			"1ad8119f9f1d67937d77fb1a9cdfd96f62550063a31b37e2805dd6d9f1cfa67e2fda56a0c7788ff3d66909e1c5d59343a7000100000001",
+		},
+	},
+	{
+		cipher:   "3des",
+		nonce:    "SV1/uCsdZplvJfevqbDD89cQ8+NO7bqzD2ce4ZBb",
+		cnonce:   "T8xW/aPHTQCbjXs+mNLimxOxw3AVpLilda4AH7rMb78=",
+		response: "ba101f183680c7297a8791f518d74e8b",
+		rspAuth:  "ec60f5621376e5c26a43b2843d9003bf",
+		clientTokens: []string{
+			"2f59caa3d48b29b2a13af1a09b5d7c1a000100000000",
+			"bb50893cab31104a2544de4d4018121b4ed07321de1a9519eaac06a3d754d6b927fd9177df8e2eafb6ab5e32d90f03057c6283ac009a15ba000100000001",
+		},
+		serverTokens: []string{
+			"15f02a8a90de37708a17d222ae051183000100000000",
+			"6e068d88b3a9f1eb9b4d1964c95ef61015878a256fbdfb62f51bf73016d07891b3eb2a3a3d8bea4f77a86ed08907e4e5aaa52af81dd7f70f000100000001",
+		},
+	},
+}
+
+var cyrusMessages = []string{"hello", "Hello, World! This is a longer message."}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCyrusVectorsServer(t *testing.T) {
+	for _, v := range cyrusVectors {
+		t.Run(v.cipher, func(t *testing.T) {
+			useNonce(t, v.nonce)
+			props := map[string]interface{}{sasl.SaslPropertyQop: "auth-conf,auth-int"}
+			server, err := NewMD5Server("imap", "elwood.innosoft.com", props, userHandler("chris", "secret", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.EvaluateResponse(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			response := fmt.Sprintf(`username="chris",realm="elwood.innosoft.com",nonce="%s",cnonce="%s",nc=00000001,`+
+				`qop=auth-conf,cipher=%s,maxbuf=65536,digest-uri="imap/elwood.innosoft.com",response=%s`,
+				v.nonce, v.cnonce, v.cipher, v.response)
+			rspAuth, err := server.EvaluateResponse([]byte(response))
+			if err != nil {
+				t.Fatal(err)
+			} else if string(rspAuth) != "rspauth="+v.rspAuth {
+				t.Fatalf("response-auth %q, want %q", rspAuth, v.rspAuth)
+			}
+
+			for i, message := range cyrusMessages {
+				token := decodeHex(t, v.clientTokens[i])
+				if got, err := server.Unwrap(token, 0, len(token)); err != nil {
+					t.Fatal(err)
+				} else if string(got) != message {
+					t.Errorf("unwrapped %q, want %q", got, message)
+				}
+				if token, err := server.Wrap([]byte(message), 0, len(message)); err != nil {
+					t.Fatal(err)
+				} else if hex.EncodeToString(token) != v.serverTokens[i] {
+					t.Errorf("server token %x, want %s", token, v.serverTokens[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCyrusVectorsClient(t *testing.T) {
+	for _, v := range cyrusVectors {
+		t.Run(v.cipher, func(t *testing.T) {
+			useNonce(t, v.cnonce)
+			props := map[string]interface{}{sasl.SaslPropertyQop: "auth-conf", CIPHER_PROPERTY: v.cipher}
+			client, err := NewMD5Client("", "imap", "elwood.innosoft.com", props, userHandler("chris", "secret", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			challenge := fmt.Sprintf(`realm="elwood.innosoft.com",nonce="%s",qop="auth-conf,auth-int",charset=utf-8,`+
+				`cipher="3des,rc4,des,rc4-56,rc4-40",algorithm=md5-sess`, v.nonce)
+			if _, err := client.EvaluateChallenge([]byte(challenge)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.EvaluateChallenge([]byte("rspauth=" + v.rspAuth)); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, message := range cyrusMessages {
+				if token, err := client.Wrap([]byte(message), 0, len(message)); err != nil {
+					t.Fatal(err)
+				} else if hex.EncodeToString(token) != v.clientTokens[i] {
+					t.Errorf("client token %x, want %s", token, v.clientTokens[i])
+				}
+				token := decodeHex(t, v.serverTokens[i])
+				if got, err := client.Unwrap(token, 0, len(token)); err != nil {
+					t.Fatal(err)
+				} else if string(got) != message {
+					t.Errorf("unwrapped %q, want %q", got, message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package sasl
+
+import "errors"
+
+// ExternalClient implements the EXTERNAL SASL client mechanism
+// https://tools.ietf.org/html/rfc4422#appendix-A
+//
+// The client is authenticated by means external to SASL, such as a TLS
+// client certificate, so its only message is the optional authorization
+// ID it asks to act as.
+type ExternalClient struct {
+	completed       bool
+	authorizationID string
+}
+
+// NewExternalClient creates a new ExternalClient instance. An empty
+// authorizationID requests the identity established by the external
+// means.
+func NewExternalClient(authorizationID string) (*ExternalClient, error) {
+	return &ExternalClient{authorizationID: authorizationID}, nil
+}
+
+// GetMechanismName returns the mechanism name "EXTERNAL".
+func (c *ExternalClient) GetMechanismName() string {
+	return "EXTERNAL"
+}
+
+// HasInitialResponse returns true: the authorization ID is sent as
+// initial response.
+func (c *ExternalClient) HasInitialResponse() bool {
+	return true
+}
+
+// EvaluateChallenge returns the authorization ID, which is empty if none
+// was requested.
+func (c *ExternalClient) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("EXTERNAL authentication already completed")
+	}
+	c.completed = true
+	return []byte(c.authorizationID), nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+// EXTERNAL completes after returning one response.
+func (c *ExternalClient) IsComplete() bool {
+	return c.completed
+}
+
+// Unwrap the incoming buffer.
+func (c *ExternalClient) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("EXTERNAL supports neither integrity nor privacy")
+	}
+	return nil, errors.New("EXTERNAL authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (c *ExternalClient) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("EXTERNAL supports neither integrity nor privacy")
+	}
+	return nil, errors.New("EXTERNAL authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (c *ExternalClient) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !c.completed {
+		return nil, errors.New("EXTERNAL authentication not completed")
+	}
+
+	if propName == SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (c *ExternalClient) Dispose() error {
+	return nil
+}
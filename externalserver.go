@@ -0,0 +1,254 @@
+package sasl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	// EXTERNAL_IDENTITY_PROPERTY is a property that specifies the identity
+	// of the client when it was established by the application itself.
+	// It takes precedence over the other sources of identity.
+	EXTERNAL_IDENTITY_PROPERTY = "golang.security.sasl.external.identity"
+
+	// EXTERNAL_TLS_STATE_PROPERTY is a property that specifies the
+	// *tls.ConnectionState of the connection the exchange is carried
+	// over. The identity of the client is mapped from its certificate,
+	// which must have been verified during the handshake.
+	EXTERNAL_TLS_STATE_PROPERTY = "golang.security.sasl.external.tls.state"
+
+	// EXTERNAL_CERTIFICATE_MAPPING_PROPERTY is a property that specifies
+	// how the identity is mapped from the client certificate. The property
+	// contains one of the EXTERNAL_MAPPING_* values, or a
+	// CertificateMapper. If this property is absent, the common name of
+	// the subject is used.
+	EXTERNAL_CERTIFICATE_MAPPING_PROPERTY = "golang.security.sasl.external.certificate.mapping"
+
+	// EXTERNAL_UNIX_CONN_PROPERTY is a property that specifies the
+	// *net.UnixConn the exchange is carried over. The identity of the
+	// client is the name of the user owning the peer process, or its
+	// numeric user ID if it has no name. Peer credentials are only
+	// available on Linux.
+	EXTERNAL_UNIX_CONN_PROPERTY = "golang.security.sasl.external.unix.conn"
+)
+
+// Mappings of a client certificate to an identity.
+const (
+	EXTERNAL_MAPPING_SUBJECT     = "subject"
+	EXTERNAL_MAPPING_COMMON_NAME = "cn"
+	EXTERNAL_MAPPING_EMAIL       = "san-email"
+	EXTERNAL_MAPPING_DNS         = "san-dns"
+	EXTERNAL_MAPPING_URI         = "san-uri"
+)
+
+// CertificateMapper maps a verified client certificate to an identity.
+type CertificateMapper func(certificate *x509.Certificate) (string, error)
+
+// PeerCredentials are the credentials of the process at the other end of
+// a Unix socket.
+type PeerCredentials struct {
+	Pid int
+	Uid int
+	Gid int
+}
+
+// ExternalServer implements the EXTERNAL SASL server mechanism
+// https://tools.ietf.org/html/rfc4422#appendix-A
+//
+// The identity of the client is taken, in order, from
+// EXTERNAL_IDENTITY_PROPERTY, from the verified client certificate of
+// EXTERNAL_TLS_STATE_PROPERTY, or from the peer credentials of
+// EXTERNAL_UNIX_CONN_PROPERTY. If the client asks for an authorization ID,
+// it is checked with an AuthorizeCallback.
+type ExternalServer struct {
+	completed       bool
+	cbh             CallbackHandler
+	props           map[string]interface{}
+	authorizationID string
+}
+
+// NewExternalServer creates a new ExternalServer instance.
+func NewExternalServer(props map[string]interface{}, cbh CallbackHandler) (*ExternalServer, error) {
+	if err := CheckMechanismPolicy("EXTERNAL", props); err != nil {
+		return nil, err
+	}
+	server := &ExternalServer{
+		cbh:   cbh,
+		props: props,
+	}
+	return server, nil
+}
+
+// GetMechanismName returns the mechanism name "EXTERNAL".
+func (s *ExternalServer) GetMechanismName() string {
+	return "EXTERNAL"
+}
+
+// EvaluateResponse processes the authorization ID sent by the client,
+// which is empty if the client acts as its external identity.
+func (s *ExternalServer) EvaluateResponse(response []byte) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("EXTERNAL authentication already completed")
+	}
+	if !utf8.Valid(response) {
+		return nil, errors.New("EXTERNAL: authorization ID is not valid UTF-8")
+	}
+
+	identity, err := s.getIdentity()
+	if err != nil {
+		return nil, err
+	}
+	authorizationID, err := Authorize(s.cbh, identity, string(response))
+	if err != nil {
+		return nil, errors.New("EXTERNAL: " + err.Error())
+	}
+	s.authorizationID = authorizationID
+	s.completed = true
+	return nil, nil
+}
+
+// getIdentity returns the identity of the client established outside of
+// SASL.
+func (s *ExternalServer) getIdentity() (string, error) {
+	if identity := PropertyValue(s.props, EXTERNAL_IDENTITY_PROPERTY); len(identity) > 0 {
+		return identity, nil
+	}
+
+	var state *tls.ConnectionState
+	switch value := s.props[EXTERNAL_TLS_STATE_PROPERTY].(type) {
+	case *tls.ConnectionState:
+		state = value
+	case tls.ConnectionState:
+		state = &value
+	}
+	if state != nil {
+		if len(state.VerifiedChains) <= 0 || len(state.VerifiedChains[0]) <= 0 {
+			return "", errors.New("EXTERNAL: client certificate not verified")
+		}
+		identity, err := s.mapCertificate(state.VerifiedChains[0][0])
+		if err != nil {
+			return "", fmt.Errorf("EXTERNAL: %s", err)
+		}
+		return identity, nil
+	}
+
+	if conn, ok := s.props[EXTERNAL_UNIX_CONN_PROPERTY].(*net.UnixConn); ok && conn != nil {
+		creds, err := GetPeerCredentials(conn)
+		if err != nil {
+			return "", fmt.Errorf("EXTERNAL: %s", err)
+		}
+		uid := strconv.Itoa(creds.Uid)
+		if u, err := user.LookupId(uid); err == nil {
+			return u.Username, nil
+		}
+		return uid, nil
+	}
+	return "", errors.New("EXTERNAL: no external identity available")
+}
+
+// hasExternalIdentity determines whether props hold a source of external
+// identity.
+func hasExternalIdentity(props map[string]interface{}) bool {
+	return props[EXTERNAL_IDENTITY_PROPERTY] != nil || props[EXTERNAL_TLS_STATE_PROPERTY] != nil ||
+		props[EXTERNAL_UNIX_CONN_PROPERTY] != nil
+}
+
+// mapCertificate maps certificate to an identity according to
+// EXTERNAL_CERTIFICATE_MAPPING_PROPERTY.
+func (s *ExternalServer) mapCertificate(certificate *x509.Certificate) (string, error) {
+	mapping := EXTERNAL_MAPPING_COMMON_NAME
+	switch value := s.props[EXTERNAL_CERTIFICATE_MAPPING_PROPERTY].(type) {
+	case CertificateMapper:
+		return value(certificate)
+	case func(*x509.Certificate) (string, error):
+		return value(certificate)
+	case string:
+		if len(value) > 0 {
+			mapping = value
+		}
+	}
+
+	var identity string
+	switch mapping {
+	case EXTERNAL_MAPPING_SUBJECT:
+		identity = certificate.Subject.String()
+	case EXTERNAL_MAPPING_COMMON_NAME:
+		identity = certificate.Subject.CommonName
+	case EXTERNAL_MAPPING_EMAIL:
+		if len(certificate.EmailAddresses) > 0 {
+			identity = certificate.EmailAddresses[0]
+		}
+	case EXTERNAL_MAPPING_DNS:
+		if len(certificate.DNSNames) > 0 {
+			identity = certificate.DNSNames[0]
+		}
+	case EXTERNAL_MAPPING_URI:
+		if len(certificate.URIs) > 0 {
+			identity = certificate.URIs[0].String()
+		}
+	default:
+		return "", fmt.Errorf("invalid certificate mapping %s", mapping)
+	}
+	if len(identity) <= 0 {
+		return "", fmt.Errorf("client certificate has no %s", mapping)
+	}
+	return identity, nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+func (s *ExternalServer) IsComplete() bool {
+	return s.completed
+}
+
+// GetAuthorizationID returns the authorization ID of the client, which is
+// its external identity when the client did not supply an authzid.
+func (s *ExternalServer) GetAuthorizationID() (string, error) {
+	if !s.completed {
+		return "", errors.New("EXTERNAL authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// Unwrap the incoming buffer.
+func (s *ExternalServer) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("EXTERNAL supports neither integrity nor privacy")
+	}
+	return nil, errors.New("EXTERNAL authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (s *ExternalServer) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("EXTERNAL supports neither integrity nor privacy")
+	}
+	return nil, errors.New("EXTERNAL authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (s *ExternalServer) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.completed {
+		return nil, errors.New("EXTERNAL authentication not completed")
+	}
+
+	if propName == SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (s *ExternalServer) Dispose() error {
+	s.cbh = nil
+	s.props = nil
+	return nil
+}
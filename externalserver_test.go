@@ -0,0 +1,262 @@
+package sasl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCertificate creates a certificate for template, signed by parent with
+// parentKey, or self-signed if parent is nil.
+func newCertificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, key
+}
+
+// verifiedState returns the state of a TLS connection whose client
+// presented certificate, issued by ca, and verified it.
+func verifiedState(t *testing.T, certificate, ca *x509.Certificate) *tls.ConnectionState {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	chains, err := certificate.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}, VerifiedChains: chains}
+}
+
+// clientCertificates returns a CA, a client certificate of alice with
+// every kind of identity, and one carrying a common name only.
+func clientCertificates(t *testing.T) (ca, alice, bare *x509.Certificate) {
+	ca, caKey := newCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Example CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	uri, err := url.Parse("spiffe://example.com/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ = newCertificate(t, &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		EmailAddresses: []string{"alice@example.com"},
+		DNSNames:       []string{"alice.example.com"},
+		URIs:           []*url.URL{uri},
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	bare, _ = newCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "bob"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	return ca, alice, bare
+}
+
+// authenticateExternal runs an EXTERNAL exchange in which the client asks
+// for authorizationID, and returns the authorization ID of the server.
+func authenticateExternal(props map[string]interface{}, cbh CallbackHandler, authorizationID string) (string, error) {
+	server, err := NewExternalServer(props, cbh)
+	if err != nil {
+		return "", err
+	}
+	if _, err := server.EvaluateResponse([]byte(authorizationID)); err != nil {
+		return "", err
+	} else if !server.IsComplete() {
+		return "", errors.New("exchange not complete")
+	}
+	return server.GetAuthorizationID()
+}
+
+func TestExternalCertificateMapping(t *testing.T) {
+	ca, alice, bare := clientCertificates(t)
+	state := verifiedState(t, alice, ca)
+	tests := []struct {
+		mapping interface{}
+		want    string
+	}{
+		{nil, "alice"},
+		{"", "alice"},
+		{EXTERNAL_MAPPING_SUBJECT, "CN=alice,O=Example"},
+		{EXTERNAL_MAPPING_COMMON_NAME, "alice"},
+		{EXTERNAL_MAPPING_EMAIL, "alice@example.com"},
+		{EXTERNAL_MAPPING_DNS, "alice.example.com"},
+		{EXTERNAL_MAPPING_URI, "spiffe://example.com/alice"},
+		{CertificateMapper(func(certificate *x509.Certificate) (string, error) {
+			return "serial-" + certificate.SerialNumber.String(), nil
+		}), "serial-2"},
+		{func(certificate *x509.Certificate) (string, error) {
+			return strings.ToUpper(certificate.Subject.CommonName), nil
+		}, "ALICE"},
+	}
+	for _, test := range tests {
+		props := map[string]interface{}{EXTERNAL_TLS_STATE_PROPERTY: state, EXTERNAL_CERTIFICATE_MAPPING_PROPERTY: test.mapping}
+		if identity, err := authenticateExternal(props, nil, ""); err != nil {
+			t.Errorf("mapping %v: %s", test.mapping, err)
+		} else if identity != test.want {
+			t.Errorf("mapping %v: identity %q, want %q", test.mapping, identity, test.want)
+		}
+	}
+
+	// A connection state given by value is accepted as well.
+	props := map[string]interface{}{EXTERNAL_TLS_STATE_PROPERTY: *state}
+	if identity, err := authenticateExternal(props, nil, ""); err != nil || identity != "alice" {
+		t.Errorf("identity %q, %v", identity, err)
+	}
+
+	bareState := verifiedState(t, bare, ca)
+	for _, mapping := range []string{EXTERNAL_MAPPING_EMAIL, EXTERNAL_MAPPING_DNS, EXTERNAL_MAPPING_URI} {
+		props := map[string]interface{}{EXTERNAL_TLS_STATE_PROPERTY: bareState, EXTERNAL_CERTIFICATE_MAPPING_PROPERTY: mapping}
+		if _, err := authenticateExternal(props, nil, ""); err == nil || !strings.Contains(err.Error(), "client certificate has no "+mapping) {
+			t.Errorf("mapping %s of a certificate without it: %v", mapping, err)
+		}
+	}
+
+	rejecting := CertificateMapper(func(certificate *x509.Certificate) (string, error) {
+		return "", errors.New("certificate revoked")
+	})
+	for _, mapping := range []interface{}{"issuer", rejecting} {
+		props := map[string]interface{}{EXTERNAL_TLS_STATE_PROPERTY: state, EXTERNAL_CERTIFICATE_MAPPING_PROPERTY: mapping}
+		if _, err := authenticateExternal(props, nil, ""); err == nil || !strings.HasPrefix(err.Error(), "EXTERNAL: ") {
+			t.Errorf("mapping %v: %v", mapping, err)
+		}
+	}
+}
+
+// TestExternalUnverifiedCertificate checks that certificates which were
+// not verified during the handshake are ignored.
+func TestExternalUnverifiedCertificate(t *testing.T) {
+	_, alice, _ := clientCertificates(t)
+	states := []*tls.ConnectionState{
+		{},
+		{PeerCertificates: []*x509.Certificate{alice}},
+		{PeerCertificates: []*x509.Certificate{alice}, VerifiedChains: [][]*x509.Certificate{{}}},
+	}
+	for _, state := range states {
+		props := map[string]interface{}{EXTERNAL_TLS_STATE_PROPERTY: state}
+		if _, err := authenticateExternal(props, nil, ""); err == nil || !strings.Contains(err.Error(), "not verified") {
+			t.Errorf("state %+v: %v", state, err)
+		}
+	}
+}
+
+func TestExternalIdentityPrecedence(t *testing.T) {
+	ca, alice, _ := clientCertificates(t)
+	state := verifiedState(t, alice, ca)
+
+	props := map[string]interface{}{EXTERNAL_IDENTITY_PROPERTY: "carol", EXTERNAL_TLS_STATE_PROPERTY: state}
+	if identity, err := authenticateExternal(props, nil, ""); err != nil || identity != "carol" {
+		t.Errorf("identity %q, %v, want the identity property", identity, err)
+	}
+	// An empty identity property is no identity.
+	props[EXTERNAL_IDENTITY_PROPERTY] = ""
+	if identity, err := authenticateExternal(props, nil, ""); err != nil || identity != "alice" {
+		t.Errorf("identity %q, %v, want the certificate", identity, err)
+	}
+
+	if _, err := authenticateExternal(nil, nil, ""); err == nil || !strings.Contains(err.Error(), "no external identity") {
+		t.Errorf("no identity: %v", err)
+	}
+}
+
+func TestExternalAuthorization(t *testing.T) {
+	cbh := CallbackHandlerFunc(func(callbacks []Callback) error {
+		for _, callback := range callbacks {
+			cb, ok := callback.(*AuthorizeCallback)
+			if !ok {
+				return &UnsupportedCallbackError{Callback: callback}
+			}
+			cb.SetAuthorized(cb.GetAuthenticationID() == cb.GetAuthorizationID() || cb.GetAuthorizationID() == "admin")
+		}
+		return nil
+	})
+	props := map[string]interface{}{EXTERNAL_IDENTITY_PROPERTY: "alice"}
+	tests := []struct {
+		cbh             CallbackHandler
+		authorizationID string
+		want            string
+	}{
+		{nil, "", "alice"},
+		{nil, "alice", "alice"},
+		{nil, "admin", ""},
+		{cbh, "", "alice"},
+		{cbh, "admin", "admin"},
+		{cbh, "root", ""},
+		{cbh, "\xff", ""},
+	}
+	for _, test := range tests {
+		authorized, err := authenticateExternal(props, test.cbh, test.authorizationID)
+		if len(test.want) <= 0 {
+			if err == nil {
+				t.Errorf("alice authorized as %q", test.authorizationID)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %s", test.authorizationID, err)
+		} else if authorized != test.want {
+			t.Errorf("%q: authorized %q, want %q", test.authorizationID, authorized, test.want)
+		}
+	}
+}
+
+// TestExternalServerFactory checks that EXTERNAL is only offered when the
+// properties hold a source of external identity.
+func TestExternalServerFactory(t *testing.T) {
+	ca, alice, _ := clientCertificates(t)
+	tests := []struct {
+		name  string
+		props map[string]interface{}
+		want  bool
+	}{
+		{"no identity", nil, false},
+		{"identity property", map[string]interface{}{EXTERNAL_IDENTITY_PROPERTY: "alice"}, true},
+		{"TLS state", map[string]interface{}{EXTERNAL_TLS_STATE_PROPERTY: verifiedState(t, alice, ca)}, true},
+		{"mapping only", map[string]interface{}{EXTERNAL_CERTIFICATE_MAPPING_PROPERTY: EXTERNAL_MAPPING_EMAIL}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if offered := containsMechanism(GetServerMechanismNames(test.props), "EXTERNAL"); offered != test.want {
+				t.Errorf("EXTERNAL offered: %v", offered)
+			}
+			server, err := CreateServer("EXTERNAL", "imap", "localhost", test.props, nil)
+			if test.want {
+				if err != nil {
+					t.Fatal(err)
+				} else if _, ok := server.(*ExternalServer); !ok {
+					t.Errorf("server %T", server)
+				}
+			} else if err == nil {
+				t.Error("EXTERNAL server created without an external identity")
+			}
+		})
+	}
+}
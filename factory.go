@@ -0,0 +1,133 @@
+package sasl
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+var (
+	// MECHANISMS are the mechanisms of this package, in order of
+	// preference.
+	MECHANISMS = []string{"EXTERNAL", "CRAM-MD5", "PLAIN", "LOGIN", "ANONYMOUS"}
+)
+
+func init() {
+	RegisterMechanismPolicy("PLAIN", POLICY_NOANONYMOUS)
+	RegisterMechanismPolicy("LOGIN", POLICY_NOANONYMOUS)
+	RegisterMechanismPolicy("CRAM-MD5", POLICY_NOANONYMOUS|POLICY_NOPLAINTEXT)
+	RegisterMechanismPolicy("ANONYMOUS", POLICY_NOPLAINTEXT)
+	RegisterMechanismPolicy("EXTERNAL", POLICY_NOPLAINTEXT|POLICY_NOACTIVE|POLICY_NODICTIONARY)
+	RegisterClientFactory(&clientFactory{})
+	RegisterServerFactory(&serverFactory{})
+}
+
+// clientFactory creates the client mechanisms of this package.
+type clientFactory struct{}
+
+// GetMechanismNames returns the client mechanisms allowed by props.
+func (f *clientFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return FilterMechanisms(MECHANISMS, props)
+}
+
+// CreateClient creates the client for the first supported mechanism.
+func (f *clientFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Client, error) {
+	allowed := f.GetMechanismNames(props)
+	for _, mechanism := range mechanisms {
+		if !containsMechanism(allowed, mechanism) {
+			continue
+		}
+		switch mechanism {
+		case "EXTERNAL":
+			return NewExternalClient(authorizationID)
+		case "CRAM-MD5":
+			return NewCramMD5ClientWithHandler(authorizationID, cbh)
+		case "PLAIN":
+			return NewPlainClientWithHandler(authorizationID, cbh)
+		case "LOGIN":
+			return NewLoginClientWithHandler(authorizationID, cbh)
+		case "ANONYMOUS":
+			return NewAnonymousClientWithHandler(cbh)
+		}
+	}
+	return nil, nil
+}
+
+// serverFactory creates the server mechanisms of this package.
+type serverFactory struct{}
+
+// GetMechanismNames returns the server mechanisms allowed by props.
+// EXTERNAL is only offered if props hold a source of external identity.
+func (f *serverFactory) GetMechanismNames(props map[string]interface{}) []string {
+	names := []string{}
+	for _, mechanism := range MECHANISMS {
+		if mechanism != "EXTERNAL" || hasExternalIdentity(props) {
+			names = append(names, mechanism)
+		}
+	}
+	return FilterMechanisms(names, props)
+}
+
+// CreateServer creates the server for mechanism.
+func (f *serverFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Server, error) {
+	if !containsMechanism(f.GetMechanismNames(props), mechanism) {
+		return nil, nil
+	}
+	switch mechanism {
+	case "EXTERNAL":
+		return NewExternalServer(props, cbh)
+	case "CRAM-MD5":
+		return NewCramMD5Server(protocol, serverName, props, cbh)
+	case "ANONYMOUS":
+		return NewAnonymousServer(props)
+	case "LOGIN":
+		return NewLoginServerWithHandler(props, cbh)
+	case "PLAIN":
+		return NewPlainServerWithHandler(props, cbh)
+	}
+	return nil, nil
+}
+
+// getUserInfo retrieves the authentication ID and password of the user
+// through cbh. The authorization ID, if any, is offered as default name.
+func getUserInfo(prefix, authorizationID string, cbh CallbackHandler) (string, []byte, error) {
+	if cbh == nil {
+		return "", nil, errors.New(prefix + ": callback handler to get username/password required")
+	}
+	ncb := NewNameCallback(prefix+" authentication id: ", authorizationID)
+	pcb := NewPasswordCallback(prefix+" password: ", false)
+	if err := cbh.Handle([]Callback{ncb, pcb}); err != nil {
+		return "", nil, err
+	}
+	defer pcb.ClearPassword()
+
+	name := ncb.GetName()
+	if len(name) <= 0 {
+		name = ncb.GetDefaultName()
+	}
+	if len(name) <= 0 {
+		return "", nil, errors.New(prefix + ": authentication ID not supplied")
+	} else if pcb.GetPassword() == nil {
+		return "", nil, errors.New(prefix + ": password not supplied")
+	}
+	return name, append([]byte{}, pcb.GetPassword()...), nil
+}
+
+// verifyPassword compares pw with the password of authenticationID
+// retrieved through cbh. The user name is passed as default name of the
+// NameCallback.
+func verifyPassword(prefix, authenticationID string, pw []byte, cbh CallbackHandler) error {
+	ncb := NewNameCallback(prefix+" authentication id: ", authenticationID)
+	pcb := NewPasswordCallback(prefix+" password: ", false)
+	if err := cbh.Handle([]Callback{ncb, pcb}); err != nil {
+		return err
+	}
+	defer pcb.ClearPassword()
+
+	expected := pcb.GetPassword()
+	if expected == nil || subtle.ConstantTimeCompare(expected, pw) != 1 {
+		return errors.New(prefix + ": authentication failed")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package sasl
+
+import (
+	"testing"
+)
+
+// plainHandler answers the callbacks of a PLAIN server for user "tim",
+// allowing tim to act as "admin" only.
+func plainHandler(callbacks []Callback) error {
+	for _, callback := range callbacks {
+		switch cb := callback.(type) {
+		case *NameCallback:
+			cb.SetName(cb.GetDefaultName())
+		case *PasswordCallback:
+			cb.SetPassword([]byte("tanstaaftanstaaf"))
+		case *AuthorizeCallback:
+			cb.SetAuthorized(cb.GetAuthenticationID() == cb.GetAuthorizationID() ||
+				cb.GetAuthorizationID() == "admin")
+		default:
+			return &UnsupportedCallbackError{Callback: callback}
+		}
+	}
+	return nil
+}
+
+func TestPlainServerFactoryAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   string
+		authorized string
+	}{
+		{"no authzid", "\x00tim\x00tanstaaftanstaaf", "tim"},
+		{"same authzid", "tim\x00tim\x00tanstaaftanstaaf", "tim"},
+		{"approved proxy", "admin\x00tim\x00tanstaaftanstaaf", "admin"},
+		{"denied proxy", "root\x00tim\x00tanstaaftanstaaf", ""},
+		{"wrong password", "\x00tim\x00secret", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := CreateServer("PLAIN", "imap", "localhost", nil, CallbackHandlerFunc(plainHandler))
+			if err != nil {
+				t.Fatal(err)
+			}
+			challenge, err := server.EvaluateResponse([]byte(test.response))
+			if len(test.authorized) <= 0 {
+				if err == nil || server.IsComplete() {
+					t.Fatalf("expected failure, got challenge %q", challenge)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			} else if challenge != nil || !server.IsComplete() {
+				t.Fatalf("expected completion, got challenge %q", challenge)
+			}
+			if authorized, err := server.GetAuthorizationID(); err != nil {
+				t.Fatal(err)
+			} else if authorized != test.authorized {
+				t.Errorf("authorization ID = %q, want %q", authorized, test.authorized)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package sasl
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// GS2 channel binding flags (RFC 5801, RFC 5802).
+const (
+	// GS2_CBIND_NONE: the client does not support channel binding.
+	GS2_CBIND_NONE = "n"
+	// GS2_CBIND_NOT_ADVERTISED: the client supports channel binding but
+	// thinks the server does not.
+	GS2_CBIND_NOT_ADVERTISED = "y"
+	// GS2_CBIND_USED: the client requires channel binding; the flag is
+	// followed by "=" and the channel binding type.
+	GS2_CBIND_USED = "p"
+)
+
+// GS2Header is the header starting the first message of GS2-style
+// mechanisms such as SCRAM:
+//
+//	gs2-header = [gs2-nonstd-flag ","] gs2-cb-flag "," [gs2-authzid] ","
+//	gs2-cb-flag = ("p=" cb-name) / "n" / "y"
+//	gs2-authzid = "a=" saslname
+type GS2Header struct {
+	NonStandard        bool
+	ChannelBinding     string
+	ChannelBindingType string
+	AuthorizationID    string
+	raw                []byte
+}
+
+// NewGS2Header creates a GS2 header without channel binding.
+func NewGS2Header(authorizationID string) *GS2Header {
+	return &GS2Header{ChannelBinding: GS2_CBIND_NONE, AuthorizationID: authorizationID}
+}
+
+// Bytes encodes the header, including its trailing comma.
+func (h *GS2Header) Bytes() []byte {
+	header := &bytes.Buffer{}
+	if h.NonStandard {
+		header.WriteString("F,")
+	}
+	header.WriteString(h.ChannelBinding)
+	if h.ChannelBinding == GS2_CBIND_USED {
+		header.WriteString("=" + h.ChannelBindingType)
+	}
+	header.WriteByte(',')
+	if len(h.AuthorizationID) > 0 {
+		header.WriteString("a=" + EscapeSaslName(h.AuthorizationID))
+	}
+	header.WriteByte(',')
+	return header.Bytes()
+}
+
+// Raw returns the header as received if it was parsed by ParseGS2Header,
+// and its encoding otherwise. The channel binding input of the client
+// covers the header as sent, so servers compare it with this form rather
+// than with a new encoding.
+func (h *GS2Header) Raw() []byte {
+	if h.raw == nil {
+		return h.Bytes()
+	}
+	return append([]byte{}, h.raw...)
+}
+
+// ParseGS2Header parses the GS2 header at the start of message and returns
+// it together with the rest of the message.
+func ParseGS2Header(message []byte) (*GS2Header, []byte, error) {
+	h := &GS2Header{}
+	rest := message
+	if bytes.HasPrefix(rest, []byte("F,")) {
+		h.NonStandard = true
+		rest = rest[2:]
+	}
+
+	fields := bytes.SplitN(rest, []byte{','}, 3)
+	if len(fields) != 3 {
+		return nil, nil, errors.New("GS2: invalid header")
+	}
+	cbFlag, authzid := string(fields[0]), string(fields[1])
+	switch {
+	case cbFlag == GS2_CBIND_NONE, cbFlag == GS2_CBIND_NOT_ADVERTISED:
+		h.ChannelBinding = cbFlag
+	case strings.HasPrefix(cbFlag, GS2_CBIND_USED+"="):
+		h.ChannelBinding = GS2_CBIND_USED
+		h.ChannelBindingType = cbFlag[2:]
+		if !isChannelBindingType(h.ChannelBindingType) {
+			return nil, nil, errors.New("GS2: invalid channel binding type " + h.ChannelBindingType)
+		}
+	default:
+		return nil, nil, errors.New("GS2: invalid channel binding flag " + cbFlag)
+	}
+
+	if len(authzid) > 0 {
+		if !strings.HasPrefix(authzid, "a=") {
+			return nil, nil, errors.New("GS2: invalid authorization ID")
+		}
+		name, err := UnescapeSaslName(authzid[2:])
+		if err != nil {
+			return nil, nil, err
+		}
+		h.AuthorizationID = name
+	}
+	h.raw = append([]byte{}, message[:len(message)-len(fields[2])]...)
+	return h, fields[2], nil
+}
+
+// EscapeSaslName encodes "=" and "," in name as "=3D" and "=2C".
+func EscapeSaslName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+// UnescapeSaslName decodes a saslname encoded by EscapeSaslName. Any other
+// use of "=" is an error.
+func UnescapeSaslName(name string) (string, error) {
+	if !strings.Contains(name, "=") {
+		return name, nil
+	}
+	decoded := &strings.Builder{}
+	for i := 0; i < len(name); i++ {
+		if name[i] != '=' {
+			decoded.WriteByte(name[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name[i:], "=3D"):
+			decoded.WriteByte('=')
+		case strings.HasPrefix(name[i:], "=2C"):
+			decoded.WriteByte(',')
+		default:
+			return "", errors.New("GS2: invalid encoding in saslname")
+		}
+		i += 2
+	}
+	return decoded.String(), nil
+}
+
+// isChannelBindingType checks the syntax of a channel binding type:
+//
+//	cb-name = 1*(ALPHA / DIGIT / "." / "-")
+func isChannelBindingType(name string) bool {
+	if len(name) <= 0 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
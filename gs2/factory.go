@@ -0,0 +1,88 @@
+package gs2
+
+import (
+	"strings"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// RegisterMechanism makes mech available as the SASL mechanisms
+// GS2-<NAME> and GS2-<NAME>-PLUS, with the policy flags of mech. The
+// -PLUS mechanism is offered, ahead of the other, when channel bindings
+// are available.
+func RegisterMechanism(mech Mechanism, flags int) error {
+	mechanism, err := GetMechanismName(mech)
+	if err != nil {
+		return err
+	}
+	sasl.RegisterMechanismPolicy(mechanism, flags)
+	sasl.RegisterMechanismPolicy(mechanism+PLUS_SUFFIX, flags)
+	sasl.RegisterClientFactory(&clientFactory{mech: mech, mechanism: mechanism})
+	sasl.RegisterServerFactory(&serverFactory{mech: mech, mechanism: mechanism})
+	return nil
+}
+
+// clientFactory creates the GS2 clients of a GSS-API mechanism.
+type clientFactory struct {
+	mech      Mechanism
+	mechanism string
+}
+
+// GetMechanismNames returns the GS2 mechanisms allowed by props.
+func (f *clientFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms(withPlusMechanism(f.mechanism, props), props)
+}
+
+// CreateClient creates a client for the first requested GS2 mechanism.
+// The credentials are taken from props by the GSS-API mechanism; cbh is
+// not used.
+func (f *clientFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Client, error) {
+	allowed := f.GetMechanismNames(props)
+	for _, mechanism := range mechanisms {
+		if containsMechanism(allowed, mechanism) {
+			return NewGS2Client(f.mech, strings.HasSuffix(mechanism, PLUS_SUFFIX), authorizationID, protocol, serverName, props)
+		}
+	}
+	return nil, nil
+}
+
+// serverFactory creates the GS2 servers of a GSS-API mechanism.
+type serverFactory struct {
+	mech      Mechanism
+	mechanism string
+}
+
+// GetMechanismNames returns the GS2 mechanisms allowed by props.
+func (f *serverFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms(withPlusMechanism(f.mechanism, props), props)
+}
+
+// CreateServer creates a server for mechanism, whose credentials are
+// taken from props by the GSS-API mechanism. Authorization decisions are
+// retrieved through cbh.
+func (f *serverFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Server, error) {
+	if !containsMechanism(f.GetMechanismNames(props), mechanism) {
+		return nil, nil
+	}
+	return NewGS2Server(f.mech, strings.HasSuffix(mechanism, PLUS_SUFFIX), protocol, serverName, props, cbh)
+}
+
+// withPlusMechanism returns mechanism, preceded by its -PLUS variant if
+// props hold channel bindings.
+func withPlusMechanism(mechanism string, props map[string]interface{}) []string {
+	if sasl.GetChannelBindings(props) == nil {
+		return []string{mechanism}
+	}
+	return []string{mechanism + PLUS_SUFFIX, mechanism}
+}
+
+func containsMechanism(names []string, mechanism string) bool {
+	for _, name := range names {
+		if name == mechanism {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package gs2
+
+import (
+	"bytes"
+	"errors"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+const (
+	GS2_PREFIX  = "GS2-"
+	PLUS_SUFFIX = "-PLUS"
+)
+
+// gs2Base contains the state shared by GS2 clients and servers.
+type gs2Base struct {
+	*sasl.Sasl
+	mechanism string
+	mech      Mechanism
+	plus      bool
+	secCtx    SecurityContext
+	step      int
+
+	// channelBinding is the channel binding of -PLUS exchanges, nil
+	// otherwise.
+	channelBinding *sasl.ChannelBinding
+}
+
+// newGS2Base creates the state of the client or server of the GS2
+// mechanism of mech, or of its -PLUS variant, whose policy must be
+// allowed by props.
+func newGS2Base(mech Mechanism, plus bool, props map[string]interface{}) (*gs2Base, error) {
+	mechanism, err := GetMechanismName(mech)
+	if err != nil {
+		return nil, err
+	}
+	if plus {
+		mechanism += PLUS_SUFFIX
+	}
+	if err := sasl.CheckMechanismPolicy(mechanism, props); err != nil {
+		return nil, err
+	}
+	b := &gs2Base{
+		Sasl:      &sasl.Sasl{},
+		mechanism: mechanism,
+		mech:      mech,
+		plus:      plus,
+		step:      1,
+	}
+	return b, nil
+}
+
+// GetMechanismName returns the GS2 mechanism name, such as "GS2-KRB5".
+func (b *gs2Base) GetMechanismName() string {
+	return b.mechanism
+}
+
+// Unwrap the incoming buffer.
+func (b *gs2Base) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if b.Completed {
+		return nil, errors.New(b.mechanism + " supports neither integrity nor privacy")
+	}
+	return nil, errors.New(b.mechanism + " authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (b *gs2Base) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if b.Completed {
+		return nil, errors.New(b.mechanism + " supports neither integrity nor privacy")
+	}
+	return nil, errors.New(b.mechanism + " authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property. The channel
+// binding the exchange was bound to is available as
+// sasl.SaslPropertyChannelBinding.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (b *gs2Base) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !b.Completed {
+		return nil, errors.New(b.mechanism + " authentication not completed")
+	}
+	switch propName {
+	case sasl.SaslPropertyQop, sasl.SaslPropertyChannelBinding, sasl.SaslPropertyChannelBindingType:
+		return b.Sasl.GetNegotiatedProperty(propName)
+	}
+	return nil, nil
+}
+
+// GetSecurityContext returns the GSS-API security context of the
+// exchange, which can be used to protect messages with Wrap() and
+// GetMIC() once the exchange has completed.
+func (b *gs2Base) GetSecurityContext() SecurityContext {
+	return b.secCtx
+}
+
+// checkEstablished ends the exchange if the security context is
+// established, which requires the acceptor to have authenticated to the
+// initiator.
+func (b *gs2Base) checkEstablished() (bool, error) {
+	if !b.secCtx.IsEstablished() {
+		return false, nil
+	}
+	if !b.secCtx.GetMutualAuthState() {
+		return false, errors.New(b.mechanism + ": mutual authentication not established")
+	}
+	return true, nil
+}
+
+// complete ends the exchange, recording the channel binding it was bound
+// to as verified.
+func (b *gs2Base) complete() {
+	b.Completed = true
+	b.ChannelBinding = b.channelBinding
+	b.step = 0
+}
+
+func (b *gs2Base) fail(err error) error {
+	b.step = 0
+	return err
+}
+
+// Dispose the sasl
+func (b *gs2Base) Dispose() error {
+	if b.secCtx != nil {
+		return b.secCtx.Dispose()
+	}
+	return nil
+}
+
+// channelBindingData returns the application data of the channel
+// bindings of the security context: the GS2 header as sent by the client
+// without the non-standard flag, followed by the channel binding data if
+// the client asked for channel binding (RFC 5801 section 5.1).
+func channelBindingData(header *sasl.GS2Header, cb *sasl.ChannelBinding) []byte {
+	data := header.Raw()
+	if header.NonStandard {
+		data = bytes.TrimPrefix(data, []byte("F,"))
+	}
+	if cb != nil {
+		data = append(data, cb.Data...)
+	}
+	return data
+}
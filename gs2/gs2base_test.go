@@ -0,0 +1,40 @@
+package gs2
+
+import (
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// TestChannelBindingData checks that the channel bindings cover the GS2
+// header as sent by the client.
+func TestChannelBindingData(t *testing.T) {
+	cb := &sasl.ChannelBinding{Type: sasl.CHANNEL_BINDING_TLS_EXPORTER, Data: []byte("exporter")}
+	tests := []struct {
+		message string
+		cb      *sasl.ChannelBinding
+		want    string
+	}{
+		{"n,,", nil, "n,,"},
+		{"y,a=admin,", nil, "y,a=admin,"},
+		// An empty authzid would be encoded without "a=".
+		{"n,a=,", nil, "n,a=,"},
+		{"F,n,a=admin,", nil, "n,a=admin,"},
+		{"p=tls-exporter,,", cb, "p=tls-exporter,,exporter"},
+		{"F,p=tls-exporter,a=a=2Cb,", cb, "p=tls-exporter,a=a=2Cb,exporter"},
+	}
+	for _, test := range tests {
+		header, _, err := sasl.ParseGS2Header([]byte(test.message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := channelBindingData(header, test.cb); string(data) != test.want {
+			t.Errorf("%q: channel binding data %q, want %q", test.message, data, test.want)
+		}
+	}
+
+	// Headers created by clients are encoded.
+	if data := channelBindingData(sasl.NewGS2Header("admin"), nil); string(data) != "n,a=admin," {
+		t.Errorf("channel binding data %q", data)
+	}
+}
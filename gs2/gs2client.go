@@ -0,0 +1,152 @@
+package gs2
+
+import (
+	"errors"
+	"fmt"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// GS2Client is an implementation of the GS2 SASL client-side mechanisms
+// (RFC 5801), which authenticate with a GSS-API Mechanism.
+//
+// The initial response is the GS2 header followed by the first context
+// token of the initiator, whose mechanism-independent header is removed;
+// the "F" flag is sent if the mechanism produced a token without such a
+// header. The tokens of the acceptor are then processed until the
+// context is established, which requires mutual authentication. The GS2
+// header, and the channel binding data of -PLUS clients, are the
+// application data of the channel bindings of the security context. No
+// security layer is negotiated.
+//
+// A -PLUS client binds the exchange to the connection with the GS2 flag
+// "p". Any other client given channel bindings sends the flag "y", so
+// that a server supporting channel binding can detect that the -PLUS
+// mechanisms were stripped from the list it advertised.
+//
+// The following properties are used, besides those of the mechanism:
+//
+//	sasl.SaslPropertyChannelBinding     - the channel bindings of the
+//	                                      connection
+//	sasl.SaslPropertyChannelBindingType - channel binding type of -PLUS
+//	                                      clients
+type GS2Client struct {
+	*gs2Base
+	gs2Header *sasl.GS2Header
+}
+
+// NewGS2Client creates a client of the GS2 mechanism of mech, or of its
+// -PLUS variant, authenticating to the service protocol/serverName.
+func NewGS2Client(mech Mechanism, plus bool, authorizationID, protocol, serverName string, props map[string]interface{}) (*GS2Client, error) {
+	base, err := newGS2Base(mech, plus, props)
+	if err != nil {
+		return nil, err
+	}
+	client := &GS2Client{
+		gs2Base:   base,
+		gs2Header: sasl.NewGS2Header(authorizationID),
+	}
+	if err := client.setChannelBinding(props); err != nil {
+		return nil, err
+	}
+	if client.secCtx, err = mech.CreateInitiatorContext(protocol, serverName, props); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// setChannelBinding selects the GS2 channel binding flag, and retrieves
+// the channel binding data of -PLUS clients.
+func (c *GS2Client) setChannelBinding(props map[string]interface{}) error {
+	bindings := sasl.GetChannelBindings(props)
+	if !c.plus {
+		if bindings != nil {
+			c.gs2Header.ChannelBinding = sasl.GS2_CBIND_NOT_ADVERTISED
+		}
+		return nil
+	}
+
+	if bindings == nil {
+		return errors.New(c.mechanism + ": channel binding requires " + sasl.SaslPropertyChannelBinding)
+	}
+	cb, err := bindings.Select(sasl.PropertyValue(props, sasl.SaslPropertyChannelBindingType))
+	if err != nil {
+		return fmt.Errorf("%s: %s", c.mechanism, err)
+	}
+	c.gs2Header.ChannelBinding = sasl.GS2_CBIND_USED
+	c.gs2Header.ChannelBindingType = cb.Type
+	c.channelBinding = cb
+	return nil
+}
+
+// HasInitialResponse returns true: GS2 starts with the GS2 header and
+// the first context token.
+func (c *GS2Client) HasInitialResponse() bool {
+	return true
+}
+
+// EvaluateChallenge processes the challenges sent by the server.
+//
+// Step 1 returns the GS2 header and the first context token. Step 2
+// processes the context tokens of the server, and returns the next
+// context token until the context is established.
+func (c *GS2Client) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	switch c.step {
+	case 1:
+		response, err := c.generateInitialResponse()
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		return response, nil
+	case 2:
+		response, err := c.initSecContext(challengeData)
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		return response, nil
+	default:
+		return nil, errors.New(c.mechanism + ": Client at illegal state")
+	}
+}
+
+// generateInitialResponse creates the first context token and prefixes
+// it with the GS2 header:
+//
+//	gss-header-initial-response = gs2-header [gss-token]
+func (c *GS2Client) generateInitialResponse() ([]byte, error) {
+	c.secCtx.RequestMutualAuth(true)
+	c.secCtx.SetChannelBinding(channelBindingData(c.gs2Header, c.channelBinding))
+	token, err := c.secCtx.InitSecContext(nil)
+	if err != nil {
+		return nil, err
+	}
+	token, standard := stripTokenHeader(token, c.mech.GetOID())
+	c.gs2Header.NonStandard = !standard
+
+	if established, err := c.checkEstablished(); err != nil {
+		return nil, err
+	} else if established {
+		c.complete()
+	} else {
+		c.step = 2
+	}
+	return append(c.gs2Header.Bytes(), token...), nil
+}
+
+// initSecContext processes a context token of the server and returns the
+// next context token, if any.
+func (c *GS2Client) initSecContext(token []byte) ([]byte, error) {
+	response, err := c.secCtx.InitSecContext(token)
+	if err != nil {
+		return nil, err
+	}
+	if established, err := c.checkEstablished(); err != nil {
+		return nil, err
+	} else if established {
+		c.complete()
+		if len(response) <= 0 {
+			return nil, nil
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,178 @@
+package gs2
+
+import (
+	"errors"
+	"fmt"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// GS2Server is an implementation of the GS2 SASL server-side mechanisms
+// (RFC 5801), which authenticate with a GSS-API Mechanism.
+//
+// The GS2 header is parsed from the initial response, and the context
+// tokens of the client are processed until the context is established,
+// which requires mutual authentication. The last context token of the
+// server, if any, is returned as the challenge accompanying the success.
+// The name of the initiator, mapped by the security context if it is a
+// NameMapper, is the authentication ID, and the authorization ID is checked with an AuthorizeCallback if a callback
+// handler is given; otherwise clients may only act as themselves.
+//
+// A server given channel bindings through sasl.SaslPropertyChannelBinding
+// supports channel binding: -PLUS servers require the client to bind the
+// exchange to the connection with one of them, and the other servers
+// reject clients sending the GS2 flag "y". The security context verifies
+// that the client used the same channel bindings.
+type GS2Server struct {
+	*gs2Base
+	cbh             sasl.CallbackHandler
+	bindings        sasl.ChannelBindings
+	gs2Header       *sasl.GS2Header
+	authorizationID string
+	challenged      bool
+}
+
+// NewGS2Server creates a server of the GS2 mechanism of mech, or of its
+// -PLUS variant, for the service protocol/serverName.
+func NewGS2Server(mech Mechanism, plus bool, protocol, serverName string, props map[string]interface{}, cbh sasl.CallbackHandler) (*GS2Server, error) {
+	base, err := newGS2Base(mech, plus, props)
+	if err != nil {
+		return nil, err
+	}
+	server := &GS2Server{
+		gs2Base:  base,
+		cbh:      cbh,
+		bindings: sasl.GetChannelBindings(props),
+	}
+	if server.plus && server.bindings == nil {
+		return nil, errors.New(server.mechanism + ": channel binding requires " + sasl.SaslPropertyChannelBinding)
+	}
+	if server.secCtx, err = mech.CreateAcceptorContext(protocol, serverName, props); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// EvaluateResponse processes the responses sent by the client.
+//
+// Step 1 parses the GS2 header and processes the first context token; an
+// empty challenge is returned if the client sent no initial response.
+// Step 2 processes the following context tokens. Both return the next
+// context token of the server.
+func (s *GS2Server) EvaluateResponse(response []byte) ([]byte, error) {
+	switch s.step {
+	case 1:
+		if len(response) == 0 && !s.challenged {
+			s.challenged = true
+			return []byte{}, nil
+		}
+		challenge, err := s.processInitialResponse(response)
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		return challenge, nil
+	case 2:
+		challenge, err := s.acceptSecContext(response)
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		return challenge, nil
+	default:
+		return nil, errors.New(s.mechanism + ": Server at illegal state")
+	}
+}
+
+// processInitialResponse parses the GS2 header, and restores the header
+// of the first context token unless the client sent the "F" flag.
+func (s *GS2Server) processInitialResponse(response []byte) ([]byte, error) {
+	header, token, err := sasl.ParseGS2Header(response)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.mechanism, err)
+	}
+	if err := s.checkChannelBinding(header); err != nil {
+		return nil, err
+	}
+	s.gs2Header = header
+	if !header.NonStandard {
+		if token, err = addTokenHeader(token, s.mech.GetOID()); err != nil {
+			return nil, err
+		}
+	}
+
+	s.secCtx.SetChannelBinding(channelBindingData(header, s.channelBinding))
+	s.step = 2
+	return s.acceptSecContext(token)
+}
+
+// checkChannelBinding applies the GS2 channel binding flag sent by the
+// client, and retrieves the channel binding data if the client asked for
+// channel binding.
+func (s *GS2Server) checkChannelBinding(header *sasl.GS2Header) error {
+	switch header.ChannelBinding {
+	case sasl.GS2_CBIND_USED:
+		if !s.plus {
+			return errors.New(s.mechanism + ": channel binding not supported")
+		}
+		cb := s.bindings.Get(header.ChannelBindingType)
+		if cb == nil {
+			return errors.New(s.mechanism + ": unsupported channel binding type " + header.ChannelBindingType)
+		}
+		s.channelBinding = cb
+	case sasl.GS2_CBIND_NOT_ADVERTISED:
+		if s.bindings != nil {
+			return errors.New(s.mechanism + ": server does support channel binding")
+		}
+	}
+	if s.plus && header.ChannelBinding != sasl.GS2_CBIND_USED {
+		return errors.New(s.mechanism + ": channel binding required")
+	}
+	return nil
+}
+
+// acceptSecContext processes a context token of the client and returns
+// the next context token. Once the context is established, the
+// authorization ID is checked.
+func (s *GS2Server) acceptSecContext(token []byte) ([]byte, error) {
+	challenge, err := s.secCtx.AcceptSecContext(token)
+	if err != nil {
+		return nil, err
+	}
+	if established, err := s.checkEstablished(); err != nil {
+		return nil, err
+	} else if !established {
+		return challenge, nil
+	}
+
+	authenticationID, err := s.secCtx.GetSrcName()
+	if err != nil {
+		return nil, err
+	}
+	if mapper, ok := s.secCtx.(NameMapper); ok {
+		if authenticationID, err = mapper.MapSrcName(authenticationID); err != nil {
+			return nil, err
+		}
+	}
+	if s.authorizationID, err = sasl.Authorize(s.cbh, authenticationID, s.gs2Header.AuthorizationID); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.mechanism, err)
+	}
+	s.complete()
+	if len(challenge) <= 0 {
+		return nil, nil
+	}
+	return challenge, nil
+}
+
+// GetAuthorizationID reports the authorization ID of the client, which is
+// the name of the initiator when the client did not supply an authzid.
+func (s *GS2Server) GetAuthorizationID() (string, error) {
+	if !s.Completed {
+		return "", errors.New(s.mechanism + " authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// Dispose the sasl
+func (s *GS2Server) Dispose() error {
+	s.cbh = nil
+	return s.gs2Base.Dispose()
+}
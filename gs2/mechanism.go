@@ -0,0 +1,151 @@
+package gs2
+
+import (
+	"crypto/sha1"
+	"encoding/asn1"
+	"errors"
+)
+
+// Mechanism is a GSS-API mechanism (RFC 2743) that can be used through
+// the GS2 SASL mechanisms GS2-<NAME> and GS2-<NAME>-PLUS (RFC 5801).
+// Mechanisms are made available with RegisterMechanism.
+type Mechanism interface {
+	// Returns the SASL name of the mechanism, without the "GS2-" prefix
+	// (e.g. "KRB5"), or an empty string if it has none, in which case
+	// the name is derived from the object identifier.
+	GetName() string
+	// Returns the object identifier of the mechanism.
+	GetOID() asn1.ObjectIdentifier
+	// Creates the security context of an initiator, authenticating to the
+	// service protocol/serverName. The credentials are taken from props.
+	CreateInitiatorContext(protocol, serverName string, props map[string]interface{}) (SecurityContext, error)
+	// Creates the security context of an acceptor for the service
+	// protocol/serverName. An empty serverName accepts the initiators of
+	// any host the credentials taken from props allow.
+	CreateAcceptorContext(protocol, serverName string, props map[string]interface{}) (SecurityContext, error)
+}
+
+// SecurityContext is the security context established between a
+// GSS-API initiator and acceptor, after org.ietf.jgss.GSSContext.
+//
+// The initiator calls InitSecContext(), and the acceptor
+// AcceptSecContext(), with the tokens received from the peer until the
+// context is established; each call returns the token to send to the
+// peer, if any. Once established, the context protects messages with
+// Wrap() and GetMIC().
+type SecurityContext interface {
+	// Requests that the acceptor authenticates to the initiator. It must
+	// be called before the context establishment starts.
+	RequestMutualAuth(state bool)
+	// Sets the application data of the channel bindings (RFC 2743
+	// section 1.1.6), which the initiator and acceptor must agree on. It
+	// must be called before the context establishment starts.
+	SetChannelBinding(applicationData []byte)
+	// Processes the token received from the acceptor, which is nil on
+	// the first call, and returns the token to send to the acceptor.
+	InitSecContext(inputToken []byte) ([]byte, error)
+	// Processes the token received from the initiator and returns the
+	// token to send to the initiator.
+	AcceptSecContext(inputToken []byte) ([]byte, error)
+	// Determines whether the context is established.
+	IsEstablished() bool
+	// Determines whether the acceptor authenticated to the initiator.
+	GetMutualAuthState() bool
+	// Returns the name of the initiator, once the context is
+	// established.
+	GetSrcName() (string, error)
+	// Returns the name of the acceptor, once the context is established.
+	GetTargName() (string, error)
+	// Wraps message into a token, encrypted if confReq is true.
+	Wrap(message []byte, confReq bool) ([]byte, error)
+	// Unwraps a token created by Wrap() on the peer, and returns the
+	// message and whether it was encrypted.
+	Unwrap(token []byte) ([]byte, bool, error)
+	// Returns a token carrying the message integrity code of message.
+	GetMIC(message []byte) ([]byte, error)
+	// Verifies a token created by GetMIC() on the peer for message.
+	VerifyMIC(message, mic []byte) error
+	// Disposes of the keys of the context. This method is idempotent.
+	Dispose() error
+}
+
+// NameMapper is implemented by the acceptor security contexts which map
+// the name of the initiator to the authentication ID of the client, such
+// as a principal to a local user name.
+type NameMapper interface {
+	// Maps the name of the initiator returned by GetSrcName().
+	MapSrcName(name string) (string, error)
+}
+
+// base32Alphabet is the alphabet of the Base32 encoding (RFC 4648
+// section 6).
+const base32Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// ComputeMechanismName derives the SASL name of a GSS-API mechanism from
+// its object identifier (RFC 5801 section 3.1): the first 55 bits of the
+// SHA-1 hash of the DER encoding of oid are encoded in Base32, and
+// prefixed with "GS2-".
+func ComputeMechanismName(oid asn1.ObjectIdentifier) (string, error) {
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		return "", err
+	}
+	hash := sha1.Sum(der)
+	name := []byte(GS2_PREFIX)
+	for i := 0; i < 11; i++ {
+		bit := i * 5
+		group := (uint(hash[bit/8])<<8 | uint(hash[bit/8+1])) >> (11 - bit%8) & 0x1F
+		name = append(name, base32Alphabet[group])
+	}
+	return string(name), nil
+}
+
+// GetMechanismName returns the SASL name of mech, without the -PLUS
+// suffix.
+func GetMechanismName(mech Mechanism) (string, error) {
+	if name := mech.GetName(); len(name) > 0 {
+		return GS2_PREFIX + name, nil
+	}
+	return ComputeMechanismName(mech.GetOID())
+}
+
+// stripTokenHeader removes the mechanism-independent header of the
+// initial context token of mechanism oid (RFC 2743 section 3.1):
+//
+//	InitialContextToken ::= [APPLICATION 0] IMPLICIT SEQUENCE {
+//	        thisMech MechType,
+//	        innerContextToken ANY DEFINED BY thisMech }
+//
+// false is returned, together with token, if token has no such header.
+func stripTokenHeader(token []byte, oid asn1.ObjectIdentifier) ([]byte, bool) {
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(token, &raw)
+	if err != nil || len(rest) > 0 || raw.Class != asn1.ClassApplication || raw.Tag != 0 || !raw.IsCompound {
+		return token, false
+	}
+	var mechOID asn1.ObjectIdentifier
+	inner, err := asn1.Unmarshal(raw.Bytes, &mechOID)
+	if err != nil || !mechOID.Equal(oid) {
+		return token, false
+	}
+	return inner, true
+}
+
+// addTokenHeader restores the header of the initial context token of
+// mechanism oid removed by stripTokenHeader.
+func addTokenHeader(token []byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	mechOID, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, err
+	}
+	header, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassApplication,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      append(mechOID, token...),
+	})
+	if err != nil {
+		return nil, errors.New("GS2: cannot encode initial context token")
+	}
+	return header, nil
+}
@@ -0,0 +1,47 @@
+package gs2
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestComputeMechanismName(t *testing.T) {
+	tests := []struct {
+		oid  asn1.ObjectIdentifier
+		want string
+	}{
+		// RFC 5801 section 3.1 example: SPKM-1
+		{asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 1, 1}, "GS2-DT4PIK22T6A"},
+		// Kerberos V5
+		{asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}, "GS2-QLJHGJLWNPL"},
+	}
+	for _, test := range tests {
+		if name, err := ComputeMechanismName(test.oid); err != nil {
+			t.Errorf("ComputeMechanismName(%s): %s", test.oid, err)
+		} else if name != test.want {
+			t.Errorf("ComputeMechanismName(%s) = %s, want %s", test.oid, name, test.want)
+		}
+	}
+}
+
+func TestTokenHeader(t *testing.T) {
+	oid := asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	token, err := addTokenHeader([]byte{0x01, 0x00, 0xAA}, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x60, 0x0E, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x12, 0x01, 0x02, 0x02, 0x01, 0x00, 0xAA}
+	if !bytes.Equal(token, want) {
+		t.Fatalf("token %x, want %x", token, want)
+	}
+	if inner, ok := stripTokenHeader(token, oid); !ok || !bytes.Equal(inner, []byte{0x01, 0x00, 0xAA}) {
+		t.Errorf("stripTokenHeader = %x, %v", inner, ok)
+	}
+	if _, ok := stripTokenHeader(token, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 1, 1}); ok {
+		t.Error("stripped the header of another mechanism")
+	}
+	if _, ok := stripTokenHeader([]byte{0x01, 0x00, 0xAA}, oid); ok {
+		t.Error("stripped the header of a token without one")
+	}
+}
@@ -0,0 +1,47 @@
+package sasl
+
+import (
+	"testing"
+)
+
+func TestParseGS2Header(t *testing.T) {
+	tests := []struct {
+		message string
+		header  GS2Header
+		raw     string
+		rest    string
+	}{
+		{"n,,n=user", GS2Header{ChannelBinding: GS2_CBIND_NONE}, "n,,", "n=user"},
+		{"y,a=admin,n=user", GS2Header{ChannelBinding: GS2_CBIND_NOT_ADVERTISED, AuthorizationID: "admin"}, "y,a=admin,", "n=user"},
+		{"p=tls-exporter,a=a=3Db=2Cc,", GS2Header{ChannelBinding: GS2_CBIND_USED, ChannelBindingType: "tls-exporter", AuthorizationID: "a=b,c"},
+			"p=tls-exporter,a=a=3Db=2Cc,", ""},
+		{"F,n,,token", GS2Header{NonStandard: true, ChannelBinding: GS2_CBIND_NONE}, "F,n,,", "token"},
+		// An empty authzid is encoded differently from the one received.
+		{"n,a=,n=user", GS2Header{ChannelBinding: GS2_CBIND_NONE}, "n,a=,", "n=user"},
+	}
+	for _, test := range tests {
+		header, rest, err := ParseGS2Header([]byte(test.message))
+		if err != nil {
+			t.Errorf("%q: %s", test.message, err)
+			continue
+		}
+		if header.NonStandard != test.header.NonStandard || header.ChannelBinding != test.header.ChannelBinding ||
+			header.ChannelBindingType != test.header.ChannelBindingType || header.AuthorizationID != test.header.AuthorizationID {
+			t.Errorf("%q: header %+v, want %+v", test.message, header, test.header)
+		}
+		if string(header.Raw()) != test.raw {
+			t.Errorf("%q: raw header %q, want %q", test.message, header.Raw(), test.raw)
+		} else if string(rest) != test.rest {
+			t.Errorf("%q: rest %q, want %q", test.message, rest, test.rest)
+		}
+	}
+
+	if raw := NewGS2Header("admin").Raw(); string(raw) != "n,a=admin," {
+		t.Errorf("raw header of a new header %q", raw)
+	}
+	for _, message := range []string{"n,n=user", "x,,n=user", "p=,,", "p=tls_unique,,", "n,b=admin,", "n,a=a=3Eb,"} {
+		if _, _, err := ParseGS2Header([]byte(message)); err == nil {
+			t.Errorf("%q: accepted", message)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package gssapi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// authToLocalRuleParser matches one rule of an auth_to_local rule set:
+//
+//	rule   = "DEFAULT" / "RULE:[" n ":" format "]" ["(" regex ")"]
+//	         ["s/" pattern "/" replacement "/" ["g"]] ["/L"]
+var authToLocalRuleParser = regexp.MustCompile(
+	`^\s*(?:(DEFAULT)|RULE:\[(\d*):([^\]]*)\](?:\(([^)]*)\))?(?:s/([^/]*)/([^/]*)/(g)?)?/?(L)?)`)
+
+// authToLocalParameter matches a parameter of the format of a rule.
+var authToLocalParameter = regexp.MustCompile(`\$(\d*)`)
+
+// AuthToLocal maps Kerberos principals to local user names with the
+// auth_to_local rules of Hadoop's hadoop.security.auth_to_local:
+//
+//	RULE:[n:format](regex)s/pattern/replacement/g/L
+//	DEFAULT
+//
+// A RULE applies to principals with n components. The format builds a
+// string from the realm, $0, and the components, $1 to $n; the rule
+// applies if regex matches the whole string, which is then rewritten with
+// the sed-style substitution, if any: all matches of pattern are replaced
+// if "g" is given, only the first one otherwise. "/L" converts the result
+// to lower case. DEFAULT maps the principals of the default realm to
+// their first component.
+//
+// The rules are tried in order, and the first that applies gives the
+// local name, which must not contain '/' or '@'.
+type AuthToLocal struct {
+	rules []*authToLocalRule
+}
+
+type authToLocalRule struct {
+	isDefault     bool
+	numComponents int
+	format        string
+	match         *regexp.Regexp
+	pattern       *regexp.Regexp
+	replacement   string
+	repeat        bool
+	toLowerCase   bool
+}
+
+// ParseAuthToLocal parses rules separated by white space.
+func ParseAuthToLocal(rules string) (*AuthToLocal, error) {
+	a := &AuthToLocal{}
+	remaining := strings.TrimSpace(rules)
+	for len(remaining) > 0 {
+		m := authToLocalRuleParser.FindStringSubmatchIndex(remaining)
+		if m == nil {
+			return nil, fmt.Errorf("GSSAPI: invalid auth_to_local rule: %s", remaining)
+		}
+		rule, err := newAuthToLocalRule(remaining, m)
+		if err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, rule)
+		remaining = remaining[m[1]:]
+		if len(remaining) > 0 && !strings.ContainsAny(remaining[:1], " \t\r\n") {
+			return nil, fmt.Errorf("GSSAPI: invalid auth_to_local rule: %s", remaining)
+		}
+		remaining = strings.TrimSpace(remaining)
+	}
+	return a, nil
+}
+
+// newAuthToLocalRule creates the rule whose submatches m were found in
+// rules.
+func newAuthToLocalRule(rules string, m []int) (*authToLocalRule, error) {
+	group := func(i int) (string, bool) {
+		if m[2*i] < 0 {
+			return "", false
+		}
+		return rules[m[2*i]:m[2*i+1]], true
+	}
+	if _, ok := group(1); ok {
+		return &authToLocalRule{isDefault: true}, nil
+	}
+
+	rule := &authToLocalRule{}
+	var err error
+	n, _ := group(2)
+	if rule.numComponents, err = strconv.Atoi(n); err != nil || rule.numComponents <= 0 {
+		return nil, fmt.Errorf("GSSAPI: invalid number of components in auth_to_local rule: %q", n)
+	}
+	rule.format, _ = group(3)
+	if match, ok := group(4); ok {
+		if rule.match, err = regexp.Compile("^(?:" + match + ")$"); err != nil {
+			return nil, fmt.Errorf("GSSAPI: invalid auth_to_local regex: %s", err)
+		}
+	}
+	if pattern, ok := group(5); ok {
+		if rule.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("GSSAPI: invalid auth_to_local pattern: %s", err)
+		}
+		rule.replacement, _ = group(6)
+		_, rule.repeat = group(7)
+	}
+	_, rule.toLowerCase = group(8)
+	return rule, nil
+}
+
+// GetShortName maps principal, as "name@REALM", to a local name. A
+// principal without realm is in defaultRealm, unless it has a single
+// component, in which case it is its own local name. An error is returned
+// if no rule applies.
+func (a *AuthToLocal) GetShortName(principal, defaultRealm string) (string, error) {
+	if !strings.ContainsAny(principal, "/@") {
+		return principal, nil
+	}
+	name, realm := splitPrincipal(principal, defaultRealm)
+	components := strings.Split(name, "/")
+
+	for _, rule := range a.rules {
+		result, err := rule.apply(realm, components, defaultRealm)
+		if err != nil {
+			return "", err
+		}
+		if len(result) > 0 {
+			return result, nil
+		}
+	}
+	return "", fmt.Errorf("GSSAPI: no auth_to_local rule applies to principal %s", principal)
+}
+
+// apply returns the local name given by the rule, or an empty string if
+// the rule does not apply.
+func (r *authToLocalRule) apply(realm string, components []string, defaultRealm string) (string, error) {
+	var result string
+	if r.isDefault {
+		if realm != defaultRealm {
+			return "", nil
+		}
+		result = components[0]
+	} else {
+		if len(components) != r.numComponents {
+			return "", nil
+		}
+		base, err := r.expandFormat(realm, components)
+		if err != nil {
+			return "", err
+		}
+		if r.match != nil && !r.match.MatchString(base) {
+			return "", nil
+		}
+		result = base
+		if r.pattern != nil {
+			result = r.substitute(base)
+		}
+	}
+
+	if strings.ContainsAny(result, "/@") {
+		return "", fmt.Errorf("GSSAPI: auth_to_local rule gives non-simple name %s", result)
+	}
+	if r.toLowerCase {
+		result = strings.ToLower(result)
+	}
+	return result, nil
+}
+
+// expandFormat replaces the parameters of the format with the realm and
+// components.
+func (r *authToLocalRule) expandFormat(realm string, components []string) (string, error) {
+	var err error
+	base := authToLocalParameter.ReplaceAllStringFunc(r.format, func(parameter string) string {
+		index, e := strconv.Atoi(parameter[1:])
+		switch {
+		case e != nil || index > len(components):
+			err = fmt.Errorf("GSSAPI: invalid parameter %s in auth_to_local format %s", parameter, r.format)
+			return ""
+		case index == 0:
+			return realm
+		default:
+			return components[index-1]
+		}
+	})
+	return base, err
+}
+
+// substitute applies the sed-style substitution of the rule.
+func (r *authToLocalRule) substitute(base string) string {
+	if r.repeat {
+		return r.pattern.ReplaceAllString(base, r.replacement)
+	}
+	loc := r.pattern.FindStringSubmatchIndex(base)
+	if loc == nil {
+		return base
+	}
+	replaced := r.pattern.ExpandString(nil, r.replacement, base, loc)
+	return base[:loc[0]] + string(replaced) + base[loc[1]:]
+}
+
+// NewAuthToLocalHandler creates a CallbackHandler authorizing clients to
+// act as the local user their authentication ID maps to with rules, in
+// defaultRealm. The authorized ID is the local name. Other callbacks, and
+// AuthorizeCallbacks for other authorization IDs, are passed to cbh; if
+// cbh is nil, such authorization requests are denied.
+func NewAuthToLocalHandler(rules *AuthToLocal, defaultRealm string, cbh sasl.CallbackHandler) sasl.CallbackHandler {
+	return sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		var unhandled []sasl.Callback
+		for _, callback := range callbacks {
+			acb, ok := callback.(*sasl.AuthorizeCallback)
+			if !ok {
+				unhandled = append(unhandled, callback)
+				continue
+			}
+			authenticationID := acb.GetAuthenticationID()
+			localName, err := rules.GetShortName(authenticationID, defaultRealm)
+			if err == nil && (acb.GetAuthorizationID() == authenticationID || acb.GetAuthorizationID() == localName) {
+				acb.SetAuthorized(true)
+				acb.SetAuthorizedID(localName)
+				continue
+			}
+			if cbh != nil {
+				unhandled = append(unhandled, callback)
+			}
+		}
+		if len(unhandled) <= 0 {
+			return nil
+		} else if cbh == nil {
+			return &sasl.UnsupportedCallbackError{Callback: unhandled[0]}
+		}
+		return cbh.Handle(unhandled)
+	})
+}
@@ -0,0 +1,211 @@
+package gssapi
+
+import (
+	"strings"
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// checkShortNames maps the principals of tests, in realm EXAMPLE.COM,
+// with rules. An empty local name expects an error containing err.
+func checkShortNames(t *testing.T, rules string, tests []struct{ principal, want, err string }) {
+	a, err := ParseAuthToLocal(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		name, err := a.GetShortName(test.principal, "EXAMPLE.COM")
+		if len(test.want) <= 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: mapped to %q, %v, want error %q", test.principal, name, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.principal, err)
+		} else if name != test.want {
+			t.Errorf("%s: mapped to %q, want %q", test.principal, name, test.want)
+		}
+	}
+}
+
+// TestAuthToLocalHadoop runs the vectors of Hadoop's TestKerberosName.
+func TestAuthToLocalHadoop(t *testing.T) {
+	rules := `RULE:[1:$1@$0](.*@YAHOO\.COM)s/@.*//
+		RULE:[2:$1](johndoe)s/^.*$/guest/
+		RULE:[2:$1;$2](^.*;admin$)s/;admin$//
+		RULE:[2:$2](root)
+		DEFAULT`
+	checkShortNames(t, rules, []struct{ principal, want, err string }{
+		{"omalley@EXAMPLE.COM", "omalley", ""},
+		{"hdfs/10.0.0.1@EXAMPLE.COM", "hdfs", ""},
+		{"oom@YAHOO.COM", "oom", ""},
+		{"johndoe/zoo@FOO.COM", "guest", ""},
+		{"joe/admin@FOO.COM", "joe", ""},
+		{"joe/root@FOO.COM", "root", ""},
+		// testAntiPatterns
+		{"owen/owen/owen@FOO.COM", "", "no auth_to_local rule applies"},
+		{"owen@foo/bar.com", "", "no auth_to_local rule applies"},
+		{"foo@ACME.COM", "", "no auth_to_local rule applies"},
+		{"root/joe@FOO.COM", "", "no auth_to_local rule applies"},
+	})
+
+	// testToLowerCase
+	rules = `RULE:[1:$1]/L
+		RULE:[2:$1]/L
+		RULE:[2:$1;$2](^.*;admin$)s/;admin$///L
+		RULE:[2:$1;$2](^.*;guest$)s/;guest$//g/L
+		DEFAULT`
+	checkShortNames(t, rules, []struct{ principal, want, err string }{
+		{"Joe@FOO.COM", "joe", ""},
+		{"Joe/root@FOO.COM", "joe", ""},
+		{"Joe/admin@FOO.COM", "joe", ""},
+		{"Joe/guestguest@FOO.COM", "joe", ""},
+	})
+}
+
+func TestAuthToLocalRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		tests []struct{ principal, want, err string }
+	}{
+		{"DEFAULT", "DEFAULT", []struct{ principal, want, err string }{
+			{"alice@EXAMPLE.COM", "alice", ""},
+			{"imap/host.example.com@EXAMPLE.COM", "imap", ""},
+			{"alice@OTHER.ORG", "", "no auth_to_local rule applies"},
+		}},
+		{"parameters", "RULE:[2:$0-$2-$1]", []struct{ principal, want, err string }{
+			{"imap/host.example.com@EXAMPLE.COM", "EXAMPLE.COM-host.example.com-imap", ""},
+			{"alice@EXAMPLE.COM", "", "no auth_to_local rule applies"},
+		}},
+		{"parameter out of range", "RULE:[1:$1$2]", []struct{ principal, want, err string }{
+			{"alice@EXAMPLE.COM", "", "invalid parameter $2"},
+		}},
+		{"empty parameter", "RULE:[1:$]", []struct{ principal, want, err string }{
+			{"alice@EXAMPLE.COM", "", "invalid parameter $"},
+		}},
+		{"substitution", "RULE:[1:$1]s/o/0/", []struct{ principal, want, err string }{
+			{"foo@EXAMPLE.COM", "f0o", ""},
+			{"bar@EXAMPLE.COM", "bar", ""},
+		}},
+		{"global substitution", "RULE:[1:$1]s/o/0/g", []struct{ principal, want, err string }{
+			{"foo@EXAMPLE.COM", "f00", ""},
+		}},
+		{"lower case", "RULE:[1:$1]s/-ADMIN//g/L", []struct{ principal, want, err string }{
+			{"ALICE-ADMIN@EXAMPLE.COM", "alice", ""},
+		}},
+		{"regex", "RULE:[1:$1@$0](.*@EXAMPLE\\.COM)s/@.*// DEFAULT", []struct{ principal, want, err string }{
+			{"alice@EXAMPLE.COM", "alice", ""},
+			{"alice@EXAMPLE.COMPANY", "", "no auth_to_local rule applies"},
+		}},
+		{"non-simple name", "RULE:[1:$1@$0] RULE:[2:$1/$2]", []struct{ principal, want, err string }{
+			{"alice@EXAMPLE.COM", "", "non-simple name alice@EXAMPLE.COM"},
+			{"imap/host@EXAMPLE.COM", "", "non-simple name imap/host"},
+		}},
+		// A principal without realm is in the default realm, and one
+		// without components is its own local name.
+		{"no realm", "RULE:[2:$1@$0]s/@EXAMPLE.COM$//", []struct{ principal, want, err string }{
+			{"alice", "alice", ""},
+			{"imap/host.example.com", "imap", ""},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkShortNames(t, test.rules, test.tests)
+		})
+	}
+}
+
+func TestParseAuthToLocalErrors(t *testing.T) {
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{"RULE:[0:$1]", "invalid number of components"},
+		{"RULE:[:$1]", "invalid number of components"},
+		{"RULE:[x:$1]", "invalid auth_to_local rule"},
+		{"RULE:[1:$1", "invalid auth_to_local rule"},
+		{"DEFAULTS", "invalid auth_to_local rule"},
+		{"RULE:[1:$1]junk", "invalid auth_to_local rule"},
+		{"DEFAULT RULE", "invalid auth_to_local rule"},
+		{"RULE:[1:$1](a(b)", "invalid auth_to_local regex"},
+		{"RULE:[1:$1]s/a(//", "invalid auth_to_local pattern"},
+	}
+	for _, test := range tests {
+		if _, err := ParseAuthToLocal(test.rules); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: %v, want %q", test.rules, err, test.err)
+		}
+	}
+	if a, err := ParseAuthToLocal(" \n"); err != nil || len(a.rules) != 0 {
+		t.Errorf("empty rules: %v", err)
+	}
+}
+
+func TestAuthToLocalHandler(t *testing.T) {
+	rules, err := ParseAuthToLocal("DEFAULT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// delegate authorizes anyone to act as "shared".
+	delegated := 0
+	delegate := sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		for _, callback := range callbacks {
+			delegated++
+			switch cb := callback.(type) {
+			case *sasl.AuthorizeCallback:
+				cb.SetAuthorized(cb.GetAuthorizationID() == "shared")
+			case *sasl.NameCallback:
+				cb.SetName("alice")
+			default:
+				return &sasl.UnsupportedCallbackError{Callback: callback}
+			}
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name             string
+		cbh              sasl.CallbackHandler
+		authenticationID string
+		authorizationID  string
+		want             string
+		delegated        bool
+	}{
+		{"principal", nil, "alice@EXAMPLE.COM", "alice@EXAMPLE.COM", "alice", false},
+		{"local name", nil, "alice@EXAMPLE.COM", "alice", "alice", false},
+		{"other user", nil, "alice@EXAMPLE.COM", "shared", "", false},
+		{"foreign realm", nil, "alice@OTHER.ORG", "alice", "", false},
+		{"delegated other user", delegate, "alice@EXAMPLE.COM", "shared", "shared", true},
+		{"delegated denial", delegate, "alice@EXAMPLE.COM", "root", "", true},
+		{"delegated foreign realm", delegate, "alice@OTHER.ORG", "shared", "shared", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delegated = 0
+			handler := NewAuthToLocalHandler(rules, "EXAMPLE.COM", test.cbh)
+			authorizedID, err := sasl.Authorize(handler, test.authenticationID, test.authorizationID)
+			if len(test.want) <= 0 {
+				if err == nil {
+					t.Errorf("authorized as %q", authorizedID)
+				}
+			} else if err != nil {
+				t.Error(err)
+			} else if authorizedID != test.want {
+				t.Errorf("authorized ID %q, want %q", authorizedID, test.want)
+			}
+			if (delegated > 0) != test.delegated {
+				t.Errorf("%d callbacks delegated", delegated)
+			}
+		})
+	}
+
+	// Other callbacks are passed to the delegate, or rejected without one.
+	ncb := sasl.NewNameCallback("name: ", "")
+	if err := NewAuthToLocalHandler(rules, "EXAMPLE.COM", delegate).Handle([]sasl.Callback{ncb}); err != nil || ncb.GetName() != "alice" {
+		t.Errorf("name %q, %v", ncb.GetName(), err)
+	}
+	err = NewAuthToLocalHandler(rules, "EXAMPLE.COM", nil).Handle([]sasl.Callback{ncb})
+	if _, ok := err.(*sasl.UnsupportedCallbackError); !ok {
+		t.Errorf("error %v", err)
+	}
+}
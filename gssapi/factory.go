@@ -0,0 +1,55 @@
+package gssapi
+
+import (
+	sasl "github.com/jellybean4/go-sasl"
+	"github.com/jellybean4/go-sasl/gs2"
+)
+
+func init() {
+	sasl.RegisterMechanismPolicy(MECHANISM_NAME, sasl.POLICY_NOPLAINTEXT|sasl.POLICY_NOACTIVE|sasl.POLICY_NOANONYMOUS)
+	sasl.RegisterClientFactory(&clientFactory{})
+	sasl.RegisterServerFactory(&serverFactory{})
+	if err := gs2.RegisterMechanism(&Krb5Mechanism{}, sasl.POLICY_NOPLAINTEXT|sasl.POLICY_NOACTIVE|sasl.POLICY_NOANONYMOUS); err != nil {
+		panic(err)
+	}
+}
+
+// clientFactory creates GSSAPI clients.
+type clientFactory struct{}
+
+// GetMechanismNames returns the GSSAPI mechanism if props allow it.
+func (f *clientFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms([]string{MECHANISM_NAME}, props)
+}
+
+// CreateClient creates a GSSAPI client if it is requested. The Kerberos
+// credentials are taken from props; cbh is not used.
+func (f *clientFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Client, error) {
+	for _, mechanism := range mechanisms {
+		if mechanism != MECHANISM_NAME {
+			continue
+		}
+		return NewKrb5Client(authorizationID, protocol, serverName, props)
+	}
+	return nil, nil
+}
+
+// serverFactory creates GSSAPI servers.
+type serverFactory struct{}
+
+// GetMechanismNames returns the GSSAPI mechanism if props allow it.
+func (f *serverFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms([]string{MECHANISM_NAME}, props)
+}
+
+// CreateServer creates a GSSAPI server whose service keys are taken from
+// the keytab given in props. Authorization decisions are retrieved
+// through cbh.
+func (f *serverFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Server, error) {
+	if mechanism != MECHANISM_NAME {
+		return nil, nil
+	}
+	return NewKrb5Server(protocol, serverName, props, cbh)
+}
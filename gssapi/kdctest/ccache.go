@@ -0,0 +1,67 @@
+package kdctest
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// CCACHE_VERSION is the version of the credential cache files written by
+// a KDC.
+const CCACHE_VERSION = 0x0504
+
+// marshalCCache encodes a credential cache of cname holding a single
+// ticket, in the file format of MIT Kerberos version 4:
+//
+//	file       = version header default-principal *credential
+//	header     = length *(tag length value)
+//	credential = client server keyblock times is-skey ticket-flags
+//	             addresses authdata ticket second-ticket
+//
+// Integers are big-endian, and data are prefixed with their length as a
+// 4-octet integer.
+func marshalCCache(realm string, cname types.PrincipalName, tkt messages.Ticket, encPart *messages.EncKDCRepPart) ([]byte, error) {
+	ticket, err := tkt.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	b := &bytes.Buffer{}
+	write := func(value interface{}) {
+		binary.Write(b, binary.BigEndian, value)
+	}
+	writeData := func(data []byte) {
+		write(uint32(len(data)))
+		b.Write(data)
+	}
+	writePrincipal := func(name types.PrincipalName, realm string) {
+		write(uint32(name.NameType))
+		write(uint32(len(name.NameString)))
+		writeData([]byte(realm))
+		for _, component := range name.NameString {
+			writeData([]byte(component))
+		}
+	}
+
+	write(uint16(CCACHE_VERSION))
+	write(uint16(0))
+	writePrincipal(cname, realm)
+
+	writePrincipal(cname, realm)
+	writePrincipal(encPart.SName, encPart.SRealm)
+	write(uint16(encPart.Key.KeyType))
+	writeData(encPart.Key.KeyValue)
+	for _, t := range [...]int64{encPart.AuthTime.Unix(), encPart.StartTime.Unix(), encPart.EndTime.Unix(), 0} {
+		write(uint32(t))
+	}
+	b.WriteByte(0)
+	ticketFlags := make([]byte, 4)
+	copy(ticketFlags, encPart.Flags.Bytes)
+	b.Write(ticketFlags)
+	write(uint32(0))
+	write(uint32(0))
+	writeData(ticket)
+	writeData(nil)
+	return b.Bytes(), nil
+}
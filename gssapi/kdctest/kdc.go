@@ -0,0 +1,636 @@
+package kdctest
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const (
+	// DEFAULT_TICKET_LIFETIME is the lifetime of the tickets issued by a
+	// KDC, unless the client asks for a shorter one.
+	DEFAULT_TICKET_LIFETIME = 10 * time.Hour
+
+	// CLOCK_SKEW is the maximum difference between the time of a request
+	// and the time of the KDC.
+	CLOCK_SKEW = 5 * time.Minute
+
+	// MAX_MESSAGE_SIZE is the largest request accepted by a KDC.
+	MAX_MESSAGE_SIZE = 65536
+
+	// KVNO is the key version number of all keys of a KDC.
+	KVNO = 1
+)
+
+// ENCTYPE_NAMES holds the encryption types a KDC supports, by the names
+// used in krb5.conf.
+var ENCTYPE_NAMES = map[int32]string{
+	etypeID.AES256_CTS_HMAC_SHA1_96:    "aes256-cts-hmac-sha1-96",
+	etypeID.AES128_CTS_HMAC_SHA1_96:    "aes128-cts-hmac-sha1-96",
+	etypeID.AES128_CTS_HMAC_SHA256_128: "aes128-cts-hmac-sha256-128",
+}
+
+// DEFAULT_ENCTYPES are the encryption types of a KDC created without
+// explicit ones, in order of preference.
+var DEFAULT_ENCTYPES = []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96}
+
+// KDC is an in-memory Kerberos V5 key distribution center for tests. It
+// serves AS and TGS exchanges over TCP on the loopback interface, for the
+// principals added with AddPrincipal, and writes the krb5.conf, keytabs
+// and credential caches of its realm to a temporary directory, which is
+// removed by Close().
+//
+// Clients must pre-authenticate with PA-ENC-TIMESTAMP. Only the features
+// needed to obtain service tickets are implemented: there are no
+// renewable, forwardable or user-to-user tickets, no cross-realm
+// referrals and no FAST.
+type KDC struct {
+	realm          string
+	encTypes       []int32
+	dir            string
+	listener       net.Listener
+	mutex          sync.Mutex
+	passwords      map[string]string
+	keytab         *keytab.Keytab
+	ticketLifetime time.Duration
+	wg             sync.WaitGroup
+}
+
+// NewKDC starts a KDC for realm, with the given encryption types or
+// DEFAULT_ENCTYPES, and adds the principal of its ticket-granting
+// service.
+func NewKDC(realm string, encTypes ...int32) (*KDC, error) {
+	if len(realm) <= 0 {
+		return nil, errors.New("kdctest: realm must be specified")
+	}
+	if len(encTypes) <= 0 {
+		encTypes = DEFAULT_ENCTYPES
+	}
+	for _, encType := range encTypes {
+		if _, ok := ENCTYPE_NAMES[encType]; !ok {
+			return nil, fmt.Errorf("kdctest: unsupported encryption type %d", encType)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "kdctest")
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	k := &KDC{
+		realm:          realm,
+		encTypes:       append([]int32(nil), encTypes...),
+		dir:            dir,
+		listener:       listener,
+		passwords:      map[string]string{},
+		keytab:         keytab.New(),
+		ticketLifetime: DEFAULT_TICKET_LIFETIME,
+	}
+	if err := k.AddPrincipal("krbtgt/"+realm, ""); err != nil {
+		k.Close()
+		return nil, err
+	}
+	if err := os.WriteFile(k.GetConfigPath(), []byte(k.configString()), 0600); err != nil {
+		k.Close()
+		return nil, err
+	}
+
+	k.wg.Add(1)
+	go k.serve()
+	return k, nil
+}
+
+// GetRealm returns the realm of the KDC.
+func (k *KDC) GetRealm() string {
+	return k.realm
+}
+
+// GetAddress returns the TCP address the KDC listens on.
+func (k *KDC) GetAddress() string {
+	return k.listener.Addr().String()
+}
+
+// GetDirectory returns the temporary directory the files of the KDC are
+// written to.
+func (k *KDC) GetDirectory() string {
+	return k.dir
+}
+
+// SetTicketLifetime changes the lifetime of the tickets issued from now
+// on.
+func (k *KDC) SetTicketLifetime(lifetime time.Duration) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.ticketLifetime = lifetime
+}
+
+// AddPrincipal adds the principal name, such as "alice" or
+// "imap/host.example.com", to the realm. Its keys are derived from
+// password, or from a random password if password is empty.
+func (k *KDC) AddPrincipal(name, password string) error {
+	if len(name) <= 0 || strings.ContainsRune(name, '@') {
+		return fmt.Errorf("kdctest: invalid principal name %q", name)
+	}
+	if len(password) <= 0 {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		password = hex.EncodeToString(random)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, ok := k.passwords[name]; ok {
+		return fmt.Errorf("kdctest: principal %s already exists", name)
+	}
+	for _, encType := range k.encTypes {
+		if err := k.keytab.AddEntry(name, k.realm, password, time.Now(), KVNO, encType); err != nil {
+			return fmt.Errorf("kdctest: %s", err)
+		}
+	}
+	k.passwords[name] = password
+	return nil
+}
+
+// GetKeytab returns a keytab holding the keys of the principals names.
+func (k *KDC) GetKeytab(names ...string) (*keytab.Keytab, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	kt := keytab.New()
+	for _, name := range names {
+		password, ok := k.passwords[name]
+		if !ok {
+			return nil, fmt.Errorf("kdctest: unknown principal %s", name)
+		}
+		for _, encType := range k.encTypes {
+			if err := kt.AddEntry(name, k.realm, password, time.Now(), KVNO, encType); err != nil {
+				return nil, fmt.Errorf("kdctest: %s", err)
+			}
+		}
+	}
+	return kt, nil
+}
+
+// WriteKeytab writes the keys of the principals names to a new keytab
+// file, and returns its path.
+func (k *KDC) WriteKeytab(names ...string) (string, error) {
+	kt, err := k.GetKeytab(names...)
+	if err != nil {
+		return "", err
+	}
+	data, err := kt.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("kdctest: %s", err)
+	}
+	return k.writeFile("keytab", data)
+}
+
+// WriteCCache logs the principal name in, and writes its ticket-granting
+// ticket to a new credential cache file, whose path is returned.
+func (k *KDC) WriteCCache(name string) (string, error) {
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, name)
+	if _, err := k.getKey(cname, k.encTypes[0]); err != nil {
+		return "", err
+	}
+	tgs := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+k.realm)
+	now := time.Now().UTC()
+	tkt, encPart, err := k.issueTicket(cname, tgs, k.encTypes[0], now, time.Time{}, flags.Initial)
+	if err != nil {
+		return "", err
+	}
+	data, err := marshalCCache(k.realm, cname, tkt, encPart)
+	if err != nil {
+		return "", err
+	}
+	return k.writeFile("krb5cc_", data)
+}
+
+// GetConfigPath returns the path of the krb5.conf of the realm.
+func (k *KDC) GetConfigPath() string {
+	return filepath.Join(k.dir, "krb5.conf")
+}
+
+// GetConfig returns the Kerberos configuration of the realm.
+func (k *KDC) GetConfig() *config.Config {
+	cfg, _ := config.NewFromString(k.configString())
+	return cfg
+}
+
+// Close stops the KDC and removes its directory.
+func (k *KDC) Close() error {
+	err := k.listener.Close()
+	k.wg.Wait()
+	os.RemoveAll(k.dir)
+	return err
+}
+
+// configString returns the krb5.conf of the realm. Clients must use TCP,
+// as the KDC does not serve UDP.
+func (k *KDC) configString() string {
+	names := make([]string, 0, len(k.encTypes))
+	for _, encType := range k.encTypes {
+		names = append(names, ENCTYPE_NAMES[encType])
+	}
+	encTypes := strings.Join(names, " ")
+	return fmt.Sprintf(`[libdefaults]
+ default_realm = %[1]s
+ dns_lookup_kdc = false
+ dns_lookup_realm = false
+ dns_canonicalize_hostname = false
+ rdns = false
+ noaddresses = true
+ udp_preference_limit = 1
+ default_tkt_enctypes = %[3]s
+ default_tgs_enctypes = %[3]s
+ permitted_enctypes = %[3]s
+
+[realms]
+ %[1]s = {
+  kdc = %[2]s
+ }
+`, k.realm, k.GetAddress(), encTypes)
+}
+
+// writeFile writes data to a new file of the directory of the KDC.
+func (k *KDC) writeFile(prefix string, data []byte) (string, error) {
+	file, err := os.CreateTemp(k.dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// serve accepts connections until the listener is closed.
+func (k *KDC) serve() {
+	defer k.wg.Done()
+	for {
+		conn, err := k.listener.Accept()
+		if err != nil {
+			return
+		}
+		k.wg.Add(1)
+		go k.serveConn(conn)
+	}
+}
+
+// serveConn answers the requests sent over conn, each framed with its
+// length as a 4-octet big-endian integer (RFC 4120 section 7.2.2).
+func (k *KDC) serveConn(conn net.Conn) {
+	defer k.wg.Done()
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(30 * time.Second))
+		var length [4]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > MAX_MESSAGE_SIZE {
+			return
+		}
+		request := make([]byte, size)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		reply := k.handle(request)
+		binary.BigEndian.PutUint32(length[:], uint32(len(reply)))
+		if _, err := conn.Write(append(length[:], reply...)); err != nil {
+			return
+		}
+	}
+}
+
+// kdcError is an error reported to the client as a KRB-ERROR.
+type kdcError struct {
+	code  int32
+	text  string
+	eData []byte
+}
+
+func (e *kdcError) Error() string {
+	return e.text
+}
+
+func newKDCError(code int32, format string, args ...interface{}) *kdcError {
+	return &kdcError{code: code, text: fmt.Sprintf(format, args...)}
+}
+
+// handle answers an AS-REQ or a TGS-REQ with a reply or a KRB-ERROR.
+func (k *KDC) handle(request []byte) []byte {
+	var reply []byte
+	var err error
+	switch {
+	case len(request) > 0 && request[0] == 0x60|asnAppTag.ASREQ:
+		reply, err = k.handleASReq(request)
+	case len(request) > 0 && request[0] == 0x60|asnAppTag.TGSREQ:
+		reply, err = k.handleTGSReq(request)
+	default:
+		err = newKDCError(errorcode.KRB_ERR_GENERIC, "unsupported request")
+	}
+	if err == nil {
+		return reply
+	}
+
+	kerr, ok := err.(*kdcError)
+	if !ok {
+		kerr = newKDCError(errorcode.KRB_ERR_GENERIC, "%s", err)
+	}
+	tgs := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+k.realm)
+	krbErr := messages.NewKRBError(tgs, k.realm, kerr.code, kerr.text)
+	krbErr.EData = kerr.eData
+	reply, _ = krbErr.Marshal()
+	return reply
+}
+
+// handleASReq issues the ticket requested by an AS-REQ, once the client
+// proved the knowledge of its key with an encrypted timestamp.
+func (k *KDC) handleASReq(request []byte) ([]byte, error) {
+	var asReq messages.ASReq
+	if err := asReq.Unmarshal(request); err != nil {
+		return nil, newKDCError(errorcode.KRB_ERR_GENERIC, "%s", err)
+	}
+	body := &asReq.ReqBody
+	if body.Realm != k.realm {
+		return nil, newKDCError(errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "unknown realm %s", body.Realm)
+	}
+	encType, err := k.selectEncType(body.EType)
+	if err != nil {
+		return nil, err
+	}
+	clientKey, err := k.getKey(body.CName, encType)
+	if err != nil {
+		return nil, newKDCError(errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "%s", err)
+	}
+	if _, err := k.getKey(body.SName, encType); err != nil {
+		return nil, newKDCError(errorcode.KDC_ERR_S_PRINCIPAL_UNKNOWN, "%s", err)
+	}
+	eTypeInfo, err := k.newETypeInfo2(body.CName, encType)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.verifyPreAuthentication(asReq.PAData, body.CName, eTypeInfo); err != nil {
+		return nil, err
+	}
+
+	authTime := time.Now().UTC()
+	tkt, encPart, err := k.issueTicket(body.CName, body.SName, encType, authTime, body.Till, flags.Initial, flags.PreAuthent)
+	if err != nil {
+		return nil, err
+	}
+	encPart.Nonce = body.Nonce
+	encrypted, err := encryptKDCRepPart(encPart, asnAppTag.EncASRepPart, clientKey, keyusage.AS_REP_ENCPART)
+	if err != nil {
+		return nil, err
+	}
+	asRep := messages.ASRep{KDCRepFields: messages.KDCRepFields{
+		PVNO:    iana.PVNO,
+		MsgType: msgtype.KRB_AS_REP,
+		PAData:  types.PADataSequence{eTypeInfo},
+		CRealm:  k.realm,
+		CName:   body.CName,
+		Ticket:  tkt,
+		EncPart: encrypted,
+	}}
+	return asRep.Marshal()
+}
+
+// verifyPreAuthentication checks the PA-ENC-TIMESTAMP of an AS-REQ. The
+// error asking for pre-authentication carries the salt of the client's
+// key in eTypeInfo.
+func (k *KDC) verifyPreAuthentication(paData types.PADataSequence, cname types.PrincipalName, eTypeInfo types.PAData) error {
+	for _, pa := range paData {
+		if pa.PADataType != patype.PA_ENC_TIMESTAMP {
+			continue
+		}
+		var encrypted types.EncryptedData
+		if err := encrypted.Unmarshal(pa.PADataValue); err != nil {
+			return newKDCError(errorcode.KDC_ERR_PREAUTH_FAILED, "%s", err)
+		}
+		key, err := k.getKey(cname, encrypted.EType)
+		if err != nil {
+			return newKDCError(errorcode.KDC_ERR_PREAUTH_FAILED, "%s", err)
+		}
+		plaintext, err := crypto.DecryptEncPart(encrypted, key, keyusage.AS_REQ_PA_ENC_TIMESTAMP)
+		if err != nil {
+			return newKDCError(errorcode.KDC_ERR_PREAUTH_FAILED, "%s", err)
+		}
+		var timestamp types.PAEncTSEnc
+		if err := timestamp.Unmarshal(plaintext); err != nil {
+			return newKDCError(errorcode.KDC_ERR_PREAUTH_FAILED, "%s", err)
+		}
+		if skew := time.Since(timestamp.PATimestamp); skew > CLOCK_SKEW || skew < -CLOCK_SKEW {
+			return newKDCError(errorcode.KRB_AP_ERR_SKEW, "clock skew too great")
+		}
+		return nil
+	}
+
+	methods, err := asn1.Marshal(types.PADataSequence{eTypeInfo, {PADataType: patype.PA_ENC_TIMESTAMP}})
+	if err != nil {
+		return err
+	}
+	return &kdcError{code: errorcode.KDC_ERR_PREAUTH_REQUIRED, text: "pre-authentication required", eData: methods}
+}
+
+// handleTGSReq issues the ticket requested by a TGS-REQ, authenticated
+// with a ticket-granting ticket of the realm.
+func (k *KDC) handleTGSReq(request []byte) ([]byte, error) {
+	var tgsReq messages.TGSReq
+	if err := tgsReq.Unmarshal(request); err != nil {
+		return nil, newKDCError(errorcode.KRB_ERR_GENERIC, "%s", err)
+	}
+	body := &tgsReq.ReqBody
+	if types.IsFlagSet(&body.KDCOptions, flags.EncTktInSkey) || types.IsFlagSet(&body.KDCOptions, flags.Renew) ||
+		types.IsFlagSet(&body.KDCOptions, flags.Validate) {
+		return nil, newKDCError(errorcode.KDC_ERR_BADOPTION, "unsupported KDC options")
+	}
+
+	var apReq messages.APReq
+	for _, pa := range tgsReq.PAData {
+		if pa.PADataType == patype.PA_TGS_REQ {
+			if err := apReq.Unmarshal(pa.PADataValue); err != nil {
+				return nil, newKDCError(errorcode.KRB_ERR_GENERIC, "%s", err)
+			}
+		}
+	}
+	tgt := &apReq.Ticket
+	if len(tgt.SName.NameString) != 2 || tgt.SName.NameString[0] != "krbtgt" || tgt.SName.NameString[1] != k.realm ||
+		tgt.Realm != k.realm {
+		return nil, newKDCError(errorcode.KDC_ERR_POLICY, "ticket-granting ticket of %s expected", k.realm)
+	}
+	tgsKey, err := k.getKey(tgt.SName, tgt.EncPart.EType)
+	if err != nil {
+		return nil, newKDCError(errorcode.KRB_AP_ERR_BADKEYVER, "%s", err)
+	}
+	if err := tgt.Decrypt(tgsKey); err != nil {
+		return nil, newKDCError(errorcode.KRB_AP_ERR_BAD_INTEGRITY, "%s", err)
+	}
+	if err := apReq.DecryptAuthenticator(tgt.DecryptedEncPart.Key); err != nil {
+		return nil, newKDCError(errorcode.KRB_AP_ERR_BAD_INTEGRITY, "%s", err)
+	}
+	tgtPart := &tgt.DecryptedEncPart
+	auth := &apReq.Authenticator
+	if !auth.CName.Equal(tgtPart.CName) || auth.CRealm != tgtPart.CRealm {
+		return nil, newKDCError(errorcode.KRB_AP_ERR_BADMATCH, "authenticator does not match the ticket")
+	}
+	if skew := time.Since(auth.CTime); skew > CLOCK_SKEW || skew < -CLOCK_SKEW {
+		return nil, newKDCError(errorcode.KRB_AP_ERR_SKEW, "clock skew too great")
+	}
+	if time.Now().After(tgtPart.EndTime) {
+		return nil, newKDCError(errorcode.KRB_AP_ERR_TKT_EXPIRED, "ticket-granting ticket expired")
+	}
+
+	encType, err := k.selectEncType(body.EType)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := k.getKey(body.SName, encType); err != nil {
+		return nil, newKDCError(errorcode.KDC_ERR_S_PRINCIPAL_UNKNOWN, "%s", err)
+	}
+	till := tgtPart.EndTime
+	if !body.Till.IsZero() && body.Till.Before(till) {
+		till = body.Till
+	}
+	tkt, encPart, err := k.issueTicket(tgtPart.CName, body.SName, encType, tgtPart.AuthTime, till)
+	if err != nil {
+		return nil, err
+	}
+	encPart.Nonce = body.Nonce
+
+	replyKey, usage := tgtPart.Key, uint32(keyusage.TGS_REP_ENCPART_SESSION_KEY)
+	if len(auth.SubKey.KeyValue) > 0 {
+		replyKey, usage = auth.SubKey, keyusage.TGS_REP_ENCPART_AUTHENTICATOR_SUB_KEY
+	}
+	encrypted, err := encryptKDCRepPart(encPart, asnAppTag.EncTGSRepPart, replyKey, usage)
+	if err != nil {
+		return nil, err
+	}
+	tgsRep := messages.TGSRep{KDCRepFields: messages.KDCRepFields{
+		PVNO:    iana.PVNO,
+		MsgType: msgtype.KRB_TGS_REP,
+		CRealm:  tgtPart.CRealm,
+		CName:   tgtPart.CName,
+		Ticket:  tkt,
+		EncPart: encrypted,
+	}}
+	return tgsRep.Marshal()
+}
+
+// issueTicket creates a ticket of cname for sname, valid from now until
+// till or the end of the ticket lifetime, and the reply part carrying its
+// session key.
+func (k *KDC) issueTicket(cname, sname types.PrincipalName, encType int32, authTime, till time.Time,
+	ticketFlags ...int) (messages.Ticket, *messages.EncKDCRepPart, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	startTime := time.Now().UTC().Truncate(time.Second)
+	endTime := startTime.Add(k.ticketLifetime)
+	if !till.IsZero() && till.Before(endTime) {
+		endTime = till.UTC()
+	}
+	if !endTime.After(startTime) {
+		return messages.Ticket{}, nil, newKDCError(errorcode.KDC_ERR_NEVER_VALID, "requested end time is in the past")
+	}
+	authTime = authTime.UTC().Truncate(time.Second)
+	tktFlags := types.NewKrbFlags()
+	for _, flag := range ticketFlags {
+		types.SetFlag(&tktFlags, flag)
+	}
+
+	tkt, sessionKey, err := messages.NewTicket(cname, k.realm, sname, k.realm, tktFlags, k.keytab, encType, KVNO,
+		authTime, startTime, endTime, time.Time{})
+	if err != nil {
+		return messages.Ticket{}, nil, err
+	}
+	encPart := &messages.EncKDCRepPart{
+		Key:       sessionKey,
+		LastReqs:  []messages.LastReq{},
+		Flags:     tktFlags,
+		AuthTime:  authTime,
+		StartTime: startTime,
+		EndTime:   endTime,
+		SRealm:    k.realm,
+		SName:     sname,
+	}
+	return tkt, encPart, nil
+}
+
+// encryptKDCRepPart encrypts the reply part of an AS-REP or a TGS-REP,
+// tagged with tag.
+func encryptKDCRepPart(encPart *messages.EncKDCRepPart, tag int, key types.EncryptionKey, usage uint32) (types.EncryptedData, error) {
+	plaintext, err := asn1.Marshal(*encPart)
+	if err != nil {
+		return types.EncryptedData{}, err
+	}
+	plaintext = asn1tools.AddASNAppTag(plaintext, tag)
+	return crypto.GetEncryptedData(plaintext, key, usage, KVNO)
+}
+
+// selectEncType returns the first of the encryption types requested by a
+// client that the KDC supports.
+func (k *KDC) selectEncType(requested []int32) (int32, error) {
+	for _, encType := range requested {
+		for _, supported := range k.encTypes {
+			if encType == supported {
+				return encType, nil
+			}
+		}
+	}
+	return 0, newKDCError(errorcode.KDC_ERR_ETYPE_NOSUPP, "no supported encryption type requested")
+}
+
+// getKey returns the key of a principal of the realm.
+func (k *KDC) getKey(name types.PrincipalName, encType int32) (types.EncryptionKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	key, _, err := k.keytab.GetEncryptionKey(name, k.realm, KVNO, encType)
+	if err != nil {
+		return key, fmt.Errorf("unknown principal %s@%s", name.PrincipalNameString(), k.realm)
+	}
+	return key, nil
+}
+
+// newETypeInfo2 creates the PA-ETYPE-INFO2 telling a client how to
+// derive its key from its password.
+func (k *KDC) newETypeInfo2(cname types.PrincipalName, encType int32) (types.PAData, error) {
+	info, err := asn1.Marshal(types.ETypeInfo2{{EType: encType, Salt: cname.GetSalt(k.realm)}})
+	if err != nil {
+		return types.PAData{}, err
+	}
+	return types.PAData{PADataType: patype.PA_ETYPE_INFO2, PADataValue: info}, nil
+}
@@ -0,0 +1,192 @@
+package gssapi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// securityLayer is the side of an exchange wrapping messages.
+type securityLayer interface {
+	Wrap(outgoing []byte, start, len int) ([]byte, error)
+	Unwrap(incoming []byte, start, len int) ([]byte, error)
+}
+
+func TestKrb5Exchange(t *testing.T) {
+	realm := newTestRealm(t)
+	for _, mutual := range []string{"true", "false"} {
+		for _, qop := range []string{"auth", "auth-int", "auth-conf"} {
+			t.Run("mutual "+mutual+" "+qop, func(t *testing.T) {
+				client := realm.newClient(t, "", "host.example.com", map[string]interface{}{
+					sasl.SaslPropertyServerAuth: mutual,
+					sasl.SaslPropertyQop:        qop,
+				})
+				server := realm.newServer(t, "imap", "host.example.com", map[string]interface{}{
+					sasl.SaslPropertyQop: "auth-conf,auth-int,auth",
+				}, nil)
+				if err := exchange(client, server); err != nil {
+					t.Fatal(err)
+				} else if !client.IsComplete() || !server.IsComplete() {
+					t.Fatal("exchange not complete")
+				}
+				if negotiated, err := server.GetNegotiatedProperty(sasl.SaslPropertyQop); err != nil || negotiated != qop {
+					t.Errorf("server negotiated %v, %v", negotiated, err)
+				}
+				if negotiated, err := client.GetNegotiatedProperty(sasl.SaslPropertyQop); err != nil || negotiated != qop {
+					t.Errorf("client negotiated %v, %v", negotiated, err)
+				}
+				if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "alice@EXAMPLE.COM" {
+					t.Errorf("authorization ID %q, %v", authorizationID, err)
+				}
+
+				if qop == "auth" {
+					if _, err := client.Wrap([]byte("message"), 0, 7); err == nil {
+						t.Error("Wrap succeeded without a security layer")
+					}
+					return
+				}
+				for _, message := range []string{"", "a", strings.Repeat("message ", 1000)} {
+					for _, pair := range [][2]securityLayer{{client, server}, {server, client}} {
+						token, err := pair[0].Wrap([]byte(message), 0, len(message))
+						if err != nil {
+							t.Fatal(err)
+						}
+						if len(message) > 8 && bytes.Contains(token, []byte(message[:8])) == (qop == "auth-conf") {
+							t.Errorf("%s token %x...", qop, token[:24])
+						}
+						if got, err := pair[1].Unwrap(token, 0, len(token)); err != nil {
+							t.Fatal(err)
+						} else if string(got) != message {
+							t.Errorf("unwrapped %d bytes, want %d", len(got), len(message))
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestKrb5Authorization checks the authorization ID the client asks for
+// against the callback handler of the server.
+func TestKrb5Authorization(t *testing.T) {
+	realm := newTestRealm(t)
+	cbh := sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		for _, callback := range callbacks {
+			cb, ok := callback.(*sasl.AuthorizeCallback)
+			if !ok {
+				return &sasl.UnsupportedCallbackError{Callback: callback}
+			}
+			cb.SetAuthorized(cb.GetAuthenticationID() == "alice@EXAMPLE.COM" && cb.GetAuthorizationID() != "root")
+		}
+		return nil
+	})
+	for _, test := range []struct{ authorizationID, want string }{
+		{"", "alice@EXAMPLE.COM"},
+		{"admin", "admin"},
+		{"root", ""},
+	} {
+		client := realm.newClient(t, test.authorizationID, "host.example.com", nil)
+		server := realm.newServer(t, "imap", "host.example.com", nil, cbh)
+		err := exchange(client, server)
+		if len(test.want) <= 0 {
+			if err == nil || server.IsComplete() {
+				t.Errorf("%s authorized", test.authorizationID)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.authorizationID, err)
+		} else if authorizationID, _ := server.GetAuthorizationID(); authorizationID != test.want {
+			t.Errorf("authorization ID %q, want %q", authorizationID, test.want)
+		}
+	}
+}
+
+// newSkewedAPReq creates the AP-REQ token of client for imap/serverName
+// whose authenticator is dated skew from now.
+func newSkewedAPReq(t *testing.T, client *Krb5Client, serverName string, skew time.Duration) []byte {
+	if err := client.krbClient.AffirmLogin(); err != nil {
+		t.Fatal(err)
+	}
+	tkt, sessionKey, err := client.krbClient.GetServiceTicket("imap/" + serverName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := types.NewAuthenticator(client.krbClient.Credentials.Domain(), client.krbClient.Credentials.CName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.CTime = auth.CTime.Add(skew)
+	auth.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  newAuthenticatorChecksum(GSS_C_INTEG_FLAG|GSS_C_CONF_FLAG, nil),
+	}
+	apReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := apReq.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := marshalInitialContextToken(TOK_ID_AP_REQ, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKrb5Rejects(t *testing.T) {
+	realm := newTestRealm(t)
+
+	t.Run("replay", func(t *testing.T) {
+		cache := NewReplayCache()
+		client := realm.newClient(t, "", "host.example.com", nil)
+		apReq, err := client.EvaluateChallenge(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := realm.newServer(t, "imap", "host.example.com", map[string]interface{}{REPLAY_CACHE_PROPERTY: cache}, nil)
+		if _, err := server.EvaluateResponse(apReq); err != nil {
+			t.Fatal(err)
+		}
+		server = realm.newServer(t, "imap", "host.example.com", map[string]interface{}{REPLAY_CACHE_PROPERTY: cache}, nil)
+		if _, err := server.EvaluateResponse(apReq); err == nil || !strings.Contains(err.Error(), "replay") {
+			t.Errorf("replayed AP-REQ: %v", err)
+		}
+	})
+
+	t.Run("clock skew", func(t *testing.T) {
+		client := realm.newClient(t, "", "host.example.com", nil)
+		for _, skew := range []time.Duration{-6 * time.Minute, 6 * time.Minute} {
+			server := realm.newServer(t, "imap", "host.example.com", nil, nil)
+			if _, err := server.EvaluateResponse(newSkewedAPReq(t, client, "host.example.com", skew)); err == nil ||
+				!strings.Contains(err.Error(), "clock skew") {
+				t.Errorf("authenticator skewed %s: %v", skew, err)
+			}
+		}
+		server := realm.newServer(t, "imap", "host.example.com", nil, nil)
+		if _, err := server.EvaluateResponse(newSkewedAPReq(t, client, "host.example.com", -4*time.Minute)); err != nil {
+			t.Errorf("authenticator within the clock skew: %s", err)
+		}
+	})
+
+	t.Run("wrong service principal", func(t *testing.T) {
+		tests := []struct{ protocol, serverName string }{
+			{"imap", "mail.example.com"},
+			{"ldap", "host.example.com"},
+			{"ldap", ""},
+		}
+		for _, test := range tests {
+			client := realm.newClient(t, "", "host.example.com", nil)
+			server := realm.newServer(t, test.protocol, test.serverName, nil, nil)
+			if err := exchange(client, server); err == nil || !strings.Contains(err.Error(), "imap/host.example.com") {
+				t.Errorf("%s/%s accepted the ticket of imap/host.example.com: %v", test.protocol, test.serverName, err)
+			}
+		}
+	})
+}
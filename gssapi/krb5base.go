@@ -0,0 +1,371 @@
+package gssapi
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/types"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+const (
+	MECHANISM_NAME = "GSSAPI"
+
+	// DEFAULT_MAXBUF is the default maximum size of the receive buffer
+	// offered in the security layer negotiation.
+	DEFAULT_MAXBUF = 65536
+
+	// MAX_MAXBUF is the largest buffer size the 3 octets of the security
+	// layer message can carry.
+	MAX_MAXBUF = 0xFFFFFF
+
+	// DEFAULT_KRB5_CONFIG is the krb5.conf read when neither
+	// KRB5_CONFIG_PROPERTY nor the KRB5_CONFIG environment variable is set.
+	DEFAULT_KRB5_CONFIG = "/etc/krb5.conf"
+)
+
+const (
+	// KRB5_CONFIG_PROPERTY is a property that specifies the Kerberos
+	// configuration. The property contains either the path of a krb5.conf
+	// file or a *config.Config.
+	KRB5_CONFIG_PROPERTY = "golang.security.sasl.gssapi.krb5conf"
+
+	// KEYTAB_PROPERTY is a property that specifies the keytab holding the
+	// keys of the principal. The property contains either the path of a
+	// keytab file or a *keytab.Keytab.
+	KEYTAB_PROPERTY = "golang.security.sasl.gssapi.keytab"
+
+	// CCACHE_PROPERTY is a property that specifies the credential cache
+	// of a client. The property contains either the path of a ccache
+	// file, optionally prefixed with "FILE:", or a *credentials.CCache.
+	CCACHE_PROPERTY = "golang.security.sasl.gssapi.ccache"
+
+	// PRINCIPAL_PROPERTY is a property that specifies the principal whose
+	// keys are taken from the keytab, as "name@REALM". The default realm
+	// of the configuration is used if the realm is omitted.
+	PRINCIPAL_PROPERTY = "golang.security.sasl.gssapi.principal"
+)
+
+// Token IDs of the Kerberos V5 GSS-API mechanism (RFC 4121 section 4.1
+// and 4.2.6.2).
+var (
+	TOK_ID_AP_REQ    = []byte{0x01, 0x00}
+	TOK_ID_AP_REP    = []byte{0x02, 0x00}
+	TOK_ID_KRB_ERROR = []byte{0x03, 0x00}
+	TOK_ID_MIC       = []byte{0x04, 0x04}
+	TOK_ID_WRAP      = []byte{0x05, 0x04}
+
+	// KRB5_OID is the object identifier of the Kerberos V5 GSS-API
+	// mechanism.
+	KRB5_OID = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+)
+
+// GSS-API context flags carried in the authenticator checksum (RFC 4121
+// section 4.1.1.1).
+const (
+	GSS_C_DELEG_FLAG    = 1
+	GSS_C_MUTUAL_FLAG   = 2
+	GSS_C_REPLAY_FLAG   = 4
+	GSS_C_SEQUENCE_FLAG = 8
+	GSS_C_CONF_FLAG     = 16
+	GSS_C_INTEG_FLAG    = 32
+)
+
+// Krb5Base contains the state shared by GSSAPI clients and servers: the
+// quality-of-protection and buffer size preferences and, once the
+// security layer has been negotiated, the security context used by
+// Wrap() and Unwrap().
+type Krb5Base struct {
+	*sasl.Sasl
+	secCtx *krb5Context
+}
+
+// newKrb5Base creates the state shared by GSSAPI clients and servers.
+// The quality-of-protection and buffer size preferences are taken from
+// props, whose policy properties must allow GSSAPI.
+func newKrb5Base(props map[string]interface{}) (*Krb5Base, error) {
+	if err := sasl.CheckMechanismPolicy(MECHANISM_NAME, props); err != nil {
+		return nil, err
+	}
+	b := &Krb5Base{Sasl: &sasl.Sasl{}}
+
+	var err error
+	if b.Qop, err = b.ParseQop(sasl.PropertyValue(props, sasl.SaslPropertyQop)); err != nil {
+		return nil, err
+	}
+	b.AllQop = b.CombineMasks(b.Qop)
+
+	b.RecvMaxBufSize = DEFAULT_MAXBUF
+	if maxBuf := sasl.PropertyValue(props, sasl.SaslPropertyMaxBuffer); len(maxBuf) > 0 {
+		size := 0
+		if _, err := fmt.Sscanf(maxBuf, "%d", &size); err != nil || size <= 0 {
+			return nil, fmt.Errorf("Property must be string representation of positive integer: %s", sasl.SaslPropertyMaxBuffer)
+		}
+		if size > MAX_MAXBUF {
+			size = MAX_MAXBUF
+		}
+		b.RecvMaxBufSize = size
+	}
+	if maxBuf := sasl.PropertyValue(props, sasl.MAX_SEND_BUF); len(maxBuf) > 0 {
+		size := 0
+		if _, err := fmt.Sscanf(maxBuf, "%d", &size); err != nil || size < 0 {
+			return nil, fmt.Errorf("Property must be string representation of integer: %s", sasl.MAX_SEND_BUF)
+		}
+		b.SendMaxBufSize = size
+	}
+	return b, nil
+}
+
+// GetMechanismName returns the mechanism name "GSSAPI".
+func (b *Krb5Base) GetMechanismName() string {
+	return MECHANISM_NAME
+}
+
+// Unwrap the incoming buffer, an RFC 4121 wrap token, with the negotiated
+// security layer. If privacy has been negotiated, the token must be
+// encrypted.
+func (b *Krb5Base) Unwrap(incoming []byte, start, len int) ([]byte, error) {
+	if !b.Completed {
+		return nil, errors.New("GSSAPI authentication not completed")
+	} else if !b.Integrity {
+		return nil, errors.New("No integrity or privacy has been negotiated")
+	}
+	data, sealed, err := b.secCtx.unwrap(incoming[start : start+len])
+	if err != nil {
+		return nil, err
+	} else if b.Privacy && !sealed {
+		return nil, errors.New("GSSAPI: privacy has been negotiated but the message is not encrypted")
+	}
+	return data, nil
+}
+
+// Wrap the outgoing buffer into an RFC 4121 wrap token, encrypted if
+// privacy has been negotiated.
+func (b *Krb5Base) Wrap(outgoing []byte, start, len int) ([]byte, error) {
+	if !b.Completed {
+		return nil, errors.New("GSSAPI authentication not completed")
+	} else if !b.Integrity {
+		return nil, errors.New("No integrity or privacy has been negotiated")
+	}
+	return b.secCtx.wrap(outgoing[start:start+len], b.Privacy)
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (b *Krb5Base) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !b.Completed {
+		return nil, errors.New("GSSAPI authentication not completed")
+	}
+	return b.Sasl.GetNegotiatedProperty(propName)
+}
+
+// selectQop records the quality-of-protection given as a mask, and the
+// raw send size allowed by the peer's maximum buffer size.
+func (b *Krb5Base) selectQop(mask byte, peerMaxBufSize int) error {
+	switch mask {
+	case sasl.NO_PROTECTION:
+		return nil
+	case sasl.INTEGRITY_ONLY_PROTECTION:
+		b.Integrity = true
+	case sasl.PRIVACY_PROTECTION:
+		b.Privacy = true
+		b.Integrity = true
+	default:
+		return errors.New("GSSAPI: No common protection layer between client and server")
+	}
+
+	// Limit the send buffer to what the peer is able to receive
+	if b.SendMaxBufSize == 0 || peerMaxBufSize < b.SendMaxBufSize {
+		b.SendMaxBufSize = peerMaxBufSize
+	}
+	b.RawSendSize = b.secCtx.wrapSizeLimit(b.SendMaxBufSize, b.Privacy)
+	if b.RawSendSize <= 0 {
+		return fmt.Errorf("GSSAPI: maximum buffer size %d too small for the security layer", b.SendMaxBufSize)
+	}
+	return nil
+}
+
+// Dispose the sasl
+func (b *Krb5Base) Dispose() error {
+	if b.secCtx != nil {
+		b.secCtx.dispose()
+		b.secCtx = nil
+	}
+	return nil
+}
+
+// newSecurityLayerMessage creates the 4 octets of the security layer
+// negotiation (RFC 4752 section 3.1): the bit-mask of security layers,
+// followed by the maximum buffer size in network byte order, and the
+// authorization ID if any. The buffer size must be 0 if no security
+// layer is offered.
+func newSecurityLayerMessage(mask byte, maxBufSize int, authorizationID string) []byte {
+	message := make([]byte, 4, 4+len(authorizationID))
+	message[0] = mask
+	if mask&^sasl.NO_PROTECTION == 0 {
+		maxBufSize = 0
+	}
+	message[1] = byte(maxBufSize >> 16)
+	message[2] = byte(maxBufSize >> 8)
+	message[3] = byte(maxBufSize)
+	return append(message, authorizationID...)
+}
+
+// parseSecurityLayerMessage splits a security layer message into the
+// bit-mask, the maximum buffer size and the authorization ID.
+func parseSecurityLayerMessage(message []byte) (byte, int, string, error) {
+	if len(message) < 4 {
+		return 0, 0, "", fmt.Errorf("GSSAPI: security layer message too short: %d bytes", len(message))
+	}
+	maxBufSize := int(message[1])<<16 | int(message[2])<<8 | int(message[3])
+	return message[0], maxBufSize, string(message[4:]), nil
+}
+
+// marshalInitialContextToken frames a Kerberos message into a GSS-API
+// token (RFC 2743 section 3.1):
+//
+//	InitialContextToken ::= [APPLICATION 0] IMPLICIT SEQUENCE {
+//	        thisMech MechType,
+//	        innerContextToken ANY DEFINED BY thisMech }
+//
+// The inner token of the Kerberos mechanism is the token ID followed by
+// the message.
+func marshalInitialContextToken(tokID, message []byte) ([]byte, error) {
+	oid, err := asn1.Marshal(KRB5_OID)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 0, len(oid)+len(tokID)+len(message))
+	token = append(token, oid...)
+	token = append(token, tokID...)
+	token = append(token, message...)
+	return asn1tools.AddASNAppTag(token, 0), nil
+}
+
+// unmarshalInitialContextToken checks the framing of a GSS-API token of
+// the Kerberos mechanism and returns its token ID and message.
+func unmarshalInitialContextToken(token []byte) ([]byte, []byte, error) {
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(token, &raw)
+	if err != nil || len(rest) > 0 || raw.Class != asn1.ClassApplication || raw.Tag != 0 || !raw.IsCompound {
+		return nil, nil, errors.New("GSSAPI: invalid token framing")
+	}
+	var oid asn1.ObjectIdentifier
+	inner, err := asn1.Unmarshal(raw.Bytes, &oid)
+	if err != nil {
+		return nil, nil, errors.New("GSSAPI: invalid token framing")
+	} else if !oid.Equal(KRB5_OID) {
+		return nil, nil, fmt.Errorf("GSSAPI: unsupported mechanism %s", oid)
+	} else if len(inner) < 2 {
+		return nil, nil, errors.New("GSSAPI: token too short")
+	}
+	return inner[:2], inner[2:], nil
+}
+
+// loadConfig returns the Kerberos configuration given with
+// KRB5_CONFIG_PROPERTY, or read from the file named by the KRB5_CONFIG
+// environment variable, or from DEFAULT_KRB5_CONFIG.
+func loadConfig(props map[string]interface{}) (*config.Config, error) {
+	if cfg, ok := props[KRB5_CONFIG_PROPERTY].(*config.Config); ok && cfg != nil {
+		return cfg, nil
+	}
+	path := sasl.PropertyValue(props, KRB5_CONFIG_PROPERTY)
+	if len(path) <= 0 {
+		path = os.Getenv("KRB5_CONFIG")
+	}
+	if len(path) <= 0 {
+		path = DEFAULT_KRB5_CONFIG
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: cannot load Kerberos configuration %s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// loadKeytab returns the keytab given with KEYTAB_PROPERTY, or
+// defaultPath if the property is absent. A nil keytab is returned if
+// neither is set.
+func loadKeytab(props map[string]interface{}, defaultPath string) (*keytab.Keytab, error) {
+	if kt, ok := props[KEYTAB_PROPERTY].(*keytab.Keytab); ok && kt != nil {
+		return kt, nil
+	}
+	path := strings.TrimPrefix(sasl.PropertyValue(props, KEYTAB_PROPERTY), "FILE:")
+	if len(path) <= 0 {
+		path = defaultPath
+	}
+	if len(path) <= 0 {
+		return nil, nil
+	}
+	kt, err := keytab.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: cannot load keytab %s: %s", path, err)
+	}
+	return kt, nil
+}
+
+// splitPrincipal splits "name@REALM" into the name and the realm, which
+// is defaultRealm if the principal has none.
+func splitPrincipal(principal, defaultRealm string) (string, string) {
+	if index := strings.LastIndexByte(principal, '@'); index >= 0 {
+		return principal[:index], principal[index+1:]
+	}
+	return principal, defaultRealm
+}
+
+// newAuthenticatorChecksum creates the authenticator checksum carrying
+// flags, and the hash of the channel bindings if any.
+func newAuthenticatorChecksum(flags uint32, channelBindings []byte) []byte {
+	checksum := make([]byte, 24)
+	checksum[0] = 16
+	if channelBindings != nil {
+		copy(checksum[4:20], channelBindingsHash(channelBindings))
+	}
+	checksum[20] = byte(flags)
+	checksum[21] = byte(flags >> 8)
+	checksum[22] = byte(flags >> 16)
+	checksum[23] = byte(flags >> 24)
+	return checksum
+}
+
+// checksumFlags returns the context flags of an authenticator checksum
+// (RFC 4121 section 4.1.1), whose Bnd field must be the hash of
+// channelBindings. As with MIT Kerberos, the Bnd field is ignored if the
+// acceptor has no channel bindings.
+func checksumFlags(checksum types.Checksum, channelBindings []byte) (uint32, error) {
+	if checksum.CksumType != chksumtype.GSSAPI {
+		return 0, fmt.Errorf("GSSAPI: unexpected authenticator checksum type %d", checksum.CksumType)
+	}
+	value := checksum.Checksum
+	if len(value) < 24 || binary.LittleEndian.Uint32(value[0:4]) != 16 {
+		return 0, errors.New("GSSAPI: invalid authenticator checksum")
+	}
+	if channelBindings != nil && subtle.ConstantTimeCompare(value[4:20], channelBindingsHash(channelBindings)) != 1 {
+		return 0, errors.New("GSSAPI: channel bindings do not match")
+	}
+	return binary.LittleEndian.Uint32(value[20:24]), nil
+}
+
+// channelBindingsHash returns the Bnd field of the authenticator checksum
+// for the application data of channel bindings without addresses: the
+// MD5 hash of the gss_channel_bindings_struct, whose integers are
+// encoded in little-endian order (RFC 4121 section 4.1.1.2).
+func channelBindingsHash(applicationData []byte) []byte {
+	bindings := make([]byte, 20, 20+len(applicationData))
+	binary.LittleEndian.PutUint32(bindings[16:20], uint32(len(applicationData)))
+	bindings = append(bindings, applicationData...)
+	sum := md5.Sum(bindings)
+	return sum[:]
+}
@@ -0,0 +1,340 @@
+package gssapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// Krb5Client is an implementation of the GSSAPI SASL client-side
+// mechanism (RFC 4752) for Kerberos V5, implemented in pure Go.
+//
+// The initial response is the AP-REQ for the service principal
+// protocol/serverName. If SaslPropertyServerAuth is "true", mutual
+// authentication is requested and the server's AP-REP is verified. The
+// server then sends the security layers it supports and its maximum
+// buffer size, wrapped in an RFC 4121 wrap token; the client answers with
+// the selected security layer, its own maximum buffer size and the
+// authorization ID. If 'auth-int' or 'auth-conf' has been negotiated,
+// Wrap() and Unwrap() produce and verify RFC 4121 wrap tokens.
+//
+// The Kerberos credentials are, in order of precedence:
+//
+//	SaslPropertyCredentials - a *client.Client, used as is
+//	KEYTAB_PROPERTY         - a keytab to log in PRINCIPAL_PROPERTY with,
+//	                          by default the first principal of the keytab
+//	CCACHE_PROPERTY         - a credential cache, by default the file named
+//	                          by KRB5CCNAME or /tmp/krb5cc_<uid>
+//
+// The following properties are used as well:
+//
+//	KRB5_CONFIG_PROPERTY  - the Kerberos configuration
+//	SaslPropertyQop       - quality-of-protection preferences
+//	SaslPropertyMaxBuffer - maximum size of the receive buffer
+//	MAX_SEND_BUF          - maximum size of the send buffer
+type Krb5Client struct {
+	*Krb5Base
+	krbClient       *client.Client
+	ownClient       bool
+	spn             string
+	authorizationID string
+	mutual          bool
+	channelBindings []byte
+	sessionKey      types.EncryptionKey
+	authenticator   types.Authenticator
+	step            int
+}
+
+// NewKrb5Client creates a GSSAPI client for the service
+// protocol/serverName with the Kerberos credentials given in props.
+func NewKrb5Client(authorizationID, protocol, serverName string, props map[string]interface{}) (*Krb5Client, error) {
+	if len(protocol) <= 0 || len(serverName) <= 0 {
+		return nil, errors.New("GSSAPI: protocol and server name must be specified")
+	}
+	base, err := newKrb5Base(props)
+	if err != nil {
+		return nil, err
+	}
+	c := &Krb5Client{
+		Krb5Base:        base,
+		spn:             protocol + "/" + serverName,
+		authorizationID: authorizationID,
+		mutual:          sasl.PropertyIsTrue(props, sasl.SaslPropertyServerAuth),
+		step:            1,
+	}
+	if cl, ok := props[sasl.SaslPropertyCredentials].(*client.Client); ok && cl != nil {
+		c.krbClient = cl
+		return c, nil
+	}
+	if c.krbClient, err = newKerberosClient(props); err != nil {
+		return nil, err
+	}
+	c.ownClient = true
+	return c, nil
+}
+
+// newKerberosClient creates a Kerberos client from a keytab, if one is
+// given, or else from a credential cache.
+func newKerberosClient(props map[string]interface{}) (*client.Client, error) {
+	cfg, err := loadConfig(props)
+	if err != nil {
+		return nil, err
+	}
+	kt, err := loadKeytab(props, "")
+	if err != nil {
+		return nil, err
+	}
+	if kt != nil {
+		principal := sasl.PropertyValue(props, PRINCIPAL_PROPERTY)
+		if len(principal) <= 0 {
+			if len(kt.Entries) <= 0 {
+				return nil, errors.New("GSSAPI: keytab is empty")
+			}
+			principal = kt.Entries[0].Principal.String()
+		}
+		name, realm := splitPrincipal(principal, cfg.LibDefaults.DefaultRealm)
+		return client.NewWithKeytab(name, realm, kt, cfg, client.DisablePAFXFAST(true)), nil
+	}
+
+	ccache, err := loadCCache(props)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := client.NewFromCCache(ccache, cfg, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	return cl, nil
+}
+
+// loadCCache returns the credential cache given with CCACHE_PROPERTY, or
+// read from the file named by KRB5CCNAME or from /tmp/krb5cc_<uid>.
+func loadCCache(props map[string]interface{}) (*credentials.CCache, error) {
+	if ccache, ok := props[CCACHE_PROPERTY].(*credentials.CCache); ok && ccache != nil {
+		return ccache, nil
+	}
+	path := sasl.PropertyValue(props, CCACHE_PROPERTY)
+	if len(path) <= 0 {
+		path = os.Getenv("KRB5CCNAME")
+	}
+	if len(path) <= 0 {
+		path = fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+	if index := strings.IndexByte(path, ':'); index > 0 {
+		if path[:index] != "FILE" {
+			return nil, fmt.Errorf("GSSAPI: unsupported credential cache type %s", path[:index])
+		}
+		path = path[index+1:]
+	}
+	ccache, err := credentials.LoadCCache(path)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: cannot load credential cache %s: %s", path, err)
+	}
+	return ccache, nil
+}
+
+// HasInitialResponse returns true: the AP-REQ is sent as initial
+// response.
+func (c *Krb5Client) HasInitialResponse() bool {
+	return true
+}
+
+// EvaluateChallenge processes the challenges sent by the server.
+//
+// Step 1 returns the AP-REQ. Step 2 verifies the AP-REP if mutual
+// authentication was requested, and returns an empty response. Step 3
+// unwraps the security layers offered by the server and returns the
+// wrapped selection.
+func (c *Krb5Client) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	switch c.step {
+	case 1:
+		token, err := c.initSecContext()
+		if err != nil {
+			c.step = 0
+			return nil, err
+		}
+		c.step = 2
+		if !c.mutual {
+			if err := c.establishContext(nil); err != nil {
+				c.step = 0
+				return nil, err
+			}
+			c.step = 3
+		}
+		return token, nil
+	case 2:
+		if err := c.verifyAPRep(challengeData); err != nil {
+			c.step = 0
+			return nil, err
+		}
+		c.step = 3
+		return []byte{}, nil
+	case 3:
+		// Some servers acknowledge an AP-REQ without mutual
+		// authentication with an empty challenge
+		if len(challengeData) == 0 {
+			return []byte{}, nil
+		}
+		response, err := c.doFinalHandshake(challengeData)
+		if err != nil {
+			c.step = 0
+			return nil, err
+		}
+		c.Completed = true
+		c.step = 0
+		return response, nil
+	default:
+		return nil, errors.New("GSSAPI: Client at illegal state")
+	}
+}
+
+// initSecContext obtains a service ticket and creates the AP-REQ token.
+func (c *Krb5Client) initSecContext() ([]byte, error) {
+	if err := c.krbClient.AffirmLogin(); err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	tkt, sessionKey, err := c.krbClient.GetServiceTicket(c.spn)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: cannot get service ticket for %s: %s", c.spn, err)
+	}
+	return c.createAPReq(tkt, sessionKey)
+}
+
+// createAPReq creates the AP-REQ token for tkt. The authenticator carries
+// a subkey, a sequence number and the context flags.
+func (c *Krb5Client) createAPReq(tkt messages.Ticket, sessionKey types.EncryptionKey) ([]byte, error) {
+	gssFlags := uint32(GSS_C_INTEG_FLAG | GSS_C_CONF_FLAG | GSS_C_SEQUENCE_FLAG)
+	if c.mutual {
+		gssFlags |= GSS_C_MUTUAL_FLAG
+	}
+	auth, err := types.NewAuthenticator(c.krbClient.Credentials.Domain(), c.krbClient.Credentials.CName())
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	auth.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  newAuthenticatorChecksum(gssFlags, c.channelBindings),
+	}
+	if err := auth.GenerateSeqNumberAndSubKey(sessionKey.KeyType, len(sessionKey.KeyValue)); err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	apReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	if c.mutual {
+		types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+	}
+	message, err := apReq.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	c.sessionKey = sessionKey
+	c.authenticator = auth
+	return marshalInitialContextToken(TOK_ID_AP_REQ, message)
+}
+
+// verifyAPRep checks that the AP-REP sent by the server echoes the time of
+// the authenticator, and establishes the context with the subkey and
+// sequence number it contains.
+func (c *Krb5Client) verifyAPRep(token []byte) error {
+	tokID, message, err := unmarshalInitialContextToken(token)
+	if err != nil {
+		return err
+	}
+	switch {
+	case bytes.Equal(tokID, TOK_ID_KRB_ERROR):
+		var krbErr messages.KRBError
+		if err := krbErr.Unmarshal(message); err != nil {
+			return fmt.Errorf("GSSAPI: %s", err)
+		}
+		return fmt.Errorf("GSSAPI: server rejected the AP-REQ: %s", krbErr.Error())
+	case !bytes.Equal(tokID, TOK_ID_AP_REP):
+		return errors.New("GSSAPI: AP-REP expected")
+	}
+
+	var apRep messages.APRep
+	if err := apRep.Unmarshal(message); err != nil {
+		return fmt.Errorf("GSSAPI: %s", err)
+	}
+	plaintext, err := crypto.DecryptEncPart(apRep.EncPart, c.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("GSSAPI: cannot decrypt AP-REP: %s", err)
+	}
+	var encPart messages.EncAPRepPart
+	if err := encPart.Unmarshal(plaintext); err != nil {
+		return fmt.Errorf("GSSAPI: %s", err)
+	}
+	if encPart.CTime.Unix() != c.authenticator.CTime.Unix() || encPart.Cusec != c.authenticator.Cusec {
+		return errors.New("GSSAPI: mutual authentication failed")
+	}
+	return c.establishContext(&encPart)
+}
+
+// establishContext creates the security context. The key is the subkey
+// asserted by the acceptor in the AP-REP, if any, or else the subkey of
+// the authenticator. Without mutual authentication, the acceptor numbers
+// its messages from the sequence number of the initiator.
+func (c *Krb5Client) establishContext(encPart *messages.EncAPRepPart) error {
+	key := c.authenticator.SubKey
+	acceptorSubkey := false
+	recvSeqNum := uint64(c.authenticator.SeqNumber)
+	if encPart != nil {
+		if len(encPart.Subkey.KeyValue) > 0 {
+			key = encPart.Subkey
+			acceptorSubkey = true
+		}
+		recvSeqNum = uint64(encPart.SequenceNumber)
+	}
+	secCtx, err := newKrb5Context(key, true, acceptorSubkey, uint64(c.authenticator.SeqNumber), recvSeqNum)
+	if err != nil {
+		return err
+	}
+	c.secCtx = secCtx
+	return nil
+}
+
+// doFinalHandshake processes the security layers and maximum buffer size
+// offered by the server, and returns the selected security layer with the
+// client's maximum buffer size and authorization ID.
+func (c *Krb5Client) doFinalHandshake(challengeData []byte) ([]byte, error) {
+	message, _, err := c.secCtx.unwrap(challengeData)
+	if err != nil {
+		return nil, err
+	}
+	if len(message) != 4 {
+		return nil, fmt.Errorf("GSSAPI: Server should send 4 bytes, got %d", len(message))
+	}
+	serverQop, serverMaxBufSize, _, err := parseSecurityLayerMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	selectedQop := c.FindPreferredMask(serverQop, c.Qop)
+	if err := c.selectQop(selectedQop, serverMaxBufSize); err != nil {
+		return nil, err
+	}
+
+	response := newSecurityLayerMessage(selectedQop, c.RecvMaxBufSize, c.authorizationID)
+	return c.secCtx.wrap(response, false)
+}
+
+// Dispose the sasl
+func (c *Krb5Client) Dispose() error {
+	if c.ownClient && c.krbClient != nil {
+		c.krbClient.Destroy()
+	}
+	c.krbClient = nil
+	return c.Krb5Base.Dispose()
+}
@@ -0,0 +1,224 @@
+package gssapi
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"github.com/jellybean4/go-sasl/gs2"
+)
+
+// GS2_NAME is the name of the Kerberos V5 mechanism in the GS2 SASL
+// mechanisms GS2-KRB5 and GS2-KRB5-PLUS (RFC 5801 section 14.1).
+const GS2_NAME = "KRB5"
+
+// Krb5Mechanism is the Kerberos V5 GSS-API mechanism (RFC 4121), through
+// which the GS2-KRB5 and GS2-KRB5-PLUS SASL mechanisms authenticate. The
+// initiator contexts take their Kerberos credentials from the properties
+// of Krb5Client, and the acceptor contexts the service keys from those
+// of Krb5Server.
+type Krb5Mechanism struct{}
+
+// GetName returns "KRB5".
+func (m *Krb5Mechanism) GetName() string {
+	return GS2_NAME
+}
+
+// GetOID returns KRB5_OID.
+func (m *Krb5Mechanism) GetOID() asn1.ObjectIdentifier {
+	return KRB5_OID
+}
+
+// CreateInitiatorContext creates the context of an initiator for the
+// service protocol/serverName.
+func (m *Krb5Mechanism) CreateInitiatorContext(protocol, serverName string, props map[string]interface{}) (gs2.SecurityContext, error) {
+	client, err := NewKrb5Client("", protocol, serverName, props)
+	if err != nil {
+		return nil, err
+	}
+	return &Krb5SecurityContext{base: client.Krb5Base, client: client}, nil
+}
+
+// CreateAcceptorContext creates the context of an acceptor for the
+// service protocol/serverName, or for any host of protocol if serverName
+// is empty.
+func (m *Krb5Mechanism) CreateAcceptorContext(protocol, serverName string, props map[string]interface{}) (gs2.SecurityContext, error) {
+	server, err := NewKrb5Server(protocol, serverName, props, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Krb5SecurityContext{base: server.Krb5Base, server: server}, nil
+}
+
+// Krb5SecurityContext is a Kerberos V5 GSS-API security context, held by
+// a Krb5Client on the initiator side and by a Krb5Server on the acceptor
+// side, which only perform the context establishment. The context is
+// established by the AP-REQ, or by the AP-REP if the initiator requested
+// mutual authentication. Messages are protected with the RFC 4121 wrap
+// and MIC tokens. As a gs2.NameMapper, the context maps the principal of
+// the initiator to the authentication ID of GS2 servers.
+type Krb5SecurityContext struct {
+	base        *Krb5Base
+	client      *Krb5Client
+	server      *Krb5Server
+	established bool
+	mutual      bool
+}
+
+// RequestMutualAuth requests that the acceptor sends an AP-REP. It is
+// ignored by acceptors, which follow the request of the initiator.
+func (c *Krb5SecurityContext) RequestMutualAuth(state bool) {
+	if c.client != nil {
+		c.client.mutual = state
+	}
+}
+
+// SetChannelBinding sets the application data of the channel bindings,
+// whose hash is carried by the authenticator checksum.
+func (c *Krb5SecurityContext) SetChannelBinding(applicationData []byte) {
+	if c.client != nil {
+		c.client.channelBindings = applicationData
+	} else {
+		c.server.channelBindings = applicationData
+	}
+}
+
+// InitSecContext returns the AP-REQ on the first call, and verifies the
+// AP-REP on the second one if mutual authentication was requested.
+func (c *Krb5SecurityContext) InitSecContext(inputToken []byte) ([]byte, error) {
+	switch {
+	case c.client == nil:
+		return nil, errors.New("GSSAPI: acceptor cannot initiate a context")
+	case c.established:
+		return nil, errors.New("GSSAPI: context already established")
+	case c.client.step == 1:
+		token, err := c.client.initSecContext()
+		if err != nil {
+			return nil, err
+		}
+		c.client.step = 2
+		if !c.client.mutual {
+			if err := c.client.establishContext(nil); err != nil {
+				return nil, err
+			}
+			c.established = true
+		}
+		return token, nil
+	default:
+		if err := c.client.verifyAPRep(inputToken); err != nil {
+			return nil, err
+		}
+		c.established = true
+		c.mutual = true
+		return nil, nil
+	}
+}
+
+// AcceptSecContext verifies the AP-REQ, and returns the AP-REP if the
+// initiator requested mutual authentication.
+func (c *Krb5SecurityContext) AcceptSecContext(inputToken []byte) ([]byte, error) {
+	switch {
+	case c.server == nil:
+		return nil, errors.New("GSSAPI: initiator cannot accept a context")
+	case c.established:
+		return nil, errors.New("GSSAPI: context already established")
+	}
+	apRep, err := c.server.acceptSecContext(inputToken)
+	if err != nil {
+		return nil, err
+	}
+	c.established = true
+	c.mutual = apRep != nil
+	return apRep, nil
+}
+
+// IsEstablished determines whether the context is established.
+func (c *Krb5SecurityContext) IsEstablished() bool {
+	return c.established
+}
+
+// GetMutualAuthState determines whether the acceptor sent an AP-REP.
+func (c *Krb5SecurityContext) GetMutualAuthState() bool {
+	return c.mutual
+}
+
+// GetSrcName returns the principal of the initiator, as "name@REALM".
+func (c *Krb5SecurityContext) GetSrcName() (string, error) {
+	if !c.established {
+		return "", errors.New("GSSAPI: context not established")
+	}
+	if c.server != nil {
+		return c.server.principal, nil
+	}
+	credentials := c.client.krbClient.Credentials
+	return credentials.CName().PrincipalNameString() + "@" + credentials.Domain(), nil
+}
+
+// MapSrcName maps the principal of the initiator as the authentication ID
+// of Krb5Server is, according to PRINCIPAL_MAPPING_PROPERTY or
+// AUTH_TO_LOCAL_PROPERTY. On the initiator side, name is returned as is.
+func (c *Krb5SecurityContext) MapSrcName(name string) (string, error) {
+	if c.server == nil {
+		return name, nil
+	}
+	mapped, err := c.server.mapper(name)
+	if err != nil {
+		return "", fmt.Errorf("GSSAPI: %s", err)
+	}
+	return mapped, nil
+}
+
+// GetTargName returns the principal of the acceptor, as
+// "protocol/serverName@REALM" on the acceptor side, and without realm on
+// the initiator side.
+func (c *Krb5SecurityContext) GetTargName() (string, error) {
+	if !c.established {
+		return "", errors.New("GSSAPI: context not established")
+	}
+	if c.server != nil {
+		return c.server.protocol + "/" + c.server.serverName + "@" + c.server.serviceRealm, nil
+	}
+	return c.client.spn, nil
+}
+
+// Wrap message into an RFC 4121 wrap token, encrypted if confReq is true.
+func (c *Krb5SecurityContext) Wrap(message []byte, confReq bool) ([]byte, error) {
+	if !c.established {
+		return nil, errors.New("GSSAPI: context not established")
+	}
+	return c.base.secCtx.wrap(message, confReq)
+}
+
+// Unwrap an RFC 4121 wrap token, and report whether its message was
+// encrypted.
+func (c *Krb5SecurityContext) Unwrap(token []byte) ([]byte, bool, error) {
+	if !c.established {
+		return nil, false, errors.New("GSSAPI: context not established")
+	}
+	return c.base.secCtx.unwrap(token)
+}
+
+// GetMIC returns the RFC 4121 MIC token of message.
+func (c *Krb5SecurityContext) GetMIC(message []byte) ([]byte, error) {
+	if !c.established {
+		return nil, errors.New("GSSAPI: context not established")
+	}
+	return c.base.secCtx.getMIC(message)
+}
+
+// VerifyMIC verifies the RFC 4121 MIC token of message.
+func (c *Krb5SecurityContext) VerifyMIC(message, mic []byte) error {
+	if !c.established {
+		return errors.New("GSSAPI: context not established")
+	}
+	return c.base.secCtx.verifyMIC(message, mic)
+}
+
+// Dispose of the Kerberos credentials and of the key of the context.
+func (c *Krb5SecurityContext) Dispose() error {
+	c.established = false
+	if c.client != nil {
+		return c.client.Dispose()
+	}
+	return c.server.Dispose()
+}
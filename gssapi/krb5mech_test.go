@@ -0,0 +1,154 @@
+package gssapi
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+	"github.com/jellybean4/go-sasl/gs2"
+)
+
+// gs2Exchange runs a GS2 exchange of mechanism between alice and a server
+// of imap/serverName.
+func gs2Exchange(t *testing.T, realm *testRealm, mechanism, authorizationID, serverName string,
+	clientProps, serverProps map[string]interface{}, cbh sasl.CallbackHandler) (*gs2.GS2Client, *gs2.GS2Server, error) {
+	client, err := sasl.CreateClient([]string{mechanism}, authorizationID, "imap", "host.example.com", realm.clientProps(clientProps), nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if client == nil {
+		t.Fatalf("no %s client", mechanism)
+	}
+	server, err := sasl.CreateServer(mechanism, "imap", serverName, realm.serverProps(serverProps), cbh)
+	if err != nil {
+		t.Fatal(err)
+	} else if server == nil {
+		t.Fatalf("no %s server", mechanism)
+	}
+	return client.(*gs2.GS2Client), server.(*gs2.GS2Server), exchange(client, server)
+}
+
+func TestGS2Krb5Exchange(t *testing.T) {
+	realm := newTestRealm(t)
+	for _, serverName := range []string{"host.example.com", ""} {
+		client, server, err := gs2Exchange(t, realm, "GS2-KRB5", "", serverName, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if !client.IsComplete() || !server.IsComplete() {
+			t.Fatal("exchange not complete")
+		}
+		if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "alice@EXAMPLE.COM" {
+			t.Errorf("authorization ID %q, %v", authorizationID, err)
+		}
+		if _, err := server.Wrap([]byte("message"), 0, 7); err == nil {
+			t.Error("Wrap succeeded without a security layer")
+		}
+
+		// The contexts are established with mutual authentication.
+		initiator, acceptor := client.GetSecurityContext(), server.GetSecurityContext()
+		if !initiator.GetMutualAuthState() || !acceptor.GetMutualAuthState() {
+			t.Error("context established without mutual authentication")
+		}
+		if name, err := acceptor.GetTargName(); err != nil || name != "imap/host.example.com@EXAMPLE.COM" {
+			t.Errorf("target name %q, %v", name, err)
+		}
+		token, err := initiator.Wrap([]byte("message"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message, conf, err := acceptor.Unwrap(token); err != nil || !conf || string(message) != "message" {
+			t.Errorf("unwrapped %q, %v, %v", message, conf, err)
+		}
+		mic, err := acceptor.GetMIC([]byte("message"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := initiator.VerifyMIC([]byte("message"), mic); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// TestGS2Krb5PrincipalMapping checks that the authentication ID of
+// GS2-KRB5 is mapped as the one of GSSAPI.
+func TestGS2Krb5PrincipalMapping(t *testing.T) {
+	realm := newTestRealm(t)
+	foreign := PrincipalMapper(func(principal string) (string, error) {
+		return "", errors.New("principal " + principal + " is not in realm OTHER.ORG")
+	})
+	tests := []struct {
+		name  string
+		props map[string]interface{}
+		want  string
+	}{
+		{"full", map[string]interface{}{PRINCIPAL_MAPPING_PROPERTY: PRINCIPAL_MAPPING_FULL}, "alice@EXAMPLE.COM"},
+		{"short", map[string]interface{}{PRINCIPAL_MAPPING_PROPERTY: PRINCIPAL_MAPPING_SHORT}, "alice"},
+		{"auth_to_local", map[string]interface{}{AUTH_TO_LOCAL_PROPERTY: "RULE:[1:$1@$0](.*@EXAMPLE.COM)s/@.*/-local/"}, "alice-local"},
+		{"rejected", map[string]interface{}{PRINCIPAL_MAPPING_PROPERTY: foreign}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, server, err := gs2Exchange(t, realm, "GS2-KRB5", "", "host.example.com", nil, test.props, nil)
+			if len(test.want) <= 0 {
+				if err == nil || !strings.Contains(err.Error(), "OTHER.ORG") {
+					t.Errorf("error %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != test.want {
+				t.Errorf("authorization ID %q, %v, want %q", authorizationID, err, test.want)
+			}
+			// The initiator name remains the principal.
+			if name, err := server.GetSecurityContext().GetSrcName(); err != nil || name != "alice@EXAMPLE.COM" {
+				t.Errorf("source name %q, %v", name, err)
+			}
+		})
+	}
+}
+
+func TestGS2Krb5ChannelBinding(t *testing.T) {
+	realm := newTestRealm(t)
+	binding := map[string]interface{}{
+		sasl.SaslPropertyChannelBinding: &sasl.ChannelBinding{Type: sasl.CHANNEL_BINDING_TLS_EXPORTER, Data: []byte("exporter")},
+	}
+	other := map[string]interface{}{
+		sasl.SaslPropertyChannelBinding: &sasl.ChannelBinding{Type: sasl.CHANNEL_BINDING_TLS_EXPORTER, Data: []byte("another")},
+	}
+	tests := []struct {
+		name        string
+		mechanism   string
+		clientProps map[string]interface{}
+		serverProps map[string]interface{}
+		err         string
+	}{
+		{"bound", "GS2-KRB5-PLUS", binding, binding, ""},
+		{"other connection", "GS2-KRB5-PLUS", binding, other, "channel bindings"},
+		// The client supports channel binding, but the server did not
+		// advertise GS2-KRB5-PLUS: flag "y"
+		{"server without channel binding", "GS2-KRB5", binding, nil, ""},
+		{"downgraded", "GS2-KRB5", binding, binding, "server does support channel binding"},
+		{"client without channel binding", "GS2-KRB5", nil, binding, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server, err := gs2Exchange(t, realm, test.mechanism, "", "", test.clientProps, test.serverProps, nil)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) || server.IsComplete() {
+					t.Errorf("error %v, want %q", err, test.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !client.IsComplete() || !server.IsComplete() {
+				t.Fatal("exchange not complete")
+			}
+			if test.mechanism == "GS2-KRB5-PLUS" {
+				if cbType, err := server.GetNegotiatedProperty(sasl.SaslPropertyChannelBindingType); err != nil || cbType != sasl.CHANNEL_BINDING_TLS_EXPORTER {
+					t.Errorf("channel binding type %v, %v", cbType, err)
+				}
+			}
+		})
+	}
+}
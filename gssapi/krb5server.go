@@ -0,0 +1,508 @@
+package gssapi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+const (
+	// DEFAULT_KEYTAB is the keytab read when neither KEYTAB_PROPERTY nor
+	// the KRB5_KTNAME environment variable is set.
+	DEFAULT_KEYTAB = "/etc/krb5.keytab"
+
+	// DEFAULT_CLOCK_SKEW is the default maximum difference between the
+	// time of an authenticator and the time of the server.
+	DEFAULT_CLOCK_SKEW = 5 * time.Minute
+)
+
+const (
+	// CLOCK_SKEW_PROPERTY is a property that specifies the maximum clock
+	// skew, in seconds, between the client and the server.
+	CLOCK_SKEW_PROPERTY = "golang.security.sasl.gssapi.clockskew"
+
+	// REPLAY_CACHE_PROPERTY is a property that specifies the *ReplayCache
+	// of a server. If this property is absent, DEFAULT_REPLAY_CACHE is
+	// used.
+	REPLAY_CACHE_PROPERTY = "golang.security.sasl.gssapi.replaycache"
+
+	// PRINCIPAL_MAPPING_PROPERTY is a property that specifies how the
+	// client principal is mapped to the authentication ID. The property
+	// contains one of the PRINCIPAL_MAPPING_* values, a PrincipalMapper,
+	// or an *AuthToLocal. If this property is absent, the principal is
+	// used as is.
+	PRINCIPAL_MAPPING_PROPERTY = "golang.security.sasl.gssapi.principal.mapping"
+
+	// AUTH_TO_LOCAL_PROPERTY is a property that specifies auth_to_local
+	// rules, as parsed by ParseAuthToLocal, mapping the client principal
+	// to the authentication ID. It cannot be used with
+	// PRINCIPAL_MAPPING_PROPERTY.
+	AUTH_TO_LOCAL_PROPERTY = "golang.security.sasl.gssapi.auth_to_local"
+)
+
+// Mappings of a client principal to an authentication ID.
+const (
+	// PRINCIPAL_MAPPING_FULL keeps the principal as "name@REALM".
+	PRINCIPAL_MAPPING_FULL = "principal"
+
+	// PRINCIPAL_MAPPING_SHORT strips the realm of principals of the realm
+	// of the service, and rejects principals of other realms.
+	PRINCIPAL_MAPPING_SHORT = "short"
+)
+
+// PrincipalMapper maps an authenticated client principal, given as
+// "name@REALM", to an authentication ID.
+type PrincipalMapper func(principal string) (string, error)
+
+// Krb5Server is an implementation of the GSSAPI SASL server-side
+// mechanism (RFC 4752) for Kerberos V5, implemented in pure Go.
+//
+// The client's initial response is an AP-REQ for the service principal
+// protocol/serverName, whose key is taken from a keytab. The ticket and
+// authenticator are verified, and the authenticator is recorded in a
+// replay cache. If the client asks for mutual authentication, an AP-REP
+// is returned and the client must answer with an empty response. The
+// server then sends the security layers it supports and its maximum
+// buffer size; the client answers with the selected security layer, its
+// own maximum buffer size and the authorization ID. The client principal,
+// mapped according to PRINCIPAL_MAPPING_PROPERTY or
+// AUTH_TO_LOCAL_PROPERTY, is the authentication ID, and the authorization
+// ID is checked with an AuthorizeCallback.
+//
+// If serverName is empty, the server is unbound: it accepts tickets for
+// any protocol/host principal of the keytab, and the host name is
+// available as the SaslPropertyBoundServerName negotiated property.
+//
+// The following properties are used:
+//
+//	KEYTAB_PROPERTY            - the keytab of the service, by default the
+//	                             file named by KRB5_KTNAME or DEFAULT_KEYTAB
+//	CLOCK_SKEW_PROPERTY        - maximum clock skew in seconds
+//	REPLAY_CACHE_PROPERTY      - the replay cache
+//	PRINCIPAL_MAPPING_PROPERTY - mapping of the client principal
+//	AUTH_TO_LOCAL_PROPERTY     - auth_to_local rules mapping the client
+//	                             principal
+//	SaslPropertyQop            - quality-of-protection preferences
+//	SaslPropertyMaxBuffer      - maximum size of the receive buffer
+//	MAX_SEND_BUF               - maximum size of the send buffer
+type Krb5Server struct {
+	*Krb5Base
+	cbh             sasl.CallbackHandler
+	protocol        string
+	serverName      string
+	keytab          *keytab.Keytab
+	clockSkew       time.Duration
+	replayCache     *ReplayCache
+	mapper          PrincipalMapper
+	serviceRealm    string
+	principal       string
+	offeredQop      byte
+	channelBindings []byte
+	authorizationID string
+	challenged      bool
+	step            int
+}
+
+// NewKrb5Server creates a GSSAPI server for the service
+// protocol/serverName, or for any host of protocol if serverName is
+// empty.
+func NewKrb5Server(protocol, serverName string, props map[string]interface{}, cbh sasl.CallbackHandler) (*Krb5Server, error) {
+	if len(protocol) <= 0 {
+		return nil, errors.New("GSSAPI: protocol must be specified")
+	}
+	base, err := newKrb5Base(props)
+	if err != nil {
+		return nil, err
+	}
+	s := &Krb5Server{
+		Krb5Base:    base,
+		cbh:         cbh,
+		protocol:    protocol,
+		serverName:  serverName,
+		clockSkew:   DEFAULT_CLOCK_SKEW,
+		replayCache: DEFAULT_REPLAY_CACHE,
+		step:        1,
+	}
+
+	keytabPath := strings.TrimPrefix(os.Getenv("KRB5_KTNAME"), "FILE:")
+	if len(keytabPath) <= 0 {
+		keytabPath = DEFAULT_KEYTAB
+	}
+	if s.keytab, err = loadKeytab(props, keytabPath); err != nil {
+		return nil, err
+	}
+
+	if skew := sasl.PropertyValue(props, CLOCK_SKEW_PROPERTY); len(skew) > 0 {
+		seconds, err := strconv.Atoi(skew)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("Property must be string representation of positive integer: %s", CLOCK_SKEW_PROPERTY)
+		}
+		s.clockSkew = time.Duration(seconds) * time.Second
+	}
+	if cache, ok := props[REPLAY_CACHE_PROPERTY].(*ReplayCache); ok && cache != nil {
+		s.replayCache = cache
+	}
+	mapping := props[PRINCIPAL_MAPPING_PROPERTY]
+	if rules, ok := props[AUTH_TO_LOCAL_PROPERTY].(string); ok {
+		if mapping != nil {
+			return nil, errors.New("GSSAPI: principal mapping and auth_to_local rules are exclusive")
+		}
+		if mapping, err = ParseAuthToLocal(rules); err != nil {
+			return nil, err
+		}
+	}
+	if s.mapper, err = s.newPrincipalMapper(mapping); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newPrincipalMapper returns the PrincipalMapper selected by the value of
+// PRINCIPAL_MAPPING_PROPERTY.
+func (s *Krb5Server) newPrincipalMapper(value interface{}) (PrincipalMapper, error) {
+	mapping := PRINCIPAL_MAPPING_FULL
+	switch value := value.(type) {
+	case PrincipalMapper:
+		return value, nil
+	case func(string) (string, error):
+		return value, nil
+	case *AuthToLocal:
+		return func(principal string) (string, error) {
+			return value.GetShortName(principal, s.serviceRealm)
+		}, nil
+	case string:
+		if len(value) > 0 {
+			mapping = value
+		}
+	}
+
+	switch mapping {
+	case PRINCIPAL_MAPPING_FULL:
+		return func(principal string) (string, error) {
+			return principal, nil
+		}, nil
+	case PRINCIPAL_MAPPING_SHORT:
+		return func(principal string) (string, error) {
+			name, realm := splitPrincipal(principal, "")
+			if realm != s.serviceRealm {
+				return "", fmt.Errorf("principal %s is not in realm %s", principal, s.serviceRealm)
+			}
+			return name, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("GSSAPI: invalid principal mapping %s", mapping)
+	}
+}
+
+// EvaluateResponse processes the responses sent by the client.
+//
+// Step 1 verifies the AP-REQ and returns the AP-REP if mutual
+// authentication was requested, or else the offered security layers; an
+// empty challenge is returned if the client sent no initial response.
+// Step 2 returns the offered security layers after the client accepted
+// the AP-REP. Step 3 processes the security layer selected by the client.
+func (s *Krb5Server) EvaluateResponse(response []byte) ([]byte, error) {
+	switch s.step {
+	case 1:
+		if len(response) == 0 && !s.challenged {
+			s.challenged = true
+			return []byte{}, nil
+		}
+		apRep, err := s.acceptSecContext(response)
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		if apRep != nil {
+			s.step = 2
+			return apRep, nil
+		}
+		challenge, err := s.offerSecurityLayers()
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		s.step = 3
+		return challenge, nil
+	case 2:
+		if len(response) != 0 {
+			return nil, s.fail(errors.New("GSSAPI: empty response expected after AP-REP"))
+		}
+		challenge, err := s.offerSecurityLayers()
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		s.step = 3
+		return challenge, nil
+	case 3:
+		if err := s.doHandshake2(response); err != nil {
+			return nil, s.fail(err)
+		}
+		s.Completed = true
+		s.step = 0
+		return nil, nil
+	default:
+		return nil, errors.New("GSSAPI: Server at illegal state")
+	}
+}
+
+// acceptSecContext verifies the AP-REQ token and establishes the security
+// context. The AP-REP token is returned if the client asked for mutual
+// authentication, nil otherwise.
+func (s *Krb5Server) acceptSecContext(token []byte) ([]byte, error) {
+	tokID, message, err := unmarshalInitialContextToken(token)
+	if err != nil {
+		return nil, err
+	} else if !bytes.Equal(tokID, TOK_ID_AP_REQ) {
+		return nil, errors.New("GSSAPI: AP-REQ expected")
+	}
+	var apReq messages.APReq
+	if err := apReq.Unmarshal(message); err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	if types.IsFlagSet(&apReq.APOptions, flags.APOptionUseSessionKey) {
+		return nil, errors.New("GSSAPI: user-to-user authentication not supported")
+	}
+
+	tkt := &apReq.Ticket
+	if err := s.checkServicePrincipal(tkt.SName); err != nil {
+		return nil, err
+	}
+	key, _, err := s.keytab.GetEncryptionKey(tkt.SName, tkt.Realm, tkt.EncPart.KVNO, tkt.EncPart.EType)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: no key for %s@%s in keytab: %s", tkt.SName.PrincipalNameString(), tkt.Realm, err)
+	}
+	if err := tkt.Decrypt(key); err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	if err := apReq.DecryptAuthenticator(tkt.DecryptedEncPart.Key); err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	auth := &apReq.Authenticator
+	encPart := &tkt.DecryptedEncPart
+	if !auth.CName.Equal(encPart.CName) || auth.CRealm != encPart.CRealm {
+		return nil, errors.New("GSSAPI: authenticator does not match the ticket")
+	}
+	if err := s.checkTimes(auth, encPart); err != nil {
+		return nil, err
+	}
+	gssFlags, err := checksumFlags(auth.Cksum, s.channelBindings)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(apReq.EncryptedAuthenticator.Cipher)
+	if err := s.replayCache.Check(string(sum[:]), auth.CTime.Add(s.clockSkew)); err != nil {
+		return nil, err
+	}
+
+	s.serviceRealm = tkt.Realm
+	s.principal = encPart.CName.PrincipalNameString() + "@" + encPart.CRealm
+	s.offeredQop = s.AllQop
+	if gssFlags&GSS_C_CONF_FLAG == 0 {
+		s.offeredQop &^= sasl.PRIVACY_PROTECTION
+	}
+	if gssFlags&GSS_C_INTEG_FLAG == 0 {
+		s.offeredQop &^= sasl.INTEGRITY_ONLY_PROTECTION | sasl.PRIVACY_PROTECTION
+	}
+
+	key = encPart.Key
+	if len(auth.SubKey.KeyValue) > 0 {
+		key = auth.SubKey
+	}
+	mutual := gssFlags&GSS_C_MUTUAL_FLAG != 0 || types.IsFlagSet(&apReq.APOptions, flags.APOptionMutualRequired)
+	if !mutual {
+		// The acceptor numbers its messages from the sequence number of
+		// the initiator
+		s.secCtx, err = newKrb5Context(key, false, false, uint64(auth.SeqNumber), uint64(auth.SeqNumber))
+		return nil, err
+	}
+
+	repPart := messages.EncAPRepPart{
+		CTime:  auth.CTime.UTC(),
+		Cusec:  auth.Cusec,
+		Subkey: types.EncryptionKey{KeyType: key.KeyType, KeyValue: make([]byte, len(key.KeyValue))},
+	}
+	var seqNum [4]byte
+	if _, err := rand.Read(repPart.Subkey.KeyValue); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(seqNum[:]); err != nil {
+		return nil, err
+	}
+	repPart.SequenceNumber = int64(binary.BigEndian.Uint32(seqNum[:]) & 0x3fffffff)
+	apRep, err := marshalAPRep(&repPart, encPart.Key)
+	if err != nil {
+		return nil, err
+	}
+	if s.secCtx, err = newKrb5Context(repPart.Subkey, false, true, uint64(repPart.SequenceNumber), uint64(auth.SeqNumber)); err != nil {
+		return nil, err
+	}
+	return marshalInitialContextToken(TOK_ID_AP_REP, apRep)
+}
+
+// checkServicePrincipal checks that the ticket was issued for the
+// service, and records the host name of an unbound server.
+func (s *Krb5Server) checkServicePrincipal(sname types.PrincipalName) error {
+	if len(sname.NameString) != 2 || sname.NameString[0] != s.protocol {
+		return fmt.Errorf("GSSAPI: ticket issued for %s, not for service %s", sname.PrincipalNameString(), s.protocol)
+	}
+	if len(s.serverName) <= 0 {
+		s.serverName = sname.NameString[1]
+	} else if !strings.EqualFold(sname.NameString[1], s.serverName) {
+		return fmt.Errorf("GSSAPI: ticket issued for %s, not for %s/%s", sname.PrincipalNameString(), s.protocol, s.serverName)
+	}
+	return nil
+}
+
+// checkTimes checks the validity period of the ticket and the time of the
+// authenticator, allowing for the clock skew.
+func (s *Krb5Server) checkTimes(auth *types.Authenticator, encPart *messages.EncTicketPart) error {
+	now := time.Now()
+	if types.IsFlagSet(&encPart.Flags, flags.Invalid) {
+		return errors.New("GSSAPI: ticket is invalid")
+	}
+	startTime := encPart.StartTime
+	if startTime.IsZero() {
+		startTime = encPart.AuthTime
+	}
+	if now.Add(s.clockSkew).Before(startTime) {
+		return errors.New("GSSAPI: ticket not yet valid")
+	} else if now.Add(-s.clockSkew).After(encPart.EndTime) {
+		return errors.New("GSSAPI: ticket expired")
+	}
+	if skew := now.Sub(auth.CTime); skew > s.clockSkew || skew < -s.clockSkew {
+		return errors.New("GSSAPI: clock skew too great")
+	}
+	return nil
+}
+
+// marshalAPRep creates the AP-REP carrying encPart, encrypted with the
+// session key:
+//
+//	AP-REP ::= [APPLICATION 15] SEQUENCE {
+//	        pvno     [0] INTEGER (5),
+//	        msg-type [1] INTEGER (15),
+//	        enc-part [2] EncryptedData -- EncAPRepPart }
+func marshalAPRep(encPart *messages.EncAPRepPart, sessionKey types.EncryptionKey) ([]byte, error) {
+	plaintext, err := asn1.Marshal(*encPart)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	plaintext = asn1tools.AddASNAppTag(plaintext, asnAppTag.EncAPRepPart)
+	encrypted, err := crypto.GetEncryptedData(plaintext, sessionKey, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	apRep := messages.APRep{
+		PVNO:    iana.PVNO,
+		MsgType: msgtype.KRB_AP_REP,
+		EncPart: encrypted,
+	}
+	message, err := asn1.Marshal(apRep)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	return asn1tools.AddASNAppTag(message, asnAppTag.APREP), nil
+}
+
+// offerSecurityLayers returns the wrapped security layers and maximum
+// buffer size offered to the client.
+func (s *Krb5Server) offerSecurityLayers() ([]byte, error) {
+	if s.offeredQop == 0 {
+		return nil, errors.New("GSSAPI: No common protection layer between client and server")
+	}
+	return s.secCtx.wrap(newSecurityLayerMessage(s.offeredQop, s.RecvMaxBufSize, ""), false)
+}
+
+// doHandshake2 processes the security layer selected by the client, its
+// maximum buffer size and authorization ID.
+func (s *Krb5Server) doHandshake2(response []byte) error {
+	message, _, err := s.secCtx.unwrap(response)
+	if err != nil {
+		return err
+	}
+	selectedQop, clientMaxBufSize, authorizationID, err := parseSecurityLayerMessage(message)
+	if err != nil {
+		return err
+	}
+	if selectedQop&s.offeredQop == 0 || selectedQop&(selectedQop-1) != 0 {
+		return fmt.Errorf("GSSAPI: client selected a protection layer that was not offered: %d", selectedQop)
+	}
+	if err := s.selectQop(selectedQop, clientMaxBufSize); err != nil {
+		return err
+	}
+	if !utf8.Valid([]byte(authorizationID)) {
+		return errors.New("GSSAPI: authorization ID is not valid UTF-8")
+	}
+
+	authenticationID, err := s.mapper(s.principal)
+	if err != nil {
+		return fmt.Errorf("GSSAPI: %s", err)
+	}
+	if s.authorizationID, err = sasl.Authorize(s.cbh, authenticationID, authorizationID); err != nil {
+		return errors.New("GSSAPI: " + err.Error())
+	}
+	return nil
+}
+
+// GetAuthorizationID reports the authorization ID of the client, which is
+// the mapped principal when the client did not supply an authzid.
+func (s *Krb5Server) GetAuthorizationID() (string, error) {
+	if !s.Completed {
+		return "", errors.New("GSSAPI authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// GetPrincipal reports the Kerberos principal the client authenticated
+// as, before it is mapped to the authentication ID.
+func (s *Krb5Server) GetPrincipal() (string, error) {
+	if !s.Completed {
+		return "", errors.New("GSSAPI authentication not completed")
+	}
+	return s.principal, nil
+}
+
+// GetNegotiatedProperty retrieves the negotiated property. For an unbound
+// server, SaslPropertyBoundServerName is the host name of the ticket.
+func (s *Krb5Server) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.Completed {
+		return nil, errors.New("GSSAPI authentication not completed")
+	}
+	if propName == sasl.SaslPropertyBoundServerName {
+		return s.serverName, nil
+	}
+	return s.Krb5Base.GetNegotiatedProperty(propName)
+}
+
+// fail resets the server after an error.
+func (s *Krb5Server) fail(err error) error {
+	s.step = 0
+	s.Krb5Base.Dispose()
+	return err
+}
+
+// Dispose the sasl
+func (s *Krb5Server) Dispose() error {
+	s.cbh = nil
+	return s.Krb5Base.Dispose()
+}
@@ -0,0 +1,385 @@
+package gssapi
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	sasl "github.com/jellybean4/go-sasl"
+	"github.com/jellybean4/go-sasl/gssapi/kdctest"
+)
+
+// testRealm is a KDC for EXAMPLE.COM, with the user alice, whose
+// ticket-granting ticket is in ccache, and the services of keytab.
+type testRealm struct {
+	kdc    *kdctest.KDC
+	keytab string
+	ccache string
+}
+
+// newTestRealm starts a KDC with the user alice and the services
+// imap/host.example.com and imap/mail.example.com.
+func newTestRealm(t *testing.T) *testRealm {
+	kdc, err := kdctest.NewKDC("EXAMPLE.COM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kdc.Close() })
+	for _, name := range []string{"alice", "imap/host.example.com", "imap/mail.example.com"} {
+		if err := kdc.AddPrincipal(name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &testRealm{kdc: kdc}
+	if r.keytab, err = kdc.WriteKeytab("imap/host.example.com", "imap/mail.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if r.ccache, err = kdc.WriteCCache("alice"); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// clientProps returns props with the credentials of alice.
+func (r *testRealm) clientProps(props map[string]interface{}) map[string]interface{} {
+	clientProps := map[string]interface{}{
+		CCACHE_PROPERTY:      "FILE:" + r.ccache,
+		KRB5_CONFIG_PROPERTY: r.kdc.GetConfigPath(),
+	}
+	for name, value := range props {
+		clientProps[name] = value
+	}
+	return clientProps
+}
+
+// serverProps returns props with the keys of the realm's services and,
+// unless props has one, a replay cache of their own.
+func (r *testRealm) serverProps(props map[string]interface{}) map[string]interface{} {
+	serverProps := map[string]interface{}{
+		KEYTAB_PROPERTY:       r.keytab,
+		REPLAY_CACHE_PROPERTY: NewReplayCache(),
+	}
+	for name, value := range props {
+		serverProps[name] = value
+	}
+	return serverProps
+}
+
+// newClient creates a client of alice for imap/serverName.
+func (r *testRealm) newClient(t *testing.T, authorizationID, serverName string, props map[string]interface{}) *Krb5Client {
+	client, err := NewKrb5Client(authorizationID, "imap", serverName, r.clientProps(props))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// newServer creates a server of the realm's services.
+func (r *testRealm) newServer(t *testing.T, protocol, serverName string, props map[string]interface{}, cbh sasl.CallbackHandler) *Krb5Server {
+	server, err := NewKrb5Server(protocol, serverName, r.serverProps(props), cbh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// exchange runs the exchange between client and server, passing the
+// challenge accompanying the success of the server to the client.
+func exchange(client sasl.Client, server sasl.Server) error {
+	var response []byte
+	if client.HasInitialResponse() {
+		var err error
+		if response, err = client.EvaluateChallenge(nil); err != nil {
+			return err
+		}
+	}
+	challenge, err := server.EvaluateResponse(response)
+	for err == nil && !server.IsComplete() {
+		if response, err = client.EvaluateChallenge(challenge); err == nil {
+			challenge, err = server.EvaluateResponse(response)
+		}
+	}
+	if err == nil && !client.IsComplete() {
+		_, err = client.EvaluateChallenge(challenge)
+	}
+	return err
+}
+
+func TestReplayCache(t *testing.T) {
+	cache := NewReplayCache()
+	expires := time.Now().Add(time.Minute)
+	if err := cache.Check("first", expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Check("second", expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Check("first", expires); err == nil {
+		t.Error("replay of first accepted")
+	}
+
+	// Expired entries are forgotten.
+	if err := cache.Check("expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Check("expired", expires); err != nil {
+		t.Errorf("expired entry still recorded: %s", err)
+	}
+
+	// Expired entries are swept at most once per interval.
+	if err := cache.Check("stale", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Check("third", expires); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.entries["stale"]; !ok {
+		t.Error("entries swept before the sweep interval elapsed")
+	}
+	cache.nextSweep = time.Now()
+	if err := cache.Check("fourth", expires); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.entries["stale"]; ok {
+		t.Error("expired entry not swept")
+	}
+	if len(cache.entries) != 5 {
+		t.Errorf("%d entries recorded, want 5", len(cache.entries))
+	}
+}
+
+// TestChecksumFlags checks the Bnd field of authenticator checksums.
+func TestChecksumFlags(t *testing.T) {
+	gssFlags := uint32(GSS_C_MUTUAL_FLAG | GSS_C_INTEG_FLAG)
+	tests := []struct {
+		name      string
+		initiator []byte
+		acceptor  []byte
+		err       string
+	}{
+		{"unbound", nil, nil, ""},
+		{"bound", []byte("exporter"), []byte("exporter"), ""},
+		{"other connection", []byte("another"), []byte("exporter"), "channel bindings do not match"},
+		{"initiator without channel bindings", nil, []byte("exporter"), "channel bindings do not match"},
+		// Like MIT Kerberos, acceptors without channel bindings ignore
+		// those of the initiator.
+		{"acceptor without channel bindings", []byte("exporter"), nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checksum := types.Checksum{CksumType: chksumtype.GSSAPI, Checksum: newAuthenticatorChecksum(gssFlags, test.initiator)}
+			got, err := checksumFlags(checksum, test.acceptor)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error %v, want %q", err, test.err)
+				}
+			} else if err != nil {
+				t.Error(err)
+			} else if got != gssFlags {
+				t.Errorf("flags %#x, want %#x", got, gssFlags)
+			}
+		})
+	}
+}
+
+// TestReplayCacheShared checks that an AP-REQ is accepted once by the
+// servers sharing a replay cache.
+func TestReplayCacheShared(t *testing.T) {
+	realm := newTestRealm(t)
+	client := realm.newClient(t, "", "host.example.com", nil)
+	apReq, err := client.EvaluateChallenge(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewReplayCache()
+	props := map[string]interface{}{REPLAY_CACHE_PROPERTY: cache}
+	if _, err := realm.newServer(t, "imap", "host.example.com", props, nil).EvaluateResponse(apReq); err != nil {
+		t.Fatal(err)
+	}
+	// An unbound server of the same service
+	if _, err := realm.newServer(t, "imap", "", props, nil).EvaluateResponse(apReq); err == nil ||
+		!strings.Contains(err.Error(), "replay") {
+		t.Errorf("replayed AP-REQ: %v", err)
+	}
+	// A server with its own cache does not know the AP-REQ
+	if _, err := realm.newServer(t, "imap", "host.example.com", nil, nil).EvaluateResponse(apReq); err != nil {
+		t.Errorf("AP-REQ rejected by another cache: %s", err)
+	}
+}
+
+func TestCheckTimes(t *testing.T) {
+	now := time.Now()
+	invalid := types.NewKrbFlags()
+	types.SetFlag(&invalid, flags.Invalid)
+
+	tests := []struct {
+		name      string
+		clockSkew string
+		ctime     time.Duration
+		authTime  time.Duration
+		startTime time.Duration
+		endTime   time.Duration
+		invalid   bool
+		err       string
+	}{
+		{"valid", "", 0, -time.Hour, -time.Hour, time.Hour, false, ""},
+		{"no start time", "", 0, -time.Hour, 0, time.Hour, false, ""},
+		{"start within skew", "", 0, 4 * time.Minute, 4 * time.Minute, time.Hour, false, ""},
+		{"not yet valid", "", 0, 6 * time.Minute, 6 * time.Minute, time.Hour, false, "not yet valid"},
+		{"auth time in the future", "", 0, 6 * time.Minute, 0, time.Hour, false, "not yet valid"},
+		{"expired within skew", "", 0, -time.Hour, -time.Hour, -4 * time.Minute, false, ""},
+		{"expired", "", 0, -time.Hour, -time.Hour, -6 * time.Minute, false, "expired"},
+		{"invalid", "", 0, -time.Hour, -time.Hour, time.Hour, true, "invalid"},
+		{"slow clock within skew", "", -4 * time.Minute, -time.Hour, -time.Hour, time.Hour, false, ""},
+		{"slow clock", "", -6 * time.Minute, -time.Hour, -time.Hour, time.Hour, false, "clock skew"},
+		{"fast clock", "", 6 * time.Minute, -time.Hour, -time.Hour, time.Hour, false, "clock skew"},
+		{"slow clock with greater skew", "600", -6 * time.Minute, -time.Hour, -time.Hour, time.Hour, false, ""},
+		{"slow clock with smaller skew", "60", -2 * time.Minute, -time.Hour, -time.Hour, time.Hour, false, "clock skew"},
+	}
+	realm := newTestRealm(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := realm.newServer(t, "imap", "host.example.com", map[string]interface{}{CLOCK_SKEW_PROPERTY: test.clockSkew}, nil)
+			auth := &types.Authenticator{CTime: now.Add(test.ctime)}
+			encPart := &messages.EncTicketPart{
+				Flags:    types.NewKrbFlags(),
+				AuthTime: now.Add(test.authTime),
+				EndTime:  now.Add(test.endTime),
+			}
+			if test.startTime != 0 {
+				encPart.StartTime = now.Add(test.startTime)
+			}
+			if test.invalid {
+				encPart.Flags = invalid
+			}
+
+			err := server.checkTimes(auth, encPart)
+			if len(test.err) <= 0 {
+				if err != nil {
+					t.Error(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+// TestExpiredTicket checks that a ticket past its end time is rejected,
+// the clock skew being 1 second.
+func TestExpiredTicket(t *testing.T) {
+	realm := newTestRealm(t)
+	realm.kdc.SetTicketLifetime(time.Second)
+	client := realm.newClient(t, "", "host.example.com", nil)
+	apReq, err := client.EvaluateChallenge(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2100 * time.Millisecond)
+
+	server := realm.newServer(t, "imap", "host.example.com", map[string]interface{}{CLOCK_SKEW_PROPERTY: "1"}, nil)
+	if _, err := server.EvaluateResponse(apReq); err == nil {
+		t.Fatal("accepted an expired ticket")
+	} else if !strings.Contains(err.Error(), "expired") {
+		t.Errorf("error %s", err)
+	}
+}
+
+func TestCheckServicePrincipal(t *testing.T) {
+	tests := []struct {
+		serverName string
+		sname      string
+		valid      bool
+	}{
+		{"host.example.com", "imap/host.example.com", true},
+		{"host.example.com", "imap/HOST.EXAMPLE.COM", true},
+		{"host.example.com", "imap/mail.example.com", false},
+		{"host.example.com", "ldap/host.example.com", false},
+		{"host.example.com", "imap", false},
+		{"host.example.com", "imap/host.example.com/extra", false},
+		{"", "imap/mail.example.com", true},
+		{"", "ldap/mail.example.com", false},
+		{"", "imap", false},
+	}
+	for _, test := range tests {
+		server := &Krb5Server{protocol: "imap", serverName: test.serverName}
+		err := server.checkServicePrincipal(types.NewPrincipalName(nametype.KRB_NT_SRV_HST, test.sname))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("server %q accepted %s", test.serverName, test.sname)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("server %q: %s", test.serverName, err)
+		}
+		if len(test.serverName) <= 0 && server.serverName != strings.Split(test.sname, "/")[1] {
+			t.Errorf("unbound server bound to %q by %s", server.serverName, test.sname)
+		}
+	}
+}
+
+// TestUnboundServer checks that an unbound server accepts tickets for each
+// host of its keytab, and reports the host the client asked for.
+func TestUnboundServer(t *testing.T) {
+	realm := newTestRealm(t)
+	for _, serverName := range []string{"host.example.com", "mail.example.com"} {
+		client := realm.newClient(t, "", serverName, nil)
+		server := realm.newServer(t, "imap", "", nil, nil)
+		if err := exchange(client, server); err != nil {
+			t.Fatal(err)
+		}
+		if bound, err := server.GetNegotiatedProperty(sasl.SaslPropertyBoundServerName); err != nil || bound != serverName {
+			t.Errorf("bound server name %v, %v, want %s", bound, err, serverName)
+		}
+	}
+
+	// A bound server rejects the tickets of the other host
+	client := realm.newClient(t, "", "mail.example.com", nil)
+	server := realm.newServer(t, "imap", "host.example.com", nil, nil)
+	if err := exchange(client, server); err == nil || !strings.Contains(err.Error(), "mail.example.com") {
+		t.Errorf("ticket for another host: %v", err)
+	}
+}
+
+func TestPrincipalMappingShort(t *testing.T) {
+	realm := newTestRealm(t)
+	props := map[string]interface{}{PRINCIPAL_MAPPING_PROPERTY: PRINCIPAL_MAPPING_SHORT}
+	client := realm.newClient(t, "", "host.example.com", nil)
+	server := realm.newServer(t, "imap", "host.example.com", props, nil)
+	if err := exchange(client, server); err != nil {
+		t.Fatal(err)
+	}
+	if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "alice" {
+		t.Errorf("authorization ID %q, %v, want alice", authorizationID, err)
+	}
+	if principal, err := server.GetPrincipal(); err != nil || principal != "alice@EXAMPLE.COM" {
+		t.Errorf("principal %q, %v", principal, err)
+	}
+
+	tests := []struct {
+		principal string
+		want      string
+	}{
+		{"alice@EXAMPLE.COM", "alice"},
+		{"imap/host.example.com@EXAMPLE.COM", "imap/host.example.com"},
+		{"alice@OTHER.ORG", ""},
+		{"alice@example.com", ""},
+		{"alice@EXAMPLE.COM.OTHER.ORG", ""},
+	}
+	for _, test := range tests {
+		got, err := server.mapper(test.principal)
+		if len(test.want) <= 0 {
+			if err == nil {
+				t.Errorf("mapped %s of a foreign realm to %q", test.principal, got)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("mapped %s to %q, %v, want %q", test.principal, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package gssapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DEFAULT_REPLAY_CACHE is the replay cache shared by the GSSAPI servers
+// created without REPLAY_CACHE_PROPERTY.
+var DEFAULT_REPLAY_CACHE = NewReplayCache()
+
+// ReplayCache remembers the authenticators accepted by GSSAPI servers, so
+// that an AP-REQ cannot be replayed while its authenticator is within the
+// allowed clock skew (RFC 4120 section 3.2.3). A ReplayCache is safe for
+// concurrent use, and may be shared by the servers of several services.
+type ReplayCache struct {
+	mutex     sync.Mutex
+	entries   map[string]time.Time
+	nextSweep time.Time
+}
+
+// REPLAY_CACHE_SWEEP_INTERVAL is the minimum interval between two sweeps
+// of the expired entries of a ReplayCache.
+const REPLAY_CACHE_SWEEP_INTERVAL = time.Minute
+
+// NewReplayCache creates an empty ReplayCache.
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{entries: map[string]time.Time{}}
+}
+
+// Check records the authenticator identified by id, which can be
+// forgotten after expires. An error is returned if the authenticator has
+// been recorded already and has not expired.
+func (c *ReplayCache) Check(id string, expires time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if !now.Before(c.nextSweep) {
+		for entry, entryExpires := range c.entries {
+			if now.After(entryExpires) {
+				delete(c.entries, entry)
+			}
+		}
+		c.nextSweep = now.Add(REPLAY_CACHE_SWEEP_INTERVAL)
+	}
+	if entryExpires, ok := c.entries[id]; ok && !now.After(entryExpires) {
+		return errors.New("GSSAPI: request is a replay")
+	}
+	c.entries[id] = expires
+	return nil
+}
@@ -0,0 +1,293 @@
+package gssapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/crypto/etype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// Flags of RFC 4121 wrap tokens.
+const (
+	FLAG_SENT_BY_ACCEPTOR = byte(0x01)
+	FLAG_SEALED           = byte(0x02)
+	FLAG_ACCEPTOR_SUBKEY  = byte(0x04)
+
+	WRAP_TOKEN_HEADER_LENGTH = 16
+	MIC_TOKEN_HEADER_LENGTH  = 16
+)
+
+// krb5Context protects messages with the key of an established Kerberos
+// security context, using the wrap tokens of RFC 4121 section 4.2.6.2:
+//
+//	Octet no   Name        Description
+//	0..1       TOK_ID      0x05 0x04
+//	2          Flags       SentByAcceptor, Sealed, AcceptorSubkey
+//	3          Filler      0xFF
+//	4..5       EC          Extra count, big-endian
+//	6..7       RRC         Right rotation count, big-endian
+//	8..15      SND_SEQ     Sequence number, big-endian
+//	16..last   Data        Encrypted {data | filler | header}, or
+//	                       plaintext data followed by the checksum
+//
+// Both forms are protected with the SEAL key usage of the sender. Tokens
+// are sent with RRC 0 and, when sealed, EC 0; received tokens may
+// be rotated. Sequence numbers must follow each other, as messages are
+// delivered in order over the connection.
+type krb5Context struct {
+	key            types.EncryptionKey
+	etype          etype.EType
+	initiator      bool
+	acceptorSubkey bool
+	sendSeqNum     uint64
+	recvSeqNum     uint64
+}
+
+// newKrb5Context creates the security context of the initiator or the
+// acceptor. acceptorSubkey tells whether key is a subkey asserted by the
+// acceptor. The encryption types of RFC 1964 and RFC 4757 contexts, which
+// use different token formats, are not supported.
+func newKrb5Context(key types.EncryptionKey, initiator, acceptorSubkey bool, sendSeqNum, recvSeqNum uint64) (*krb5Context, error) {
+	switch key.KeyType {
+	case etypeID.DES3_CBC_SHA1_KD, etypeID.RC4_HMAC, etypeID.RC4_HMAC_EXP:
+		return nil, fmt.Errorf("GSSAPI: encryption type %d requires RFC 1964 tokens, which are not supported", key.KeyType)
+	}
+	et, err := crypto.GetEtype(key.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	// The key is copied so that dispose() leaves the ticket caches intact
+	ctx := &krb5Context{
+		key:            types.EncryptionKey{KeyType: key.KeyType, KeyValue: append([]byte(nil), key.KeyValue...)},
+		etype:          et,
+		initiator:      initiator,
+		acceptorSubkey: acceptorSubkey,
+		sendSeqNum:     sendSeqNum,
+		recvSeqNum:     recvSeqNum,
+	}
+	return ctx, nil
+}
+
+// wrap creates the wrap token carrying data, encrypted if confidential
+// is true.
+func (c *krb5Context) wrap(data []byte, confidential bool) ([]byte, error) {
+	flags := byte(0)
+	usage := uint32(keyusage.GSSAPI_INITIATOR_SEAL)
+	if !c.initiator {
+		flags |= FLAG_SENT_BY_ACCEPTOR
+		usage = keyusage.GSSAPI_ACCEPTOR_SEAL
+	}
+	if c.acceptorSubkey {
+		flags |= FLAG_ACCEPTOR_SUBKEY
+	}
+
+	var token []byte
+	if confidential {
+		header := newWrapTokenHeader(flags|FLAG_SEALED, c.sendSeqNum)
+		plaintext := make([]byte, 0, len(data)+WRAP_TOKEN_HEADER_LENGTH)
+		plaintext = append(plaintext, data...)
+		plaintext = append(plaintext, header...)
+		_, ciphertext, err := c.etype.EncryptMessage(c.key.KeyValue, plaintext, usage)
+		if err != nil {
+			return nil, fmt.Errorf("GSSAPI: %s", err)
+		}
+		token = append(header, ciphertext...)
+	} else {
+		header := newWrapTokenHeader(flags, c.sendSeqNum)
+		signed := make([]byte, 0, len(data)+WRAP_TOKEN_HEADER_LENGTH)
+		signed = append(signed, data...)
+		signed = append(signed, header...)
+		checksum, err := c.etype.GetChecksumHash(c.key.KeyValue, signed, usage)
+		if err != nil {
+			return nil, fmt.Errorf("GSSAPI: %s", err)
+		}
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(checksum)))
+		token = make([]byte, 0, len(header)+len(data)+len(checksum))
+		token = append(token, header...)
+		token = append(token, data...)
+		token = append(token, checksum...)
+	}
+	c.sendSeqNum++
+	return token, nil
+}
+
+// unwrap verifies a wrap token sent by the peer and returns its data, and
+// whether the data was encrypted.
+func (c *krb5Context) unwrap(token []byte) ([]byte, bool, error) {
+	if len(token) < WRAP_TOKEN_HEADER_LENGTH || !bytes.Equal(token[0:2], TOK_ID_WRAP) || token[3] != 0xFF {
+		return nil, false, errors.New("GSSAPI: invalid wrap token")
+	}
+	flags := token[2]
+	usage := uint32(keyusage.GSSAPI_ACCEPTOR_SEAL)
+	if !c.initiator {
+		usage = keyusage.GSSAPI_INITIATOR_SEAL
+	}
+	if (flags&FLAG_SENT_BY_ACCEPTOR != 0) != c.initiator {
+		return nil, false, errors.New("GSSAPI: wrap token sent in the wrong direction")
+	} else if (flags&FLAG_ACCEPTOR_SUBKEY != 0) != c.acceptorSubkey {
+		return nil, false, errors.New("GSSAPI: wrap token protected with an unexpected key")
+	}
+	ec := int(binary.BigEndian.Uint16(token[4:6]))
+	rrc := int(binary.BigEndian.Uint16(token[6:8]))
+	seqNum := binary.BigEndian.Uint64(token[8:16])
+	body := rotateLeft(token[WRAP_TOKEN_HEADER_LENGTH:], rrc)
+
+	// The header protected by the token has a zero RRC, and a zero EC too
+	// when the data is only signed
+	header := make([]byte, WRAP_TOKEN_HEADER_LENGTH)
+	copy(header, token)
+	header[6], header[7] = 0, 0
+
+	var data []byte
+	sealed := flags&FLAG_SEALED != 0
+	if sealed {
+		if len(body) < c.etype.GetConfounderByteSize()+c.etype.GetHMACBitLength()/8+WRAP_TOKEN_HEADER_LENGTH {
+			return nil, false, errors.New("GSSAPI: invalid wrap token")
+		}
+		plaintext, err := crypto.DecryptMessage(body, c.key, usage)
+		if err != nil {
+			return nil, false, fmt.Errorf("GSSAPI: %s", err)
+		}
+		if len(plaintext) < ec+WRAP_TOKEN_HEADER_LENGTH ||
+			!bytes.Equal(plaintext[len(plaintext)-WRAP_TOKEN_HEADER_LENGTH:], header) {
+			return nil, false, errors.New("GSSAPI: wrap token header mismatch")
+		}
+		data = plaintext[:len(plaintext)-WRAP_TOKEN_HEADER_LENGTH-ec]
+	} else {
+		if len(body) < ec {
+			return nil, false, errors.New("GSSAPI: invalid wrap token")
+		}
+		header[4], header[5] = 0, 0
+		data = body[:len(body)-ec]
+		signed := make([]byte, 0, len(data)+WRAP_TOKEN_HEADER_LENGTH)
+		signed = append(signed, data...)
+		signed = append(signed, header...)
+		if !c.etype.VerifyChecksum(c.key.KeyValue, signed, body[len(body)-ec:], usage) {
+			return nil, false, errors.New("GSSAPI: wrap token checksum verification failed")
+		}
+	}
+
+	if seqNum != c.recvSeqNum {
+		return nil, false, fmt.Errorf("GSSAPI: wrap token out of sequence: got %d, expected %d", seqNum, c.recvSeqNum)
+	}
+	c.recvSeqNum++
+	return data, sealed, nil
+}
+
+// getMIC creates the MIC token of data (RFC 4121 section 4.2.6.1):
+//
+//	Octet no   Name        Description
+//	0..1       TOK_ID      0x04 0x04
+//	2          Flags       SentByAcceptor, Sealed, AcceptorSubkey
+//	3..7       Filler      0xFF
+//	8..15      SND_SEQ     Sequence number, big-endian
+//	16..last   SGN_CKSUM   Checksum of {data | header}
+//
+// MIC tokens are numbered along with the wrap tokens.
+func (c *krb5Context) getMIC(data []byte) ([]byte, error) {
+	flags := byte(0)
+	usage := uint32(keyusage.GSSAPI_INITIATOR_SIGN)
+	if !c.initiator {
+		flags |= FLAG_SENT_BY_ACCEPTOR
+		usage = keyusage.GSSAPI_ACCEPTOR_SIGN
+	}
+	if c.acceptorSubkey {
+		flags |= FLAG_ACCEPTOR_SUBKEY
+	}
+	header := newMICTokenHeader(flags, c.sendSeqNum)
+	signed := make([]byte, 0, len(data)+MIC_TOKEN_HEADER_LENGTH)
+	signed = append(signed, data...)
+	signed = append(signed, header...)
+	checksum, err := c.etype.GetChecksumHash(c.key.KeyValue, signed, usage)
+	if err != nil {
+		return nil, fmt.Errorf("GSSAPI: %s", err)
+	}
+	c.sendSeqNum++
+	return append(header, checksum...), nil
+}
+
+// verifyMIC verifies a MIC token sent by the peer for data.
+func (c *krb5Context) verifyMIC(data, token []byte) error {
+	if len(token) < MIC_TOKEN_HEADER_LENGTH || !bytes.Equal(token[0:2], TOK_ID_MIC) ||
+		!bytes.Equal(token[3:8], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF}) {
+		return errors.New("GSSAPI: invalid MIC token")
+	}
+	flags := token[2]
+	usage := uint32(keyusage.GSSAPI_ACCEPTOR_SIGN)
+	if !c.initiator {
+		usage = keyusage.GSSAPI_INITIATOR_SIGN
+	}
+	if (flags&FLAG_SENT_BY_ACCEPTOR != 0) != c.initiator {
+		return errors.New("GSSAPI: MIC token sent in the wrong direction")
+	} else if (flags&FLAG_ACCEPTOR_SUBKEY != 0) != c.acceptorSubkey {
+		return errors.New("GSSAPI: MIC token protected with an unexpected key")
+	}
+
+	signed := make([]byte, 0, len(data)+MIC_TOKEN_HEADER_LENGTH)
+	signed = append(signed, data...)
+	signed = append(signed, token[:MIC_TOKEN_HEADER_LENGTH]...)
+	if !c.etype.VerifyChecksum(c.key.KeyValue, signed, token[MIC_TOKEN_HEADER_LENGTH:], usage) {
+		return errors.New("GSSAPI: MIC token checksum verification failed")
+	}
+	seqNum := binary.BigEndian.Uint64(token[8:16])
+	if seqNum != c.recvSeqNum {
+		return fmt.Errorf("GSSAPI: MIC token out of sequence: got %d, expected %d", seqNum, c.recvSeqNum)
+	}
+	c.recvSeqNum++
+	return nil
+}
+
+// wrapSizeLimit returns the largest message whose wrap token does not
+// exceed maxSize.
+func (c *krb5Context) wrapSizeLimit(maxSize int, confidential bool) int {
+	overhead := WRAP_TOKEN_HEADER_LENGTH + c.etype.GetHMACBitLength()/8
+	if confidential {
+		overhead += WRAP_TOKEN_HEADER_LENGTH + c.etype.GetConfounderByteSize()
+	}
+	return maxSize - overhead
+}
+
+// dispose clears the key of the context.
+func (c *krb5Context) dispose() {
+	for i := range c.key.KeyValue {
+		c.key.KeyValue[i] = 0
+	}
+}
+
+// newWrapTokenHeader creates the header of a wrap token with zero EC and
+// RRC.
+func newWrapTokenHeader(flags byte, seqNum uint64) []byte {
+	header := make([]byte, WRAP_TOKEN_HEADER_LENGTH)
+	copy(header, TOK_ID_WRAP)
+	header[2] = flags
+	header[3] = 0xFF
+	binary.BigEndian.PutUint64(header[8:16], seqNum)
+	return header
+}
+
+// newMICTokenHeader creates the header of a MIC token.
+func newMICTokenHeader(flags byte, seqNum uint64) []byte {
+	header := make([]byte, MIC_TOKEN_HEADER_LENGTH)
+	copy(header, TOK_ID_MIC)
+	header[2] = flags
+	copy(header[3:8], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	binary.BigEndian.PutUint64(header[8:16], seqNum)
+	return header
+}
+
+// rotateLeft undoes a right rotation of count bytes.
+func rotateLeft(buf []byte, count int) []byte {
+	if len(buf) == 0 || count%len(buf) == 0 {
+		return buf
+	}
+	count %= len(buf)
+	rotated := make([]byte, 0, len(buf))
+	rotated = append(rotated, buf[count:]...)
+	return append(rotated, buf[:count]...)
+}
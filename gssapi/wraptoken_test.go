@@ -0,0 +1,318 @@
+package gssapi
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/types"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// newContextPair creates the contexts of an initiator and an acceptor
+// sharing a key of encryption type keyType.
+func newContextPair(t *testing.T, keyType int32, acceptorSubkey bool) (*krb5Context, *krb5Context) {
+	size := map[int32]int{
+		etypeID.AES128_CTS_HMAC_SHA1_96:    16,
+		etypeID.AES256_CTS_HMAC_SHA1_96:    32,
+		etypeID.AES128_CTS_HMAC_SHA256_128: 16,
+		etypeID.AES256_CTS_HMAC_SHA384_192: 32,
+	}[keyType]
+	key := types.EncryptionKey{KeyType: keyType, KeyValue: make([]byte, size)}
+	for i := range key.KeyValue {
+		key.KeyValue[i] = byte(i)
+	}
+	initiator, err := newKrb5Context(key, true, acceptorSubkey, 1000, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptor, err := newKrb5Context(key, false, acceptorSubkey, 2000, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return initiator, acceptor
+}
+
+// rotate moves the last rrc octets of the body of a wrap token to its
+// front, as a sender using RRC does.
+func rotate(token []byte, rrc int) []byte {
+	body := token[WRAP_TOKEN_HEADER_LENGTH:]
+	rotated := append([]byte{}, token[:WRAP_TOKEN_HEADER_LENGTH]...)
+	binary.BigEndian.PutUint16(rotated[6:8], uint16(rrc))
+	rotated = append(rotated, body[len(body)-rrc:]...)
+	return append(rotated, body[:len(body)-rrc]...)
+}
+
+// sealWithFiller creates a sealed wrap token carrying ec octets of filler,
+// which the senders of this package never add.
+func sealWithFiller(t *testing.T, c *krb5Context, data []byte, ec int) []byte {
+	header := newWrapTokenHeader(FLAG_SENT_BY_ACCEPTOR|FLAG_SEALED, c.sendSeqNum)
+	binary.BigEndian.PutUint16(header[4:6], uint16(ec))
+	plaintext := append(append(append([]byte{}, data...), make([]byte, ec)...), header...)
+	_, ciphertext, err := c.etype.EncryptMessage(c.key.KeyValue, plaintext, keyusage.GSSAPI_ACCEPTOR_SEAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.sendSeqNum++
+	return append(header, ciphertext...)
+}
+
+func TestWrapTokens(t *testing.T) {
+	keyTypes := []struct {
+		name    string
+		keyType int32
+	}{
+		{"aes128-cts-hmac-sha1-96", etypeID.AES128_CTS_HMAC_SHA1_96},
+		{"aes256-cts-hmac-sha1-96", etypeID.AES256_CTS_HMAC_SHA1_96},
+		{"aes128-cts-hmac-sha256-128", etypeID.AES128_CTS_HMAC_SHA256_128},
+		{"aes256-cts-hmac-sha384-192", etypeID.AES256_CTS_HMAC_SHA384_192},
+	}
+	data := []byte("The quick brown fox jumps over the lazy dog")
+
+	tests := []struct {
+		name         string
+		confidential bool
+		// token creates the token the acceptor sends to the initiator.
+		token func(t *testing.T, acceptor *krb5Context) []byte
+		fails bool
+	}{
+		{"sealed", true, nil, false},
+		{"signed", false, nil, false},
+		{"sealed RRC 28", true, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, true)
+			return rotate(token, 28)
+		}, false},
+		{"sealed RRC longer than the body", true, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, true)
+			rotated := rotate(token, 5)
+			binary.BigEndian.PutUint16(rotated[6:8], uint16(5+len(rotated)-WRAP_TOKEN_HEADER_LENGTH))
+			return rotated
+		}, false},
+		{"signed RRC 3", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			return rotate(token, 3)
+		}, false},
+		{"sealed EC 7", true, func(t *testing.T, acceptor *krb5Context) []byte {
+			return sealWithFiller(t, acceptor, data, 7)
+		}, false},
+		{"sealed EC 7 RRC 12", true, func(t *testing.T, acceptor *krb5Context) []byte {
+			return rotate(sealWithFiller(t, acceptor, data, 7), 12)
+		}, false},
+		{"tampered checksum", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			token[len(token)-1] ^= 0x01
+			return token
+		}, true},
+		{"tampered data", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			token[WRAP_TOKEN_HEADER_LENGTH] ^= 0x01
+			return token
+		}, true},
+		{"tampered ciphertext", true, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, true)
+			token[WRAP_TOKEN_HEADER_LENGTH+20] ^= 0x01
+			return token
+		}, true},
+		{"tampered sealed header", true, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, true)
+			token[15] ^= 0x01
+			return token
+		}, true},
+		{"EC larger than the token", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			binary.BigEndian.PutUint16(token[4:6], 0xFFFF)
+			return token
+		}, true},
+		{"out of sequence", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			acceptor.wrap(data, false)
+			token, _ := acceptor.wrap(data, false)
+			return token
+		}, true},
+		{"wrong direction", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			token[2] &^= FLAG_SENT_BY_ACCEPTOR
+			return token
+		}, true},
+		{"unexpected acceptor subkey", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			token[2] |= FLAG_ACCEPTOR_SUBKEY
+			return token
+		}, true},
+		{"MIC token ID", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			copy(token, TOK_ID_MIC)
+			return token
+		}, true},
+		{"short token", false, func(t *testing.T, acceptor *krb5Context) []byte {
+			token, _ := acceptor.wrap(data, false)
+			return token[:WRAP_TOKEN_HEADER_LENGTH-1]
+		}, true},
+	}
+	for _, keyType := range keyTypes {
+		for _, test := range tests {
+			t.Run(keyType.name+" "+test.name, func(t *testing.T) {
+				initiator, acceptor := newContextPair(t, keyType.keyType, false)
+				var token []byte
+				if test.token != nil {
+					token = test.token(t, acceptor)
+				} else {
+					var err error
+					if token, err = acceptor.wrap(data, test.confidential); err != nil {
+						t.Fatal(err)
+					}
+				}
+				got, sealed, err := initiator.unwrap(token)
+				if test.fails {
+					if err == nil {
+						t.Fatalf("unwrapped %q", got)
+					}
+					return
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) || sealed != test.confidential {
+					t.Errorf("unwrapped %q, sealed %v", got, sealed)
+				}
+				if test.confidential && bytes.Contains(token, data[:16]) {
+					t.Error("sealed token holds the data in clear")
+				}
+			})
+		}
+	}
+}
+
+// TestWrapSequence checks both directions of a context with an acceptor
+// subkey, with MIC tokens numbered along with wrap tokens.
+func TestWrapSequence(t *testing.T) {
+	initiator, acceptor := newContextPair(t, etypeID.AES256_CTS_HMAC_SHA1_96, true)
+	for i, message := range []string{"", "a", "hello", "the last message"} {
+		for _, pair := range [][2]*krb5Context{{initiator, acceptor}, {acceptor, initiator}} {
+			sender, receiver := pair[0], pair[1]
+			token, err := sender.wrap([]byte(message), i%2 == 0)
+			if err != nil {
+				t.Fatal(err)
+			} else if token[2]&FLAG_ACCEPTOR_SUBKEY == 0 {
+				t.Errorf("flags %02x without AcceptorSubkey", token[2])
+			}
+			if got, _, err := receiver.unwrap(token); err != nil {
+				t.Fatal(err)
+			} else if string(got) != message {
+				t.Errorf("unwrapped %q, want %q", got, message)
+			}
+			// A replayed token is out of sequence.
+			if _, _, err := receiver.unwrap(token); err == nil {
+				t.Error("unwrapped a replayed token")
+			}
+
+			mic, err := sender.getMIC([]byte(message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := receiver.verifyMIC([]byte(message+"!"), mic); err == nil {
+				t.Error("verified the MIC of other data")
+			}
+			if err := receiver.verifyMIC([]byte(message), mic); err != nil {
+				t.Fatal(err)
+			}
+			if err := sender.verifyMIC([]byte(message), mic); err == nil {
+				t.Error("verified an own MIC token")
+			}
+		}
+	}
+	if initiator.sendSeqNum != 1008 || initiator.recvSeqNum != 2008 {
+		t.Errorf("initiator sequence numbers %d, %d", initiator.sendSeqNum, initiator.recvSeqNum)
+	}
+}
+
+func TestRotateLeft(t *testing.T) {
+	tests := []struct {
+		buf   string
+		count int
+		want  string
+	}{
+		{"", 3, ""},
+		{"abcdef", 0, "abcdef"},
+		{"abcdef", 2, "cdefab"},
+		{"abcdef", 6, "abcdef"},
+		{"abcdef", 8, "cdefab"},
+	}
+	for _, test := range tests {
+		if got := rotateLeft([]byte(test.buf), test.count); string(got) != test.want {
+			t.Errorf("rotateLeft(%q, %d) = %q, want %q", test.buf, test.count, got, test.want)
+		}
+	}
+}
+
+func TestSecurityLayerMessage(t *testing.T) {
+	tests := []struct {
+		mask            byte
+		maxBufSize      int
+		authorizationID string
+		want            []byte
+	}{
+		{sasl.NO_PROTECTION, 65536, "", []byte{0x01, 0, 0, 0}},
+		{sasl.INTEGRITY_ONLY_PROTECTION, 65536, "", []byte{0x02, 0x01, 0, 0}},
+		{sasl.NO_PROTECTION | sasl.PRIVACY_PROTECTION, MAX_MAXBUF, "alice", []byte{0x05, 0xFF, 0xFF, 0xFF, 'a', 'l', 'i', 'c', 'e'}},
+	}
+	for _, test := range tests {
+		message := newSecurityLayerMessage(test.mask, test.maxBufSize, test.authorizationID)
+		if !bytes.Equal(message, test.want) {
+			t.Errorf("newSecurityLayerMessage(%d, %d, %q) = %x, want %x",
+				test.mask, test.maxBufSize, test.authorizationID, message, test.want)
+		}
+		mask, maxBufSize, authorizationID, err := parseSecurityLayerMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		} else if mask != test.mask || authorizationID != test.authorizationID ||
+			(maxBufSize != test.maxBufSize && mask != sasl.NO_PROTECTION) {
+			t.Errorf("parseSecurityLayerMessage(%x) = %d, %d, %q", message, mask, maxBufSize, authorizationID)
+		}
+	}
+	for _, message := range [][]byte{nil, {0x01}, {0x01, 0, 0}} {
+		if _, _, _, err := parseSecurityLayerMessage(message); err == nil {
+			t.Errorf("parseSecurityLayerMessage(%x) succeeded", message)
+		}
+	}
+}
+
+func TestInitialContextToken(t *testing.T) {
+	token, err := marshalInitialContextToken(TOK_ID_AP_REQ, []byte("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// [APPLICATION 0] { OID 1.2.840.113554.1.2.2, 01 00, "request" }
+	want := append([]byte{0x60, 0x14, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x12, 0x01, 0x02, 0x02, 0x01, 0x00}, "request"...)
+	if !bytes.Equal(token, want) {
+		t.Fatalf("token %x, want %x", token, want)
+	}
+	tokID, message, err := unmarshalInitialContextToken(token)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(tokID, TOK_ID_AP_REQ) || string(message) != "request" {
+		t.Errorf("token ID %x, message %q", tokID, message)
+	}
+
+	frame := func(oid asn1.ObjectIdentifier, inner string) []byte {
+		encoded, _ := asn1.Marshal(oid)
+		return asn1tools.AddASNAppTag(append(encoded, inner...), 0)
+	}
+	invalid := map[string][]byte{
+		"empty":          nil,
+		"trailing data":  append(append([]byte{}, token...), 0x00),
+		"truncated":      token[:len(token)-1],
+		"other tag":      asn1tools.AddASNAppTag(token[2:], 1),
+		"other mech":     frame(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}, "\x01\x00request"),
+		"no token ID":    frame(KRB5_OID, "\x01"),
+		"no mechanism":   asn1tools.AddASNAppTag([]byte("\x01\x00request"), 0),
+		"universal type": append([]byte{0x30}, token[1:]...),
+	}
+	for name, token := range invalid {
+		if _, _, err := unmarshalInitialContextToken(token); err == nil {
+			t.Errorf("%s: unmarshalInitialContextToken(%x) succeeded", name, token)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package sasl
+
+import (
+	"errors"
+	"strings"
+)
+
+// LoginClient implements the non-standard LOGIN SASL client mechanism
+// https://tools.ietf.org/html/draft-murchison-sasl-login-00
+//
+// The server prompts for the user name and then for the password, which
+// the client sends in separate responses. Prompts are matched loosely:
+// any prompt mentioning a password is answered with the password, any
+// prompt mentioning a user or a name with the user name, and unknown
+// prompts are answered with the user name first and the password next.
+type LoginClient struct {
+	completed        bool
+	userSent         bool
+	cbh              CallbackHandler
+	pw               []byte
+	authorizationID  string
+	authenticationID string
+}
+
+// NewLoginClient creates a new LoginClient instance.
+func NewLoginClient(authenticationID string, pw []byte) (*LoginClient, error) {
+	if len(authenticationID) <= 0 || pw == nil {
+		return nil, errors.New("LOGIN: authentication ID and password must be specified")
+	}
+	client := &LoginClient{
+		authenticationID: authenticationID,
+		pw:               pw,
+	}
+	return client, nil
+}
+
+// NewLoginClientWithHandler creates a LoginClient which retrieves the
+// authentication ID and password through cbh when the first prompt is
+// evaluated. The authorization ID is offered as default name.
+func NewLoginClientWithHandler(authorizationID string, cbh CallbackHandler) (*LoginClient, error) {
+	if cbh == nil {
+		return nil, errors.New("LOGIN: callback handler to get username/password required")
+	}
+	client := &LoginClient{
+		authorizationID: authorizationID,
+		cbh:             cbh,
+	}
+	return client, nil
+}
+
+// GetMechanismName returns the mechanism name "LOGIN".
+func (c *LoginClient) GetMechanismName() string {
+	return "LOGIN"
+}
+
+// HasInitialResponse returns false: the server prompts for the user name.
+func (c *LoginClient) HasInitialResponse() bool {
+	return false
+}
+
+// EvaluateChallenge answers a prompt of the server with the user name or
+// the password. The client completes once it has sent the password.
+func (c *LoginClient) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("LOGIN authentication already completed")
+	}
+	if c.pw == nil {
+		authenticationID, pw, err := getUserInfo("LOGIN", c.authorizationID, c.cbh)
+		if err != nil {
+			return nil, err
+		}
+		c.authenticationID, c.pw = authenticationID, pw
+	}
+
+	prompt := strings.ToLower(string(challengeData))
+	switch {
+	case strings.Contains(prompt, "pass"):
+	case strings.Contains(prompt, "user") || strings.Contains(prompt, "name") || !c.userSent:
+		c.userSent = true
+		return []byte(c.authenticationID), nil
+	}
+	answer := append([]byte{}, c.pw...)
+	c.clearPassword()
+	c.completed = true
+	return answer, nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+// LOGIN completes after sending the password.
+func (c *LoginClient) IsComplete() bool {
+	return c.completed
+}
+
+// Unwrap the incoming buffer.
+func (c *LoginClient) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("LOGIN supports neither integrity nor privacy")
+	}
+	return nil, errors.New("LOGIN authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (c *LoginClient) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("LOGIN supports neither integrity nor privacy")
+	}
+	return nil, errors.New("LOGIN authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (c *LoginClient) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !c.completed {
+		return nil, errors.New("LOGIN authentication not completed")
+	}
+
+	if propName == SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (c *LoginClient) Dispose() error {
+	c.clearPassword()
+	return nil
+}
+
+func (c *LoginClient) clearPassword() {
+	if c.pw == nil {
+		return
+	}
+	ClearBytes(c.pw)
+	c.pw = nil
+}
@@ -0,0 +1,164 @@
+package sasl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoginClientPrompts(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompts []string
+	}{
+		{"default", []string{"Username:", "Password:"}},
+		{"lower case", []string{"username:", "password:"}},
+		{"user name", []string{"User Name", "Pass phrase"}},
+		{"name", []string{"Your name?", "Your password?"}},
+		{"unknown", []string{"Who are you?", "Prove it"}},
+		{"unknown user prompt", []string{"Identify yourself", "Password:"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewLoginClient("tim", []byte("tanstaaftanstaaf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response, err := client.EvaluateChallenge([]byte(test.prompts[0])); err != nil || string(response) != "tim" {
+				t.Fatalf("response to %q: %q, %v", test.prompts[0], response, err)
+			} else if client.IsComplete() {
+				t.Fatal("completed after the user name")
+			}
+			if response, err := client.EvaluateChallenge([]byte(test.prompts[1])); err != nil || string(response) != "tanstaaftanstaaf" {
+				t.Fatalf("response to %q: %q, %v", test.prompts[1], response, err)
+			} else if !client.IsComplete() {
+				t.Fatal("not completed after the password")
+			}
+			if _, err := client.EvaluateChallenge([]byte(test.prompts[1])); err == nil {
+				t.Error("evaluated a challenge after completion")
+			}
+		})
+	}
+}
+
+// TestLoginClientPasswordPrompt checks that a password prompt is answered
+// with the password even if the user name was not asked for.
+func TestLoginClientPasswordPrompt(t *testing.T) {
+	client, err := NewLoginClient("tim", []byte("tanstaaftanstaaf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := client.EvaluateChallenge([]byte("Password:")); err != nil || string(response) != "tanstaaftanstaaf" {
+		t.Errorf("response %q, %v", response, err)
+	}
+}
+
+func TestLoginExchange(t *testing.T) {
+	tests := []struct {
+		name    string
+		props   map[string]interface{}
+		prompts []string
+	}{
+		{"default prompts", nil, []string{DEFAULT_LOGIN_USERNAME_PROMPT, DEFAULT_LOGIN_PASSWORD_PROMPT}},
+		{"custom prompts", map[string]interface{}{
+			LOGIN_USERNAME_PROMPT_PROPERTY: "User Name",
+			LOGIN_PASSWORD_PROMPT_PROPERTY: "Pass phrase",
+		}, []string{"User Name", "Pass phrase"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewLoginClientWithHandler("", cramMD5Handler(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewLoginServerWithHandler(test.props, cramMD5Handler(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := []byte(nil)
+			for _, prompt := range test.prompts {
+				challenge, err := server.EvaluateResponse(response)
+				if err != nil {
+					t.Fatal(err)
+				} else if string(challenge) != prompt {
+					t.Fatalf("prompt %q, want %q", challenge, prompt)
+				}
+				if response, err = client.EvaluateChallenge(challenge); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if challenge, err := server.EvaluateResponse(response); err != nil || challenge != nil {
+				t.Fatalf("challenge %q, %v", challenge, err)
+			} else if !client.IsComplete() || !server.IsComplete() {
+				t.Fatal("exchange not complete")
+			}
+			if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "tim" {
+				t.Errorf("authorization ID %q, %v", authorizationID, err)
+			}
+		})
+	}
+}
+
+// TestLoginServerInitialResponse checks that an initial response is taken
+// as the user name.
+func TestLoginServerInitialResponse(t *testing.T) {
+	server, err := NewLoginServer(nil, plainVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge, err := server.EvaluateResponse([]byte("tim")); err != nil || string(challenge) != DEFAULT_LOGIN_PASSWORD_PROMPT {
+		t.Fatalf("challenge %q, %v", challenge, err)
+	}
+	if _, err := server.EvaluateResponse([]byte("tanstaaftanstaaf")); err != nil || !server.IsComplete() {
+		t.Fatalf("rejected: %v", err)
+	}
+	if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "tim" {
+		t.Errorf("authorization ID %q, %v", authorizationID, err)
+	}
+}
+
+func TestLoginServerResponses(t *testing.T) {
+	long := strings.Repeat("a", PLAIN_MAX_FIELD_LENGTH)
+	tests := []struct {
+		name      string
+		responses []string
+		err       string
+	}{
+		{"longest user name", []string{long, "tanstaaftanstaaf"}, ""},
+		{"user name too long", []string{long + "a"}, "exceeds 255 octets"},
+		{"password too long", []string{"tim", long + "a"}, "exceeds 255 octets"},
+		{"UTF-8 user name", []string{"t\xc3\xafm", "tanstaaftanstaaf"}, ""},
+		{"invalid UTF-8 user name", []string{"t\xc3im"}, "not valid UTF-8"},
+		{"empty user name", []string{"", ""}, "user name must not be empty"},
+		{"empty password", []string{"tim", ""}, "password must not be empty"},
+		{"wrong password", []string{"tim", "secret"}, "authentication failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := NewLoginServer(nil, plainVerifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, response := range test.responses {
+				if _, err = server.EvaluateResponse([]byte(response)); err != nil {
+					break
+				}
+			}
+			if len(test.err) <= 0 {
+				if err != nil || !server.IsComplete() {
+					t.Fatalf("rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error %v, want %q", err, test.err)
+			} else if server.IsComplete() {
+				t.Fatal("completed after an error")
+			}
+			// A failed exchange cannot be resumed.
+			if _, err := server.EvaluateResponse([]byte("tanstaaftanstaaf")); err == nil || !strings.Contains(err.Error(), "already failed") {
+				t.Errorf("response after the failure: %v", err)
+			}
+		})
+	}
+}
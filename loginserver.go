@@ -0,0 +1,198 @@
+package sasl
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+const (
+	// LOGIN_USERNAME_PROMPT_PROPERTY is a property that specifies the
+	// prompt a LoginServer sends for the user name. If this property is
+	// absent, DEFAULT_LOGIN_USERNAME_PROMPT is used.
+	LOGIN_USERNAME_PROMPT_PROPERTY = "golang.security.sasl.login.username.prompt"
+
+	// LOGIN_PASSWORD_PROMPT_PROPERTY is a property that specifies the
+	// prompt a LoginServer sends for the password. If this property is
+	// absent, DEFAULT_LOGIN_PASSWORD_PROMPT is used.
+	LOGIN_PASSWORD_PROMPT_PROPERTY = "golang.security.sasl.login.password.prompt"
+
+	DEFAULT_LOGIN_USERNAME_PROMPT = "Username:"
+	DEFAULT_LOGIN_PASSWORD_PROMPT = "Password:"
+)
+
+// LoginServer implements the non-standard LOGIN SASL server mechanism
+// https://tools.ietf.org/html/draft-murchison-sasl-login-00
+//
+// The server prompts for the user name, unless the client sent it as
+// initial response, then for the password. The credentials are checked
+// like those of PLAIN, with the user name as authorization ID.
+type LoginServer struct {
+	completed       bool
+	failed          bool
+	challenged      bool
+	verifier        PlainVerifier
+	cbh             CallbackHandler
+	usernamePrompt  string
+	passwordPrompt  string
+	username        string
+	authorizationID string
+}
+
+// NewLoginServer creates a new LoginServer instance.
+// The verifier is consulted for every authentication attempt.
+func NewLoginServer(props map[string]interface{}, verifier PlainVerifier) (*LoginServer, error) {
+	if verifier == nil {
+		return nil, errors.New("LOGIN: password verifier must be specified")
+	}
+	return newLoginServer(props, verifier, nil)
+}
+
+// NewLoginServerWithHandler creates a LoginServer which verifies the
+// password against the one retrieved through cbh with a NameCallback and
+// a PasswordCallback, and checks the user with an AuthorizeCallback.
+func NewLoginServerWithHandler(props map[string]interface{}, cbh CallbackHandler) (*LoginServer, error) {
+	if cbh == nil {
+		return nil, errors.New("LOGIN: callback handler to get password required")
+	}
+	return newLoginServer(props, nil, cbh)
+}
+
+func newLoginServer(props map[string]interface{}, verifier PlainVerifier, cbh CallbackHandler) (*LoginServer, error) {
+	if err := CheckMechanismPolicy("LOGIN", props); err != nil {
+		return nil, err
+	}
+	server := &LoginServer{
+		verifier:       verifier,
+		cbh:            cbh,
+		usernamePrompt: PropertyValue(props, LOGIN_USERNAME_PROMPT_PROPERTY),
+		passwordPrompt: PropertyValue(props, LOGIN_PASSWORD_PROMPT_PROPERTY),
+	}
+	if len(server.usernamePrompt) <= 0 {
+		server.usernamePrompt = DEFAULT_LOGIN_USERNAME_PROMPT
+	}
+	if len(server.passwordPrompt) <= 0 {
+		server.passwordPrompt = DEFAULT_LOGIN_PASSWORD_PROMPT
+	}
+	return server, nil
+}
+
+// GetMechanismName returns the mechanism name "LOGIN".
+func (s *LoginServer) GetMechanismName() string {
+	return "LOGIN"
+}
+
+// EvaluateResponse prompts for the user name and the password, and
+// verifies them once both have been received. A response which is
+// malformed or fails verification ends the exchange.
+func (s *LoginServer) EvaluateResponse(response []byte) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("LOGIN authentication already completed")
+	} else if s.failed {
+		return nil, errors.New("LOGIN authentication already failed")
+	}
+	if len(response) > PLAIN_MAX_FIELD_LENGTH {
+		return nil, s.fail(errors.New("LOGIN: response exceeds 255 octets"))
+	}
+
+	if len(s.username) <= 0 {
+		if len(response) == 0 {
+			if s.challenged {
+				return nil, s.fail(errors.New("LOGIN: user name must not be empty"))
+			}
+			s.challenged = true
+			return []byte(s.usernamePrompt), nil
+		}
+		if !utf8.Valid(response) {
+			return nil, s.fail(errors.New("LOGIN: user name is not valid UTF-8"))
+		}
+		s.username = string(response)
+		return []byte(s.passwordPrompt), nil
+	}
+
+	pw := response
+	defer ClearBytes(pw)
+	if len(pw) == 0 {
+		return nil, s.fail(errors.New("LOGIN: password must not be empty"))
+	}
+	authorizationID, err := s.verify(pw)
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	s.authorizationID = authorizationID
+	s.completed = true
+	return nil, nil
+}
+
+// fail aborts the exchange, so that the server cannot be used any further.
+func (s *LoginServer) fail(err error) error {
+	s.failed = true
+	return err
+}
+
+// verify checks the credentials of the client and returns the authorized
+// ID.
+func (s *LoginServer) verify(pw []byte) (string, error) {
+	if s.verifier != nil {
+		return s.username, s.verifier(s.username, s.username, pw)
+	}
+	if err := verifyPassword("LOGIN", s.username, pw, s.cbh); err != nil {
+		return "", err
+	}
+	authorizedID, err := Authorize(s.cbh, s.username, "")
+	if err != nil {
+		return "", errors.New("LOGIN: " + err.Error())
+	}
+	return authorizedID, nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+func (s *LoginServer) IsComplete() bool {
+	return s.completed
+}
+
+// GetAuthorizationID returns the authorization ID of the client, which is
+// its user name.
+func (s *LoginServer) GetAuthorizationID() (string, error) {
+	if !s.completed {
+		return "", errors.New("LOGIN authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// Unwrap the incoming buffer.
+func (s *LoginServer) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("LOGIN supports neither integrity nor privacy")
+	}
+	return nil, errors.New("LOGIN authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (s *LoginServer) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("LOGIN supports neither integrity nor privacy")
+	}
+	return nil, errors.New("LOGIN authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (s *LoginServer) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.completed {
+		return nil, errors.New("LOGIN authentication not completed")
+	}
+
+	if propName == SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (s *LoginServer) Dispose() error {
+	s.verifier = nil
+	s.cbh = nil
+	return nil
+}
@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"errors"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+var (
+	// MECHANISMS are the mechanisms of this package, in order of
+	// preference.
+	MECHANISMS = []string{OAUTHBEARER, XOAUTH2}
+)
+
+func init() {
+	sasl.RegisterMechanismPolicy(OAUTHBEARER, sasl.POLICY_NOANONYMOUS)
+	sasl.RegisterMechanismPolicy(XOAUTH2, sasl.POLICY_NOANONYMOUS)
+	sasl.RegisterClientFactory(&clientFactory{})
+	sasl.RegisterServerFactory(&serverFactory{})
+}
+
+// clientFactory creates OAuth clients.
+type clientFactory struct{}
+
+// GetMechanismNames returns the OAuth mechanisms allowed by props.
+func (f *clientFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms(MECHANISMS, props)
+}
+
+// CreateClient creates a client for the first requested OAuth mechanism.
+// The token is retrieved through cbh with a TokenCallback.
+func (f *clientFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Client, error) {
+	allowed := f.GetMechanismNames(props)
+	for _, mechanism := range mechanisms {
+		if !containsMechanism(allowed, mechanism) {
+			continue
+		}
+		switch mechanism {
+		case OAUTHBEARER:
+			return NewOAuthBearerClient(authorizationID, serverName, props, cbh)
+		case XOAUTH2:
+			return NewXOAuth2Client(authorizationID, props, cbh)
+		}
+	}
+	return nil, nil
+}
+
+// serverFactory creates OAuth servers.
+type serverFactory struct{}
+
+// GetMechanismNames returns the OAuth mechanisms allowed by props.
+func (f *serverFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms(MECHANISMS, props)
+}
+
+// CreateServer creates a server for mechanism. Tokens are validated
+// through cbh with a TokenValidatorCallback, and the authorization ID is
+// checked with an AuthorizeCallback.
+func (f *serverFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Server, error) {
+	if !containsMechanism(f.GetMechanismNames(props), mechanism) {
+		return nil, nil
+	}
+	if cbh == nil {
+		return nil, errors.New(mechanism + ": callback handler to validate tokens required")
+	}
+	validator := func(mechanism string, message *BearerMessage) (string, error) {
+		vcb := NewTokenValidatorCallback(mechanism, message)
+		if err := cbh.Handle([]sasl.Callback{vcb}); err != nil {
+			return "", err
+		}
+		if vcb.GetError() != nil {
+			return "", vcb.GetError()
+		} else if len(vcb.GetIdentity()) <= 0 {
+			return "", errors.New(mechanism + ": token not accepted")
+		}
+		return vcb.GetIdentity(), nil
+	}
+	switch mechanism {
+	case OAUTHBEARER:
+		return NewOAuthBearerServer(props, validator, cbh)
+	case XOAUTH2:
+		return NewXOAuth2Server(props, validator, cbh)
+	}
+	return nil, nil
+}
+
+func containsMechanism(names []string, mechanism string) bool {
+	for _, name := range names {
+		if name == mechanism {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package oauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+const (
+	OAUTHBEARER = "OAUTHBEARER"
+
+	// KVSEP separates the key/value pairs of OAUTHBEARER messages.
+	KVSEP = byte(0x01)
+
+	AUTH_KEY = "auth"
+	HOST_KEY = "host"
+	PORT_KEY = "port"
+
+	BEARER_SCHEME = "Bearer"
+
+	// STATUS_INVALID_TOKEN is the status reported when a token is rejected
+	// without a more specific status.
+	STATUS_INVALID_TOKEN = "invalid_token"
+)
+
+const (
+	// PORT_PROPERTY is a property that specifies the port of the server,
+	// sent by OAUTHBEARER clients along with the host name. The property
+	// contains the string representation of an integer.
+	PORT_PROPERTY = "golang.security.sasl.oauth.port"
+
+	// EXTENSIONS_PROPERTY is a property that specifies additional
+	// key/value pairs sent by OAUTHBEARER clients. The property contains
+	// a map[string]string.
+	EXTENSIONS_PROPERTY = "golang.security.sasl.oauth.extensions"
+)
+
+// BearerMessage is the client response of OAUTHBEARER (RFC 7628 section
+// 3.1):
+//
+//	client-resp = (gs2-header kvsep *kvpair kvsep) / kvsep
+//	kvpair      = key "=" value kvsep
+type BearerMessage struct {
+	AuthorizationID string
+	Token           string
+	Host            string
+	Port            int
+	Extensions      map[string]string
+}
+
+// Bytes encodes the message. Extensions are sent in the order of their
+// keys.
+func (m *BearerMessage) Bytes() []byte {
+	message := &bytes.Buffer{}
+	message.Write(sasl.NewGS2Header(m.AuthorizationID).Bytes())
+	message.WriteByte(KVSEP)
+	writePair := func(key, value string) {
+		message.WriteString(key + "=" + value)
+		message.WriteByte(KVSEP)
+	}
+	writePair(AUTH_KEY, BEARER_SCHEME+" "+m.Token)
+	if len(m.Host) > 0 {
+		writePair(HOST_KEY, m.Host)
+	}
+	if m.Port > 0 {
+		writePair(PORT_KEY, strconv.Itoa(m.Port))
+	}
+	keys := make([]string, 0, len(m.Extensions))
+	for key := range m.Extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writePair(key, m.Extensions[key])
+	}
+	message.WriteByte(KVSEP)
+	return message.Bytes()
+}
+
+// ParseBearerMessage parses an OAUTHBEARER client response.
+func ParseBearerMessage(message []byte) (*BearerMessage, error) {
+	header, rest, err := sasl.ParseGS2Header(message)
+	if err != nil {
+		return nil, err
+	}
+	if header.NonStandard || header.ChannelBinding == sasl.GS2_CBIND_USED {
+		return nil, errors.New("OAUTHBEARER: channel binding not supported")
+	}
+	// The pairs lie between the leading KVSEP and the final two.
+	if len(rest) < 3 || rest[0] != KVSEP || !bytes.HasSuffix(rest, []byte{KVSEP, KVSEP}) {
+		return nil, errors.New("OAUTHBEARER: invalid message format")
+	}
+
+	m := &BearerMessage{AuthorizationID: header.AuthorizationID, Extensions: map[string]string{}}
+	pairs := bytes.Split(rest[1:len(rest)-2], []byte{KVSEP})
+	for _, pair := range pairs {
+		key, value, err := parsePair(string(pair))
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case AUTH_KEY:
+			if m.Token, err = parseBearerToken(value); err != nil {
+				return nil, err
+			}
+		case HOST_KEY:
+			m.Host = value
+		case PORT_KEY:
+			if m.Port, err = strconv.Atoi(value); err != nil || m.Port <= 0 || m.Port > 65535 {
+				return nil, errors.New("OAUTHBEARER: invalid port " + value)
+			}
+		default:
+			if _, ok := m.Extensions[key]; ok {
+				return nil, errors.New("OAUTHBEARER: duplicate key " + key)
+			}
+			m.Extensions[key] = value
+		}
+	}
+	if len(m.Token) <= 0 {
+		return nil, errors.New("OAUTHBEARER: no bearer token")
+	}
+	return m, nil
+}
+
+// XOAuth2Bytes encodes the user name, held as authorization ID, and the
+// token of the message as an XOAUTH2 client response. The user name must
+// be valid UTF-8 and must not contain KVSEP.
+func (m *BearerMessage) XOAuth2Bytes() ([]byte, error) {
+	if len(m.AuthorizationID) <= 0 || strings.IndexByte(m.AuthorizationID, KVSEP) >= 0 || !utf8.ValidString(m.AuthorizationID) {
+		return nil, fmt.Errorf("XOAUTH2: invalid user name %q", m.AuthorizationID)
+	}
+	message := &bytes.Buffer{}
+	message.WriteString(XOAUTH2_USER_KEY + "=" + m.AuthorizationID)
+	message.WriteByte(KVSEP)
+	message.WriteString(AUTH_KEY + "=" + BEARER_SCHEME + " " + m.Token)
+	message.WriteByte(KVSEP)
+	message.WriteByte(KVSEP)
+	return message.Bytes(), nil
+}
+
+// ParseXOAuth2Message parses an XOAUTH2 client response. The user name is
+// returned as the authorization ID of the message.
+func ParseXOAuth2Message(message []byte) (*BearerMessage, error) {
+	if !bytes.HasSuffix(message, []byte{KVSEP, KVSEP}) || !utf8.Valid(message) {
+		return nil, errors.New("XOAUTH2: invalid message format")
+	}
+	pairs := bytes.Split(message[:len(message)-2], []byte{KVSEP})
+	if len(pairs) != 2 {
+		return nil, errors.New("XOAUTH2: invalid message format")
+	}
+	user, ok := strings.CutPrefix(string(pairs[0]), XOAUTH2_USER_KEY+"=")
+	if !ok || len(user) <= 0 {
+		return nil, errors.New("XOAUTH2: user name expected")
+	}
+	auth, ok := strings.CutPrefix(string(pairs[1]), AUTH_KEY+"=")
+	if !ok {
+		return nil, errors.New("XOAUTH2: auth expected")
+	}
+	token, err := parseBearerToken(auth)
+	if err != nil {
+		return nil, errors.New("XOAUTH2: invalid auth value")
+	}
+	return &BearerMessage{AuthorizationID: user, Token: token}, nil
+}
+
+// parsePair splits a key/value pair:
+//
+//	key   = 1*(ALPHA)
+//	value = *(VCHAR / SP / HTAB / CR / LF)
+func parsePair(pair string) (string, string, error) {
+	index := strings.IndexByte(pair, '=')
+	if index <= 0 {
+		return "", "", fmt.Errorf("OAUTHBEARER: invalid key/value pair %q", pair)
+	}
+	key, value := pair[:index], pair[index+1:]
+	if err := checkPair(key, value); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// checkPair checks the syntax of a key/value pair.
+func checkPair(key, value string) error {
+	if len(key) <= 0 {
+		return errors.New("OAUTHBEARER: empty key")
+	}
+	for i := 0; i < len(key); i++ {
+		if !(key[i] >= 'a' && key[i] <= 'z' || key[i] >= 'A' && key[i] <= 'Z') {
+			return fmt.Errorf("OAUTHBEARER: invalid key %q", key)
+		}
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= 0x21 && c <= 0x7E || c == ' ' || c == '\t' || c == '\r' || c == '\n') {
+			return fmt.Errorf("OAUTHBEARER: invalid value for key %s", key)
+		}
+	}
+	return nil
+}
+
+// parseBearerToken extracts the token of an "auth" value:
+//
+//	auth-value = "Bearer" 1*SP b64token
+//	b64token   = 1*(ALPHA / DIGIT / "-" / "." / "_" / "~" / "+" / "/") *"="
+func parseBearerToken(value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], BEARER_SCHEME) || !isBearerToken(fields[1]) {
+		return "", errors.New("OAUTHBEARER: invalid auth value")
+	}
+	return fields[1], nil
+}
+
+func isBearerToken(token string) bool {
+	body := strings.TrimRight(token, "=")
+	if len(body) <= 0 {
+		return false
+	}
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("-._~+/", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ErrorResponse is the JSON error a server sends when it rejects a token
+// (RFC 7628 section 3.2.2). It is also returned by token validators to
+// choose the error sent to the client.
+type ErrorResponse struct {
+	Status              string `json:"status"`
+	Scope               string `json:"scope,omitempty"`
+	OpenIDConfiguration string `json:"openid-configuration,omitempty"`
+	// Schemes lists the accepted authentication schemes; it is only sent
+	// by XOAUTH2 servers.
+	Schemes string `json:"schemes,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	if len(e.Scope) > 0 {
+		return fmt.Sprintf("authentication failed: %s (scope %s)", e.Status, e.Scope)
+	}
+	return "authentication failed: " + e.Status
+}
+
+// ParseErrorResponse decodes the JSON error sent by a server.
+func ParseErrorResponse(data []byte) (*ErrorResponse, error) {
+	response := &ErrorResponse{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, fmt.Errorf("invalid error response: %s", err)
+	}
+	if len(response.Status) <= 0 {
+		return nil, errors.New("invalid error response: status missing")
+	}
+	return response, nil
+}
+
+// Bytes encodes the error response as JSON.
+func (e *ErrorResponse) Bytes() []byte {
+	data, _ := json.Marshal(e)
+	return data
+}
+
+// TokenCallback retrieves the bearer token sent by a client.
+type TokenCallback struct {
+	prompt string
+	token  string
+}
+
+// NewTokenCallback creates a TokenCallback with a prompt.
+func NewTokenCallback(prompt string) *TokenCallback {
+	return &TokenCallback{prompt: prompt}
+}
+
+// GetPrompt returns the prompt.
+func (c *TokenCallback) GetPrompt() string {
+	return c.prompt
+}
+
+// SetToken sets the retrieved token.
+func (c *TokenCallback) SetToken(token string) {
+	c.token = token
+}
+
+// GetToken returns the retrieved token.
+func (c *TokenCallback) GetToken() string {
+	return c.token
+}
+
+// TokenValidator validates the token of a client response and returns
+// the identity the token was issued to. The token is rejected if an
+// error is returned; an *ErrorResponse is sent to the client as is,
+// other errors with the default status of the mechanism.
+type TokenValidator func(mechanism string, message *BearerMessage) (string, error)
+
+// TokenValidatorCallback validates the token of a client response through
+// a sasl.CallbackHandler. It is used by the servers created from the
+// registered ServerFactory. The handler either sets the identity the
+// token was issued to, or an error response.
+type TokenValidatorCallback struct {
+	mechanism string
+	message   *BearerMessage
+	identity  string
+	err       *ErrorResponse
+}
+
+// NewTokenValidatorCallback creates a TokenValidatorCallback for message.
+func NewTokenValidatorCallback(mechanism string, message *BearerMessage) *TokenValidatorCallback {
+	return &TokenValidatorCallback{mechanism: mechanism, message: message}
+}
+
+// GetMechanism returns the mechanism the token was sent with.
+func (c *TokenValidatorCallback) GetMechanism() string {
+	return c.mechanism
+}
+
+// GetMessage returns the client response carrying the token.
+func (c *TokenValidatorCallback) GetMessage() *BearerMessage {
+	return c.message
+}
+
+// SetIdentity accepts the token as issued to identity.
+func (c *TokenValidatorCallback) SetIdentity(identity string) {
+	c.identity = identity
+}
+
+// GetIdentity returns the identity the token was issued to, or an empty
+// string if the token was not accepted.
+func (c *TokenValidatorCallback) GetIdentity() string {
+	return c.identity
+}
+
+// SetError rejects the token with err.
+func (c *TokenValidatorCallback) SetError(err *ErrorResponse) {
+	c.err = err
+}
+
+// GetError returns the error the token was rejected with, if any.
+func (c *TokenValidatorCallback) GetError() *ErrorResponse {
+	return c.err
+}
+
+// getToken retrieves the bearer token through cbh.
+func getToken(mechanism string, cbh sasl.CallbackHandler) (string, error) {
+	tcb := NewTokenCallback(mechanism + " token: ")
+	if err := cbh.Handle([]sasl.Callback{tcb}); err != nil {
+		return "", err
+	}
+	if len(tcb.GetToken()) <= 0 {
+		return "", errors.New(mechanism + ": token not supplied")
+	}
+	return tcb.GetToken(), nil
+}
@@ -0,0 +1,410 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// RFC 7628 section 4 examples.
+const (
+	// Section 4.1: the client response of an IMAP authentication.
+	rfcIMAPResponse = "bixhPXVzZXJAZXhhbXBsZS5jb20sAWhvc3Q9c2VydmVyLmV4YW1wbGUuY29tAXBvcnQ9MTQzAWF1dGg9QmVhcmVyIHZGOWRmdDRxbVRjMk52YjNSbGNrQmhiSFJoZG1semRHRXVZMjl0Q2c9PQEB"
+	// Section 4.2: the client response of an SMTP authentication.
+	rfcSMTPResponse = "bixhPXVzZXJAZXhhbXBsZS5jb20sAWhvc3Q9c2VydmVyLmV4YW1wbGUuY29tAXBvcnQ9NTg3AWF1dGg9QmVhcmVyIHZGOWRmdDRxbVRjMk52YjNSbGNrQmhiSFJoZG1semRHRXVZMjl0Q2c9PQEB"
+	// Section 4.3: the error sent for a rejected token.
+	rfcErrorChallenge = "eyJzdGF0dXMiOiJpbnZhbGlkX3Rva2VuIiwic2NvcGUiOiJleGFtcGxlX3Njb3BlIiwib3BlbmlkLWNvbmZpZ3VyYXRpb24iOiJodHRwczovL2V4YW1wbGUuY29tLy53ZWxsLWtub3duL29wZW5pZC1jb25maWd1cmF0aW9uIn0="
+	// The token of the examples.
+	rfcToken = "vF9dft4qmTc2Nvb3RlckBhbHRhdmlzdGEuY29tCg=="
+)
+
+// rfcErrorResponse is the error of RFC 7628 section 4.3.
+var rfcErrorResponse = &ErrorResponse{
+	Status:              "invalid_token",
+	Scope:               "example_scope",
+	OpenIDConfiguration: "https://example.com/.well-known/openid-configuration",
+}
+
+func decodeBase64(t *testing.T, data string) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// tokenHandler answers TokenCallbacks with token.
+func tokenHandler(token string) sasl.CallbackHandler {
+	return sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		for _, callback := range callbacks {
+			cb, ok := callback.(*TokenCallback)
+			if !ok {
+				return &sasl.UnsupportedCallbackError{Callback: callback}
+			}
+			cb.SetToken(token)
+		}
+		return nil
+	})
+}
+
+// tokenValidator accepts rfcToken as issued to "user@example.com", and
+// rejects any other token with rfcErrorResponse.
+func tokenValidator(mechanism string, message *BearerMessage) (string, error) {
+	if message.Token != rfcToken {
+		return "", rfcErrorResponse
+	}
+	return "user@example.com", nil
+}
+
+func TestParseBearerMessageRFCVectors(t *testing.T) {
+	tests := []struct {
+		response string
+		port     int
+	}{
+		{rfcIMAPResponse, 143},
+		{rfcSMTPResponse, 587},
+	}
+	for _, test := range tests {
+		message, err := ParseBearerMessage(decodeBase64(t, test.response))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &BearerMessage{
+			AuthorizationID: "user@example.com",
+			Token:           rfcToken,
+			Host:            "server.example.com",
+			Port:            test.port,
+			Extensions:      map[string]string{},
+		}
+		if !reflect.DeepEqual(message, want) {
+			t.Errorf("message %+v, want %+v", message, want)
+		}
+	}
+}
+
+func TestBearerMessageBytes(t *testing.T) {
+	message := &BearerMessage{
+		AuthorizationID: "user,name=x@example.com",
+		Token:           rfcToken,
+		Host:            "server.example.com",
+		Port:            143,
+		Extensions:      map[string]string{"traceid": "abc 123", "client": "mail"},
+	}
+	encoded := message.Bytes()
+	want := "n,a=user=2Cname=3Dx@example.com,\x01auth=Bearer " + rfcToken +
+		"\x01host=server.example.com\x01port=143\x01client=mail\x01traceid=abc 123\x01\x01"
+	if string(encoded) != want {
+		t.Errorf("message %q, want %q", encoded, want)
+	}
+	parsed, err := ParseBearerMessage(encoded)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(parsed, message) {
+		t.Errorf("parsed %+v, want %+v", parsed, message)
+	}
+
+	// Without authzid, host and port.
+	message = &BearerMessage{Token: rfcToken}
+	if encoded := string(message.Bytes()); encoded != "n,,\x01auth=Bearer "+rfcToken+"\x01\x01" {
+		t.Errorf("message %q", encoded)
+	}
+}
+
+func TestParseBearerMessageMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		err     string
+	}{
+		{"no pairs", "n,,\x01\x01", "invalid message format"},
+		{"single KVSEP", "n,,\x01", "invalid message format"},
+		{"header only", "n,,", "invalid message format"},
+		{"empty pair", "n,,\x01\x01\x01", "invalid key/value pair"},
+		{"no leading KVSEP", "n,,auth=Bearer abc\x01\x01", "invalid message format"},
+		{"no final KVSEP", "n,,\x01auth=Bearer abc\x01", "invalid message format"},
+		{"invalid GS2 header", "n,a=user\x01auth=Bearer abc\x01\x01", ""},
+		{"channel binding", "p=tls-unique,,\x01auth=Bearer abc\x01\x01", "channel binding not supported"},
+		{"no token", "n,,\x01host=server.example.com\x01\x01", "no bearer token"},
+		{"no scheme", "n,,\x01auth=abc\x01\x01", "invalid auth value"},
+		{"other scheme", "n,,\x01auth=Basic abc\x01\x01", "invalid auth value"},
+		{"empty token", "n,,\x01auth=Bearer \x01\x01", "invalid auth value"},
+		{"invalid token", "n,,\x01auth=Bearer a*b\x01\x01", "invalid auth value"},
+		{"two tokens", "n,,\x01auth=Bearer abc def\x01\x01", "invalid auth value"},
+		{"zero port", "n,,\x01auth=Bearer abc\x01port=0\x01\x01", "invalid port"},
+		{"port out of range", "n,,\x01auth=Bearer abc\x01port=65536\x01\x01", "invalid port"},
+		{"non-numeric port", "n,,\x01auth=Bearer abc\x01port=imap\x01\x01", "invalid port"},
+		{"duplicate extension", "n,,\x01auth=Bearer abc\x01trace=1\x01trace=2\x01\x01", "duplicate key trace"},
+		{"invalid key", "n,,\x01auth=Bearer abc\x01trace1=1\x01\x01", "invalid key"},
+		{"empty key", "n,,\x01auth=Bearer abc\x01=1\x01\x01", "invalid key/value pair"},
+		{"no value", "n,,\x01auth=Bearer abc\x01trace\x01\x01", "invalid key/value pair"},
+		{"invalid value", "n,,\x01auth=Bearer abc\x01trace=\x00\x01\x01", "invalid value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if message, err := ParseBearerMessage([]byte(test.message)); err == nil {
+				t.Errorf("parsed %+v", message)
+			} else if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestOAuthBearerClientProperties(t *testing.T) {
+	tests := []struct {
+		name  string
+		props map[string]interface{}
+		err   string
+	}{
+		{"port", map[string]interface{}{PORT_PROPERTY: "143"}, ""},
+		{"zero port", map[string]interface{}{PORT_PROPERTY: "0"}, PORT_PROPERTY},
+		{"port out of range", map[string]interface{}{PORT_PROPERTY: "65536"}, PORT_PROPERTY},
+		{"non-numeric port", map[string]interface{}{PORT_PROPERTY: "imap"}, PORT_PROPERTY},
+		{"extensions", map[string]interface{}{EXTENSIONS_PROPERTY: map[string]string{"trace": "abc 123"}}, ""},
+		{"reserved extension", map[string]interface{}{EXTENSIONS_PROPERTY: map[string]string{HOST_KEY: "other"}}, "reserved extension key host"},
+		{"invalid extension key", map[string]interface{}{EXTENSIONS_PROPERTY: map[string]string{"trace-id": "1"}}, "invalid key"},
+		{"invalid extension value", map[string]interface{}{EXTENSIONS_PROPERTY: map[string]string{"trace": "\x01"}}, "invalid value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewOAuthBearerClient("", "server.example.com", test.props, tokenHandler(rfcToken))
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error %v, want %q", err, test.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.EvaluateChallenge(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message, err := ParseBearerMessage(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if port := sasl.PropertyValue(test.props, PORT_PROPERTY); len(port) > 0 && message.Port != 143 {
+				t.Errorf("port %d", message.Port)
+			}
+			if extensions, ok := test.props[EXTENSIONS_PROPERTY].(map[string]string); ok && !reflect.DeepEqual(message.Extensions, extensions) {
+				t.Errorf("extensions %v, want %v", message.Extensions, extensions)
+			}
+		})
+	}
+}
+
+func TestOAuthBearerExchange(t *testing.T) {
+	props := map[string]interface{}{
+		PORT_PROPERTY:       "143",
+		EXTENSIONS_PROPERTY: map[string]string{"trace": "abc"},
+	}
+	client, err := NewOAuthBearerClient("", "server.example.com", props, tokenHandler(rfcToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewOAuthBearerServer(nil, tokenValidator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an initial response, the server sends an empty challenge.
+	if challenge, err := server.EvaluateResponse(nil); err != nil || challenge == nil || len(challenge) != 0 {
+		t.Fatalf("challenge %q, %v", challenge, err)
+	}
+	response, err := client.EvaluateChallenge(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge, err := server.EvaluateResponse(response); err != nil || challenge != nil {
+		t.Fatalf("challenge %q, %v", challenge, err)
+	}
+	if response, err := client.EvaluateChallenge(nil); err != nil || response != nil {
+		t.Fatalf("response %q, %v", response, err)
+	}
+	if !client.IsComplete() || !server.IsComplete() {
+		t.Fatal("exchange not complete")
+	}
+	if client.GetErrorResponse() != nil {
+		t.Errorf("error response %+v", client.GetErrorResponse())
+	}
+	if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "user@example.com" {
+		t.Errorf("authorization ID %q, %v", authorizationID, err)
+	}
+	if extensions, err := server.GetExtensions(); err != nil || extensions["trace"] != "abc" {
+		t.Errorf("extensions %v, %v", extensions, err)
+	}
+}
+
+// TestOAuthBearerRejectedToken follows RFC 7628 section 4.3: the server
+// sends a JSON error, the client acknowledges it with %x01, and the
+// server fails the exchange.
+func TestOAuthBearerRejectedToken(t *testing.T) {
+	client, err := NewOAuthBearerClient("", "server.example.com", nil, tokenHandler("expired"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewOAuthBearerServer(nil, tokenValidator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.EvaluateChallenge(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := server.EvaluateResponse(response)
+	if err != nil {
+		t.Fatal(err)
+	} else if want := decodeBase64(t, rfcErrorChallenge); string(challenge) != string(want) {
+		t.Errorf("challenge %s, want %s", challenge, want)
+	}
+	response, err = client.EvaluateChallenge(challenge)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(response) != "\x01" {
+		t.Errorf("response %q, want %%x01", response)
+	}
+	if !reflect.DeepEqual(client.GetErrorResponse(), rfcErrorResponse) {
+		t.Errorf("error response %+v", client.GetErrorResponse())
+	}
+	if _, err := server.EvaluateResponse(response); err == nil || !strings.Contains(err.Error(), "invalid_token (scope example_scope)") {
+		t.Errorf("error %v", err)
+	} else if server.IsComplete() {
+		t.Error("server completed with a rejected token")
+	}
+	if _, err := client.EvaluateChallenge(nil); err == nil || client.IsComplete() {
+		t.Errorf("client completed with a rejected token: %v", err)
+	}
+
+	// The error must be acknowledged with %x01 alone.
+	server, err = NewOAuthBearerServer(nil, tokenValidator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.EvaluateResponse(sasl.NewGS2Header("").Bytes()); err == nil {
+		t.Fatal("accepted a response without token")
+	}
+	message := &BearerMessage{Token: "expired"}
+	if _, err := server.EvaluateResponse(message.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.EvaluateResponse(message.Bytes()); err == nil || !strings.Contains(err.Error(), "invalid response to the error challenge") {
+		t.Errorf("error %v", err)
+	}
+}
+
+// TestOAuthBearerServerErrors checks the errors of validators which are
+// not an ErrorResponse, and the authorization of the identity.
+func TestOAuthBearerServerErrors(t *testing.T) {
+	failing := func(mechanism string, message *BearerMessage) (string, error) {
+		return "", errors.New("token store unavailable")
+	}
+	server, err := NewOAuthBearerServer(nil, failing, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &BearerMessage{Token: rfcToken}
+	if challenge, err := server.EvaluateResponse(message.Bytes()); err != nil || string(challenge) != `{"status":"invalid_token"}` {
+		t.Errorf("challenge %s, %v", challenge, err)
+	}
+
+	// The client aborts the exchange with %x01.
+	server, err = NewOAuthBearerServer(nil, tokenValidator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.EvaluateResponse([]byte{KVSEP}); err == nil || !strings.Contains(err.Error(), "aborted") {
+		t.Errorf("error %v", err)
+	}
+
+	// Without callback handler the identity may only act as itself.
+	for _, authorizationID := range []string{"user@example.com", "admin@example.com"} {
+		server, err := NewOAuthBearerServer(nil, tokenValidator, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message := &BearerMessage{AuthorizationID: authorizationID, Token: rfcToken}
+		_, err = server.EvaluateResponse(message.Bytes())
+		if authorized := err == nil; authorized != (authorizationID == "user@example.com") {
+			t.Errorf("%s: %v", authorizationID, err)
+		}
+	}
+}
+
+// TestOAuthBearerValidatorCallback checks the servers of the registered
+// factory, which validate tokens with a TokenValidatorCallback.
+func TestOAuthBearerValidatorCallback(t *testing.T) {
+	cbh := sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		for _, callback := range callbacks {
+			switch cb := callback.(type) {
+			case *TokenValidatorCallback:
+				if cb.GetMechanism() != OAUTHBEARER {
+					return errors.New("unexpected mechanism " + cb.GetMechanism())
+				}
+				switch cb.GetMessage().Token {
+				case rfcToken:
+					cb.SetIdentity("user@example.com")
+				case "expired":
+					cb.SetError(rfcErrorResponse)
+				}
+			case *sasl.AuthorizeCallback:
+				cb.SetAuthorized(cb.GetAuthorizationID() == "user@example.com" || cb.GetAuthorizationID() == "shared@example.com")
+			default:
+				return &sasl.UnsupportedCallbackError{Callback: callback}
+			}
+		}
+		return nil
+	})
+	tests := []struct {
+		name            string
+		authorizationID string
+		token           string
+		challenge       string
+		err             string
+	}{
+		{"accepted", "", rfcToken, "", ""},
+		{"authorized", "shared@example.com", rfcToken, "", ""},
+		{"not authorized", "admin@example.com", rfcToken, "", "not authorized"},
+		{"rejected", "", "expired", string(rfcErrorResponse.Bytes()), ""},
+		{"unknown", "", "unknown", `{"status":"invalid_token"}`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := sasl.CreateServer(OAUTHBEARER, "imap", "server.example.com", nil, cbh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message := &BearerMessage{AuthorizationID: test.authorizationID, Token: test.token}
+			challenge, err := server.EvaluateResponse(message.Bytes())
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error %v, want %q", err, test.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if string(challenge) != test.challenge {
+				t.Fatalf("challenge %s, want %s", challenge, test.challenge)
+			}
+			if len(test.challenge) > 0 {
+				return
+			}
+			want := test.authorizationID
+			if len(want) <= 0 {
+				want = "user@example.com"
+			}
+			if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != want {
+				t.Errorf("authorization ID %q, %v, want %q", authorizationID, err, want)
+			}
+		})
+	}
+
+	if _, err := sasl.CreateServer(OAUTHBEARER, "imap", "server.example.com", nil, nil); err == nil {
+		t.Error("server created without callback handler")
+	}
+}
@@ -0,0 +1,170 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// OAuthBearerClient is an implementation of the OAUTHBEARER SASL
+// client-side mechanism (RFC 7628).
+//
+// The initial response carries the GS2 header with the authorization ID,
+// the bearer token retrieved through the callback handler with a
+// TokenCallback, the host and port of the server and any extension. The
+// server either accepts the token, completing the exchange, or sends a
+// JSON error, which the client answers with a single KVSEP as required
+// before the server reports the failure. The error is available through
+// GetErrorResponse.
+//
+// The following properties are used:
+//
+//	PORT_PROPERTY       - the port of the server
+//	EXTENSIONS_PROPERTY - extensions sent with the token
+type OAuthBearerClient struct {
+	cbh           sasl.CallbackHandler
+	message       *BearerMessage
+	step          int
+	completed     bool
+	errorResponse *ErrorResponse
+}
+
+// NewOAuthBearerClient creates a client authenticating to host, whose
+// token is retrieved through cbh.
+func NewOAuthBearerClient(authorizationID, host string, props map[string]interface{}, cbh sasl.CallbackHandler) (*OAuthBearerClient, error) {
+	if cbh == nil {
+		return nil, errors.New("OAUTHBEARER: callback handler to get token required")
+	}
+	if err := sasl.CheckMechanismPolicy(OAUTHBEARER, props); err != nil {
+		return nil, err
+	}
+	message := &BearerMessage{
+		AuthorizationID: authorizationID,
+		Host:            host,
+		Extensions:      map[string]string{},
+	}
+	if port := sasl.PropertyValue(props, PORT_PROPERTY); len(port) > 0 {
+		var err error
+		message.Port, err = strconv.Atoi(port)
+		if err != nil || message.Port <= 0 || message.Port > 65535 {
+			return nil, fmt.Errorf("Property must be string representation of a port number: %s", PORT_PROPERTY)
+		}
+	}
+	if extensions, ok := props[EXTENSIONS_PROPERTY].(map[string]string); ok {
+		for key, value := range extensions {
+			if key == AUTH_KEY || key == HOST_KEY || key == PORT_KEY {
+				return nil, errors.New("OAUTHBEARER: reserved extension key " + key)
+			}
+			if err := checkPair(key, value); err != nil {
+				return nil, err
+			}
+			message.Extensions[key] = value
+		}
+	}
+	client := &OAuthBearerClient{
+		cbh:     cbh,
+		message: message,
+		step:    1,
+	}
+	return client, nil
+}
+
+// GetMechanismName returns the mechanism name "OAUTHBEARER".
+func (c *OAuthBearerClient) GetMechanismName() string {
+	return OAUTHBEARER
+}
+
+// HasInitialResponse returns true: the token is sent as initial response.
+func (c *OAuthBearerClient) HasInitialResponse() bool {
+	return true
+}
+
+// EvaluateChallenge processes the messages sent by the server.
+//
+// Step 1 returns the client response. Step 2 completes the exchange if
+// the server sent no challenge, or answers its error with KVSEP. Any
+// later challenge is an error.
+func (c *OAuthBearerClient) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	switch c.step {
+	case 1:
+		token, err := getToken(OAUTHBEARER, c.cbh)
+		if err != nil {
+			c.step = 0
+			return nil, err
+		}
+		c.message.Token = token
+		response := c.message.Bytes()
+		c.message.Token = ""
+		c.step = 2
+		return response, nil
+	case 2:
+		if len(challengeData) <= 0 {
+			c.completed = true
+			c.step = 0
+			return nil, nil
+		}
+		errorResponse, err := ParseErrorResponse(challengeData)
+		if err != nil {
+			c.step = 0
+			return nil, errors.New("OAUTHBEARER: " + err.Error())
+		}
+		c.errorResponse = errorResponse
+		c.step = 3
+		return []byte{KVSEP}, nil
+	case 3:
+		c.step = 0
+		return nil, errors.New("OAUTHBEARER: " + c.errorResponse.Error())
+	default:
+		return nil, errors.New("OAUTHBEARER: Client at illegal state")
+	}
+}
+
+// GetErrorResponse returns the error sent by the server, or nil if the
+// server did not reject the token.
+func (c *OAuthBearerClient) GetErrorResponse() *ErrorResponse {
+	return c.errorResponse
+}
+
+// IsComplete determines whether the authentication exchange has completed.
+func (c *OAuthBearerClient) IsComplete() bool {
+	return c.completed
+}
+
+// Unwrap the incoming buffer.
+func (c *OAuthBearerClient) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("OAUTHBEARER supports neither integrity nor privacy")
+	}
+	return nil, errors.New("OAUTHBEARER authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (c *OAuthBearerClient) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("OAUTHBEARER supports neither integrity nor privacy")
+	}
+	return nil, errors.New("OAUTHBEARER authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (c *OAuthBearerClient) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !c.completed {
+		return nil, errors.New("OAUTHBEARER authentication not completed")
+	}
+
+	if propName == sasl.SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (c *OAuthBearerClient) Dispose() error {
+	c.cbh = nil
+	return nil
+}
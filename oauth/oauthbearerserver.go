@@ -0,0 +1,154 @@
+package oauth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// OAuthBearerServer is an implementation of the OAUTHBEARER SASL
+// server-side mechanism (RFC 7628).
+//
+// The token of the client response is checked by a TokenValidator. A
+// rejected token is answered with a JSON ErrorResponse; the exchange then
+// fails once the client has acknowledged it with KVSEP. The authorization
+// ID, if any, is checked with an AuthorizeCallback.
+type OAuthBearerServer struct {
+	validator       TokenValidator
+	cbh             sasl.CallbackHandler
+	completed       bool
+	challenged      bool
+	errorResponse   *ErrorResponse
+	message         *BearerMessage
+	authorizationID string
+}
+
+// NewOAuthBearerServer creates a server validating tokens with validator.
+func NewOAuthBearerServer(props map[string]interface{}, validator TokenValidator, cbh sasl.CallbackHandler) (*OAuthBearerServer, error) {
+	if validator == nil {
+		return nil, errors.New("OAUTHBEARER: token validator must be specified")
+	}
+	if err := sasl.CheckMechanismPolicy(OAUTHBEARER, props); err != nil {
+		return nil, err
+	}
+	server := &OAuthBearerServer{
+		validator: validator,
+		cbh:       cbh,
+	}
+	return server, nil
+}
+
+// GetMechanismName returns the mechanism name "OAUTHBEARER".
+func (s *OAuthBearerServer) GetMechanismName() string {
+	return OAUTHBEARER
+}
+
+// EvaluateResponse processes the client response. An empty challenge is
+// returned if the client sent no initial response, and an ErrorResponse
+// if the token is rejected.
+func (s *OAuthBearerServer) EvaluateResponse(response []byte) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("OAUTHBEARER authentication already completed")
+	}
+	if s.errorResponse != nil {
+		if !bytes.Equal(response, []byte{KVSEP}) {
+			return nil, errors.New("OAUTHBEARER: invalid response to the error challenge")
+		}
+		return nil, errors.New("OAUTHBEARER: " + s.errorResponse.Error())
+	}
+	if len(response) == 0 && !s.challenged {
+		s.challenged = true
+		return []byte{}, nil
+	}
+	s.challenged = true
+	if bytes.Equal(response, []byte{KVSEP}) {
+		return nil, errors.New("OAUTHBEARER: authentication aborted by the client")
+	}
+
+	message, err := ParseBearerMessage(response)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := s.validator(OAUTHBEARER, message)
+	if err != nil {
+		errorResponse, ok := err.(*ErrorResponse)
+		if !ok {
+			errorResponse = &ErrorResponse{Status: STATUS_INVALID_TOKEN}
+		}
+		s.errorResponse = errorResponse
+		return errorResponse.Bytes(), nil
+	}
+
+	authorizationID, err := sasl.Authorize(s.cbh, identity, message.AuthorizationID)
+	if err != nil {
+		return nil, fmt.Errorf("OAUTHBEARER: %s", err)
+	}
+	message.Token = ""
+	s.message = message
+	s.authorizationID = authorizationID
+	s.completed = true
+	return nil, nil
+}
+
+// IsComplete determines whether the authentication exchange has completed.
+func (s *OAuthBearerServer) IsComplete() bool {
+	return s.completed
+}
+
+// GetAuthorizationID reports the authorization ID of the client, which is
+// the identity the token was issued to when the client did not supply an
+// authzid.
+func (s *OAuthBearerServer) GetAuthorizationID() (string, error) {
+	if !s.completed {
+		return "", errors.New("OAUTHBEARER authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// GetExtensions returns the extensions sent by the client.
+func (s *OAuthBearerServer) GetExtensions() (map[string]string, error) {
+	if !s.completed {
+		return nil, errors.New("OAUTHBEARER authentication not completed")
+	}
+	return s.message.Extensions, nil
+}
+
+// Unwrap the incoming buffer.
+func (s *OAuthBearerServer) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("OAUTHBEARER supports neither integrity nor privacy")
+	}
+	return nil, errors.New("OAUTHBEARER authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (s *OAuthBearerServer) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("OAUTHBEARER supports neither integrity nor privacy")
+	}
+	return nil, errors.New("OAUTHBEARER authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (s *OAuthBearerServer) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.completed {
+		return nil, errors.New("OAUTHBEARER authentication not completed")
+	}
+
+	if propName == sasl.SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (s *OAuthBearerServer) Dispose() error {
+	s.validator = nil
+	s.cbh = nil
+	return nil
+}
@@ -0,0 +1,213 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// gmailError is the error Gmail sends for a rejected token.
+const gmailError = `{"status":"401","schemes":"Bearer","scope":"https://mail.google.com/"}`
+
+// xoauth2Handler answers NameCallbacks with user, unless empty, and
+// TokenCallbacks with token.
+func xoauth2Handler(user, token string) sasl.CallbackHandler {
+	return sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		for _, callback := range callbacks {
+			switch cb := callback.(type) {
+			case *sasl.NameCallback:
+				cb.SetName(user)
+			case *TokenCallback:
+				cb.SetToken(token)
+			default:
+				return &sasl.UnsupportedCallbackError{Callback: callback}
+			}
+		}
+		return nil
+	})
+}
+
+func TestParseXOAuth2Error(t *testing.T) {
+	want := &ErrorResponse{Status: "401", Schemes: "Bearer", Scope: "https://mail.google.com/"}
+	tests := []struct {
+		name      string
+		challenge string
+		err       string
+	}{
+		{"base64", base64.StdEncoding.EncodeToString([]byte(gmailError)), ""},
+		{"base64 with line break", base64.StdEncoding.EncodeToString([]byte(gmailError)) + "\r\n", ""},
+		{"JSON", gmailError, ""},
+		{"JSON with spaces", " " + gmailError + "\n", ""},
+		{"invalid base64", "eyJzdGF0dXMi*", "invalid error challenge"},
+		{"invalid JSON", "{\"status\":", "invalid error response"},
+		{"base64 invalid JSON", base64.StdEncoding.EncodeToString([]byte("status")), "invalid error response"},
+		{"no status", `{"scope":"https://mail.google.com/"}`, "status missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errorResponse, err := parseXOAuth2Error([]byte(test.challenge))
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error %v, want %q", err, test.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(errorResponse, want) {
+				t.Errorf("error response %+v, want %+v", errorResponse, want)
+			}
+		})
+	}
+}
+
+func TestParseXOAuth2Message(t *testing.T) {
+	message, err := ParseXOAuth2Message([]byte("user=someuser@example.com\x01auth=Bearer ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg\x01\x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.AuthorizationID != "someuser@example.com" || message.Token != "ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg" {
+		t.Errorf("message %+v", message)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		err     string
+	}{
+		{"extra pair", "user=bob\x01auth=Bearer abc\x01host=imap.example.com\x01\x01", "invalid message format"},
+		{"missing auth", "user=bob\x01\x01", "invalid message format"},
+		{"missing user", "auth=Bearer abc\x01\x01", "invalid message format"},
+		{"empty", "\x01\x01", "invalid message format"},
+		{"no final KVSEP", "user=bob\x01auth=Bearer abc\x01", "invalid message format"},
+		{"invalid UTF-8", "user=b\xffb\x01auth=Bearer abc\x01\x01", "invalid message format"},
+		{"swapped pairs", "auth=Bearer abc\x01user=bob\x01\x01", "user name expected"},
+		{"empty user", "user=\x01auth=Bearer abc\x01\x01", "user name expected"},
+		{"no auth key", "user=bob\x01token=Bearer abc\x01\x01", "auth expected"},
+		{"other scheme", "user=bob\x01auth=Basic abc\x01\x01", "invalid auth value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if message, err := ParseXOAuth2Message([]byte(test.message)); err == nil {
+				t.Errorf("parsed %+v", message)
+			} else if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestXOAuth2Bytes(t *testing.T) {
+	message := &BearerMessage{AuthorizationID: "someuser@example.com", Token: "abc"}
+	if encoded, err := message.XOAuth2Bytes(); err != nil || string(encoded) != "user=someuser@example.com\x01auth=Bearer abc\x01\x01" {
+		t.Errorf("message %q, %v", encoded, err)
+	}
+	for _, user := range []string{"", "bob\x01auth=Bearer stolen", "b\xffb"} {
+		message := &BearerMessage{AuthorizationID: user, Token: "abc"}
+		if encoded, err := message.XOAuth2Bytes(); err == nil {
+			t.Errorf("user %q encoded as %q", user, encoded)
+		}
+	}
+
+	// The client checks the user name it retrieved.
+	client, err := NewXOAuth2Client("", nil, xoauth2Handler("bob\x01", "abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response, err := client.EvaluateChallenge(nil); err == nil {
+		t.Errorf("response %q", response)
+	}
+}
+
+func TestXOAuth2Exchange(t *testing.T) {
+	validator := func(mechanism string, message *BearerMessage) (string, error) {
+		if mechanism != XOAUTH2 || message.Token != "abc" {
+			return "", &ErrorResponse{Status: "invalid_token"}
+		}
+		return message.AuthorizationID, nil
+	}
+	// The authorization ID is offered as default user name.
+	client, err := NewXOAuth2Client("someuser@example.com", nil, xoauth2Handler("", "abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewXOAuth2Server(nil, validator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := client.EvaluateChallenge(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge, err := server.EvaluateResponse(response); err != nil || challenge != nil {
+		t.Fatalf("challenge %q, %v", challenge, err)
+	}
+	if response, err := client.EvaluateChallenge(nil); err != nil || response != nil {
+		t.Fatalf("response %q, %v", response, err)
+	}
+	if !client.IsComplete() || !server.IsComplete() {
+		t.Fatal("exchange not complete")
+	}
+	if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "someuser@example.com" {
+		t.Errorf("authorization ID %q, %v", authorizationID, err)
+	}
+}
+
+// TestXOAuth2RejectedToken checks that the client answers the error of
+// the server with an empty response, after which the server fails.
+func TestXOAuth2RejectedToken(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want *ErrorResponse
+	}{
+		{"error response", &ErrorResponse{Status: "400", Scope: "https://mail.google.com/"}, &ErrorResponse{Status: "400", Scope: "https://mail.google.com/"}},
+		// Other errors are sent with the default status.
+		{"other error", errors.New("token store unavailable"), &ErrorResponse{Status: XOAUTH2_STATUS_UNAUTHORIZED, Schemes: BEARER_SCHEME}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := func(mechanism string, message *BearerMessage) (string, error) {
+				return "", test.err
+			}
+			client, err := NewXOAuth2Client("someuser@example.com", nil, xoauth2Handler("", "abc"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewXOAuth2Server(nil, validator, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.EvaluateChallenge(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			challenge, err := server.EvaluateResponse(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Servers send the error base64-encoded.
+			response, err = client.EvaluateChallenge([]byte(base64.StdEncoding.EncodeToString(challenge)))
+			if err != nil {
+				t.Fatal(err)
+			} else if response == nil || len(response) != 0 {
+				t.Errorf("response %q, want an empty response", response)
+			}
+			if errorResponse := client.GetErrorResponse(); !reflect.DeepEqual(errorResponse, test.want) {
+				t.Errorf("error response %+v, want %+v", errorResponse, test.want)
+			}
+			if _, err := server.EvaluateResponse(response); err == nil || !strings.Contains(err.Error(), test.want.Status) {
+				t.Errorf("error %v", err)
+			} else if server.IsComplete() {
+				t.Error("server completed with a rejected token")
+			}
+			if _, err := client.EvaluateChallenge(nil); err == nil || client.IsComplete() {
+				t.Errorf("client completed with a rejected token: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package oauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+const (
+	XOAUTH2 = "XOAUTH2"
+
+	// XOAUTH2_USER_KEY is the key of the user name in XOAUTH2 messages.
+	XOAUTH2_USER_KEY = "user"
+
+	// XOAUTH2_STATUS_UNAUTHORIZED is the status reported by XOAUTH2
+	// servers when a token is rejected without a more specific status.
+	XOAUTH2_STATUS_UNAUTHORIZED = "401"
+)
+
+// XOAuth2Client is an implementation of the XOAUTH2 SASL client-side
+// mechanism used by Gmail and Outlook:
+//
+//	"user=" user ^A "auth=Bearer " token ^A ^A
+//
+// The user name is retrieved through the callback handler with a
+// NameCallback, the authorization ID being offered as default name, and
+// the token with a TokenCallback. A server rejecting the token sends a
+// JSON error, possibly still base64-encoded, which the client answers
+// with an empty response before the server reports the failure. The
+// error is available through GetErrorResponse.
+type XOAuth2Client struct {
+	cbh             sasl.CallbackHandler
+	authorizationID string
+	step            int
+	completed       bool
+	errorResponse   *ErrorResponse
+}
+
+// NewXOAuth2Client creates a client whose user name and token are
+// retrieved through cbh.
+func NewXOAuth2Client(authorizationID string, props map[string]interface{}, cbh sasl.CallbackHandler) (*XOAuth2Client, error) {
+	if cbh == nil {
+		return nil, errors.New("XOAUTH2: callback handler to get username/token required")
+	}
+	if err := sasl.CheckMechanismPolicy(XOAUTH2, props); err != nil {
+		return nil, err
+	}
+	client := &XOAuth2Client{
+		cbh:             cbh,
+		authorizationID: authorizationID,
+		step:            1,
+	}
+	return client, nil
+}
+
+// GetMechanismName returns the mechanism name "XOAUTH2".
+func (c *XOAuth2Client) GetMechanismName() string {
+	return XOAUTH2
+}
+
+// HasInitialResponse returns true: the token is sent as initial response.
+func (c *XOAuth2Client) HasInitialResponse() bool {
+	return true
+}
+
+// EvaluateChallenge processes the messages sent by the server.
+//
+// Step 1 returns the client response. Step 2 completes the exchange if
+// the server sent no challenge, or answers its error with an empty
+// response. Any later challenge is an error.
+func (c *XOAuth2Client) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	switch c.step {
+	case 1:
+		response, err := c.generateResponse()
+		if err != nil {
+			c.step = 0
+			return nil, err
+		}
+		c.step = 2
+		return response, nil
+	case 2:
+		if len(challengeData) <= 0 {
+			c.completed = true
+			c.step = 0
+			return nil, nil
+		}
+		errorResponse, err := parseXOAuth2Error(challengeData)
+		if err != nil {
+			c.step = 0
+			return nil, errors.New("XOAUTH2: " + err.Error())
+		}
+		c.errorResponse = errorResponse
+		c.step = 3
+		return []byte{}, nil
+	case 3:
+		c.step = 0
+		return nil, errors.New("XOAUTH2: " + c.errorResponse.Error())
+	default:
+		return nil, errors.New("XOAUTH2: Client at illegal state")
+	}
+}
+
+// generateResponse retrieves the user name and token and encodes them.
+func (c *XOAuth2Client) generateResponse() ([]byte, error) {
+	ncb := sasl.NewNameCallback(XOAUTH2+" user name: ", c.authorizationID)
+	tcb := NewTokenCallback(XOAUTH2 + " token: ")
+	if err := c.cbh.Handle([]sasl.Callback{ncb, tcb}); err != nil {
+		return nil, err
+	}
+	user := ncb.GetName()
+	if len(user) <= 0 {
+		user = ncb.GetDefaultName()
+	}
+	if len(user) <= 0 {
+		return nil, errors.New(XOAUTH2 + ": user name not supplied")
+	} else if len(tcb.GetToken()) <= 0 {
+		return nil, errors.New(XOAUTH2 + ": token not supplied")
+	}
+	message := &BearerMessage{AuthorizationID: user, Token: tcb.GetToken()}
+	return message.XOAuth2Bytes()
+}
+
+// parseXOAuth2Error decodes the JSON error sent by the server. Servers
+// send it base64-encoded, and protocols may hand it over undecoded.
+func parseXOAuth2Error(challenge []byte) (*ErrorResponse, error) {
+	challenge = bytes.TrimSpace(challenge)
+	if len(challenge) > 0 && challenge[0] != '{' {
+		decoded, err := base64.StdEncoding.DecodeString(string(challenge))
+		if err != nil {
+			return nil, errors.New("invalid error challenge")
+		}
+		challenge = decoded
+	}
+	return ParseErrorResponse(challenge)
+}
+
+// GetErrorResponse returns the error sent by the server, or nil if the
+// server did not reject the token.
+func (c *XOAuth2Client) GetErrorResponse() *ErrorResponse {
+	return c.errorResponse
+}
+
+// IsComplete determines whether the authentication exchange has completed.
+func (c *XOAuth2Client) IsComplete() bool {
+	return c.completed
+}
+
+// Unwrap the incoming buffer.
+func (c *XOAuth2Client) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("XOAUTH2 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("XOAUTH2 authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (c *XOAuth2Client) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if c.completed {
+		return nil, errors.New("XOAUTH2 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("XOAUTH2 authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (c *XOAuth2Client) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !c.completed {
+		return nil, errors.New("XOAUTH2 authentication not completed")
+	}
+
+	if propName == sasl.SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (c *XOAuth2Client) Dispose() error {
+	c.cbh = nil
+	return nil
+}
@@ -0,0 +1,136 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// XOAuth2Server is an implementation of the XOAUTH2 SASL server-side
+// mechanism, mainly meant to stand in for providers in tests.
+//
+// The token is checked by a TokenValidator, given the user name as
+// authorization ID of the message. A rejected token is answered with a
+// JSON ErrorResponse, by default with status XOAUTH2_STATUS_UNAUTHORIZED;
+// the exchange then fails once the client has sent its empty response.
+// The user name is checked against the identity the token was issued to
+// with an AuthorizeCallback.
+type XOAuth2Server struct {
+	validator       TokenValidator
+	cbh             sasl.CallbackHandler
+	completed       bool
+	challenged      bool
+	errorResponse   *ErrorResponse
+	authorizationID string
+}
+
+// NewXOAuth2Server creates a server validating tokens with validator.
+func NewXOAuth2Server(props map[string]interface{}, validator TokenValidator, cbh sasl.CallbackHandler) (*XOAuth2Server, error) {
+	if validator == nil {
+		return nil, errors.New("XOAUTH2: token validator must be specified")
+	}
+	if err := sasl.CheckMechanismPolicy(XOAUTH2, props); err != nil {
+		return nil, err
+	}
+	server := &XOAuth2Server{
+		validator: validator,
+		cbh:       cbh,
+	}
+	return server, nil
+}
+
+// GetMechanismName returns the mechanism name "XOAUTH2".
+func (s *XOAuth2Server) GetMechanismName() string {
+	return XOAUTH2
+}
+
+// EvaluateResponse processes the client response. An empty challenge is
+// returned if the client sent no initial response, and an ErrorResponse
+// if the token is rejected.
+func (s *XOAuth2Server) EvaluateResponse(response []byte) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("XOAUTH2 authentication already completed")
+	}
+	if s.errorResponse != nil {
+		return nil, errors.New("XOAUTH2: " + s.errorResponse.Error())
+	}
+	if len(response) == 0 && !s.challenged {
+		s.challenged = true
+		return []byte{}, nil
+	}
+	s.challenged = true
+
+	message, err := ParseXOAuth2Message(response)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := s.validator(XOAUTH2, message)
+	if err != nil {
+		errorResponse, ok := err.(*ErrorResponse)
+		if !ok {
+			errorResponse = &ErrorResponse{Status: XOAUTH2_STATUS_UNAUTHORIZED, Schemes: BEARER_SCHEME}
+		}
+		s.errorResponse = errorResponse
+		return errorResponse.Bytes(), nil
+	}
+
+	authorizationID, err := sasl.Authorize(s.cbh, identity, message.AuthorizationID)
+	if err != nil {
+		return nil, fmt.Errorf("XOAUTH2: %s", err)
+	}
+	s.authorizationID = authorizationID
+	s.completed = true
+	return nil, nil
+}
+
+// IsComplete determines whether the authentication exchange has completed.
+func (s *XOAuth2Server) IsComplete() bool {
+	return s.completed
+}
+
+// GetAuthorizationID reports the user name of the client.
+func (s *XOAuth2Server) GetAuthorizationID() (string, error) {
+	if !s.completed {
+		return "", errors.New("XOAUTH2 authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// Unwrap the incoming buffer.
+func (s *XOAuth2Server) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("XOAUTH2 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("XOAUTH2 authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (s *XOAuth2Server) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("XOAUTH2 supports neither integrity nor privacy")
+	}
+	return nil, errors.New("XOAUTH2 authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (s *XOAuth2Server) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.completed {
+		return nil, errors.New("XOAUTH2 authentication not completed")
+	}
+
+	if propName == sasl.SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (s *XOAuth2Server) Dispose() error {
+	s.validator = nil
+	s.cbh = nil
+	return nil
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package sasl
+
+import (
+	"net"
+	"syscall"
+)
+
+// GetPeerCredentials returns the credentials of the process connected to
+// conn, read with SO_PEERCRED.
+func GetPeerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	} else if credErr != nil {
+		return nil, credErr
+	}
+	return &PeerCredentials{Pid: int(ucred.Pid), Uid: int(ucred.Uid), Gid: int(ucred.Gid)}, nil
+}
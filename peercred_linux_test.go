@@ -0,0 +1,72 @@
+package sasl
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// unixConnPair returns both ends of a Unix socket pair.
+func unixConnPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		file := os.NewFile(uintptr(fd), "socketpair")
+		conn, err := net.FileConn(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = conn.(*net.UnixConn)
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conns[0], conns[1]
+}
+
+func TestGetPeerCredentials(t *testing.T) {
+	conn, _ := unixConnPair(t)
+	creds, err := GetPeerCredentials(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Pid != os.Getpid() || creds.Uid != os.Getuid() || creds.Gid != os.Getgid() {
+		t.Errorf("credentials %+v, want pid %d, uid %d, gid %d", creds, os.Getpid(), os.Getuid(), os.Getgid())
+	}
+}
+
+func TestExternalUnixConn(t *testing.T) {
+	want := strconv.Itoa(os.Getuid())
+	if u, err := user.LookupId(want); err == nil {
+		want = u.Username
+	}
+	conn, _ := unixConnPair(t)
+
+	props := map[string]interface{}{EXTERNAL_UNIX_CONN_PROPERTY: conn}
+	if identity, err := authenticateExternal(props, nil, ""); err != nil || identity != want {
+		t.Errorf("identity %q, %v, want %q", identity, err, want)
+	}
+	if !containsMechanism(GetServerMechanismNames(props), "EXTERNAL") {
+		t.Error("EXTERNAL not offered over a Unix connection")
+	}
+
+	// The verified client certificate takes precedence over the peer
+	// credentials.
+	ca, alice, _ := clientCertificates(t)
+	props[EXTERNAL_TLS_STATE_PROPERTY] = verifiedState(t, alice, ca)
+	if identity, err := authenticateExternal(props, nil, ""); err != nil || identity != "alice" {
+		t.Errorf("identity %q, %v, want the certificate", identity, err)
+	}
+
+	closed, _ := unixConnPair(t)
+	closed.Close()
+	props = map[string]interface{}{EXTERNAL_UNIX_CONN_PROPERTY: closed}
+	if _, err := authenticateExternal(props, nil, ""); err == nil {
+		t.Error("identity of a closed connection")
+	}
+}
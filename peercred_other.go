@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sasl
+
+import (
+	"errors"
+	"net"
+)
+
+// GetPeerCredentials is only supported on Linux.
+func GetPeerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	return nil, errors.New("peer credentials not supported on this platform")
+}
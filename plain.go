@@ -12,6 +12,7 @@ var (
 // http://ftp.isi.edu/in-notes/rfc2595.txt
 type PlainClient struct {
 	completed        bool
+	cbh              CallbackHandler
 	pw               []byte
 	authorizationID  string
 	authenticationID string
@@ -30,6 +31,20 @@ func NewPlainClient(authorizationID, authenticationID string, pw []byte) (*Plain
 	return client, nil
 }
 
+// NewPlainClientWithHandler creates a PlainClient which retrieves the
+// authentication ID and password through cbh when the initial response is
+// evaluated. The authorization ID is offered as default name.
+func NewPlainClientWithHandler(authorizationID string, cbh CallbackHandler) (*PlainClient, error) {
+	if cbh == nil {
+		return nil, errors.New("PLAIN: callback handler to get username/password required")
+	}
+	client := &PlainClient{
+		authorizationID: authorizationID,
+		cbh:             cbh,
+	}
+	return client, nil
+}
+
 // GetMechanismName retrieves this mechanism's name for to initiate the PLAIN protocol
 // exchange.
 func (c *PlainClient) GetMechanismName() string {
@@ -54,6 +69,13 @@ func (c *PlainClient) EvaluateChallenge(challengeData []byte) ([]byte, error) {
 	if c.completed {
 		return nil, errors.New("PLAIN authentication already completed")
 	}
+	if c.cbh != nil {
+		authenticationID, pw, err := getUserInfo("PLAIN", c.authorizationID, c.cbh)
+		if err != nil {
+			return nil, err
+		}
+		c.authenticationID, c.pw = authenticationID, pw
+	}
 	c.completed = true
 
 	var authz []byte
@@ -115,8 +137,6 @@ func (c *PlainClient) clearPassword() {
 	if c.pw == nil {
 		return
 	}
-	for i := range c.pw {
-		c.pw[i] = 0
-	}
+	ClearBytes(c.pw)
 	c.pw = nil
 }
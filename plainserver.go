@@ -0,0 +1,198 @@
+package sasl
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf8"
+)
+
+const (
+	// PLAIN_MAX_FIELD_LENGTH is the maximum length in octets of the
+	// authzid, authcid and passwd fields of a PLAIN message.
+	PLAIN_MAX_FIELD_LENGTH = 255
+)
+
+// PlainVerifier checks the credentials carried by a PLAIN message.
+// authorizationID is the identity to act as, which equals authenticationID
+// if the client did not ask for another identity. A non-nil error rejects
+// the authentication. The password slice is cleared once the verifier
+// returns, so it must not be retained.
+type PlainVerifier func(authorizationID, authenticationID string, password []byte) error
+
+// PlainServer implements the PLAIN SASL server mechanism
+// https://tools.ietf.org/html/rfc4616
+type PlainServer struct {
+	completed       bool
+	challenged      bool
+	failed          bool
+	verifier        PlainVerifier
+	cbh             CallbackHandler
+	authorizationID string
+}
+
+// NewPlainServer creates a new PlainServer instance.
+// The verifier is consulted for every authentication attempt.
+// The policy properties of props must be satisfied by the characteristics
+// declared for PLAIN, which by default only satisfy
+// SaslPropertyPolicyNoAnonymous.
+func NewPlainServer(props map[string]interface{}, verifier PlainVerifier) (*PlainServer, error) {
+	if verifier == nil {
+		return nil, errors.New("PLAIN: password verifier must be specified")
+	}
+	if err := CheckMechanismPolicy("PLAIN", props); err != nil {
+		return nil, err
+	}
+	server := &PlainServer{
+		verifier: verifier,
+	}
+	return server, nil
+}
+
+// NewPlainServerWithHandler creates a PlainServer which verifies the
+// password against the one retrieved through cbh with a NameCallback and
+// a PasswordCallback, and checks the authorization ID with an
+// AuthorizeCallback.
+func NewPlainServerWithHandler(props map[string]interface{}, cbh CallbackHandler) (*PlainServer, error) {
+	if cbh == nil {
+		return nil, errors.New("PLAIN: callback handler to get password required")
+	}
+	if err := CheckMechanismPolicy("PLAIN", props); err != nil {
+		return nil, err
+	}
+	server := &PlainServer{
+		cbh: cbh,
+	}
+	return server, nil
+}
+
+// GetMechanismName returns the mechanism name "PLAIN".
+func (s *PlainServer) GetMechanismName() string {
+	return "PLAIN"
+}
+
+// EvaluateResponse processes the PLAIN message sent by the client.
+// If the client sent no initial response an empty challenge is returned,
+// asking the client to send its credentials. A message which is malformed
+// or fails verification ends the exchange.
+func (s *PlainServer) EvaluateResponse(response []byte) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("PLAIN authentication already completed")
+	} else if s.failed {
+		return nil, errors.New("PLAIN authentication already failed")
+	}
+	if len(response) == 0 && !s.challenged {
+		s.challenged = true
+		return []byte{}, nil
+	}
+	s.challenged = true
+
+	if len(response) > 3*PLAIN_MAX_FIELD_LENGTH+2 {
+		return nil, s.fail(errors.New("PLAIN: message too long"))
+	}
+	if bytes.Count(response, []byte{SEP}) != 2 {
+		return nil, s.fail(errors.New("PLAIN: message must contain exactly two NUL separators"))
+	}
+
+	fields := bytes.SplitN(response, []byte{SEP}, 3)
+	authz, auth, pw := fields[0], fields[1], fields[2]
+	defer ClearBytes(pw)
+
+	if len(auth) == 0 {
+		return nil, s.fail(errors.New("PLAIN: authentication ID must not be empty"))
+	} else if len(pw) == 0 {
+		return nil, s.fail(errors.New("PLAIN: password must not be empty"))
+	}
+	for _, field := range fields {
+		if len(field) > PLAIN_MAX_FIELD_LENGTH {
+			return nil, s.fail(errors.New("PLAIN: field exceeds 255 octets"))
+		} else if !utf8.Valid(field) {
+			return nil, s.fail(errors.New("PLAIN: field is not valid UTF-8"))
+		}
+	}
+
+	authorizationID, err := s.verify(string(authz), string(auth), pw)
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	s.authorizationID = authorizationID
+	s.completed = true
+	return nil, nil
+}
+
+// fail aborts the exchange, so that the server cannot be used any further.
+func (s *PlainServer) fail(err error) error {
+	s.failed = true
+	return err
+}
+
+// verify checks the credentials of the client and returns the authorized
+// ID.
+func (s *PlainServer) verify(authorizationID, authenticationID string, pw []byte) (string, error) {
+	if s.verifier != nil {
+		if len(authorizationID) <= 0 {
+			authorizationID = authenticationID
+		}
+		return authorizationID, s.verifier(authorizationID, authenticationID, pw)
+	}
+	if err := verifyPassword("PLAIN", authenticationID, pw, s.cbh); err != nil {
+		return "", err
+	}
+	authorizedID, err := Authorize(s.cbh, authenticationID, authorizationID)
+	if err != nil {
+		return "", errors.New("PLAIN: " + err.Error())
+	}
+	return authorizedID, nil
+}
+
+// IsComplete determines whether this mechanism has completed.
+// Plain completes after verifying one message.
+func (s *PlainServer) IsComplete() bool {
+	return s.completed
+}
+
+// GetAuthorizationID returns the authorization ID of the client, which is
+// the authentication ID when the client did not supply an authzid.
+func (s *PlainServer) GetAuthorizationID() (string, error) {
+	if !s.completed {
+		return "", errors.New("PLAIN authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// Unwrap the incoming buffer.
+func (s *PlainServer) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("PLAIN supports neither integrity nor privacy")
+	}
+	return nil, errors.New("PLAIN authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (s *PlainServer) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if s.completed {
+		return nil, errors.New("PLAIN supports neither integrity nor privacy")
+	}
+	return nil, errors.New("PLAIN authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (s *PlainServer) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !s.completed {
+		return nil, errors.New("PLAIN authentication not completed")
+	}
+
+	if propName == SaslPropertyQop {
+		return "auth", nil
+	}
+	return nil, nil
+}
+
+// Dispose the sasl
+func (s *PlainServer) Dispose() error {
+	s.verifier = nil
+	s.cbh = nil
+	return nil
+}
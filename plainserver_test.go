@@ -0,0 +1,118 @@
+package sasl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// plainVerifier accepts the password "tanstaaftanstaaf" of any user.
+func plainVerifier(authorizationID, authenticationID string, password []byte) error {
+	if string(password) != "tanstaaftanstaaf" {
+		return errors.New("PLAIN: authentication failed")
+	}
+	return nil
+}
+
+func TestPlainServerMessage(t *testing.T) {
+	long := strings.Repeat("a", PLAIN_MAX_FIELD_LENGTH)
+	tests := []struct {
+		name     string
+		response string
+		err      string
+	}{
+		{"two fields", "tim\x00tanstaaftanstaaf", "two NUL separators"},
+		{"four fields", "\x00tim\x00tanstaaf\x00taaf", "two NUL separators"},
+		{"no separator", "timtanstaaftanstaaf", "two NUL separators"},
+		{"empty authcid", "admin\x00\x00tanstaaftanstaaf", "authentication ID must not be empty"},
+		{"empty password", "\x00tim\x00", "password must not be empty"},
+		{"longest fields", long + "\x00" + long + "\x00tanstaaftanstaaf", ""},
+		{"authzid too long", long + "a\x00tim\x00tanstaaftanstaaf", "exceeds 255 octets"},
+		{"authcid too long", "\x00" + long + "a\x00tanstaaftanstaaf", "exceeds 255 octets"},
+		{"message too long", long + "\x00" + long + "\x00" + long + "a", "message too long"},
+		{"invalid UTF-8 authzid", "\xff\x00tim\x00tanstaaftanstaaf", "not valid UTF-8"},
+		{"invalid UTF-8 authcid", "\x00t\xc3im\x00tanstaaftanstaaf", "not valid UTF-8"},
+		{"invalid UTF-8 password", "\x00tim\x00tanstaaf\xfe", "not valid UTF-8"},
+		{"UTF-8", "\x00t\xc3\xafm\x00tanstaaftanstaaf", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := NewPlainServer(nil, plainVerifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = server.EvaluateResponse([]byte(test.response))
+			if len(test.err) <= 0 {
+				if err != nil || !server.IsComplete() {
+					t.Fatalf("rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error %v, want %q", err, test.err)
+			} else if server.IsComplete() {
+				t.Fatal("completed after an error")
+			}
+		})
+	}
+}
+
+func TestPlainServerExchange(t *testing.T) {
+	client, err := NewPlainClient("", "tim", []byte("tanstaaftanstaaf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewPlainServer(nil, plainVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an initial response, the server sends an empty challenge.
+	challenge, err := server.EvaluateResponse(nil)
+	if err != nil || challenge == nil || len(challenge) != 0 {
+		t.Fatalf("challenge %q, %v", challenge, err)
+	}
+	response, err := client.EvaluateChallenge(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.EvaluateResponse(response); err != nil || !server.IsComplete() {
+		t.Fatalf("rejected: %v", err)
+	}
+	if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "tim" {
+		t.Errorf("authorization ID %q, %v", authorizationID, err)
+	}
+	if _, err := server.EvaluateResponse(response); err == nil {
+		t.Error("evaluated a response after completion")
+	}
+}
+
+// TestPlainServerFailure checks that a failed verification ends the
+// exchange.
+func TestPlainServerFailure(t *testing.T) {
+	server, err := NewPlainServer(nil, plainVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.EvaluateResponse([]byte("\x00tim\x00secret")); err == nil {
+		t.Fatal("accepted a wrong password")
+	}
+	if _, err := server.EvaluateResponse([]byte("\x00tim\x00tanstaaftanstaaf")); err == nil || server.IsComplete() {
+		t.Errorf("accepted a second attempt: %v", err)
+	}
+	if _, err := server.GetAuthorizationID(); err == nil {
+		t.Error("authorization ID of a failed exchange")
+	}
+}
+
+func TestPlainServerPolicy(t *testing.T) {
+	if _, err := NewPlainServer(map[string]interface{}{SaslPropertyPolicyNoAnonymous: "true"}, plainVerifier); err != nil {
+		t.Error(err)
+	}
+	for _, policy := range []string{SaslPropertyPolicyNoPlainText, SaslPropertyPolicyNoDictionary} {
+		_, err := NewPlainServer(map[string]interface{}{policy: "true"}, plainVerifier)
+		if _, ok := err.(*PolicyError); !ok {
+			t.Errorf("%s: %v", policy, err)
+		}
+	}
+}
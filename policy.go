@@ -0,0 +1,107 @@
+package sasl
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Security characteristics of a mechanism, declared with
+// RegisterMechanismPolicy and checked against the policy properties.
+const (
+	// POLICY_NOPLAINTEXT is set by mechanisms not susceptible to simple
+	// plain passive attacks.
+	POLICY_NOPLAINTEXT = 0x0001
+	// POLICY_NOACTIVE is set by mechanisms not susceptible to active,
+	// non-dictionary attacks.
+	POLICY_NOACTIVE = 0x0002
+	// POLICY_NODICTIONARY is set by mechanisms not susceptible to passive
+	// dictionary attacks.
+	POLICY_NODICTIONARY = 0x0004
+	// POLICY_FORWARD_SECRECY is set by mechanisms implementing forward
+	// secrecy between sessions.
+	POLICY_FORWARD_SECRECY = 0x0008
+	// POLICY_NOANONYMOUS is set by mechanisms which do not accept
+	// anonymous logins.
+	POLICY_NOANONYMOUS = 0x0010
+	// POLICY_PASS_CREDENTIALS is set by mechanisms able to pass client
+	// credentials.
+	POLICY_PASS_CREDENTIALS = 0x0200
+)
+
+// policyChecks associates each policy property with the characteristic a
+// mechanism must have to satisfy it.
+var policyChecks = []struct {
+	property string
+	flag     int
+}{
+	{SaslPropertyPolicyNoPlainText, POLICY_NOPLAINTEXT},
+	{SaslPropertyPolicyNoActive, POLICY_NOACTIVE},
+	{SaslPropertyPolicyNoDictionary, POLICY_NODICTIONARY},
+	{SaslPropertyPolicyNoAnonymous, POLICY_NOANONYMOUS},
+	{SaslPropertyPolicyForwardSecrecy, POLICY_FORWARD_SECRECY},
+	{SaslPropertyPolicyPassCredentials, POLICY_PASS_CREDENTIALS},
+}
+
+// PolicyError reports a mechanism excluded by a policy property.
+type PolicyError struct {
+	Mechanism string
+	Policy    string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: mechanism excluded by policy %s", e.Mechanism, e.Policy)
+}
+
+var (
+	policyLock        sync.RWMutex
+	mechanismPolicies = make(map[string]int)
+)
+
+// RegisterMechanismPolicy declares the security characteristics of
+// mechanism as a combination of the POLICY_* flags.
+func RegisterMechanismPolicy(mechanism string, flags int) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	mechanismPolicies[mechanism] = flags
+}
+
+// GetMechanismPolicy returns the security characteristics declared for
+// mechanism, and whether any were declared.
+func GetMechanismPolicy(mechanism string) (int, bool) {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	flags, ok := mechanismPolicies[mechanism]
+	return flags, ok
+}
+
+// CheckPolicy verifies that a mechanism with the characteristics flags
+// satisfies the policy properties set to "true" in props. The returned
+// error is a *PolicyError naming the first violated policy.
+func CheckPolicy(mechanism string, flags int, props map[string]interface{}) error {
+	for _, check := range policyChecks {
+		if PropertyIsTrue(props, check.property) && flags&check.flag == 0 {
+			return &PolicyError{Mechanism: mechanism, Policy: check.property}
+		}
+	}
+	return nil
+}
+
+// CheckMechanismPolicy verifies that mechanism satisfies the policy of
+// props with the characteristics it declared. A mechanism without a
+// declaration satisfies no policy.
+func CheckMechanismPolicy(mechanism string, props map[string]interface{}) error {
+	flags, _ := GetMechanismPolicy(mechanism)
+	return CheckPolicy(mechanism, flags, props)
+}
+
+// FilterMechanisms returns the mechanisms of names which satisfy the
+// policy of props.
+func FilterMechanisms(names []string, props map[string]interface{}) []string {
+	filtered := []string{}
+	for _, name := range names {
+		if CheckMechanismPolicy(name, props) == nil {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,163 @@
+package sasl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ClientFactory is implemented by mechanism packages to create Client
+// instances. Factories register themselves with RegisterClientFactory,
+// usually from an init function, and are then used by CreateClient.
+type ClientFactory interface {
+	// CreateClient creates a Client for the first of mechanisms that the
+	// factory supports with props. A nil Client and a nil error are
+	// returned if the factory supports none of them.
+	CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+		props map[string]interface{}, cbh CallbackHandler) (Client, error)
+
+	// GetMechanismNames returns the names of the mechanisms the factory
+	// is able to produce under props.
+	GetMechanismNames(props map[string]interface{}) []string
+}
+
+// ServerFactory is implemented by mechanism packages to create Server
+// instances. Factories register themselves with RegisterServerFactory,
+// usually from an init function, and are then used by CreateServer.
+type ServerFactory interface {
+	// CreateServer creates a Server for mechanism. A nil Server and a nil
+	// error are returned if the factory does not support the mechanism
+	// with props.
+	CreateServer(mechanism, protocol, serverName string,
+		props map[string]interface{}, cbh CallbackHandler) (Server, error)
+
+	// GetMechanismNames returns the names of the mechanisms the factory
+	// is able to produce under props.
+	GetMechanismNames(props map[string]interface{}) []string
+}
+
+var (
+	registryLock    sync.RWMutex
+	clientFactories []ClientFactory
+	serverFactories []ServerFactory
+)
+
+// RegisterClientFactory makes the mechanisms of factory available to
+// CreateClient. Factories are consulted in registration order.
+func RegisterClientFactory(factory ClientFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	clientFactories = append(clientFactories, factory)
+}
+
+// RegisterServerFactory makes the mechanisms of factory available to
+// CreateServer. Factories are consulted in registration order.
+func RegisterServerFactory(factory ServerFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	serverFactories = append(serverFactories, factory)
+}
+
+// GetClientFactories returns the registered client factories.
+func GetClientFactories() []ClientFactory {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return append([]ClientFactory{}, clientFactories...)
+}
+
+// GetServerFactories returns the registered server factories.
+func GetServerFactories() []ServerFactory {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return append([]ServerFactory{}, serverFactories...)
+}
+
+// GetServerMechanismNames returns the names of the server mechanisms
+// available under props, as a server would advertise them.
+func GetServerMechanismNames(props map[string]interface{}) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, factory := range GetServerFactories() {
+		for _, name := range factory.GetMechanismNames(props) {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// CreateClient creates a Client for the first of mechanisms, in order of
+// preference, which is registered and allowed by props. Mechanisms whose
+// declared characteristics violate the policy properties of props, or
+// which declared none while a policy is required, are skipped; if no
+// mechanism remains, the error names the policy which excluded each of
+// them.
+//
+// authorizationID is the identity to act as, or empty to act as the
+// authenticated identity. protocol and serverName name the service, e.g.
+// "hive" and the fully qualified host name of the server. cbh supplies the
+// credentials the mechanism needs.
+func CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Client, error) {
+	factories := GetClientFactories()
+	excluded := []string{}
+	for _, mechanism := range mechanisms {
+		if err := CheckMechanismPolicy(mechanism, props); err != nil {
+			excluded = append(excluded, err.Error())
+			continue
+		}
+		for _, factory := range factories {
+			if !containsMechanism(factory.GetMechanismNames(props), mechanism) {
+				continue
+			}
+			client, err := factory.CreateClient([]string{mechanism}, authorizationID, protocol, serverName, props, cbh)
+			if err != nil {
+				return nil, err
+			} else if client != nil {
+				return client, nil
+			}
+		}
+	}
+	if len(excluded) > 0 {
+		return nil, fmt.Errorf("no SASL client available for mechanisms %v: %s", mechanisms, strings.Join(excluded, "; "))
+	}
+	return nil, fmt.Errorf("no SASL client available for mechanisms %v", mechanisms)
+}
+
+// CreateServer creates a Server for mechanism if it is registered and
+// allowed by props. A *PolicyError is returned if the declared
+// characteristics of mechanism violate the policy properties of props;
+// a mechanism which declared none satisfies no policy.
+//
+// protocol and serverName name the service; an empty serverName creates
+// an unbound server where the mechanism supports it. cbh supplies the
+// information needed to verify the client.
+func CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Server, error) {
+	if err := CheckMechanismPolicy(mechanism, props); err != nil {
+		return nil, err
+	}
+	for _, factory := range GetServerFactories() {
+		if !containsMechanism(factory.GetMechanismNames(props), mechanism) {
+			continue
+		}
+		server, err := factory.CreateServer(mechanism, protocol, serverName, props, cbh)
+		if err != nil {
+			return nil, err
+		} else if server != nil {
+			return server, nil
+		}
+	}
+	return nil, fmt.Errorf("no SASL server available for mechanism %s", mechanism)
+}
+
+func containsMechanism(names []string, mechanism string) bool {
+	for _, name := range names {
+		if name == mechanism {
+			return true
+		}
+	}
+	return false
+}
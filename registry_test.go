@@ -0,0 +1,219 @@
+package sasl
+
+import (
+	"strings"
+	"testing"
+)
+
+// undeclaredFactory offers a mechanism which declared no policy.
+type undeclaredFactory struct{}
+
+func (f *undeclaredFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return []string{"X-UNDECLARED"}
+}
+
+func (f *undeclaredFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Client, error) {
+	return NewAnonymousClient(authorizationID)
+}
+
+func (f *undeclaredFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Server, error) {
+	return NewAnonymousServer(props)
+}
+
+// testFactory is both a client and a server factory.
+type testFactory interface {
+	ClientFactory
+	ServerFactory
+}
+
+// registerFactories registers factories after the ones already registered,
+// and returns the function restoring the previous registrations.
+func registerFactories(factories ...testFactory) func() {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	clients, servers := clientFactories, serverFactories
+	for _, factory := range factories {
+		clientFactories = append(clientFactories, factory)
+		serverFactories = append(serverFactories, factory)
+	}
+	return func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		clientFactories, serverFactories = clients, servers
+	}
+}
+
+func TestUndeclaredMechanismPolicy(t *testing.T) {
+	defer registerFactories(&undeclaredFactory{})()
+
+	if _, err := CreateClient([]string{"X-UNDECLARED"}, "", "imap", "localhost", nil, nil); err != nil {
+		t.Errorf("client without policy: %s", err)
+	} else if _, err := CreateServer("X-UNDECLARED", "imap", "localhost", nil, nil); err != nil {
+		t.Errorf("server without policy: %s", err)
+	}
+
+	props := map[string]interface{}{SaslPropertyPolicyNoActive: "true"}
+	if CheckMechanismPolicy("X-UNDECLARED", props) == nil {
+		t.Error("undeclared mechanism satisfied a required policy")
+	}
+	if _, err := CreateClient([]string{"X-UNDECLARED"}, "", "imap", "localhost", props, nil); err == nil {
+		t.Error("client created for undeclared mechanism under a required policy")
+	}
+	if _, err := CreateServer("X-UNDECLARED", "imap", "localhost", props, nil); err == nil {
+		t.Error("server created for undeclared mechanism under a required policy")
+	} else if _, ok := err.(*PolicyError); !ok {
+		t.Errorf("expected *PolicyError, got %T: %s", err, err)
+	}
+}
+
+// namedFactory offers mechanisms, creating clients and servers which
+// record the factory that created them. It declines to create declined.
+type namedFactory struct {
+	name       string
+	mechanisms []string
+	declined   string
+}
+
+// namedClient is a client created by a namedFactory.
+type namedClient struct {
+	Client
+	factory, mechanism string
+}
+
+// namedServer is a server created by a namedFactory.
+type namedServer struct {
+	Server
+	factory, mechanism string
+}
+
+func (f *namedFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return f.mechanisms
+}
+
+func (f *namedFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Client, error) {
+	for _, mechanism := range mechanisms {
+		if mechanism == f.declined || !containsMechanism(f.mechanisms, mechanism) {
+			continue
+		}
+		client, err := NewAnonymousClient("trace")
+		if err != nil {
+			return nil, err
+		}
+		return &namedClient{Client: client, factory: f.name, mechanism: mechanism}, nil
+	}
+	return nil, nil
+}
+
+func (f *namedFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh CallbackHandler) (Server, error) {
+	if mechanism == f.declined || !containsMechanism(f.mechanisms, mechanism) {
+		return nil, nil
+	}
+	server, err := NewAnonymousServer(props)
+	if err != nil {
+		return nil, err
+	}
+	return &namedServer{Server: server, factory: f.name, mechanism: mechanism}, nil
+}
+
+func TestRegisterFactories(t *testing.T) {
+	clients, servers := len(GetClientFactories()), len(GetServerFactories())
+	factory := &namedFactory{name: "first", mechanisms: []string{"X-FIRST"}}
+	unregister := registerFactories(factory)
+
+	if registered := GetClientFactories(); len(registered) != clients+1 || registered[clients] != factory {
+		t.Errorf("client factories %v", registered)
+	}
+	if registered := GetServerFactories(); len(registered) != servers+1 || registered[servers] != factory {
+		t.Errorf("server factories %v", registered)
+	}
+	// The returned slices are copies.
+	GetClientFactories()[clients] = nil
+	if GetClientFactories()[clients] != factory {
+		t.Error("modified the registered client factories through a copy")
+	}
+
+	unregister()
+	if len(GetClientFactories()) != clients || len(GetServerFactories()) != servers {
+		t.Error("factory still registered")
+	}
+}
+
+func TestGetServerMechanismNames(t *testing.T) {
+	defer registerFactories(
+		&namedFactory{name: "first", mechanisms: []string{"X-B", "X-A", "PLAIN"}},
+		&namedFactory{name: "second", mechanisms: []string{"X-A", "X-C"}},
+	)()
+
+	// The mechanisms of this package come first, without EXTERNAL for
+	// lack of an external identity, then those of the later factories in
+	// registration order, without duplicates.
+	want := []string{"CRAM-MD5", "PLAIN", "LOGIN", "ANONYMOUS", "X-B", "X-A", "X-C"}
+	if names := GetServerMechanismNames(nil); strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("mechanisms %v, want %v", names, want)
+	}
+}
+
+func TestCreateClientSelection(t *testing.T) {
+	defer registerFactories(
+		&namedFactory{name: "first", mechanisms: []string{"X-A", "X-B"}, declined: "X-B"},
+		&namedFactory{name: "second", mechanisms: []string{"X-B", "X-C"}},
+	)()
+
+	tests := []struct {
+		name       string
+		mechanisms []string
+		factory    string
+		mechanism  string
+	}{
+		{"first preference", []string{"X-A", "X-C"}, "first", "X-A"},
+		{"preference over registration", []string{"X-C", "X-A"}, "second", "X-C"},
+		{"unknown mechanism skipped", []string{"X-UNKNOWN", "X-C"}, "second", "X-C"},
+		{"declined by the first factory", []string{"X-B"}, "second", "X-B"},
+		{"no mechanism", []string{"X-UNKNOWN"}, "", ""},
+		{"empty preference", nil, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := CreateClient(test.mechanisms, "", "imap", "localhost", nil, nil)
+			if len(test.factory) <= 0 {
+				if err == nil || !strings.Contains(err.Error(), "no SASL client available") {
+					t.Errorf("client %v, error %v", client, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if named, ok := client.(*namedClient); !ok {
+				t.Errorf("client %T", client)
+			} else if named.factory != test.factory || named.mechanism != test.mechanism {
+				t.Errorf("%s client of %s, want %s client of %s", named.mechanism, named.factory, test.mechanism, test.factory)
+			}
+		})
+	}
+}
+
+func TestCreateServerSelection(t *testing.T) {
+	defer registerFactories(
+		&namedFactory{name: "first", mechanisms: []string{"X-A", "X-B"}, declined: "X-B"},
+		&namedFactory{name: "second", mechanisms: []string{"X-A", "X-B"}},
+	)()
+
+	for _, test := range []struct{ mechanism, factory string }{{"X-A", "first"}, {"X-B", "second"}} {
+		server, err := CreateServer(test.mechanism, "imap", "localhost", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if named, ok := server.(*namedServer); !ok || named.factory != test.factory {
+			t.Errorf("%s: server %#v, want one of %s", test.mechanism, server, test.factory)
+		}
+	}
+
+	server, err := CreateServer("X-UNKNOWN", "imap", "localhost", nil, nil)
+	if err == nil || server != nil || !strings.Contains(err.Error(), "no SASL server available for mechanism X-UNKNOWN") {
+		t.Errorf("server %v, error %v", server, err)
+	}
+}
@@ -194,6 +194,9 @@ type Sasl struct {
 	SendMaxBufSize int
 	RecvMaxBufSize int
 	RawSendSize    int
+	// ChannelBinding is the channel binding the peer was verified
+	// against, nil if the exchange was not bound to the channel.
+	ChannelBinding *ChannelBinding
 }
 
 // IsCompete determines whether the authentication exchange has completed.
@@ -204,6 +207,13 @@ func (s *Sasl) IsCompete() bool {
 	return s.Completed
 }
 
+// IsComplete determines whether the authentication exchange has completed.
+// It is shared by the client and server sides, so mechanisms embedding
+// *Sasl satisfy the IsComplete() method of Client and Server.
+func (s *Sasl) IsComplete() bool {
+	return s.Completed
+}
+
 // GetNegotiatedProperty retrieves the negotiated property.
 // This method can be called only after the authentication exchange has
 // completed (i.e., when IsComplete() returns true); otherwise, an
@@ -227,6 +237,16 @@ func (s *Sasl) GetNegotiatedProperty(propName string) (interface{}, error) {
 		return fmt.Sprintf("%d", s.RawSendSize), nil
 	case MAX_SEND_BUF:
 		return fmt.Sprintf("%d", s.SendMaxBufSize), nil
+	case SaslPropertyChannelBinding:
+		if s.ChannelBinding == nil {
+			return nil, nil
+		}
+		return s.ChannelBinding, nil
+	case SaslPropertyChannelBindingType:
+		if s.ChannelBinding == nil {
+			return nil, nil
+		}
+		return s.ChannelBinding.Type, nil
 	default:
 		return nil, nil
 	}
@@ -275,13 +295,15 @@ func (s *Sasl) ParseStrength(strength string) ([]byte, error) {
 // ParseProp parse property value from given vals
 func (s *Sasl) ParseProp(propName, propVal string, vals []string, masks []byte, tokens []string, ignore bool) ([]byte, error) {
 	found := false
-	parts := strings.Split(propVal, ", \t\n")
+	parts := strings.FieldsFunc(propVal, func(r rune) bool {
+		return strings.ContainsRune(", \t\n", r)
+	})
 	answer := make([]byte, len(vals), len(vals))
 	i := 0
 	for i = 0; i < len(answer) && i < len(parts); i++ {
 		found = false
 		for j := 0; !found && j < len(vals); j++ {
-			if strings.ToLower(parts[i]) != strings.ToLower(vals[i]) {
+			if !strings.EqualFold(parts[i], vals[j]) {
 				continue
 			}
 			found = true
@@ -326,3 +348,28 @@ func (s *Sasl) IntToNetworkByteOrder(num int, buf []byte, start, count int) erro
 	}
 	return nil
 }
+
+// PropertyValue returns the string value of the named property. An empty
+// string is returned if the property is absent or is not a string.
+func PropertyValue(props map[string]interface{}, propName string) string {
+	if props == nil {
+		return ""
+	}
+	if val, ok := props[propName].(string); ok {
+		return val
+	}
+	return ""
+}
+
+// PropertyIsTrue determines whether the named property contains "true".
+// The comparison ignores case, so "TRUE" enables the property as well.
+func PropertyIsTrue(props map[string]interface{}, propName string) bool {
+	return strings.EqualFold(PropertyValue(props, propName), "true")
+}
+
+// ClearBytes overwrites security-sensitive data such as passwords.
+func ClearBytes(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+}
@@ -0,0 +1,123 @@
+package sasl
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SASLprep prepares a user name or password with the SASLprep profile of
+// stringprep (RFC 4013):
+//
+//   - non-ASCII spaces are mapped to SPACE and the characters commonly
+//     mapped to nothing are removed,
+//   - the result is normalized with Unicode normalization form KC,
+//   - prohibited output and strings breaking the bidirectional rules are
+//     rejected.
+//
+// Unassigned code points are allowed, as for stringprep queries.
+func SASLprep(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", errors.New("SASLprep: string is not valid UTF-8")
+	}
+
+	mapped := strings.Map(func(r rune) rune {
+		if isMappedToNothing(r) {
+			return -1
+		} else if isNonASCIISpace(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	prepared := norm.NFKC.String(mapped)
+
+	hasRandAL, hasL := false, false
+	for _, r := range prepared {
+		if isProhibited(r) {
+			return "", errors.New("SASLprep: prohibited character in string")
+		}
+		props, _ := bidi.LookupRune(r)
+		switch props.Class() {
+		case bidi.R, bidi.AL:
+			hasRandAL = true
+		case bidi.L:
+			hasL = true
+		}
+	}
+	if hasRandAL {
+		first, _ := utf8.DecodeRuneInString(prepared)
+		last, _ := utf8.DecodeLastRuneInString(prepared)
+		if hasL || !isRandAL(first) || !isRandAL(last) {
+			return "", errors.New("SASLprep: string violates the bidirectional rules")
+		}
+	}
+	return prepared, nil
+}
+
+func isRandAL(r rune) bool {
+	props, _ := bidi.LookupRune(r)
+	return props.Class() == bidi.R || props.Class() == bidi.AL
+}
+
+// isMappedToNothing reports the characters of RFC 3454 table B.1.
+func isMappedToNothing(r rune) bool {
+	switch {
+	case r == 0x00AD, r == 0x034F, r == 0x1806, r >= 0x180B && r <= 0x180D,
+		r >= 0x200B && r <= 0x200D, r == 0x2060, r >= 0xFE00 && r <= 0xFE0F, r == 0xFEFF:
+		return true
+	}
+	return false
+}
+
+// isNonASCIISpace reports the characters of RFC 3454 table C.1.2.
+func isNonASCIISpace(r rune) bool {
+	switch {
+	case r == 0x00A0, r == 0x1680, r >= 0x2000 && r <= 0x200B, r == 0x202F, r == 0x205F, r == 0x3000:
+		return true
+	}
+	return false
+}
+
+// isProhibited reports the characters of RFC 3454 tables C.1.2 and C.2.1
+// to C.9, which SASLprep prohibits.
+func isProhibited(r rune) bool {
+	switch {
+	// C.1.2 non-ASCII space characters
+	case isNonASCIISpace(r):
+		return true
+	// C.2.1 ASCII control characters
+	case r <= 0x001F, r == 0x007F:
+		return true
+	// C.2.2 non-ASCII control characters
+	case r >= 0x0080 && r <= 0x009F, r == 0x06DD, r == 0x070F, r == 0x180E,
+		r == 0x200C, r == 0x200D, r == 0x2028, r == 0x2029, r >= 0x2060 && r <= 0x2063,
+		r >= 0x206A && r <= 0x206F, r == 0xFEFF, r >= 0xFFF9 && r <= 0xFFFC,
+		r >= 0x1D173 && r <= 0x1D17A:
+		return true
+	// C.3 private use
+	case r >= 0xE000 && r <= 0xF8FF, r >= 0xF0000 && r <= 0xFFFFD, r >= 0x100000 && r <= 0x10FFFD:
+		return true
+	// C.4 non-character code points
+	case r >= 0xFDD0 && r <= 0xFDEF, r&0xFFFE == 0xFFFE:
+		return true
+	// C.5 surrogate codes
+	case r >= 0xD800 && r <= 0xDFFF:
+		return true
+	// C.6 inappropriate for plain text
+	case r >= 0xFFF9 && r <= 0xFFFD:
+		return true
+	// C.7 inappropriate for canonical representation
+	case r >= 0x2FF0 && r <= 0x2FFB:
+		return true
+	// C.8 change display properties or deprecated
+	case r == 0x0340, r == 0x0341, r == 0x200E, r == 0x200F, r >= 0x202A && r <= 0x202E:
+		return true
+	// C.9 tagging characters
+	case r == 0xE0001, r >= 0xE0020 && r <= 0xE007F:
+		return true
+	}
+	return false
+}
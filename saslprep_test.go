@@ -0,0 +1,38 @@
+package sasl
+
+import (
+	"testing"
+)
+
+// TestSASLprep checks the examples of RFC 4013 section 3.
+func TestSASLprep(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+		valid  bool
+	}{
+		{"I\u00adX", "IX", true},               // SOFT HYPHEN mapped to nothing
+		{"user", "user", true},                 // no transformation
+		{"USER", "USER", true},                 // case preserved
+		{"\u00aa", "a", true},                  // output is NFKC, input in ISO 8859-1
+		{"\u2168", "IX", true},                 // output is NFKC, will match 1st example
+		{"\u0007", "", false},                  // error - prohibited character
+		{"\u0627\u0031", "", false},            // error - bidirectional check
+		{"a\u00a0b", "a b", true},              // non-ASCII space mapped to SPACE
+		{"\u0627\u0628", "\u0627\u0628", true}, // RandALCat characters only
+	}
+	for _, test := range tests {
+		output, err := SASLprep(test.input)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("SASLprep(%+q) = %+q, want error", test.input, output)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SASLprep(%+q): %s", test.input, err)
+		} else if output != test.output {
+			t.Errorf("SASLprep(%+q) = %+q, want %+q", test.input, output, test.output)
+		}
+	}
+}
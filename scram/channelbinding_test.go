@@ -0,0 +1,114 @@
+package scram
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// tlsConnectionStates returns the connection states of the client and
+// the server of a new TLS connection, and the certificate of the server.
+func tlsConnectionStates(t *testing.T, version uint16) (*tls.ConnectionState, *tls.ConnectionState, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	server := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   version,
+		MaxVersion:   version,
+	})
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true, MinVersion: version, MaxVersion: version})
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Handshake()
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	} else if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	clientState, serverState := client.ConnectionState(), server.ConnectionState()
+	return &clientState, &serverState, certificate
+}
+
+func TestChannelBinding(t *testing.T) {
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		clientState, serverState, certificate := tlsConnectionStates(t, version)
+		clientBindings, err := sasl.NewTLSClientChannelBindings(clientState)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverBindings, err := sasl.NewTLSServerChannelBindings(serverState, certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherState, _, _ := tlsConnectionStates(t, version)
+		otherBindings, err := sasl.NewTLSClientChannelBindings(otherState)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name      string
+			mechanism string
+			bindings  sasl.ChannelBindings
+			errValue  string
+		}{
+			{"bound", SCRAM_SHA_256_PLUS, clientBindings, ""},
+			{"bound SHA-512", SCRAM_SHA_512_PLUS, clientBindings, ""},
+			{"other connection", SCRAM_SHA_256_PLUS, otherBindings, SERVER_ERROR_CHANNEL_BINDINGS_DONT_MATCH},
+			{"downgraded", SCRAM_SHA_256, clientBindings, SERVER_ERROR_SERVER_DOES_SUPPORT_CHANNEL_BINDING},
+		}
+		for _, test := range tests {
+			t.Run(tls.VersionName(version)+" "+test.name, func(t *testing.T) {
+				clientProps := map[string]interface{}{sasl.SaslPropertyChannelBinding: test.bindings}
+				client, err := NewScramClient(test.mechanism, "", clientProps, userHandler("user", "pencil"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				serverProps := map[string]interface{}{sasl.SaslPropertyChannelBinding: serverBindings}
+				server := newTestServer(t, test.mechanism, serverProps)
+				err = exchange(t, client, server)
+				if len(test.errValue) > 0 {
+					if serverErr, ok := err.(*ServerError); !ok || serverErr.Value != test.errValue {
+						t.Fatalf("expected server error %s, got %v", test.errValue, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if bound, err := server.GetNegotiatedProperty(sasl.SaslPropertyChannelBindingType); err != nil || bound == nil {
+					t.Errorf("no channel binding negotiated: %v", err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package scram
+
+import (
+	"errors"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+var (
+	// CLIENT_MECHANISMS are the client mechanisms, in order of preference.
+	CLIENT_MECHANISMS = []string{SCRAM_SHA_512, SCRAM_SHA_256, SCRAM_SHA_1}
+	// SERVER_MECHANISMS are the server mechanisms.
+	SERVER_MECHANISMS = []string{SCRAM_SHA_512, SCRAM_SHA_256, SCRAM_SHA_1}
+	// PLUS_MECHANISMS are the mechanisms offered, ahead of the others, when
+	// channel bindings are available.
+	PLUS_MECHANISMS = []string{SCRAM_SHA_512_PLUS, SCRAM_SHA_256_PLUS}
+)
+
+func init() {
+	for _, mechanism := range []string{SCRAM_SHA_1, SCRAM_SHA_256, SCRAM_SHA_512} {
+		sasl.RegisterMechanismPolicy(mechanism, sasl.POLICY_NOPLAINTEXT|sasl.POLICY_NOANONYMOUS)
+	}
+	for _, mechanism := range PLUS_MECHANISMS {
+		sasl.RegisterMechanismPolicy(mechanism, sasl.POLICY_NOPLAINTEXT|sasl.POLICY_NOACTIVE|sasl.POLICY_NOANONYMOUS)
+	}
+	sasl.RegisterClientFactory(&clientFactory{})
+	sasl.RegisterServerFactory(&serverFactory{})
+}
+
+// clientFactory creates SCRAM clients.
+type clientFactory struct{}
+
+// GetMechanismNames returns the SCRAM mechanisms allowed by props.
+func (f *clientFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms(withPlusMechanisms(CLIENT_MECHANISMS, props), props)
+}
+
+// CreateClient creates a client for the first requested SCRAM mechanism.
+// The username and password are retrieved through cbh.
+func (f *clientFactory) CreateClient(mechanisms []string, authorizationID, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Client, error) {
+	allowed := f.GetMechanismNames(props)
+	for _, mechanism := range mechanisms {
+		if containsMechanism(allowed, mechanism) {
+			return NewScramClient(mechanism, authorizationID, props, cbh)
+		}
+	}
+	return nil, nil
+}
+
+// serverFactory creates SCRAM servers.
+type serverFactory struct{}
+
+// GetMechanismNames returns the SCRAM mechanisms allowed by props.
+func (f *serverFactory) GetMechanismNames(props map[string]interface{}) []string {
+	return sasl.FilterMechanisms(withPlusMechanisms(SERVER_MECHANISMS, props), props)
+}
+
+// CreateServer creates a server for mechanism. The credentials of the
+// users are retrieved through cbh with a CredentialsCallback, and the
+// authorization ID is checked with an AuthorizeCallback.
+func (f *serverFactory) CreateServer(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) (sasl.Server, error) {
+	if !containsMechanism(f.GetMechanismNames(props), mechanism) {
+		return nil, nil
+	}
+	if cbh == nil {
+		return nil, errors.New(mechanism + ": callback handler to get credentials required")
+	}
+	return NewScramServer(mechanism, props, func(mechanism, username string) (*Credentials, error) {
+		ccb := NewCredentialsCallback(mechanism, username)
+		if err := cbh.Handle([]sasl.Callback{ccb}); err != nil {
+			return nil, err
+		}
+		return ccb.GetCredentials(), nil
+	}, cbh)
+}
+
+// withPlusMechanisms prepends the -PLUS mechanisms to names if props hold
+// channel bindings.
+func withPlusMechanisms(names []string, props map[string]interface{}) []string {
+	if sasl.GetChannelBindings(props) == nil {
+		return names
+	}
+	return append(append([]string{}, PLUS_MECHANISMS...), names...)
+}
+
+func containsMechanism(names []string, mechanism string) bool {
+	for _, name := range names {
+		if name == mechanism {
+			return true
+		}
+	}
+	return false
+}
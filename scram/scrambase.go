@@ -0,0 +1,251 @@
+package scram
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	sasl "github.com/jellybean4/go-sasl"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	SCRAM_SHA_1   = "SCRAM-SHA-1"
+	SCRAM_SHA_256 = "SCRAM-SHA-256"
+	SCRAM_SHA_512 = "SCRAM-SHA-512"
+
+	// The -PLUS variants bind the exchange to the TLS channel it is
+	// carried over.
+	PLUS_SUFFIX        = "-PLUS"
+	SCRAM_SHA_256_PLUS = SCRAM_SHA_256 + PLUS_SUFFIX
+	SCRAM_SHA_512_PLUS = SCRAM_SHA_512 + PLUS_SUFFIX
+
+	RAW_NONCE_SIZE          = 24
+	MIN_ITERATION_COUNT     = 4096
+	MAX_ITERATION_COUNT     = 1000000
+	MAX_SCRAM_MESSAGE_SIZE  = 4096
+	CLIENT_KEY              = "Client Key"
+	SERVER_KEY              = "Server Key"
+	MIN_ITERATIONS_PROPERTY = "golang.security.sasl.scram.min.iterations"
+)
+
+// hashes gives the hash function of each SCRAM mechanism.
+var hashes = map[string]func() hash.Hash{
+	SCRAM_SHA_1:   sha1.New,
+	SCRAM_SHA_256: sha256.New,
+	SCRAM_SHA_512: sha512.New,
+}
+
+// scramBase holds the state shared by SCRAM clients and servers.
+//
+// The exchange is:
+//
+//	client-first-message = gs2-header client-first-message-bare
+//	client-first-message-bare = [reserved-mext ","] username "," nonce
+//	server-first-message = [reserved-mext ","] nonce "," salt ","
+//	                       iteration-count
+//	client-final-message = channel-binding "," nonce "," proof
+//	server-final-message = (server-error / verifier)
+//
+// and AuthMessage, signed by both sides, is
+//
+//	client-first-message-bare + "," + server-first-message + "," +
+//	client-final-message-without-proof
+type scramBase struct {
+	*sasl.Sasl
+	mechanism       string
+	newHash         func() hash.Hash
+	plus            bool
+	step            int
+	authorizationID string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	serverSignature []byte
+	channelBinding  *sasl.ChannelBinding
+}
+
+func newScramBase(mechanism string, firstStep int) (*scramBase, error) {
+	newHash, ok := hashes[strings.TrimSuffix(mechanism, PLUS_SUFFIX)]
+	if !ok {
+		return nil, fmt.Errorf("SCRAM: unsupported mechanism %s", mechanism)
+	}
+	b := &scramBase{
+		Sasl:      &sasl.Sasl{},
+		mechanism: mechanism,
+		newHash:   newHash,
+		plus:      strings.HasSuffix(mechanism, PLUS_SUFFIX),
+		step:      firstStep,
+	}
+	return b, nil
+}
+
+// GetMechanismName returns the name of the SCRAM variant.
+func (b *scramBase) GetMechanismName() string {
+	return b.mechanism
+}
+
+// Unwrap the incoming buffer.
+func (b *scramBase) Unwrap(incoming []byte, offset, len int) ([]byte, error) {
+	if b.Completed {
+		return nil, errors.New(b.mechanism + " supports neither integrity nor privacy")
+	}
+	return nil, errors.New(b.mechanism + " authentication not completed")
+}
+
+// Wrap the outgoing buffer.
+func (b *scramBase) Wrap(outgoing []byte, offset, len int) ([]byte, error) {
+	if b.Completed {
+		return nil, errors.New(b.mechanism + " supports neither integrity nor privacy")
+	}
+	return nil, errors.New(b.mechanism + " authentication not completed")
+}
+
+// GetNegotiatedProperty retrieves the negotiated property. The channel
+// binding the exchange was bound to is available as
+// sasl.SaslPropertyChannelBinding.
+// This method can be called only after the authentication exchange has
+// completed (i.e., when IsComplete() returns true); otherwise, an error
+// is returned.
+func (b *scramBase) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if !b.Completed {
+		return nil, errors.New(b.mechanism + " authentication not completed")
+	}
+	switch propName {
+	case sasl.SaslPropertyQop, sasl.SaslPropertyChannelBinding, sasl.SaslPropertyChannelBindingType:
+		return b.Sasl.GetNegotiatedProperty(propName)
+	}
+	return nil, nil
+}
+
+// complete ends the exchange, recording the channel binding it was bound
+// to as verified.
+func (b *scramBase) complete() {
+	b.Completed = true
+	b.ChannelBinding = b.channelBinding
+	b.step = 0
+}
+
+// authMessage returns the AuthMessage for the client-final-message
+// without proof.
+func (b *scramBase) authMessage(clientFinalWithoutProof string) []byte {
+	return []byte(b.clientFirstBare + "," + b.serverFirst + "," + clientFinalWithoutProof)
+}
+
+func (b *scramBase) fail(err error) error {
+	b.step = 0
+	return err
+}
+
+func (b *scramBase) hmac(key, data []byte) []byte {
+	mac := hmac.New(b.newHash, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (b *scramBase) hash(data []byte) []byte {
+	h := b.newHash()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// saltedPassword computes SaltedPassword := Hi(Normalize(password), salt, i),
+// where Hi is PBKDF2 with HMAC over the hash of the mechanism.
+func (b *scramBase) saltedPassword(passwd []byte, salt []byte, iterations int) ([]byte, error) {
+	prepared, err := sasl.SASLprep(string(passwd))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid password: %s", b.mechanism, err)
+	}
+	return pbkdf2.Key([]byte(prepared), salt, iterations, b.newHash().Size(), b.newHash), nil
+}
+
+// clientKey computes ClientKey := HMAC(SaltedPassword, "Client Key").
+func (b *scramBase) clientKey(saltedPassword []byte) []byte {
+	return b.hmac(saltedPassword, []byte(CLIENT_KEY))
+}
+
+// serverKey computes ServerKey := HMAC(SaltedPassword, "Server Key").
+func (b *scramBase) serverKey(saltedPassword []byte) []byte {
+	return b.hmac(saltedPassword, []byte(SERVER_KEY))
+}
+
+// generateNonce returns a random printable nonce.
+func generateNonce() (string, error) {
+	raw := make([]byte, RAW_NONCE_SIZE)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// attribute is one "name=value" pair of a SCRAM message.
+type attribute struct {
+	name  byte
+	value string
+}
+
+// parseAttributes splits a SCRAM message into its attributes.
+func parseAttributes(message string) ([]attribute, error) {
+	attrs := []attribute{}
+	for _, field := range strings.Split(message, ",") {
+		if len(field) < 2 || field[1] != '=' || !isAttributeName(field[0]) {
+			return nil, fmt.Errorf("invalid attribute %q", field)
+		}
+		attrs = append(attrs, attribute{name: field[0], value: field[2:]})
+	}
+	return attrs, nil
+}
+
+// expectAttribute returns the value of attrs[index], which must be named
+// name.
+func expectAttribute(attrs []attribute, index int, name byte) (string, error) {
+	if index >= len(attrs) || attrs[index].name != name {
+		return "", fmt.Errorf("attribute '%c' expected", name)
+	}
+	return attrs[index].value, nil
+}
+
+func isAttributeName(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isPrintable checks that a nonce only contains printable ASCII
+// characters other than ",".
+func isPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7E || s[i] == ',' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// parseIterationCount parses an iteration count within [minimum,
+// MAX_ITERATION_COUNT].
+func parseIterationCount(value string, minimum int) (int, error) {
+	iterations, err := strconv.Atoi(value)
+	if err != nil || iterations <= 0 {
+		return 0, fmt.Errorf("invalid iteration count %q", value)
+	} else if iterations < minimum {
+		return 0, fmt.Errorf("iteration count %d below the minimum of %d", iterations, minimum)
+	} else if iterations > MAX_ITERATION_COUNT {
+		return 0, fmt.Errorf("iteration count %d above the maximum of %d", iterations, MAX_ITERATION_COUNT)
+	}
+	return iterations, nil
+}
+
+func xorBytes(a, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
@@ -0,0 +1,280 @@
+package scram
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// ScramClient is an implementation of the SCRAM SASL client-side
+// mechanisms SCRAM-SHA-1 (RFC 5802) and SCRAM-SHA-256 (RFC 7677), and of
+// the SCRAM-SHA-256-PLUS and SCRAM-SHA-512-PLUS variants with channel
+// binding.
+//
+// The client sends the client-first-message as initial response, answers
+// the server-first-message with its proof, and only completes once the
+// server signature of the server-final-message has been verified. The
+// username is requested from the callback handler with a NameCallback
+// when the initial response is built, the password with a
+// PasswordCallback when the server-first-message is processed. Both are
+// prepared with SASLprep.
+//
+// A -PLUS client binds the exchange to the connection with the GS2 flag
+// "p". Any other client given channel bindings sends the flag
+// "y", telling the server that the client supports channel binding but
+// did not see it offered; a server supporting it then aborts the
+// exchange, as the -PLUS mechanisms must have been stripped from the
+// list it advertised.
+//
+// The following properties are used:
+//
+//	MIN_ITERATIONS_PROPERTY             - lowest iteration count accepted
+//	                                      from the server,
+//	                                      MIN_ITERATION_COUNT by default
+//	sasl.SaslPropertyChannelBinding     - the channel bindings of the
+//	                                      connection
+//	sasl.SaslPropertyChannelBindingType - channel binding type of -PLUS
+//	                                      clients
+type ScramClient struct {
+	*scramBase
+	cbh           sasl.CallbackHandler
+	gs2Header     *sasl.GS2Header
+	clientNonce   string
+	minIterations int
+}
+
+// NewScramClient creates a client for mechanism, such as SCRAM_SHA_256,
+// whose credentials are retrieved through cbh.
+func NewScramClient(mechanism, authorizationID string, props map[string]interface{}, cbh sasl.CallbackHandler) (*ScramClient, error) {
+	if cbh == nil {
+		return nil, errors.New(mechanism + ": callback handler to get username/password required")
+	}
+	if err := sasl.CheckMechanismPolicy(mechanism, props); err != nil {
+		return nil, err
+	}
+	base, err := newScramBase(mechanism, 1)
+	if err != nil {
+		return nil, err
+	}
+	base.authorizationID = authorizationID
+
+	client := &ScramClient{
+		scramBase:     base,
+		cbh:           cbh,
+		gs2Header:     sasl.NewGS2Header(authorizationID),
+		minIterations: MIN_ITERATION_COUNT,
+	}
+	if minimum := sasl.PropertyValue(props, MIN_ITERATIONS_PROPERTY); len(minimum) > 0 {
+		client.minIterations, err = strconv.Atoi(minimum)
+		if err != nil || client.minIterations <= 0 {
+			return nil, fmt.Errorf("Property must be string representation of positive integer: %s", MIN_ITERATIONS_PROPERTY)
+		}
+	}
+	if err := client.setChannelBinding(props); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// setChannelBinding selects the GS2 channel binding flag, and retrieves
+// the channel binding data of -PLUS clients.
+func (c *ScramClient) setChannelBinding(props map[string]interface{}) error {
+	bindings := sasl.GetChannelBindings(props)
+	if !c.plus {
+		if bindings != nil {
+			c.gs2Header.ChannelBinding = sasl.GS2_CBIND_NOT_ADVERTISED
+		}
+		return nil
+	}
+
+	if bindings == nil {
+		return errors.New(c.mechanism + ": channel binding requires " + sasl.SaslPropertyChannelBinding)
+	}
+	cb, err := bindings.Select(sasl.PropertyValue(props, sasl.SaslPropertyChannelBindingType))
+	if err != nil {
+		return fmt.Errorf("%s: %s", c.mechanism, err)
+	}
+	c.gs2Header.ChannelBinding = sasl.GS2_CBIND_USED
+	c.gs2Header.ChannelBindingType = cb.Type
+	c.channelBinding = cb
+	return nil
+}
+
+// HasInitialResponse returns true: SCRAM starts with the
+// client-first-message.
+func (c *ScramClient) HasInitialResponse() bool {
+	return true
+}
+
+// EvaluateChallenge processes the messages sent by the server.
+//
+// Step 1 returns the client-first-message. Step 2 answers the
+// server-first-message with the client-final-message. Step 3 verifies the
+// server-final-message.
+func (c *ScramClient) EvaluateChallenge(challengeData []byte) ([]byte, error) {
+	if len(challengeData) > MAX_SCRAM_MESSAGE_SIZE {
+		return nil, c.fail(fmt.Errorf("%s: message too long", c.mechanism))
+	}
+
+	switch c.step {
+	case 1:
+		response, err := c.generateClientFirst()
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		c.step = 2
+		return response, nil
+	case 2:
+		response, err := c.generateClientFinal(string(challengeData))
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		c.step = 3
+		return response, nil
+	case 3:
+		if err := c.verifyServerFinal(string(challengeData)); err != nil {
+			return nil, c.fail(err)
+		}
+		c.complete()
+		return nil, nil
+	default:
+		return nil, errors.New(c.mechanism + ": Client at illegal state")
+	}
+}
+
+// Dispose the sasl
+func (c *ScramClient) Dispose() error {
+	sasl.ClearBytes(c.serverSignature)
+	c.serverSignature = nil
+	return nil
+}
+
+// generateClientFirst builds the client-first-message:
+//
+//	client-first-message = gs2-header [reserved-mext ","] username ","
+//	                       nonce ["," extensions]
+func (c *ScramClient) generateClientFirst() ([]byte, error) {
+	ncb := sasl.NewNameCallback(c.mechanism+" authentication ID: ", c.authorizationID)
+	if err := c.cbh.Handle([]sasl.Callback{ncb}); err != nil {
+		return nil, err
+	}
+	username := ncb.GetName()
+	if len(username) <= 0 {
+		username = ncb.GetDefaultName()
+	}
+	if len(username) <= 0 {
+		return nil, errors.New(c.mechanism + ": authentication ID not supplied")
+	}
+	username, err := sasl.SASLprep(username)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid authentication ID: %s", c.mechanism, err)
+	}
+
+	if c.clientNonce, err = generateNonce(); err != nil {
+		return nil, err
+	}
+	c.clientFirstBare = "n=" + sasl.EscapeSaslName(username) + ",r=" + c.clientNonce
+	return append(c.gs2Header.Bytes(), c.clientFirstBare...), nil
+}
+
+// generateClientFinal processes the server-first-message and builds the
+// client-final-message:
+//
+//	client-final-message = channel-binding "," nonce [","
+//	                       extensions] "," proof
+func (c *ScramClient) generateClientFinal(serverFirst string) ([]byte, error) {
+	attrs, err := parseAttributes(serverFirst)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid server-first-message: %s", c.mechanism, err)
+	}
+	if len(attrs) > 0 && attrs[0].name == 'm' {
+		return nil, errors.New(c.mechanism + ": unsupported mandatory extension")
+	}
+	nonce, err := expectAttribute(attrs, 0, 'r')
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid server-first-message: %s", c.mechanism, err)
+	}
+	if !strings.HasPrefix(nonce, c.clientNonce) || len(nonce) <= len(c.clientNonce) || !isPrintable(nonce) {
+		return nil, errors.New(c.mechanism + ": invalid nonce in server-first-message")
+	}
+	encodedSalt, err := expectAttribute(attrs, 1, 's')
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid server-first-message: %s", c.mechanism, err)
+	}
+	salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+	if err != nil || len(salt) <= 0 {
+		return nil, errors.New(c.mechanism + ": invalid salt in server-first-message")
+	}
+	count, err := expectAttribute(attrs, 2, 'i')
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid server-first-message: %s", c.mechanism, err)
+	}
+	iterations, err := parseIterationCount(count, c.minIterations)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", c.mechanism, err)
+	}
+	c.serverFirst = serverFirst
+	c.nonce = nonce
+
+	pcb := sasl.NewPasswordCallback(c.mechanism+" password: ", false)
+	defer pcb.ClearPassword()
+	if err := c.cbh.Handle([]sasl.Callback{pcb}); err != nil {
+		return nil, err
+	} else if pcb.GetPassword() == nil {
+		return nil, errors.New(c.mechanism + ": password not supplied")
+	}
+	saltedPassword, err := c.saltedPassword(pcb.GetPassword(), salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	defer sasl.ClearBytes(saltedPassword)
+
+	clientFinal := "c=" + base64.StdEncoding.EncodeToString(c.channelBindingInput()) + ",r=" + nonce
+	authMessage := c.authMessage(clientFinal)
+
+	clientKey := c.clientKey(saltedPassword)
+	defer sasl.ClearBytes(clientKey)
+	clientSignature := c.hmac(c.hash(clientKey), authMessage)
+	proof := xorBytes(clientKey, clientSignature)
+	c.serverSignature = c.hmac(c.serverKey(saltedPassword), authMessage)
+
+	clientFinal += ",p=" + base64.StdEncoding.EncodeToString(proof)
+	return []byte(clientFinal), nil
+}
+
+// channelBindingInput returns the cbind-input, the GS2 header followed
+// by the channel binding data if any.
+func (c *ScramClient) channelBindingInput() []byte {
+	if c.channelBinding == nil {
+		return c.gs2Header.Bytes()
+	}
+	return append(c.gs2Header.Bytes(), c.channelBinding.Data...)
+}
+
+// verifyServerFinal checks the server-final-message:
+//
+//	server-final-message = (server-error / verifier)
+//	                       ["," extensions]
+func (c *ScramClient) verifyServerFinal(serverFinal string) error {
+	attrs, err := parseAttributes(serverFinal)
+	if err != nil {
+		return fmt.Errorf("%s: invalid server-final-message: %s", c.mechanism, err)
+	}
+	if len(attrs) > 0 && attrs[0].name == 'e' {
+		return fmt.Errorf("%s: authentication failed: %s", c.mechanism, attrs[0].value)
+	}
+	verifier, err := expectAttribute(attrs, 0, 'v')
+	if err != nil {
+		return fmt.Errorf("%s: invalid server-final-message: %s", c.mechanism, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(verifier)
+	if err != nil || !hmac.Equal(signature, c.serverSignature) {
+		return errors.New(c.mechanism + ": invalid server signature")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package scram
+
+import (
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// userHandler answers the callbacks of a SCRAM client.
+func userHandler(username, password string) sasl.CallbackHandler {
+	return sasl.CallbackHandlerFunc(func(callbacks []sasl.Callback) error {
+		for _, callback := range callbacks {
+			switch cb := callback.(type) {
+			case *sasl.NameCallback:
+				cb.SetName(username)
+			case *sasl.PasswordCallback:
+				cb.SetPassword([]byte(password))
+			default:
+				return &sasl.UnsupportedCallbackError{Callback: callback}
+			}
+		}
+		return nil
+	})
+}
+
+// rfcVectors are the example exchanges of RFC 5802 section 5 and RFC 7677
+// section 3, for user "user" with password "pencil".
+var rfcVectors = []struct {
+	mechanism   string
+	clientNonce string
+	serverNonce string
+	salt        string
+	clientFinal string
+	serverFinal string
+}{
+	{
+		SCRAM_SHA_1,
+		"fyko+d2lbbFgONRv9qkxdawL",
+		"3rfcNHYJY1ZVvWVs7j",
+		"QSXCR+Q6sek8bf92",
+		"c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+		"v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+	},
+	{
+		SCRAM_SHA_256,
+		"rOprNGfwEbeRWgbNEkqO",
+		"%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0",
+		"W22ZaJ0SNY7soEsUEjb6gQ==",
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+	},
+}
+
+func TestClientRFCVectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		t.Run(v.mechanism, func(t *testing.T) {
+			client, err := NewScramClient(v.mechanism, "", nil, userHandler("user", "pencil"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.EvaluateChallenge(nil); err != nil {
+				t.Fatal(err)
+			}
+			client.clientNonce = v.clientNonce
+			client.clientFirstBare = "n=user,r=" + v.clientNonce
+
+			serverFirst := "r=" + v.clientNonce + v.serverNonce + ",s=" + v.salt + ",i=4096"
+			clientFinal, err := client.EvaluateChallenge([]byte(serverFirst))
+			if err != nil {
+				t.Fatal(err)
+			} else if string(clientFinal) != v.clientFinal {
+				t.Fatalf("client-final-message = %q, want %q", clientFinal, v.clientFinal)
+			}
+			if _, err := client.EvaluateChallenge([]byte(v.serverFinal)); err != nil {
+				t.Fatal(err)
+			} else if !client.IsComplete() {
+				t.Fatal("client not complete")
+			}
+		})
+	}
+}
+
+func TestClientRejectsServerSignature(t *testing.T) {
+	v := rfcVectors[0]
+	client, err := NewScramClient(v.mechanism, "", nil, userHandler("user", "pencil"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.EvaluateChallenge(nil)
+	client.clientNonce = v.clientNonce
+	client.clientFirstBare = "n=user,r=" + v.clientNonce
+	if _, err := client.EvaluateChallenge([]byte("r=" + v.clientNonce + v.serverNonce + ",s=" + v.salt + ",i=4096")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.EvaluateChallenge([]byte("v=AmF9pqV8S7suAoZWja4dJRkFsKQ=")); err == nil || client.IsComplete() {
+		t.Fatal("accepted a wrong server signature")
+	}
+}
+
+func TestClientMechanisms(t *testing.T) {
+	props := map[string]interface{}{sasl.SaslPropertyChannelBinding: sasl.ChannelBindings{
+		{Type: sasl.CHANNEL_BINDING_TLS_EXPORTER, Data: []byte("ekm")},
+	}}
+	names := (&clientFactory{}).GetMechanismNames(props)
+	for _, plus := range PLUS_MECHANISMS {
+		mechanism := plus[:len(plus)-len(PLUS_SUFFIX)]
+		if !containsMechanism(names, plus) || !containsMechanism(names, mechanism) {
+			t.Errorf("client mechanisms %v offer %s without %s", names, plus, mechanism)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package scram
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// Values of the server-error attribute (RFC 5802 section 7).
+const (
+	SERVER_ERROR_INVALID_ENCODING                    = "invalid-encoding"
+	SERVER_ERROR_EXTENSIONS_NOT_SUPPORTED            = "extensions-not-supported"
+	SERVER_ERROR_INVALID_PROOF                       = "invalid-proof"
+	SERVER_ERROR_CHANNEL_BINDINGS_DONT_MATCH         = "channel-bindings-dont-match"
+	SERVER_ERROR_SERVER_DOES_SUPPORT_CHANNEL_BINDING = "server-does-support-channel-binding"
+	SERVER_ERROR_CHANNEL_BINDING_NOT_SUPPORTED       = "channel-binding-not-supported"
+	SERVER_ERROR_UNSUPPORTED_CHANNEL_BINDING_TYPE    = "unsupported-channel-binding-type"
+	SERVER_ERROR_UNKNOWN_USER                        = "unknown-user"
+	SERVER_ERROR_INVALID_USERNAME_ENCODING           = "invalid-username-encoding"
+	SERVER_ERROR_NO_RESOURCES                        = "no-resources"
+	SERVER_ERROR_OTHER_ERROR                         = "other-error"
+)
+
+const (
+	DEFAULT_ITERATION_COUNT = 4096
+	DEFAULT_SALT_SIZE       = 16
+)
+
+// Credentials is the record a SCRAM server keeps for a user instead of
+// the password:
+//
+//	SaltedPassword := Hi(Normalize(password), Salt, Iterations)
+//	StoredKey      := H(HMAC(SaltedPassword, "Client Key"))
+//	ServerKey      := HMAC(SaltedPassword, "Server Key")
+type Credentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewCredentials derives the Credentials of password for mechanism. A
+// random salt of DEFAULT_SALT_SIZE octets is generated if salt is nil.
+func NewCredentials(mechanism string, password []byte, salt []byte, iterations int) (*Credentials, error) {
+	base, err := newScramBase(mechanism, 0)
+	if err != nil {
+		return nil, err
+	}
+	if salt == nil {
+		salt = make([]byte, DEFAULT_SALT_SIZE)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+	saltedPassword, err := base.saltedPassword(password, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	defer sasl.ClearBytes(saltedPassword)
+
+	clientKey := base.clientKey(saltedPassword)
+	defer sasl.ClearBytes(clientKey)
+	credentials := &Credentials{
+		Salt:       append([]byte{}, salt...),
+		Iterations: iterations,
+		StoredKey:  base.hash(clientKey),
+		ServerKey:  base.serverKey(saltedPassword),
+	}
+	return credentials, nil
+}
+
+// CredentialLookup returns the credentials of username for mechanism. It
+// returns nil credentials and a nil error if the user is unknown. The
+// -PLUS variants share the credentials of their base mechanism, which is
+// the one passed to the lookup.
+type CredentialLookup func(mechanism, username string) (*Credentials, error)
+
+// CredentialsCallback retrieves the credentials of a user through a
+// sasl.CallbackHandler. It is used by the servers created from the
+// registered ServerFactory.
+type CredentialsCallback struct {
+	mechanism   string
+	username    string
+	credentials *Credentials
+}
+
+// NewCredentialsCallback creates a CredentialsCallback for username.
+func NewCredentialsCallback(mechanism, username string) *CredentialsCallback {
+	return &CredentialsCallback{mechanism: mechanism, username: username}
+}
+
+// GetMechanism returns the SCRAM mechanism the credentials are used with.
+func (c *CredentialsCallback) GetMechanism() string {
+	return c.mechanism
+}
+
+// GetUsername returns the user whose credentials are requested.
+func (c *CredentialsCallback) GetUsername() string {
+	return c.username
+}
+
+// SetCredentials sets the credentials of the user, or nil if the user is
+// unknown.
+func (c *CredentialsCallback) SetCredentials(credentials *Credentials) {
+	c.credentials = credentials
+}
+
+// GetCredentials returns the retrieved credentials.
+func (c *CredentialsCallback) GetCredentials() *Credentials {
+	return c.credentials
+}
+
+// ServerError is returned by ScramServer when the authentication fails
+// with a server-error. Protocols which can send additional data with an
+// authentication failure should send ServerFinalMessage to the client.
+type ServerError struct {
+	Mechanism string
+	Value     string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s: authentication failed: %s", e.Mechanism, e.Value)
+}
+
+// ServerFinalMessage returns the server-final-message carrying the error.
+func (e *ServerError) ServerFinalMessage() []byte {
+	return []byte("e=" + e.Value)
+}
+
+// fakeSaltKey derives the salts offered for unknown users, so that they
+// cannot be told from existing users by the server-first-message.
+var fakeSaltKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// ScramServer is an implementation of the SCRAM SASL server-side
+// mechanisms SCRAM-SHA-1 (RFC 5802), SCRAM-SHA-256 (RFC 7677) and
+// SCRAM-SHA-512.
+//
+// Clients are verified against the Credentials returned by a
+// CredentialLookup, so the server never handles passwords. Unknown users
+// are offered a salt derived from their name and are rejected with
+// 'invalid-proof' once their proof has been received, as if their password
+// was wrong. Failures are
+// reported as *ServerError. The authorization ID is checked with an
+// AuthorizeCallback if a callback handler is given; otherwise clients may
+// only act as themselves.
+//
+// A server given channel bindings through sasl.SaslPropertyChannelBinding
+// supports channel binding: -PLUS servers require the client to bind the
+// exchange to the connection with one of them, and the other servers
+// reject clients sending the GS2 flag "y", which reveals that the -PLUS
+// mechanisms were removed from the mechanism list on the way to the
+// client.
+type ScramServer struct {
+	*scramBase
+	lookup      CredentialLookup
+	cbh         sasl.CallbackHandler
+	bindings    sasl.ChannelBindings
+	gs2Header   *sasl.GS2Header
+	username    string
+	credentials *Credentials
+	challenged  bool
+}
+
+// NewScramServer creates a server for mechanism, such as SCRAM_SHA_256.
+func NewScramServer(mechanism string, props map[string]interface{}, lookup CredentialLookup, cbh sasl.CallbackHandler) (*ScramServer, error) {
+	if lookup == nil {
+		return nil, errors.New(mechanism + ": credential lookup must be specified")
+	}
+	if err := sasl.CheckMechanismPolicy(mechanism, props); err != nil {
+		return nil, err
+	}
+	base, err := newScramBase(mechanism, 1)
+	if err != nil {
+		return nil, err
+	}
+	server := &ScramServer{
+		scramBase: base,
+		lookup:    lookup,
+		cbh:       cbh,
+		bindings:  sasl.GetChannelBindings(props),
+	}
+	if server.plus && server.bindings == nil {
+		return nil, errors.New(mechanism + ": channel binding requires " + sasl.SaslPropertyChannelBinding)
+	}
+	return server, nil
+}
+
+// EvaluateResponse processes the messages sent by the client.
+//
+// Step 1 answers the client-first-message with the server-first-message;
+// an empty challenge is returned if the client sent no initial response.
+// Step 3 verifies the client-final-message and returns the
+// server-final-message.
+func (s *ScramServer) EvaluateResponse(response []byte) ([]byte, error) {
+	if len(response) > MAX_SCRAM_MESSAGE_SIZE {
+		return nil, s.fail(s.serverError(SERVER_ERROR_OTHER_ERROR))
+	}
+
+	switch s.step {
+	case 1:
+		if len(response) == 0 && !s.challenged {
+			s.challenged = true
+			return []byte{}, nil
+		}
+		challenge, err := s.generateServerFirst(string(response))
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		s.step = 3
+		return challenge, nil
+	case 3:
+		challenge, err := s.generateServerFinal(string(response))
+		if err != nil {
+			return nil, s.fail(err)
+		}
+		s.complete()
+		return challenge, nil
+	default:
+		return nil, errors.New(s.mechanism + ": Server at illegal state")
+	}
+}
+
+// GetAuthorizationID reports the authorization ID of the client, which is
+// the username when the client did not supply an authzid.
+func (s *ScramServer) GetAuthorizationID() (string, error) {
+	if !s.Completed {
+		return "", errors.New(s.mechanism + " authentication not completed")
+	}
+	return s.authorizationID, nil
+}
+
+// Dispose the sasl
+func (s *ScramServer) Dispose() error {
+	s.credentials = nil
+	s.lookup = nil
+	s.cbh = nil
+	return nil
+}
+
+// generateServerFirst processes the client-first-message and builds the
+// server-first-message:
+//
+//	server-first-message = [reserved-mext ","] nonce "," salt ","
+//	                       iteration-count ["," extensions]
+func (s *ScramServer) generateServerFirst(clientFirst string) ([]byte, error) {
+	header, bare, err := sasl.ParseGS2Header([]byte(clientFirst))
+	if err != nil || header.NonStandard {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	if err := s.checkChannelBinding(header); err != nil {
+		return nil, err
+	}
+	s.gs2Header = header
+
+	attrs, err := parseAttributes(string(bare))
+	if err != nil {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	if len(attrs) > 0 && attrs[0].name == 'm' {
+		return nil, s.serverError(SERVER_ERROR_EXTENSIONS_NOT_SUPPORTED)
+	}
+	encodedName, err := expectAttribute(attrs, 0, 'n')
+	if err != nil {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	username, err := sasl.UnescapeSaslName(encodedName)
+	if err != nil || len(username) <= 0 {
+		return nil, s.serverError(SERVER_ERROR_INVALID_USERNAME_ENCODING)
+	}
+	clientNonce, err := expectAttribute(attrs, 1, 'r')
+	if err != nil || !isPrintable(clientNonce) {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+
+	credentials, err := s.lookup(strings.TrimSuffix(s.mechanism, PLUS_SUFFIX), username)
+	if err != nil {
+		return nil, err
+	}
+	salt, iterations := s.fakeSalt(username), DEFAULT_ITERATION_COUNT
+	if credentials != nil {
+		salt, iterations = credentials.Salt, credentials.Iterations
+	}
+	s.username = username
+	s.credentials = credentials
+
+	serverNonce, err := generateNonce()
+	if err != nil {
+		return nil, s.serverError(SERVER_ERROR_NO_RESOURCES)
+	}
+	s.nonce = clientNonce + serverNonce
+	s.clientFirstBare = string(bare)
+	s.serverFirst = "r=" + s.nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=" + strconv.Itoa(iterations)
+	return []byte(s.serverFirst), nil
+}
+
+// generateServerFinal verifies the client-final-message and builds the
+// server-final-message:
+//
+//	server-final-message = (server-error / verifier)
+//	                       ["," extensions]
+func (s *ScramServer) generateServerFinal(clientFinal string) ([]byte, error) {
+	attrs, err := parseAttributes(clientFinal)
+	if err != nil || len(attrs) < 3 {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	channelBinding, err := expectAttribute(attrs, 0, 'c')
+	if err != nil {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	cbindInput, err := base64.StdEncoding.DecodeString(channelBinding)
+	if err != nil {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	expected := s.gs2Header.Raw()
+	if s.channelBinding != nil {
+		expected = append(expected, s.channelBinding.Data...)
+	}
+	if subtle.ConstantTimeCompare(cbindInput, expected) != 1 {
+		return nil, s.serverError(SERVER_ERROR_CHANNEL_BINDINGS_DONT_MATCH)
+	}
+	nonce, err := expectAttribute(attrs, 1, 'r')
+	if err != nil || nonce != s.nonce {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	encodedProof, err := expectAttribute(attrs, len(attrs)-1, 'p')
+	if err != nil {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+	proof, err := base64.StdEncoding.DecodeString(encodedProof)
+	if err != nil {
+		return nil, s.serverError(SERVER_ERROR_INVALID_ENCODING)
+	}
+
+	// Unknown users are not told apart from wrong passwords, which the
+	// salt offered to them already hides.
+	if s.credentials == nil {
+		return nil, s.serverError(SERVER_ERROR_INVALID_PROOF)
+	}
+
+	// The proof is stripped from the client-final-message, keeping any
+	// extension.
+	withoutProof := clientFinal[:len(clientFinal)-len(",p=")-len(encodedProof)]
+	authMessage := s.authMessage(withoutProof)
+	clientSignature := s.hmac(s.credentials.StoredKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return nil, s.serverError(SERVER_ERROR_INVALID_PROOF)
+	}
+	clientKey := xorBytes(proof, clientSignature)
+	defer sasl.ClearBytes(clientKey)
+	if !hmac.Equal(s.hash(clientKey), s.credentials.StoredKey) {
+		return nil, s.serverError(SERVER_ERROR_INVALID_PROOF)
+	}
+
+	authorizationID, err := sasl.Authorize(s.cbh, s.username, s.gs2Header.AuthorizationID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.mechanism, err)
+	}
+	s.authorizationID = authorizationID
+
+	serverSignature := s.hmac(s.credentials.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), nil
+}
+
+// checkChannelBinding applies the GS2 channel binding flag sent by the
+// client, and retrieves the channel binding data if the client asked for
+// channel binding.
+func (s *ScramServer) checkChannelBinding(header *sasl.GS2Header) error {
+	switch header.ChannelBinding {
+	case sasl.GS2_CBIND_USED:
+		if !s.plus {
+			return s.serverError(SERVER_ERROR_CHANNEL_BINDING_NOT_SUPPORTED)
+		}
+		cb := s.bindings.Get(header.ChannelBindingType)
+		if cb == nil {
+			return s.serverError(SERVER_ERROR_UNSUPPORTED_CHANNEL_BINDING_TYPE)
+		}
+		s.channelBinding = cb
+	case sasl.GS2_CBIND_NOT_ADVERTISED:
+		if s.bindings != nil {
+			return s.serverError(SERVER_ERROR_SERVER_DOES_SUPPORT_CHANNEL_BINDING)
+		}
+	}
+	if s.plus && header.ChannelBinding != sasl.GS2_CBIND_USED {
+		return s.serverError(SERVER_ERROR_CHANNEL_BINDINGS_DONT_MATCH)
+	}
+	return nil
+}
+
+// fakeSalt returns the salt offered to the unknown user username. It
+// depends on the base mechanism only, like the salt of known users, so
+// that SCRAM-SHA-256 and SCRAM-SHA-256-PLUS offer the same one.
+func (s *ScramServer) fakeSalt(username string) []byte {
+	mac := hmac.New(s.newHash, fakeSaltKey)
+	mac.Write([]byte(strings.TrimSuffix(s.mechanism, PLUS_SUFFIX) + "," + username))
+	return mac.Sum(nil)[:DEFAULT_SALT_SIZE]
+}
+
+func (s *ScramServer) serverError(value string) error {
+	return &ServerError{Mechanism: s.mechanism, Value: value}
+}
@@ -0,0 +1,141 @@
+package scram
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// newTestServer creates a server knowing the single user "user" with
+// password "pencil".
+func newTestServer(t *testing.T, mechanism string, props map[string]interface{}) *ScramServer {
+	server, err := NewScramServer(mechanism, props, func(mechanism, username string) (*Credentials, error) {
+		if username != "user" {
+			return nil, nil
+		}
+		return NewCredentials(mechanism, []byte("pencil"), nil, DEFAULT_ITERATION_COUNT)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// exchange runs the exchange between client and server, and returns the
+// error of the server if it rejected the client.
+func exchange(t *testing.T, client *ScramClient, server *ScramServer) error {
+	message, err := client.EvaluateChallenge(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !server.IsComplete() {
+		if message, err = server.EvaluateResponse(message); err != nil {
+			return err
+		}
+		if message, err = client.EvaluateChallenge(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !client.IsComplete() {
+		t.Fatal("client not complete")
+	}
+	return nil
+}
+
+func TestServerRFCVectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		t.Run(v.mechanism, func(t *testing.T) {
+			salt, err := base64.StdEncoding.DecodeString(v.salt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			credentials, err := NewCredentials(v.mechanism, []byte("pencil"), salt, 4096)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server, err := NewScramServer(v.mechanism, nil, func(mechanism, username string) (*Credentials, error) {
+				return credentials, nil
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.EvaluateResponse([]byte("n,,n=user,r=" + v.clientNonce)); err != nil {
+				t.Fatal(err)
+			}
+			server.nonce = v.clientNonce + v.serverNonce
+			server.serverFirst = "r=" + server.nonce + ",s=" + v.salt + ",i=4096"
+
+			serverFinal, err := server.EvaluateResponse([]byte(v.clientFinal))
+			if err != nil {
+				t.Fatal(err)
+			} else if string(serverFinal) != v.serverFinal {
+				t.Fatalf("server-final-message = %q, want %q", serverFinal, v.serverFinal)
+			}
+			if authorizationID, err := server.GetAuthorizationID(); err != nil || authorizationID != "user" {
+				t.Errorf("authorization ID = %q, %v", authorizationID, err)
+			}
+		})
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		errValue string
+	}{
+		{"valid", "user", "pencil", ""},
+		{"wrong password", "user", "pen", SERVER_ERROR_INVALID_PROOF},
+		{"unknown user", "nobody", "pencil", SERVER_ERROR_INVALID_PROOF},
+	}
+	for _, mechanism := range []string{SCRAM_SHA_1, SCRAM_SHA_256, SCRAM_SHA_512} {
+		for _, test := range tests {
+			t.Run(mechanism+" "+test.name, func(t *testing.T) {
+				client, err := NewScramClient(mechanism, "", nil, userHandler(test.username, test.password))
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = exchange(t, client, newTestServer(t, mechanism, nil))
+				if len(test.errValue) <= 0 {
+					if err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				if serverErr, ok := err.(*ServerError); !ok || serverErr.Value != test.errValue {
+					t.Fatalf("expected server error %s, got %v", test.errValue, err)
+				}
+			})
+		}
+	}
+}
+
+// TestUnknownUserSalt checks that unknown users are offered the same salt
+// by every server of a mechanism, with or without channel binding.
+func TestUnknownUserSalt(t *testing.T) {
+	bindings := map[string]interface{}{
+		sasl.SaslPropertyChannelBinding: &sasl.ChannelBinding{Type: sasl.CHANNEL_BINDING_TLS_EXPORTER, Data: []byte("exporter")},
+	}
+	serverFirst := func(username string) string {
+		server := newTestServer(t, SCRAM_SHA_256, nil)
+		message, err := server.EvaluateResponse([]byte("n,,n=" + username + ",r=rOprNGfwEbeRWgbNEkqO"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(string(message), ",")[1]
+	}
+	if salt := serverFirst("nobody"); salt != serverFirst("nobody") {
+		t.Error("salt of an unknown user changed between servers")
+	} else if salt == serverFirst("somebody") {
+		t.Error("unknown users offered the same salt")
+	}
+
+	salt := newTestServer(t, SCRAM_SHA_256, nil).fakeSalt("nobody")
+	if plusSalt := newTestServer(t, SCRAM_SHA_256_PLUS, bindings).fakeSalt("nobody"); !bytes.Equal(salt, plusSalt) {
+		t.Errorf("%s salt %x, %s salt %x", SCRAM_SHA_256, salt, SCRAM_SHA_256_PLUS, plusSalt)
+	}
+}
@@ -0,0 +1,70 @@
+package sasl
+
+// Server performs SASL authentication as a server.
+//
+// A server such as an LDAP server gets an instance of this
+// class in order to perform authentication defined by a specific SASL
+// mechanism. Invoking methods on the Server instance
+// generates challenges according to the SASL
+// mechanism implemented by the Server.
+// As the authentication proceeds, the instance
+// encapsulates the state of a SASL server's authentication exchange.
+//
+// Implementations negotiating a security layer may embed *Sasl, which
+// keeps the negotiated quality-of-protection, strength and buffer sizes
+// shared with the client side, and which already answers IsComplete() and
+// GetNegotiatedProperty() once the exchange has completed.
+type Server interface {
+	// Returns the IANA-registered mechanism name of this SASL server.
+	// (e.g. "CRAM-MD5", "GSSAPI").
+	GetMechanismName() string
+
+	// Evaluates the response data and generates a challenge.
+	// If a response is received from the client during the authentication
+	// process, this method is called to prepare an appropriate next
+	// challenge to submit to the client. The challenge is nil if the
+	// authentication has succeeded and no more challenge data is to be sent
+	// to the client. It is non-nil if the authentication must be continued
+	// by sending a challenge to the client, or if the authentication has
+	// succeeded but challenge data needs to be processed by the client.
+	// IsComplete() should be called after each call to EvaluateResponse(),
+	// to determine if any further response is needed from the client.
+	EvaluateResponse(response []byte) ([]byte, error)
+
+	// Determines whether the authentication exchange has completed.
+	// This method is typically called after each invocation of
+	// EvaluateResponse() to determine whether the authentication has
+	// completed successfully or should be continued.
+	IsComplete() bool
+
+	// Reports the authorization ID in effect for the client of this
+	// session.
+	// This method can only be called if IsComplete() returns true;
+	// otherwise, an error is returned.
+	GetAuthorizationID() (string, error)
+
+	// Unwraps a byte array received from the client.
+	// This method can be called only after the authentication exchange has
+	// completed (i.e., when IsComplete() returns true) and only if
+	// the authentication exchange has negotiated integrity and/or privacy
+	// as the quality of protection; otherwise, an error is returned.
+	Unwrap(incoming []byte, offset, len int) ([]byte, error)
+
+	// Wraps a byte array to be sent to the client.
+	// This method can be called only after the authentication exchange has
+	// completed (i.e., when IsComplete() returns true) and only if
+	// the authentication exchange has negotiated integrity and/or privacy
+	// as the quality of protection; otherwise, an error is returned.
+	Wrap(outgoing []byte, offset, len int) ([]byte, error)
+
+	// Retrieves the negotiated property.
+	// This method can be called only after the authentication exchange has
+	// completed (i.e., when IsComplete() returns true); otherwise, an
+	// error is returned.
+	GetNegotiatedProperty(propName string) (interface{}, error)
+
+	// Disposes of any system resources or security-sensitive information
+	// the SaslServer might be using. Invoking this method invalidates
+	// the SaslServer instance. This method is idempotent.
+	Dispose() error
+}
@@ -0,0 +1,327 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// NegotiationStatus is the status byte leading every message of the SASL
+// negotiation.
+type NegotiationStatus byte
+
+// Status bytes of the negotiation messages.
+const (
+	START    NegotiationStatus = 0x01
+	OK       NegotiationStatus = 0x02
+	BAD      NegotiationStatus = 0x03
+	ERROR    NegotiationStatus = 0x04
+	COMPLETE NegotiationStatus = 0x05
+)
+
+const (
+	STATUS_BYTES         = 1
+	PAYLOAD_LENGTH_BYTES = 4
+	// MAX_PAYLOAD_LENGTH bounds the payload of a negotiation message (100 MB).
+	MAX_PAYLOAD_LENGTH = 104857600
+	// DEFAULT_MAX_LENGTH bounds the length of a data frame.
+	DEFAULT_MAX_LENGTH = 0x7FFFFFFF
+	// UNKNOWN_REMAINING is reported by RemainingBytes when no frame is
+	// buffered.
+	UNKNOWN_REMAINING = ^uint64(0)
+)
+
+// String returns the name of the status.
+func (s NegotiationStatus) String() string {
+	switch s {
+	case START:
+		return "START"
+	case OK:
+		return "OK"
+	case BAD:
+		return "BAD"
+	case ERROR:
+		return "ERROR"
+	case COMPLETE:
+		return "COMPLETE"
+	default:
+		return fmt.Sprintf("NegotiationStatus(%d)", byte(s))
+	}
+}
+
+func (s NegotiationStatus) valid() bool {
+	return s >= START && s <= COMPLETE
+}
+
+// saslPeer is the side of the SASL exchange driven by a TSaslTransport.
+type saslPeer interface {
+	evaluate(payload []byte) ([]byte, error)
+	isComplete() bool
+	isClient() bool
+	wrap(outgoing []byte) ([]byte, error)
+	unwrap(incoming []byte) ([]byte, error)
+	getNegotiatedProperty(propName string) (interface{}, error)
+	dispose() error
+}
+
+// TSaslTransport is the part of the SASL transport shared by
+// TSaslClientTransport and TSaslServerTransport.
+//
+// During the negotiation every message is a status byte followed by a
+// 4-byte big-endian payload length and the payload. Once the exchange
+// has completed, application data is sent as frames with a 4-byte length,
+// passed through Wrap/Unwrap if the negotiated QOP is auth-int or
+// auth-conf.
+type TSaslTransport struct {
+	underlying thrift.TTransport
+	peer       saslPeer
+	shouldWrap bool
+	open       bool
+	maxLength  int
+	readBuf    *bytes.Reader
+	writeBuf   *bytes.Buffer
+}
+
+func newTSaslTransport(peer saslPeer, trans thrift.TTransport) *TSaslTransport {
+	return &TSaslTransport{
+		underlying: trans,
+		peer:       peer,
+		maxLength:  DEFAULT_MAX_LENGTH,
+		readBuf:    bytes.NewReader(nil),
+		writeBuf:   &bytes.Buffer{},
+	}
+}
+
+// GetUnderlyingTransport returns the transport carrying the SASL messages.
+func (t *TSaslTransport) GetUnderlyingTransport() thrift.TTransport {
+	return t.underlying
+}
+
+// SetMaxLength limits the length of the data frames read from the peer.
+func (t *TSaslTransport) SetMaxLength(maxLength int) {
+	t.maxLength = maxLength
+}
+
+// IsOpen returns true once the SASL negotiation has completed and the
+// underlying transport is still open.
+func (t *TSaslTransport) IsOpen() bool {
+	return t.open && t.peer != nil && t.underlying.IsOpen() && t.peer.isComplete()
+}
+
+// Close disposes of the SASL mechanism and closes the underlying transport.
+func (t *TSaslTransport) Close() error {
+	t.open = false
+	if t.peer == nil {
+		return t.underlying.Close()
+	}
+	if err := t.peer.dispose(); err != nil {
+		t.underlying.Close()
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	return t.underlying.Close()
+}
+
+// Read reads application data, reading the next frame from the peer
+// whenever the current one is exhausted. Frames without payload, such as
+// empty frames or messages discarded by the security layer, are skipped.
+func (t *TSaslTransport) Read(buf []byte) (int, error) {
+	if !t.IsOpen() {
+		return 0, thrift.NewTTransportException(thrift.NOT_OPEN, "SASL authentication not complete")
+	}
+	if len(buf) <= 0 {
+		return 0, nil
+	}
+	for t.readBuf.Len() <= 0 {
+		if err := t.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	return t.readBuf.Read(buf)
+}
+
+// Write buffers application data until the next Flush.
+func (t *TSaslTransport) Write(buf []byte) (int, error) {
+	if !t.IsOpen() {
+		return 0, thrift.NewTTransportException(thrift.NOT_OPEN, "SASL authentication not complete")
+	}
+	return t.writeBuf.Write(buf)
+}
+
+// Flush sends the buffered data as one frame, wrapped by the security
+// layer if integrity or privacy has been negotiated.
+func (t *TSaslTransport) Flush(ctx context.Context) error {
+	data := t.writeBuf.Bytes()
+	t.writeBuf = &bytes.Buffer{}
+	if t.shouldWrap {
+		wrapped, err := t.peer.wrap(data)
+		if err != nil {
+			return thrift.NewTTransportExceptionFromError(err)
+		}
+		data = wrapped
+	}
+
+	frame := make([]byte, PAYLOAD_LENGTH_BYTES+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[PAYLOAD_LENGTH_BYTES:], data)
+	if _, err := t.underlying.Write(frame); err != nil {
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	return t.underlying.Flush(ctx)
+}
+
+// RemainingBytes returns the bytes left in the current frame, or
+// UNKNOWN_REMAINING if no frame is buffered.
+func (t *TSaslTransport) RemainingBytes() uint64 {
+	if t.readBuf.Len() <= 0 {
+		return UNKNOWN_REMAINING
+	}
+	return uint64(t.readBuf.Len())
+}
+
+// GetNegotiatedProperty retrieves a property negotiated by the SASL
+// mechanism, such as sasl.SaslPropertyQop.
+func (t *TSaslTransport) GetNegotiatedProperty(propName string) (interface{}, error) {
+	return t.peer.getNegotiatedProperty(propName)
+}
+
+// negotiate runs the challenge/response loop until the mechanism has
+// completed, then decides whether data frames have to be wrapped.
+func (t *TSaslTransport) negotiate(status NegotiationStatus) error {
+	for !t.peer.isComplete() {
+		var payload []byte
+		var err error
+		if status, payload, err = t.receiveSaslMessage(); err != nil {
+			return err
+		}
+		if status != COMPLETE && status != OK {
+			return t.sendAndThrowMessage(ERROR, fmt.Sprintf("Expected COMPLETE or OK, got %s", status))
+		}
+
+		challenge, err := t.peer.evaluate(payload)
+		if err != nil {
+			return t.sendAndThrowMessage(BAD, err.Error())
+		}
+
+		// If we are the client, and the server indicates COMPLETE, we don't
+		// need to send back any further response.
+		if status == COMPLETE && t.peer.isClient() {
+			continue
+		}
+		next := OK
+		if t.peer.isComplete() {
+			next = COMPLETE
+		}
+		if err := t.sendSaslMessage(next, challenge); err != nil {
+			return err
+		}
+		if err := t.underlying.Flush(context.Background()); err != nil {
+			return thrift.NewTTransportExceptionFromError(err)
+		}
+	}
+
+	// If we're the client, and we're complete, but the server isn't
+	// complete yet, we need to wait for its response. This happens with
+	// mechanisms whose initial response completes the client.
+	if t.peer.isClient() && status == OK {
+		status, _, err := t.receiveSaslMessage()
+		if err != nil {
+			return err
+		} else if status != COMPLETE {
+			return t.sendAndThrowMessage(ERROR, fmt.Sprintf("Expected SASL COMPLETE, but got %s", status))
+		}
+	}
+
+	qop, err := t.peer.getNegotiatedProperty(sasl.SaslPropertyQop)
+	if err != nil {
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	if qop, ok := qop.(string); ok && len(qop) > 0 && !strings.EqualFold(qop, "auth") {
+		t.shouldWrap = true
+	}
+	t.open = true
+	return nil
+}
+
+// sendSaslMessage writes a negotiation message to the underlying
+// transport; the caller flushes it.
+func (t *TSaslTransport) sendSaslMessage(status NegotiationStatus, payload []byte) error {
+	message := make([]byte, STATUS_BYTES+PAYLOAD_LENGTH_BYTES+len(payload))
+	message[0] = byte(status)
+	binary.BigEndian.PutUint32(message[STATUS_BYTES:], uint32(len(payload)))
+	copy(message[STATUS_BYTES+PAYLOAD_LENGTH_BYTES:], payload)
+	if _, err := t.underlying.Write(message); err != nil {
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	return nil
+}
+
+// receiveSaslMessage reads a negotiation message. A BAD or ERROR message
+// is turned into an error carrying the reason sent by the peer.
+func (t *TSaslTransport) receiveSaslMessage() (NegotiationStatus, []byte, error) {
+	header := make([]byte, STATUS_BYTES+PAYLOAD_LENGTH_BYTES)
+	if _, err := io.ReadFull(t.underlying, header); err != nil {
+		return 0, nil, thrift.NewTTransportExceptionFromError(err)
+	}
+
+	status := NegotiationStatus(header[0])
+	if !status.valid() {
+		return 0, nil, t.sendAndThrowMessage(ERROR, fmt.Sprintf("Invalid status %d", header[0]))
+	}
+	length := binary.BigEndian.Uint32(header[STATUS_BYTES:])
+	if length > MAX_PAYLOAD_LENGTH {
+		return 0, nil, t.sendAndThrowMessage(ERROR, fmt.Sprintf("Invalid payload header length: %d", length))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(t.underlying, payload); err != nil {
+		return 0, nil, thrift.NewTTransportExceptionFromError(err)
+	}
+	if status == BAD || status == ERROR {
+		return status, nil, thrift.NewTTransportException(thrift.UNKNOWN_TRANSPORT_EXCEPTION,
+			"Peer indicated failure: "+string(payload))
+	}
+	return status, payload, nil
+}
+
+// sendAndThrowMessage reports a failure to the peer, closes the underlying
+// transport and returns the failure as an error.
+func (t *TSaslTransport) sendAndThrowMessage(status NegotiationStatus, message string) error {
+	if err := t.sendSaslMessage(status, []byte(message)); err == nil {
+		t.underlying.Flush(context.Background())
+	}
+	t.underlying.Close()
+	return thrift.NewTTransportException(thrift.UNKNOWN_TRANSPORT_EXCEPTION, message)
+}
+
+// readFrame reads the next data frame and unwraps it if needed.
+func (t *TSaslTransport) readFrame() error {
+	header := make([]byte, PAYLOAD_LENGTH_BYTES)
+	if _, err := io.ReadFull(t.underlying, header); err != nil {
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > uint32(t.maxLength) {
+		return thrift.NewTTransportException(thrift.UNKNOWN_TRANSPORT_EXCEPTION,
+			fmt.Sprintf("Frame size (%d) larger than max length (%d)!", length, t.maxLength))
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(t.underlying, data); err != nil {
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	if t.shouldWrap {
+		unwrapped, err := t.peer.unwrap(data)
+		if err != nil {
+			return thrift.NewTTransportExceptionFromError(err)
+		}
+		data = unwrapped
+	}
+	t.readBuf = bytes.NewReader(data)
+	return nil
+}
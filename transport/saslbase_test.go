@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	sasl "github.com/jellybean4/go-sasl"
+	"github.com/jellybean4/go-sasl/digest"
+)
+
+// digestHandler answers the callbacks of DIGEST-MD5 clients and servers
+// for user "bob".
+func digestHandler(callbacks []sasl.Callback) error {
+	for _, callback := range callbacks {
+		switch cb := callback.(type) {
+		case *sasl.NameCallback:
+			cb.SetName("bob")
+		case *sasl.PasswordCallback:
+			cb.SetPassword([]byte("secret"))
+		case *sasl.RealmCallback:
+			cb.SetText(cb.GetDefaultText())
+		case *sasl.RealmChoiceCallback:
+			cb.SetSelectedIndex(0)
+		case *sasl.AuthorizeCallback:
+			cb.SetAuthorized(cb.GetAuthenticationID() == cb.GetAuthorizationID())
+		default:
+			return &sasl.UnsupportedCallbackError{Callback: callback}
+		}
+	}
+	return nil
+}
+
+// openPair negotiates a client and a server transport over a pipe.
+func openPair(t *testing.T, client sasl.Client, mechanism string, definition ServerDefinition) (*TSaslClientTransport, thrift.TTransport) {
+	clientConn, serverConn := net.Pipe()
+	factory := NewTSaslServerTransportFactory()
+	factory.AddServerDefinition(mechanism, definition)
+
+	type result struct {
+		trans thrift.TTransport
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		trans, err := factory.GetTransport(thrift.NewTSocketFromConnConf(serverConn, nil))
+		done <- result{trans, err}
+	}()
+	clientTrans := NewTSaslClientTransport(client, thrift.NewTSocketFromConnConf(clientConn, nil))
+	if err := clientTrans.Open(); err != nil {
+		t.Fatal(err)
+	}
+	server := <-done
+	if server.err != nil {
+		t.Fatal(server.err)
+	}
+	return clientTrans, server.trans
+}
+
+func TestReadSkipsEmptyFrames(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     func() (sasl.Client, error)
+		mechanism  string
+		definition ServerDefinition
+	}{
+		{
+			"PLAIN",
+			func() (sasl.Client, error) {
+				return sasl.NewPlainClient("", "bob", []byte("secret"))
+			},
+			"PLAIN",
+			func() (sasl.Server, error) {
+				return sasl.NewPlainServer(nil, func(authorizationID, authenticationID string, pw []byte) error {
+					if string(pw) != "secret" {
+						return errors.New("PLAIN: wrong password")
+					}
+					return nil
+				})
+			},
+		},
+		{
+			"DIGEST-MD5 auth-int",
+			func() (sasl.Client, error) {
+				props := map[string]interface{}{sasl.SaslPropertyQop: "auth-int"}
+				return digest.NewMD5Client("", "hive", "localhost", props, sasl.CallbackHandlerFunc(digestHandler))
+			},
+			"DIGEST-MD5",
+			func() (sasl.Server, error) {
+				props := map[string]interface{}{sasl.SaslPropertyQop: "auth-int"}
+				return digest.NewMD5Server("hive", "localhost", props, sasl.CallbackHandlerFunc(digestHandler))
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := test.client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientTrans, serverTrans := openPair(t, client, test.mechanism, test.definition)
+			defer clientTrans.Close()
+			defer serverTrans.Close()
+
+			// An empty frame, followed by a frame carrying data.
+			go func() {
+				clientTrans.Flush(context.Background())
+				clientTrans.Write([]byte("ping"))
+				clientTrans.Flush(context.Background())
+			}()
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(serverTrans, buf); err != nil {
+				t.Fatal(err)
+			} else if string(buf) != "ping" {
+				t.Errorf("read %q, want %q", buf, "ping")
+			}
+		})
+	}
+}
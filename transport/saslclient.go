@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// TSaslClientTransport is the client side of the Thrift SASL transport,
+// as used by Hive, Impala and Java's TSaslClientTransport.
+//
+// Open() sends the mechanism name in a START message followed by the
+// initial response, then drives the sasl.Client through the challenges of
+// the server until both sides report COMPLETE.
+type TSaslClientTransport struct {
+	*TSaslTransport
+	client sasl.Client
+}
+
+// clientPeer drives a sasl.Client on behalf of the transport.
+type clientPeer struct {
+	sasl.Client
+}
+
+func (p *clientPeer) evaluate(payload []byte) ([]byte, error) {
+	return p.EvaluateChallenge(payload)
+}
+
+func (p *clientPeer) isComplete() bool {
+	return p.IsComplete()
+}
+
+func (p *clientPeer) isClient() bool {
+	return true
+}
+
+func (p *clientPeer) wrap(outgoing []byte) ([]byte, error) {
+	return p.Wrap(outgoing, 0, len(outgoing))
+}
+
+func (p *clientPeer) unwrap(incoming []byte) ([]byte, error) {
+	return p.Unwrap(incoming, 0, len(incoming))
+}
+
+func (p *clientPeer) getNegotiatedProperty(propName string) (interface{}, error) {
+	return p.GetNegotiatedProperty(propName)
+}
+
+func (p *clientPeer) dispose() error {
+	return p.Dispose()
+}
+
+// NewTSaslClientTransport creates a transport authenticating with client
+// over trans. The negotiation takes place in Open().
+func NewTSaslClientTransport(client sasl.Client, trans thrift.TTransport) *TSaslClientTransport {
+	return &TSaslClientTransport{
+		TSaslTransport: newTSaslTransport(&clientPeer{client}, trans),
+		client:         client,
+	}
+}
+
+// GetSaslClient returns the mechanism used by this transport.
+func (t *TSaslClientTransport) GetSaslClient() sasl.Client {
+	return t.client
+}
+
+// Open opens the underlying transport if needed and performs the SASL
+// negotiation. A failure of the mechanism is reported to the server with
+// a BAD message before the underlying transport is closed.
+func (t *TSaslClientTransport) Open() error {
+	if t.open || t.client.IsComplete() {
+		return thrift.NewTTransportException(thrift.ALREADY_OPEN, "SASL transport already open")
+	}
+	if !t.underlying.IsOpen() {
+		if err := t.underlying.Open(); err != nil {
+			return err
+		}
+	}
+
+	if err := t.handleSaslStartMessage(); err != nil {
+		t.underlying.Close()
+		return err
+	}
+	if err := t.negotiate(OK); err != nil {
+		t.underlying.Close()
+		return err
+	}
+	return nil
+}
+
+// handleSaslStartMessage sends the mechanism name and the initial
+// response, which is empty if the mechanism has none.
+func (t *TSaslClientTransport) handleSaslStartMessage() error {
+	initialResponse := []byte{}
+	if t.client.HasInitialResponse() {
+		response, err := t.client.EvaluateChallenge(initialResponse)
+		if err != nil {
+			return t.sendAndThrowMessage(BAD, err.Error())
+		}
+		initialResponse = response
+	}
+
+	if err := t.sendSaslMessage(START, []byte(t.client.GetMechanismName())); err != nil {
+		return err
+	}
+	status := OK
+	if t.client.IsComplete() {
+		status = COMPLETE
+	}
+	if err := t.sendSaslMessage(status, initialResponse); err != nil {
+		return err
+	}
+	if err := t.underlying.Flush(context.Background()); err != nil {
+		return thrift.NewTTransportExceptionFromError(err)
+	}
+	return nil
+}
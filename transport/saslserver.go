@@ -0,0 +1,265 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// ServerDefinition creates the sasl.Server used to authenticate one
+// connection with a given mechanism.
+type ServerDefinition func() (sasl.Server, error)
+
+// NewServerDefinition returns a definition creating servers for mechanism
+// from the registered sasl.ServerFactory instances.
+func NewServerDefinition(mechanism, protocol, serverName string,
+	props map[string]interface{}, cbh sasl.CallbackHandler) ServerDefinition {
+	return func() (sasl.Server, error) {
+		return sasl.CreateServer(mechanism, protocol, serverName, props, cbh)
+	}
+}
+
+// TSaslServerTransport is the server side of the Thrift SASL transport,
+// accepting the same handshake as Java's TSaslServerTransport.
+//
+// Open() reads the START message of the client, selects the server
+// definition registered for the requested mechanism and runs the
+// challenge/response loop. Failures are reported to the client with BAD
+// or ERROR messages carrying the reason.
+type TSaslServerTransport struct {
+	*TSaslTransport
+	definitions map[string]ServerDefinition
+	server      sasl.Server
+	onClose     func()
+	closeOnce   sync.Once
+	closeErr    error
+}
+
+// serverPeer drives a sasl.Server on behalf of the transport.
+type serverPeer struct {
+	sasl.Server
+}
+
+func (p *serverPeer) evaluate(payload []byte) ([]byte, error) {
+	return p.EvaluateResponse(payload)
+}
+
+func (p *serverPeer) isComplete() bool {
+	return p.IsComplete()
+}
+
+func (p *serverPeer) isClient() bool {
+	return false
+}
+
+func (p *serverPeer) wrap(outgoing []byte) ([]byte, error) {
+	return p.Wrap(outgoing, 0, len(outgoing))
+}
+
+func (p *serverPeer) unwrap(incoming []byte) ([]byte, error) {
+	return p.Unwrap(incoming, 0, len(incoming))
+}
+
+func (p *serverPeer) getNegotiatedProperty(propName string) (interface{}, error) {
+	return p.GetNegotiatedProperty(propName)
+}
+
+func (p *serverPeer) dispose() error {
+	return p.Dispose()
+}
+
+// NewTSaslServerTransport creates a transport accepting the mechanisms of
+// definitions, keyed by mechanism name, over trans. The negotiation takes
+// place in Open().
+func NewTSaslServerTransport(definitions map[string]ServerDefinition, trans thrift.TTransport) *TSaslServerTransport {
+	t := &TSaslServerTransport{
+		TSaslTransport: newTSaslTransport(nil, trans),
+		definitions:    make(map[string]ServerDefinition),
+	}
+	for mechanism, definition := range definitions {
+		t.definitions[mechanism] = definition
+	}
+	return t
+}
+
+// AddServerDefinition registers the definition used when a client asks
+// for mechanism.
+func (t *TSaslServerTransport) AddServerDefinition(mechanism string, definition ServerDefinition) {
+	t.definitions[mechanism] = definition
+}
+
+// GetSaslServer returns the mechanism selected by the client, or nil
+// before the START message has been received.
+func (t *TSaslServerTransport) GetSaslServer() sasl.Server {
+	return t.server
+}
+
+// GetAuthorizationID reports the identity of the authenticated client.
+func (t *TSaslServerTransport) GetAuthorizationID() (string, error) {
+	if t.server == nil {
+		return "", errors.New("SASL negotiation not started")
+	}
+	return t.server.GetAuthorizationID()
+}
+
+// GetNegotiatedProperty retrieves a property negotiated by the SASL
+// mechanism, such as sasl.SaslPropertyQop.
+func (t *TSaslServerTransport) GetNegotiatedProperty(propName string) (interface{}, error) {
+	if t.server == nil {
+		return nil, errors.New("SASL negotiation not started")
+	}
+	return t.TSaslTransport.GetNegotiatedProperty(propName)
+}
+
+// Open opens the underlying transport if needed and performs the SASL
+// negotiation.
+func (t *TSaslServerTransport) Open() error {
+	if t.open {
+		return thrift.NewTTransportException(thrift.ALREADY_OPEN, "SASL transport already open")
+	}
+	if !t.underlying.IsOpen() {
+		if err := t.underlying.Open(); err != nil {
+			return err
+		}
+	}
+
+	if err := t.handleSaslStartMessage(); err != nil {
+		t.underlying.Close()
+		return err
+	}
+	if err := t.negotiate(START); err != nil {
+		t.underlying.Close()
+		return err
+	}
+	return nil
+}
+
+// Close disposes of the SASL mechanism and closes the underlying transport.
+// Thrift servers close both the input and the output transport of a
+// connection, which are the same transport when they come from a
+// TSaslServerTransportFactory; only the first call has an effect.
+func (t *TSaslServerTransport) Close() error {
+	t.closeOnce.Do(func() {
+		if t.onClose != nil {
+			t.onClose()
+		}
+		t.closeErr = t.TSaslTransport.Close()
+	})
+	return t.closeErr
+}
+
+// handleSaslStartMessage reads the START message and creates the server
+// for the mechanism named in it.
+func (t *TSaslServerTransport) handleSaslStartMessage() error {
+	status, payload, err := t.receiveSaslMessage()
+	if err != nil {
+		return err
+	} else if status != START {
+		return t.sendAndThrowMessage(ERROR, fmt.Sprintf("Expecting START status, received %s", status))
+	}
+
+	mechanism := string(payload)
+	definition, ok := t.definitions[mechanism]
+	if !ok {
+		return t.sendAndThrowMessage(BAD, "Unsupported mechanism type "+mechanism)
+	}
+	server, err := definition()
+	if err != nil {
+		return t.sendAndThrowMessage(BAD, err.Error())
+	}
+	t.server = server
+	t.peer = &serverPeer{server}
+	return nil
+}
+
+// TSaslServerTransportFactory wraps the transports accepted by a Thrift
+// server into TSaslServerTransports and performs the negotiation.
+//
+// Thrift servers ask both their input and output transport factories for
+// a transport; the factory hands out the same TSaslServerTransport for
+// both requests until it is closed, so that each connection is only
+// authenticated once.
+type TSaslServerTransportFactory struct {
+	definitions map[string]ServerDefinition
+	lock        sync.Mutex
+	transports  map[thrift.TTransport]*TSaslServerTransport
+}
+
+// NewTSaslServerTransportFactory creates a factory without any mechanism;
+// mechanisms are added with AddServerDefinition.
+func NewTSaslServerTransportFactory() *TSaslServerTransportFactory {
+	return &TSaslServerTransportFactory{
+		definitions: make(map[string]ServerDefinition),
+		transports:  make(map[thrift.TTransport]*TSaslServerTransport),
+	}
+}
+
+// AddServerDefinition registers the definition used when a client asks
+// for mechanism.
+func (f *TSaslServerTransportFactory) AddServerDefinition(mechanism string, definition ServerDefinition) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.definitions[mechanism] = definition
+}
+
+// GetTransport returns the TSaslServerTransport for trans, performing the
+// SASL negotiation the first time the transport is asked for.
+func (f *TSaslServerTransportFactory) GetTransport(trans thrift.TTransport) (thrift.TTransport, error) {
+	f.lock.Lock()
+	if t, ok := f.transports[trans]; ok {
+		f.lock.Unlock()
+		return t, nil
+	}
+	t := NewTSaslServerTransport(f.definitions, trans)
+	f.lock.Unlock()
+
+	if err := t.Open(); err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.transports[trans] = t
+	t.onClose = func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		delete(f.transports, trans)
+	}
+	return t, nil
+}
+
+type authorizationIDKey struct{}
+
+// TSaslProcessor passes the identity authenticated by a
+// TSaslServerTransport to the wrapped processor through the context; it
+// can be retrieved with AuthorizationIDFromContext.
+type TSaslProcessor struct {
+	thrift.TProcessor
+}
+
+// NewTSaslProcessor wraps processor into a TSaslProcessor.
+func NewTSaslProcessor(processor thrift.TProcessor) *TSaslProcessor {
+	return &TSaslProcessor{processor}
+}
+
+// Process adds the authorization ID of the client to ctx before handing
+// the request to the wrapped processor.
+func (p *TSaslProcessor) Process(ctx context.Context, in, out thrift.TProtocol) (bool, thrift.TException) {
+	if t, ok := in.Transport().(*TSaslServerTransport); ok {
+		if id, err := t.GetAuthorizationID(); err == nil {
+			ctx = context.WithValue(ctx, authorizationIDKey{}, id)
+		}
+	}
+	return p.TProcessor.Process(ctx, in, out)
+}
+
+// AuthorizationIDFromContext returns the identity of the client added by
+// TSaslProcessor.
+func AuthorizationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(authorizationIDKey{}).(string)
+	return id, ok
+}
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	sasl "github.com/jellybean4/go-sasl"
+)
+
+// plainDefinition creates PLAIN servers accepting the password "secret".
+func plainDefinition() (sasl.Server, error) {
+	return sasl.NewPlainServer(nil, func(authorizationID, authenticationID string, pw []byte) error {
+		if string(pw) != "secret" {
+			return errors.New("PLAIN: wrong password")
+		}
+		return nil
+	})
+}
+
+// disposeCounter counts the calls to Dispose of the wrapped server.
+type disposeCounter struct {
+	sasl.Server
+	disposed int
+}
+
+func (s *disposeCounter) Dispose() error {
+	s.disposed++
+	return s.Server.Dispose()
+}
+
+// accept runs GetTransport of factory on the server end of a pipe, and
+// returns the client end along with the outcome of GetTransport.
+func accept(factory *TSaslServerTransportFactory) (net.Conn, <-chan error) {
+	clientConn, serverConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := factory.GetTransport(thrift.NewTSocketFromConnConf(serverConn, nil))
+		done <- err
+	}()
+	return clientConn, done
+}
+
+// writeMessage writes a negotiation message to conn.
+func writeMessage(t *testing.T, conn net.Conn, status NegotiationStatus, payload string) {
+	message := make([]byte, STATUS_BYTES+PAYLOAD_LENGTH_BYTES+len(payload))
+	message[0] = byte(status)
+	binary.BigEndian.PutUint32(message[STATUS_BYTES:], uint32(len(payload)))
+	copy(message[STATUS_BYTES+PAYLOAD_LENGTH_BYTES:], payload)
+	if _, err := conn.Write(message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readMessage reads a negotiation message from conn.
+func readMessage(t *testing.T, conn net.Conn) (NegotiationStatus, string) {
+	header := make([]byte, STATUS_BYTES+PAYLOAD_LENGTH_BYTES)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[STATUS_BYTES:]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatal(err)
+	}
+	return NegotiationStatus(header[0]), string(payload)
+}
+
+func TestServerTransportFactoryClose(t *testing.T) {
+	factory := NewTSaslServerTransportFactory()
+	var server *disposeCounter
+	factory.AddServerDefinition("PLAIN", func() (sasl.Server, error) {
+		plain, err := plainDefinition()
+		server = &disposeCounter{Server: plain}
+		return server, err
+	})
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	client, err := sasl.NewPlainClient("", "bob", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go NewTSaslClientTransport(client, thrift.NewTSocketFromConnConf(clientConn, nil)).Open()
+
+	socket := thrift.NewTSocketFromConnConf(serverConn, nil)
+	input, err := factory.GetTransport(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := factory.GetTransport(socket)
+	if err != nil {
+		t.Fatal(err)
+	} else if input != output {
+		t.Fatal("the input and output transports of a connection differ")
+	}
+
+	if err := input.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.Close(); err != nil {
+		t.Error(err)
+	}
+	if server.disposed != 1 {
+		t.Errorf("server disposed %d times", server.disposed)
+	}
+	factory.lock.Lock()
+	defer factory.lock.Unlock()
+	if len(factory.transports) != 0 {
+		t.Errorf("%d transports left after Close", len(factory.transports))
+	}
+}
+
+func TestServerTransportRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  NegotiationStatus
+		payload string
+		reply   NegotiationStatus
+		err     string
+	}{
+		{"unknown mechanism", START, "CRAM-MD5", BAD, "Unsupported mechanism type CRAM-MD5"},
+		{"OK before START", OK, "PLAIN", ERROR, "Expecting START status"},
+		{"BAD", BAD, "client gave up", 0, "Peer indicated failure: client gave up"},
+		{"ERROR", ERROR, "client failed", 0, "Peer indicated failure: client failed"},
+		{"invalid status", 0x07, "", ERROR, "Invalid status 7"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			factory := NewTSaslServerTransportFactory()
+			factory.AddServerDefinition("PLAIN", plainDefinition)
+			conn, done := accept(factory)
+			defer conn.Close()
+
+			writeMessage(t, conn, test.status, test.payload)
+			if test.reply != 0 {
+				if status, message := readMessage(t, conn); status != test.reply || !strings.HasPrefix(message, test.err) {
+					t.Errorf("reply %s %q, want %s %q", status, message, test.reply, test.err)
+				}
+			}
+			if err := <-done; err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+			if len(factory.transports) != 0 {
+				t.Error("failed transport kept by the factory")
+			}
+		})
+	}
+}
+
+// TestServerTransportAuthenticationFailure checks that a failed
+// authentication is reported to the client with BAD.
+func TestServerTransportAuthenticationFailure(t *testing.T) {
+	factory := NewTSaslServerTransportFactory()
+	factory.AddServerDefinition("PLAIN", plainDefinition)
+	conn, done := accept(factory)
+	defer conn.Close()
+
+	client, err := sasl.NewPlainClient("", "bob", []byte("guess"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewTSaslClientTransport(client, thrift.NewTSocketFromConnConf(conn, nil)).Open()
+	if err == nil || !strings.Contains(err.Error(), "Peer indicated failure: PLAIN: wrong password") {
+		t.Errorf("client error %v", err)
+	}
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Errorf("server error %v", err)
+	}
+}